@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/backup"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupCluster   string
+	backupOutputDir string
+	backupArchive   bool
+
+	restoreFrom   string
+	restoreTarget string
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Save Cluster API objects, Flux bootstrap objects and the state of the tool of a management cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		cluster := backupCluster
+		if cluster == "" {
+			if cluster, err = permanentManagementCluster(cfg); err != nil {
+				return err
+			}
+		}
+		path, err := backup.Backup(context.Background(), logger, cfg, cluster, backupOutputDir, backupArchive)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a backup to a management cluster, the kind cluster is created if it doesn't exist",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		_, err = backup.Restore(context.Background(), logger, cfg, restoreFrom, backup.RestoreOptions{Target: restoreTarget})
+		return err
+	},
+}
+
+// permanentManagementCluster returns the management cluster when config has exactly one besides the kind cluster
+func permanentManagementCluster(cfg *config.Config) (string, error) {
+	names := cfg.ManagementClusterNames()[1:]
+	if len(names) != 1 {
+		return "", fmt.Errorf("config has %d permanent management clusters %v, set --cluster", len(names), names)
+	}
+	return names[0], nil
+}
+
+func init() {
+	backupCmd.Flags().StringVar(&backupCluster, "cluster", "", "management cluster to back up, defaults to the permanent management cluster")
+	backupCmd.Flags().StringVar(&backupOutputDir, "output-dir", ".", "directory where the backup directory or archive is created")
+	backupCmd.Flags().BoolVar(&backupArchive, "archive", false, "pack the backup to a .tar.gz archive")
+	restoreCmd.Flags().StringVar(&restoreFrom, "from", "", "backup directory or .tar.gz archive")
+	restoreCmd.Flags().StringVar(&restoreTarget, "target", config.DefaultKindClusterName, "management cluster to restore the backup to")
+	restoreCmd.MarkFlagRequired("from")
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	capiCluster         string
+	capiUpgradeContract string
+	capiVerifyTimeout   time.Duration
+)
+
+var capiCmd = &cobra.Command{
+	Use:   "capi",
+	Short: "Manage Cluster API providers on management clusters",
+}
+
+var capiUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade Cluster API providers to the versions in config.yaml `capi.providers`",
+}
+
+var capiUpgradePlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show current, configured and available versions of providers on each management cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		return forEachCAPIManagementCluster(cfg, func(name string, c *capi.ClusterAPI) error {
+			plan, err := c.PlanUpgrade()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s:\n", name)
+			if err := plan.Print(os.Stdout); err != nil {
+				return err
+			}
+			fmt.Println()
+			return nil
+		})
+	},
+}
+
+var capiUpgradeApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Upgrade providers on each management cluster and verify that all Cluster API objects reconcile afterwards",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		return forEachCAPIManagementCluster(cfg, func(name string, c *capi.ClusterAPI) error {
+			upgraded, err := c.ApplyUpgrade(capiUpgradeContract)
+			if err != nil {
+				return fmt.Errorf("cluster %s: %w", name, err)
+			}
+			if !upgraded {
+				return nil
+			}
+			if err := c.WaitForObjectsReconciled(capiVerifyTimeout); err != nil {
+				return fmt.Errorf("cluster %s: providers are upgraded but not all objects reconcile: %w", name, err)
+			}
+			return nil
+		})
+	},
+}
+
+// forEachCAPIManagementCluster runs fn for management clusters in config (or only the one set by --cluster).
+// Temporary kind cluster is skipped because Cluster API objects are moved off it during deploy.
+func forEachCAPIManagementCluster(cfg *config.Config, fn func(name string, c *capi.ClusterAPI) error) error {
+	found := false
+	for _, cluster := range cfg.Clusters {
+		if cluster.ManagementCluster != "" || cluster.Provider == "kind" {
+			continue
+		}
+		if capiCluster != "" && cluster.Name != capiCluster {
+			continue
+		}
+		found = true
+
+		clusterAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, cluster.Name)
+		if err != nil {
+			return err
+		}
+		c, err := capi.NewClusterAPI(logger, clusterAuth, cfg)
+		if err != nil {
+			return err
+		}
+		if err := fn(cluster.Name, c); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		if capiCluster != "" {
+			return fmt.Errorf("management cluster %s is not found in config", capiCluster)
+		}
+		return fmt.Errorf("no management clusters found in config")
+	}
+	return nil
+}
+
+func init() {
+	capiCmd.PersistentFlags().StringVar(&capiCluster, "cluster", "", "management cluster name, defaults to all management clusters in config")
+	capiUpgradeApplyCmd.Flags().StringVar(&capiUpgradeContract, "contract", "", "upgrade all providers to the latest versions of this contract (e.g. v1beta1) instead of the versions in config")
+	capiUpgradeApplyCmd.Flags().DurationVar(&capiVerifyTimeout, "verify-timeout", 15*time.Minute, "how long to wait for Cluster API objects to reconcile after upgrade")
+	capiUpgradeCmd.AddCommand(capiUpgradePlanCmd, capiUpgradeApplyCmd)
+	capiCmd.AddCommand(capiUpgradeCmd)
+	rootCmd.AddCommand(capiCmd)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/deployer"
+	"github.com/spf13/cobra"
+)
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Manage individual workload clusters",
+}
+
+var clusterAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Generate and push manifests of a workload cluster from config, then wait for it and create its Flux secret",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		return deployer.AddCluster(logger, cfg, args[0])
+	},
+}
+
+var clusterDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a workload cluster, its manifests in the repository and its kubeconfig context",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		return deployer.DeleteCluster(logger, cfg, args[0])
+	},
+}
+
+func init() {
+	clusterCmd.AddCommand(clusterAddCmd, clusterDeleteCmd)
+	rootCmd.AddCommand(clusterCmd)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/components"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vendorSource string
+	vendorForce  bool
+)
+
+var componentsCmd = &cobra.Command{
+	Use:   "components",
+	Short: "Manage versions of platform components vendored in k8s-platform directory",
+}
+
+var componentsVendorCmd = &cobra.Command{
+	Use:   "vendor",
+	Short: "Vendor a new version of a platform component into k8s-platform directory",
+}
+
+var componentsVendorFluxCmd = &cobra.Command{
+	Use:   "flux <version>",
+	Short: "Generate k8s-platform/flux/v<version> from a local source or the mirror and show CRD changes since the previous version",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// vendoring only writes files, clusters and credentials are not required
+		mirrorConfig, err := config.LoadMirrorConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		diff, err := components.VendorFlux(logger, args[0], components.VendorOptions{
+			Source:     vendorSource,
+			MirrorPath: mirrorConfig.Path,
+			Force:      vendorForce,
+		})
+		if err != nil {
+			return err
+		}
+
+		if diff != nil {
+			fmt.Print(diff)
+		}
+		return nil
+	},
+}
+
+func init() {
+	componentsVendorFluxCmd.Flags().StringVar(&vendorSource, "source", "", "path to gotk-components.yaml (`flux install --export` output), defaults to the mirror directory")
+	componentsVendorFluxCmd.Flags().BoolVar(&vendorForce, "force", false, "overwrite the version if it is already vendored")
+	componentsVendorCmd.AddCommand(componentsVendorFluxCmd)
+	componentsCmd.AddCommand(componentsVendorCmd)
+	rootCmd.AddCommand(componentsCmd)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/operator"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Print the config file as a versioned MultiCluster resource, which can replace config.yaml or be applied to the operator",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := config.LoadConfig(cfgFile); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(viper.ConfigFileUsed())
+		if err != nil {
+			return err
+		}
+		multiCluster, err := v1alpha1.Load(data)
+		if err != nil {
+			return err
+		}
+		if multiCluster.Name == "" {
+			multiCluster.Namespace, multiCluster.Name = operator.Namespace, operator.Name
+		}
+
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(multiCluster)
+		if err != nil {
+			return err
+		}
+		delete(obj, "status")
+		unstructured.RemoveNestedField(obj, "metadata", "creationTimestamp")
+		out, err := sigsyaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+}
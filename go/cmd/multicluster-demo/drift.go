@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/drift"
+	"github.com/spf13/cobra"
+)
+
+// driftExitCode is returned when drift is detected, to tell it apart from errors in CI
+const driftExitCode = 2
+
+// errDriftDetected is returned by drift command when drift is detected, main exits with driftExitCode
+// after the shutdown of tracing, audit log and metrics
+var errDriftDetected = errors.New("drift detected")
+
+var driftOutput string
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Compare clusters in config with live CAPI objects on management clusters, exits with code 2 on drift",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if driftOutput != "text" && driftOutput != "json" {
+			return fmt.Errorf("unsupported output %q, supported: text, json", driftOutput)
+		}
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		report := drift.NewDetector(logger, cfg).Detect()
+		if driftOutput == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(report)
+		} else {
+			err = report.Print(os.Stdout)
+		}
+		if err != nil {
+			return err
+		}
+
+		if report.HasDrift() {
+			// the report is already printed, it is not an error of the command
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			return errDriftDetected
+		}
+		return nil
+	},
+}
+
+func init() {
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", "text", "output format: text or json")
+	rootCmd.AddCommand(driftCmd)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/ipam"
+	"github.com/spf13/cobra"
+)
+
+var generateCmd = &cobra.Command{
+	Use:   "generate [cluster...]",
+	Short: "Generate CAPI cluster manifests, CNI HelmChartProxy and Flux Kustomizations from config for the given or all clusters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		if _, err := ipam.AssignCIDRs(logger, cfg); err != nil {
+			return err
+		}
+		return generator.NewGenerator(logger, cfg).Generate(args...)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateCmd)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/ipam"
+	"github.com/spf13/cobra"
+)
+
+var ipamCmd = &cobra.Command{
+	Use:   "ipam",
+	Short: "Allocate pod and service CIDRs to clusters which don't set them in config and print all allocations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		allocator, err := ipam.AssignCIDRs(logger, cfg)
+		if err != nil {
+			return err
+		}
+
+		allocations := allocator.Allocations()
+		names := make([]string, 0, len(allocations))
+		for name := range allocations {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CLUSTER\tPOD CIDR\tSERVICE CIDR")
+		for _, name := range names {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, allocations[name].PodCIDR, allocations[name].ServiceCIDR)
+		}
+		return w.Flush()
+	},
+}
+
+var ipamReleaseCmd = &cobra.Command{
+	Use:   "release <cluster>",
+	Short: "Release CIDRs of a deleted cluster so that they can be allocated to other clusters",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		allocator, err := ipam.NewAllocator(logger, cfg.IPAM)
+		if err != nil {
+			return err
+		}
+		return allocator.Release(args[0])
+	},
+}
+
+func init() {
+	ipamCmd.AddCommand(ipamReleaseCmd)
+	rootCmd.AddCommand(ipamCmd)
+}
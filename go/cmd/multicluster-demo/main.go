@@ -1,26 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/deployer"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/runner"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
-var requiredVars = []string{
-	"AWS_B64ENCODED_CREDENTIALS",
-}
-
 var logger = log.Log
 
 var cfgFile string
 
+// metricsPushgateway is Prometheus Pushgateway URL, metrics of the CLI run are pushed to it when the command finishes
+var metricsPushgateway string
+
+// traceEndpoint is OTLP/HTTP endpoint and traceFile is a file to export spans to, tracing is disabled if both are empty
+var traceEndpoint, traceFile string
+
+// shutdownTracing flushes spans, it is replaced when tracing is set up before a command runs
+var shutdownTracing = func(context.Context) error { return nil }
+
+// auditLog is the file changes made by the tool to clusters, kubeconfig and the repository are appended to
+var auditLog string
+
+// closeAuditLog is replaced when the audit log is opened before a command runs
+var closeAuditLog = func() error { return nil }
+
 // Following cmd variables could be defined inside main function, but setting them as global variables have some advantages:
 // - Organises command setup separately from the main application logic.
 // - Allows for modular command definitions, where each command's setup is contained within its own init function.
@@ -30,6 +47,20 @@ var cfgFile string
 var rootCmd = &cobra.Command{
 	Use:   "multicluster-demo",
 	Short: "Multi Cluster Demo app build a multi cluster setup in a cloud provider of choice by using Cluster API or CrossPlane and runs scenarios such as immutable cluster upgrade with no downtime or cluster failover",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		shutdown, err := tracing.Setup(cmd.Context(), traceEndpoint, traceFile)
+		if err != nil {
+			return err
+		}
+		shutdownTracing = shutdown
+
+		closeLog, err := audit.Setup(auditLog)
+		if err != nil {
+			return err
+		}
+		closeAuditLog = closeLog
+		return nil
+	},
 }
 
 var deployCmd = &cobra.Command{
@@ -69,13 +100,30 @@ var runCmd = &cobra.Command{
 }
 
 func main() {
-	// Validate required environment variables
-	if err := validateEnvVars(requiredVars); err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+	err := rootCmd.Execute()
+	// spans of a deploy are exported in batches, the last batch is flushed here
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if shutdownErr := shutdownTracing(ctx); shutdownErr != nil {
+		fmt.Printf("Failed to export traces: %s\n", shutdownErr)
 	}
-
-	if err := rootCmd.Execute(); err != nil {
+	cancel()
+	if closeErr := closeAuditLog(); closeErr != nil {
+		fmt.Printf("Failed to close audit log: %s\n", closeErr)
+	}
+	if metricsPushgateway != "" {
+		// failed runs are pushed too, so that failures can be trended
+		command := rootCmd.Name()
+		if cmd, _, findErr := rootCmd.Find(os.Args[1:]); findErr == nil {
+			command = cmd.Name()
+		}
+		if pushErr := metrics.Push(metricsPushgateway, command); pushErr != nil {
+			fmt.Printf("Failed to push metrics: %s\n", pushErr)
+		}
+	}
+	if errors.Is(err, errDriftDetected) {
+		os.Exit(driftExitCode)
+	}
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -87,6 +135,10 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.myapp.yaml)")
+	rootCmd.PersistentFlags().StringVar(&traceEndpoint, "trace-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "file to export traces to as JSON")
+	rootCmd.PersistentFlags().StringVar(&auditLog, "audit-log", audit.DefaultPath(), "file to append audit records of changes to clusters, kubeconfig and the repository to, - for stdout, empty to disable")
+	rootCmd.PersistentFlags().StringVar(&metricsPushgateway, "metrics-pushgateway", "", "Prometheus Pushgateway URL to push metrics to at the end of the run")
 	rootCmd.AddCommand(deployCmd)
 	rootCmd.AddCommand(uninstallCmd)
 	rootCmd.AddCommand(runCmd)
@@ -108,14 +160,3 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
-
-// validateEnvVars checks if all required environment variables are set.
-// It returns an error if any variable is missing.
-func validateEnvVars(vars []string) error {
-	for _, v := range vars {
-		if os.Getenv(v) == "" {
-			return fmt.Errorf("required environment variable not set: %s", v)
-		}
-	}
-	return nil
-}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/cilium"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var meshLabel string
+
+var meshCmd = &cobra.Command{
+	Use:   "mesh",
+	Short: "Manage Cilium Cluster Mesh between clusters which share the same mesh label",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		fmt.Print(cilium.NewClusterMesh(logger, cfg).Summary())
+		return nil
+	},
+}
+
+var meshEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Distribute the shared CA, connect clustermesh-apiservers and verify the mesh from each cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		return cilium.NewClusterMesh(logger, cfg).Enable(cmd.Context(), meshLabel)
+	},
+}
+
+var meshVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check clustermesh status on each cluster of the mesh",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		mesh := cilium.NewClusterMesh(logger, cfg)
+		if meshLabel != "" {
+			return mesh.Verify(cmd.Context(), meshLabel)
+		}
+		for label, clusters := range mesh.Groups() {
+			if len(clusters) < 2 {
+				continue
+			}
+			if err := mesh.Verify(cmd.Context(), label); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.PersistentFlags().StringVar(&meshLabel, "label", "", "mesh label, defaults to all meshes in config")
+	meshCmd.AddCommand(meshEnableCmd, meshVerifyCmd)
+	rootCmd.AddCommand(meshCmd)
+}
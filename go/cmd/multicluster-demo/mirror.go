@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/mirror"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mirrorSaveImages bool
+	mirrorPushImages bool
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Downloads Cluster API providers, Flux components, CNI and add-on charts and the list of their images into the mirror directory for air-gapped deploy",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		m, err := mirror.NewMirror(logger, cfg)
+		if err != nil {
+			return err
+		}
+
+		if err := m.Run(); err != nil {
+			return err
+		}
+
+		if mirrorSaveImages || mirrorPushImages {
+			return m.SaveImages(mirrorPushImages)
+		}
+		return nil
+	},
+}
+
+func init() {
+	mirrorCmd.Flags().BoolVar(&mirrorSaveImages, "save-images", false, "pull all images and save them as tarballs in the mirror directory (requires docker)")
+	mirrorCmd.Flags().BoolVar(&mirrorPushImages, "push-images", false, "pull all images and push them and the charts to the mirror registry (requires docker and helm)")
+	rootCmd.AddCommand(mirrorCmd)
+}
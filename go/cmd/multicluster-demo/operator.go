@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/operator"
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var operatorOpts operator.Options
+
+var operatorCmd = &cobra.Command{
+	Use:   "operator",
+	Short: "Run in-cluster operator which continuously reconciles the fleet described by MultiCluster resource",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		restConfig, err := ctrl.GetConfig()
+		if err != nil {
+			return err
+		}
+		return operator.Run(ctrl.SetupSignalHandler(), logger, restConfig, operatorOpts)
+	},
+}
+
+func init() {
+	operatorCmd.Flags().StringVar(&operatorOpts.RepoDir, "repo-dir", "", "directory to clone the repository to and to generate and push cluster manifests from, generation is skipped if empty")
+	operatorCmd.Flags().StringVar(&operatorOpts.KeysDir, "keys-dir", "", "directory of the keys secret created by deploy, its keys override key sources from the resource")
+	operatorCmd.Flags().StringVar(&operatorOpts.MetricsAddr, "metrics-addr", ":8080", "address of /metrics endpoint, 0 disables it")
+	operatorCmd.Flags().DurationVar(&operatorOpts.Interval, "interval", 5*time.Minute, "interval between reconciliations of the fleet")
+	rootCmd.AddCommand(operatorCmd)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/runner"
+	"github.com/spf13/cobra"
+)
+
+var managementClusterLossOpts runner.ManagementClusterLossOptions
+
+var managementClusterLossCmd = &cobra.Command{
+	Use:   "management-cluster-loss",
+	Short: "Back up and disconnect the management cluster, restore it to a new kind cluster and pivot the workload clusters to a new management cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		opts := managementClusterLossOpts
+		if opts.LostCluster == "" {
+			if opts.LostCluster, err = permanentManagementCluster(cfg); err != nil {
+				return err
+			}
+		}
+		return runner.ManagementClusterLoss(logger, cfg, opts)
+	},
+}
+
+func init() {
+	managementClusterLossCmd.Flags().StringVar(&managementClusterLossOpts.LostCluster, "lost-cluster", "", "management cluster which is lost, defaults to the permanent management cluster")
+	managementClusterLossCmd.Flags().StringVar(&managementClusterLossOpts.NewManagementCluster, "new-management-cluster", "", "cluster in config which is provisioned by the kind cluster and takes over the workload clusters")
+	managementClusterLossCmd.Flags().StringVar(&managementClusterLossOpts.BackupDir, "backup-dir", ".", "directory where the backup of the lost cluster is saved")
+	managementClusterLossCmd.MarkFlagRequired("new-management-cluster")
+	runCmd.AddCommand(managementClusterLossCmd)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/deployer"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/server"
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var serveAddr string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve REST API which runs deploy and uninstall as jobs and reports fleet status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		jobTypes := map[string]server.JobFunc{
+			"deploy":    deployer.Deploy,
+			"uninstall": deployer.Uninstall,
+		}
+		// token is read from the environment so that it doesn't show in the process list
+		srv := server.NewServer(logger, cfg, jobTypes, os.Getenv("MULTICLUSTER_API_TOKEN"))
+		return srv.ListenAndServe(ctrl.SetupSignalHandler(), serveAddr)
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "address to listen on, a non-loopback address requires MULTICLUSTER_API_TOKEN")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/sops"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var sopsCmd = &cobra.Command{
+	Use:   "sops",
+	Short: "Manage the age key used by Flux to decrypt SOPS encrypted manifests",
+}
+
+var sopsInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate or import the age key and write .sops.yaml with its public key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		s := sops.NewSOPS(logger, cfg.SOPS)
+		publicKey, err := s.EnsureAgeKey()
+		if err != nil {
+			return err
+		}
+		if err := s.WriteConfig(publicKey); err != nil {
+			return err
+		}
+		fmt.Println(publicKey)
+		return nil
+	},
+}
+
+var sopsSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Create or update the decryption secret on running management clusters and tenant secrets on workload clusters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		if !cfg.SOPS.Enabled {
+			return fmt.Errorf("sops is not enabled in config")
+		}
+
+		s := sops.NewSOPS(logger, cfg.SOPS)
+		for _, name := range cfg.ManagementClusterNames() {
+			clusterAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, name)
+			if err != nil {
+				logger.Info("Skipping cluster, it is not available", "cluster", name, "reason", err.Error())
+				continue
+			}
+			namespaces := append([]string{config.FluxNamespace}, cfg.ManagedClusters(name)...)
+			if err := s.CreateDecryptionSecret(clusterAuth.Clientset, namespaces...); err != nil {
+				return fmt.Errorf("cluster %s: %w", name, err)
+			}
+		}
+
+		var workloadClusters []*config.ClusterConfig
+		for i := range cfg.Clusters {
+			if cfg.Clusters[i].Provider != "kind" && cfg.Clusters[i].ManagementCluster != "" {
+				workloadClusters = append(workloadClusters, &cfg.Clusters[i])
+			}
+		}
+		return syncTenantSecrets(cfg, workloadClusters)
+	},
+}
+
+func init() {
+	sopsCmd.AddCommand(sopsInitCmd)
+	sopsCmd.AddCommand(sopsSyncCmd)
+	rootCmd.AddCommand(sopsCmd)
+}
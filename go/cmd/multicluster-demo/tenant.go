@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tenant"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tenantSpec          generator.Tenant
+	tenantClusters      string
+	tenantVerifyTimeout time.Duration
+)
+
+var tenantCmd = &cobra.Command{
+	Use:   "tenant",
+	Short: "Onboard and offboard tenants, manifests are generated in tenants/ and cluster directories",
+}
+
+var tenantAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Generate tenant manifests and add the tenant to the selected clusters",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		clusters, err := cfg.SelectWorkloadClusters(tenantClusters)
+		if err != nil {
+			return err
+		}
+
+		tenantSpec.Name = args[0]
+		if tenantSpec.Namespace == "" {
+			tenantSpec.Namespace = tenantSpec.Name
+		}
+		if err := generator.NewGenerator(logger, cfg).AddTenant(tenantSpec, clusters); err != nil {
+			return err
+		}
+		return syncTenantSecrets(cfg, clusters)
+	},
+}
+
+var tenantRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove the tenant from all clusters and delete its manifests",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		return generator.NewGenerator(logger, cfg).RemoveTenant(args[0])
+	},
+}
+
+var tenantVerifyCmd = &cobra.Command{
+	Use:   "verify <name>",
+	Short: "Wait for the tenant to be reconciled and check its quota, limit range and network policy on each cluster",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+		namespace, clusters, err := generator.NewGenerator(logger, cfg).TenantPlacement(args[0])
+		if err != nil {
+			return err
+		}
+		if len(clusters) == 0 {
+			return fmt.Errorf("tenant %s is not added to any cluster", args[0])
+		}
+		return tenant.NewVerifier(logger, cfg).Verify(args[0], namespace, clusters, tenantVerifyTimeout)
+	},
+}
+
+// syncTenantSecrets creates repository and decryption secrets of the tenants added to the clusters,
+// clusters which are not running yet are skipped, deploy creates the secrets when they are
+func syncTenantSecrets(cfg *config.Config, clusters []*config.ClusterConfig) error {
+	gen := generator.NewGenerator(logger, cfg)
+	for _, cluster := range clusters {
+		tenants, err := gen.ClusterTenants(cluster)
+		if err != nil {
+			return err
+		}
+		if len(tenants) == 0 {
+			continue
+		}
+		clusterAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, cluster.Name)
+		if err != nil {
+			logger.Info("Skipping cluster, it is not available", "cluster", cluster.Name, "reason", err.Error())
+			continue
+		}
+		if err := tenant.CreateSecrets(logger, cfg, clusterAuth.Clientset, tenants); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	tenantAddCmd.Flags().StringVar(&tenantSpec.RepoURL, "repo", "", "URL of the tenant git repository")
+	tenantAddCmd.Flags().StringVar(&tenantSpec.Branch, "branch", "main", "branch of the tenant repository")
+	tenantAddCmd.Flags().StringVar(&tenantSpec.Path, "path", "./", "path to the manifests in the tenant repository")
+	tenantAddCmd.Flags().StringVar(&tenantSpec.Namespace, "namespace", "", "namespace of the tenant, defaults to the tenant name")
+	tenantAddCmd.Flags().StringVar(&tenantSpec.Environment, "env", "dev", "environment overlay in tenants/")
+	tenantAddCmd.Flags().StringVar(&tenantClusters, "clusters", "", "comma separated cluster names or a label selector on cluster labels from config")
+	tenantAddCmd.MarkFlagRequired("repo")
+	tenantAddCmd.MarkFlagRequired("clusters")
+
+	tenantVerifyCmd.Flags().DurationVar(&tenantVerifyTimeout, "timeout", 10*time.Minute, "how long to wait for each cluster to reconcile the tenant")
+
+	tenantCmd.AddCommand(tenantAddCmd, tenantRemoveCmd, tenantVerifyCmd)
+	rootCmd.AddCommand(tenantCmd)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/spf13/cobra"
+)
+
+var (
+	upgradeFluxTo       string
+	upgradeFluxWaveSize int
+	upgradeFluxDryRun   bool
+
+	upgradeKubernetesTo     string
+	upgradeKubernetesResume bool
+	upgradeKubernetesCheck  bool
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade platform components across the fleet",
+}
+
+var upgradeFluxCmd = &cobra.Command{
+	Use:   "flux",
+	Short: "Upgrade Flux on the management cluster first and then on workload clusters in waves, rolling back clusters which fail to become ready",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		upgrade, err := fluxcd.NewFleetUpgrade(logger, cfg, upgradeFluxTo, upgradeFluxWaveSize)
+		if err != nil {
+			return err
+		}
+
+		if upgradeFluxDryRun {
+			stages, err := upgrade.Stages()
+			if err != nil {
+				return err
+			}
+			for i, stage := range stages {
+				fmt.Printf("stage %d:\n", i+1)
+				for _, name := range fluxcd.StageClusterNames(stage) {
+					fmt.Printf("  %s\n", name)
+				}
+			}
+			return nil
+		}
+
+		return upgrade.Run()
+	},
+}
+
+var upgradeKubernetesCmd = &cobra.Command{
+	Use:   "kubernetes <cluster>",
+	Short: "Upgrade Kubernetes version of a workload cluster in place by rolling its control plane and machine deployments",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadConfig(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		upgrade, err := capi.NewKubernetesUpgrade(logger, cfg, args[0], upgradeKubernetesTo)
+		if err != nil {
+			return err
+		}
+
+		if upgradeKubernetesCheck {
+			current, err := upgrade.Check()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s can be upgraded from %s to %s\n", args[0], current, upgradeKubernetesTo)
+			return nil
+		}
+
+		runErr := upgrade.Run(upgradeKubernetesResume)
+		// timings are useful even if the upgrade was paused half way
+		if err := upgrade.PrintTimings(os.Stdout); err != nil {
+			return err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	upgradeFluxCmd.Flags().StringVar(&upgradeFluxTo, "to", "", "Flux version to upgrade to, must be vendored in k8s-platform/flux")
+	upgradeFluxCmd.Flags().IntVar(&upgradeFluxWaveSize, "wave-size", 1, "number of workload clusters upgraded at the same time")
+	upgradeFluxCmd.Flags().BoolVar(&upgradeFluxDryRun, "dry-run", false, "only print upgrade stages")
+	upgradeFluxCmd.MarkFlagRequired("to")
+	upgradeCmd.AddCommand(upgradeFluxCmd)
+
+	upgradeKubernetesCmd.Flags().StringVar(&upgradeKubernetesTo, "to", "", "Kubernetes version to upgrade to, e.g. 1.29.1")
+	upgradeKubernetesCmd.Flags().BoolVar(&upgradeKubernetesResume, "resume", false, "unpause the cluster after a failed health check and continue watching the rollout")
+	upgradeKubernetesCmd.Flags().BoolVar(&upgradeKubernetesCheck, "check", false, "only check that the upgrade is allowed")
+	upgradeKubernetesCmd.MarkFlagRequired("to")
+	upgradeCmd.AddCommand(upgradeKubernetesCmd)
+	rootCmd.AddCommand(upgradeCmd)
+}
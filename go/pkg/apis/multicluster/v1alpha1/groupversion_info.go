@@ -0,0 +1,27 @@
+// Package v1alpha1 contains versioned API types of the fleet configuration. The same MultiCluster
+// type is read from the config file and served by the CRD watched by the operator. Spec has the
+// structure of the unversioned config.yaml, see Load. It is defaulted and validated by config.FromSpec.
+// +kubebuilder:object:generate=true
+// +groupName=multicluster.olga-mir.github.io
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+const (
+	GroupName = "multicluster.olga-mir.github.io"
+	Kind      = "MultiCluster"
+)
+
+var (
+	// GroupVersion is group version used to register these objects
+	GroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,36 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Load decodes a config file, which is either a MultiCluster resource or unversioned config.yaml.
+// Unversioned config becomes the spec of MultiCluster without a name. Unknown fields are an error.
+func Load(data []byte) (*MultiCluster, error) {
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := sigsyaml.Unmarshal(data, &typeMeta); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	multiCluster := &MultiCluster{}
+	if typeMeta.APIVersion == "" && typeMeta.Kind == "" {
+		if err := sigsyaml.UnmarshalStrict(data, &multiCluster.Spec); err != nil {
+			return nil, fmt.Errorf("failed to parse unversioned config: %w", err)
+		}
+	} else {
+		if typeMeta.APIVersion != GroupVersion.String() || typeMeta.Kind != Kind {
+			return nil, fmt.Errorf("unsupported config %s %s, supported: %s %s", typeMeta.APIVersion, typeMeta.Kind, GroupVersion, Kind)
+		}
+		if err := sigsyaml.UnmarshalStrict(data, multiCluster); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", Kind, err)
+		}
+	}
+
+	multiCluster.SetGroupVersionKind(GroupVersion.WithKind(Kind))
+	return multiCluster, nil
+}
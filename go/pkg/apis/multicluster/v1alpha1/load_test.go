@@ -0,0 +1,60 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const unversioned = `
+clusters:
+  - name: "cluster-mgmt"
+    provider: "aws"
+    kubernetesVersion: "1.28.5"
+    flux:
+      keyPath: "/keys/flux"
+      version: "2.2.2"
+    aws:
+      region: "ap-southeast-2"
+  - name: "cluster-01"
+    managementCluster: "cluster-mgmt"
+    provider: "aws"
+    flux:
+      keyPath: "/keys/flux"
+    labels:
+      env: "dev"
+github:
+  user: "olga-mir"
+  repoName: "k8s-multi-cluster"
+kubeconfigPath: "/tmp/kubeconfig"
+tenants:
+  - name: "team-1"
+    quota:
+      cpu: "8"
+`
+
+func TestLoad(t *testing.T) {
+	legacy, err := Load([]byte(unversioned))
+	if err != nil {
+		t.Fatalf("Load() unversioned error = %v", err)
+	}
+	if legacy.APIVersion != GroupVersion.String() || legacy.Kind != "MultiCluster" {
+		t.Errorf("Load() type = %s %s", legacy.APIVersion, legacy.Kind)
+	}
+
+	indented := "  " + strings.ReplaceAll(strings.TrimSpace(unversioned), "\n", "\n  ")
+	versioned, err := Load([]byte("apiVersion: multicluster.olga-mir.github.io/v1alpha1\nkind: MultiCluster\nmetadata:\n  name: fleet\nspec:\n" + indented))
+	if err != nil {
+		t.Fatalf("Load() versioned error = %v", err)
+	}
+	if versioned.Name != "fleet" || !reflect.DeepEqual(versioned.Spec, legacy.Spec) {
+		t.Errorf("Load() versioned spec differs from unversioned: %+v", versioned.Spec)
+	}
+
+	if _, err := Load([]byte("apiVersion: multicluster.olga-mir.github.io/v1\nkind: MultiCluster\n")); err == nil {
+		t.Error("Load() accepted unsupported version")
+	}
+	if _, err := Load([]byte("clusters:\n  - name: a\n    kubernetesVesion: 1.28.5\n")); err == nil {
+		t.Error("Load() accepted unknown field")
+	}
+}
@@ -0,0 +1,235 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MultiClusterSpec defines the fleet, field names are the keys of config.yaml
+type MultiClusterSpec struct {
+	Clusters []ClusterSpec `json:"clusters,omitempty"`
+	Github   GithubSpec    `json:"github,omitempty"`
+	// KubeconfigPath is the kubeconfig the CLI adds clusters to, K8S_MULTI_KUBECONFIG is used if it is not set.
+	// It is ignored by the operator.
+	KubeconfigPath string     `json:"kubeconfigPath,omitempty"`
+	Mirror         MirrorSpec `json:"mirror,omitempty"`
+	CAPI           CAPISpec   `json:"capi,omitempty"`
+	IPAM           IPAMSpec   `json:"ipam,omitempty"`
+	// AWSIdentities are AWS accounts or roles which clusters are created with, see AWSSpec.Identity
+	AWSIdentities []AWSIdentity `json:"awsIdentities,omitempty"`
+	SOPS          SOPSSpec      `json:"sops,omitempty"`
+	// AddonGroups are sets of add-ons shared by clusters, referenced by name from ClusterSpec.AddonGroups
+	AddonGroups []AddonGroup `json:"addonGroups,omitempty"`
+	// Tenants define sizing of tenants onboarded with `tenant add`, tenants which are not listed get defaults
+	Tenants  []TenantSpec `json:"tenants,omitempty"`
+	Operator OperatorSpec `json:"operator,omitempty"`
+}
+
+type GithubSpec struct {
+	User     string `json:"user,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	RepoName string `json:"repoName,omitempty"`
+}
+
+type ClusterSpec struct {
+	Name string `json:"name"`
+	// Provider is "aws" for clusters created by CAPA, the temporary management cluster is implicit
+	Provider          string   `json:"provider,omitempty"`
+	KubernetesVersion string   `json:"kubernetesVersion,omitempty"`
+	PodCIDR           string   `json:"podCIDR,omitempty"`
+	ServiceCIDR       string   `json:"serviceCIDR,omitempty"`
+	ManagementCluster string   `json:"managementCluster,omitempty"`
+	Flux              FluxSpec `json:"flux,omitempty"`
+	CNI               CNISpec  `json:"cni,omitempty"`
+	AWS               AWSSpec  `json:"aws,omitempty"`
+	Mesh              MeshSpec `json:"mesh,omitempty"`
+	// Labels are used to select clusters, e.g. by `tenant add --clusters`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Add-ons installed by CAAPH, Addons of the cluster override add-ons with the same name from AddonGroups
+	AddonGroups              []string `json:"addonGroups,omitempty"`
+	Addons                   []Addon  `json:"addons,omitempty"`
+	ControlPlaneMachineCount int      `json:"controlPlaneMachineCount,omitempty"`
+	WorkerMachineCount       int      `json:"workerMachineCount,omitempty"`
+}
+
+type FluxSpec struct {
+	// KeyPath is a shortcut for KeySource of "file" type
+	KeyPath   string       `json:"keyPath,omitempty"`
+	KeySource SecretSource `json:"keySource,omitempty"`
+	Version   string       `json:"version,omitempty"`
+	Namespace string       `json:"namespace,omitempty"`
+}
+
+// SecretSource defines where a secret is read from: "file", "env", "command" or "kubernetes"
+type SecretSource struct {
+	// +kubebuilder:validation:Enum=file;env;command;kubernetes
+	Type      string   `json:"type,omitempty"`
+	Path      string   `json:"path,omitempty"`
+	Env       string   `json:"env,omitempty"`
+	Command   []string `json:"command,omitempty"`
+	Cluster   string   `json:"cluster,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Key       string   `json:"key,omitempty"`
+}
+
+// CNISpec defines CNI installed by CAAPH, Values is a YAML document with Helm values
+type CNISpec struct {
+	// +kubebuilder:validation:Enum=cilium;calico
+	Type    string `json:"type,omitempty"`
+	Version string `json:"version,omitempty"`
+	Values  string `json:"values,omitempty"`
+}
+
+// Addon is a Helm chart installed on the cluster by CAAPH
+type Addon struct {
+	Name      string `json:"name"`
+	RepoURL   string `json:"repoURL"`
+	Chart     string `json:"chart"`
+	Version   string `json:"version"`
+	Namespace string `json:"namespace,omitempty"`
+	Values    string `json:"values,omitempty"`
+}
+
+type AddonGroup struct {
+	Name   string  `json:"name"`
+	Addons []Addon `json:"addons,omitempty"`
+}
+
+// TenantSpec sets guardrails of the tenant namespace, values are Kubernetes quantities
+type TenantSpec struct {
+	Name   string       `json:"name"`
+	Quota  TenantQuota  `json:"quota,omitempty"`
+	Limits TenantLimits `json:"limits,omitempty"`
+	// KeySource is the SSH key of the tenant repository, required when it is pulled over ssh://
+	KeySource SecretSource `json:"keySource,omitempty"`
+}
+
+type TenantQuota struct {
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Pods   string `json:"pods,omitempty"`
+}
+
+type TenantLimits struct {
+	DefaultCPU           string `json:"defaultCPU,omitempty"`
+	DefaultMemory        string `json:"defaultMemory,omitempty"`
+	DefaultRequestCPU    string `json:"defaultRequestCPU,omitempty"`
+	DefaultRequestMemory string `json:"defaultRequestMemory,omitempty"`
+}
+
+// MeshSpec defines Cilium Cluster Mesh membership, clusters with the same Label are connected
+type MeshSpec struct {
+	Label string `json:"label,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=255
+	ClusterID int `json:"clusterID,omitempty"`
+}
+
+type AWSSpec struct {
+	SSHKeyName              string `json:"sshKeyName,omitempty"`
+	Region                  string `json:"region,omitempty"`
+	ControlPlaneMachineType string `json:"controlPlaneMachineType,omitempty"`
+	NodeMachineType         string `json:"nodeMachineType,omitempty"`
+	// Identity is a name from AWSIdentities
+	Identity string `json:"identity,omitempty"`
+}
+
+// AWSIdentity is realised as CAPA AWSClusterStaticIdentity ("static") or AWSClusterRoleIdentity ("role")
+type AWSIdentity struct {
+	Name               string `json:"name"`
+	Type               string `json:"type"`
+	AccessKeyIDEnv     string `json:"accessKeyIDEnv,omitempty"`
+	SecretAccessKeyEnv string `json:"secretAccessKeyEnv,omitempty"`
+	RoleARN            string `json:"roleARN,omitempty"`
+	ExternalID         string `json:"externalID,omitempty"`
+	SourceIdentity     string `json:"sourceIdentity,omitempty"`
+}
+
+// CAPISpec defines Cluster API providers in clusterctl format "name:version"
+type CAPISpec struct {
+	Providers Providers `json:"providers,omitempty"`
+}
+
+type Providers struct {
+	Core           string   `json:"core,omitempty"`
+	Bootstrap      []string `json:"bootstrap,omitempty"`
+	ControlPlane   []string `json:"controlPlane,omitempty"`
+	Infrastructure []string `json:"infrastructure,omitempty"`
+	Addon          []string `json:"addon,omitempty"`
+}
+
+// IPAMSpec defines address pools of pod and service CIDRs
+type IPAMSpec struct {
+	PodCIDRPool         string `json:"podCIDRPool,omitempty"`
+	PodPrefixLength     int    `json:"podPrefixLength,omitempty"`
+	ServiceCIDRPool     string `json:"serviceCIDRPool,omitempty"`
+	ServicePrefixLength int    `json:"servicePrefixLength,omitempty"`
+	StatePath           string `json:"statePath,omitempty"`
+}
+
+type SOPSSpec struct {
+	Enabled    bool   `json:"enabled,omitempty"`
+	AgeKeyPath string `json:"ageKeyPath,omitempty"`
+}
+
+type MirrorSpec struct {
+	Path     string `json:"path,omitempty"`
+	Registry string `json:"registry,omitempty"`
+}
+
+type OperatorSpec struct {
+	Image string `json:"image,omitempty"`
+}
+
+// Condition types of MultiCluster status
+const (
+	ConditionConfigValid   = "ConfigValid"
+	ConditionKubeconfig    = "KubeconfigReady"
+	ConditionSecrets       = "SecretsReady"
+	ConditionGenerated     = "Generated"
+	ConditionClustersReady = "ClustersReady"
+	ConditionReady         = "Ready"
+)
+
+// MultiClusterStatus is reported by the operator
+type MultiClusterStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	Clusters           []ClusterStatus    `json:"clusters,omitempty"`
+}
+
+// ClusterStatus summarises state of a CAPI cluster
+type ClusterStatus struct {
+	Name                string `json:"name"`
+	Phase               string `json:"phase"`
+	InfrastructureReady bool   `json:"infrastructureReady"`
+	ControlPlaneReady   bool   `json:"controlPlaneReady"`
+	Ready               bool   `json:"ready"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// MultiCluster is the fleet of clusters
+type MultiCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MultiClusterSpec   `json:"spec,omitempty"`
+	Status MultiClusterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MultiClusterList contains a list of MultiCluster
+type MultiClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MultiCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MultiCluster{}, &MultiClusterList{})
+}
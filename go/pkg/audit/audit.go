@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Actions of records, API requests are recorded as create, update, patch and delete
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionPatch  = "patch"
+	ActionDelete = "delete"
+	ActionInit   = "init"
+	ActionMove   = "move"
+	ActionPush   = "push"
+)
+
+// ObjectRef refers to the object changed by the tool. Objects which are not Kubernetes resources, e.g. kubeconfig
+// file or git branch, have only Kind and Name.
+type ObjectRef struct {
+	APIVersion  string `json:"apiVersion,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// Record is a line of the audit log
+type Record struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Cluster string    `json:"cluster,omitempty"`
+	Object  ObjectRef `json:"object"`
+	// Diff summarises the change by paths of changed fields, values are not recorded as they may be secret
+	Diff    string `json:"diff,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+	TraceID string `json:"traceID,omitempty"`
+}
+
+// ActorEnv overrides identity of the operator of the tool, it is user@host by default
+const ActorEnv = "MULTICLUSTER_ACTOR"
+
+var (
+	mu     sync.Mutex
+	output io.Writer
+	actor  = currentActor()
+)
+
+// Setup opens the audit log, records are appended to the file at path, "-" writes them to stdout and
+// empty path disables the audit log. Returned function closes the file.
+func Setup(path string) (func() error, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	switch path {
+	case "":
+		output = nil
+		return func() error { return nil }, nil
+	case "-":
+		output = os.Stdout
+		return func() error { return nil }, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	output = f
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		output = nil
+		return f.Close()
+	}, nil
+}
+
+// DefaultPath is the audit log in the home directory, it is shared by all runs of the tool
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".multicluster-demo", "audit.jsonl")
+}
+
+// Log appends the record to the audit log, time and actor are set if they are empty
+func Log(r Record) {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	if r.Actor == "" {
+		r.Actor = actor
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if output == nil {
+		return
+	}
+	// a record is written in a single write so that concurrent runs appending to the same file don't interleave
+	if _, err := output.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write audit record: %s\n", err)
+	}
+}
+
+// LogResult is Log for operations which are not API requests, err is recorded if the operation failed
+func LogResult(r Record, err error) {
+	if err != nil {
+		r.Error = err.Error()
+	}
+	Log(r)
+}
+
+func currentActor() string {
+	if actor := os.Getenv(ActorEnv); actor != "" {
+		return actor
+	}
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want ObjectRef
+	}{
+		{"/api/v1/namespaces/flux-system/secrets/flux-system",
+			ObjectRef{APIVersion: "v1", Resource: "secrets", Namespace: "flux-system", Name: "flux-system"}},
+		{"/api/v1/namespaces/flux-system/secrets",
+			ObjectRef{APIVersion: "v1", Resource: "secrets", Namespace: "flux-system"}},
+		{"/api/v1/namespaces/cluster-01",
+			ObjectRef{APIVersion: "v1", Resource: "namespaces", Name: "cluster-01"}},
+		{"/api/v1/namespaces/cluster-01/finalize",
+			ObjectRef{APIVersion: "v1", Resource: "namespaces", Name: "cluster-01", Subresource: "finalize"}},
+		{"/apis/kustomize.toolkit.fluxcd.io/v1/namespaces/flux-system/kustomizations/flux-system",
+			ObjectRef{APIVersion: "kustomize.toolkit.fluxcd.io/v1", Resource: "kustomizations", Namespace: "flux-system", Name: "flux-system"}},
+		{"/apis/multicluster.olga-mir.github.io/v1alpha1/namespaces/multicluster-system/multiclusters/fleet/status",
+			ObjectRef{APIVersion: "multicluster.olga-mir.github.io/v1alpha1", Resource: "multiclusters", Namespace: "multicluster-system", Name: "fleet", Subresource: "status"}},
+		{"/apis/apiextensions.k8s.io/v1/customresourcedefinitions",
+			ObjectRef{APIVersion: "apiextensions.k8s.io/v1", Resource: "customresourcedefinitions"}},
+	}
+	for _, tt := range tests {
+		got, ok := parsePath(tt.path)
+		if !ok || got != tt.want {
+			t.Errorf("parsePath(%s) = %+v, %v, want %+v", tt.path, got, ok, tt.want)
+		}
+	}
+	if _, ok := parsePath("/version"); ok {
+		t.Error("parsePath(/version) is a resource")
+	}
+}
+
+func TestDiffPaths(t *testing.T) {
+	oldObj := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "flux-system", "labels": map[string]interface{}{"app": "flux"}},
+		"spec":     map[string]interface{}{"suspend": false, "interval": "10m", "template": map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{"a"}}}},
+	}
+	newObj := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "flux-system", "labels": map[string]interface{}{"app": "flux", "env": "dev"}},
+		"spec":     map[string]interface{}{"suspend": true, "interval": "10m", "template": map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{"b"}}}},
+	}
+	want := []string{"metadata.labels.env", "spec.suspend", "spec.template.spec"}
+	if got := diffPaths(oldObj, newObj, "", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffPaths() = %v, want %v", got, want)
+	}
+	if got := diffPaths(oldObj, oldObj, "", 0); got != nil {
+		t.Errorf("diffPaths() of equal objects = %v", got)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	existing := `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"flux-system","namespace":"flux-system","resourceVersion":"1"},"data":{"identity":"b2xk"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(existing))
+		case http.MethodPost:
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"AlreadyExists","code":409}`))
+		default:
+			w.Write([]byte(existing))
+		}
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	closeLog, err := Setup(file)
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	config := &rest.Config{Host: server.URL}
+	WrapConfig(config, "cluster-mgmt")
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := clientset.CoreV1().Secrets("flux-system")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "flux-system", Namespace: "flux-system"},
+		Data:       map[string][]byte{"identity": []byte("new"), "known_hosts": []byte("github.com")},
+	}
+	ctx := context.Background()
+	secrets.Create(ctx, secret, metav1.CreateOptions{})
+	secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	secrets.Patch(ctx, "flux-system", types.MergePatchType, []byte(`{"metadata":{"labels":{"env":null}}}`), metav1.PatchOptions{})
+	secrets.Delete(ctx, "flux-system", metav1.DeleteOptions{})
+	secrets.Get(ctx, "flux-system", metav1.GetOptions{})
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid record %s: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+
+	want := []struct {
+		action, diff string
+		code         int
+	}{
+		{ActionCreate, "data.identity, data.known_hosts, metadata.name, metadata.namespace", http.StatusConflict},
+		{ActionUpdate, "data.identity, data.known_hosts", http.StatusOK},
+		{ActionPatch, "metadata.labels.env", http.StatusOK},
+		{ActionDelete, "", http.StatusOK},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, w := range want {
+		r := records[i]
+		if r.Action != w.action || r.Diff != w.diff || r.Code != w.code || r.Cluster != "cluster-mgmt" || r.Actor == "" {
+			t.Errorf("record %d = %+v, want %s %q %d", i, r, w.action, w.diff, w.code)
+		}
+		if r.Object.Resource != "secrets" || r.Object.Namespace != "flux-system" || r.Object.Name != "flux-system" {
+			t.Errorf("record %d object = %+v", i, r.Object)
+		}
+		if strings.Contains(r.Diff, "bmV3") || strings.Contains(r.Diff, "new") {
+			t.Errorf("record %d contains secret value: %s", i, r.Diff)
+		}
+	}
+}
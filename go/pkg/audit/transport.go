@@ -0,0 +1,307 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// InCluster is the cluster of the records of the operator, which manages the cluster it runs on
+const InCluster = "in-cluster"
+
+const (
+	// maxDepth limits paths in diffs, e.g. spec.template.spec instead of all fields of the pod template
+	maxDepth = 3
+	maxPaths = 20
+)
+
+var actions = map[string]string{
+	http.MethodPost:   ActionCreate,
+	http.MethodPut:    ActionUpdate,
+	http.MethodPatch:  ActionPatch,
+	http.MethodDelete: ActionDelete,
+}
+
+// WrapConfig records create, update, patch and delete requests made by clients built from the config
+// to the cluster. It must be called before clients are created from the config.
+func WrapConfig(config *rest.Config, cluster string) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &transport{next: rt, cluster: cluster}
+	})
+}
+
+type transport struct {
+	next    http.RoundTripper
+	cluster string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	action, ok := actions[req.Method]
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	ref, ok := parsePath(req.URL.Path)
+	if !ok || !audited(ref) {
+		return t.next.RoundTrip(req)
+	}
+
+	record := Record{Action: action, Cluster: t.cluster, Object: ref}
+	if spanContext := trace.SpanContextFromContext(req.Context()); spanContext.HasTraceID() {
+		record.TraceID = spanContext.TraceID().String()
+	}
+
+	// body of delete requests is DeleteOptions, it doesn't describe the object
+	if action != ActionDelete {
+		var body []byte
+		if req.Body != nil && req.Body != http.NoBody {
+			var err error
+			if body, err = io.ReadAll(req.Body); err != nil {
+				return nil, err
+			}
+			req.Body.Close()
+			req = req.Clone(req.Context())
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		}
+		t.describe(req, &record, body)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Code = resp.StatusCode
+		if resp.StatusCode >= http.StatusBadRequest {
+			record.Error = resp.Status
+		}
+	}
+	Log(record)
+	return resp, err
+}
+
+// describe fills the object reference from the request body and summarises the change
+func (t *transport) describe(req *http.Request, record *Record, body []byte) {
+	var obj interface{}
+	if len(body) > 0 {
+		if err := yaml.Unmarshal(body, &obj); err != nil {
+			record.Diff = "request body is not JSON or YAML"
+			return
+		}
+	}
+	if m, ok := obj.(map[string]interface{}); ok {
+		if apiVersion, ok := m["apiVersion"].(string); ok {
+			record.Object.APIVersion = apiVersion
+		}
+		if kind, ok := m["kind"].(string); ok {
+			record.Object.Kind = kind
+		}
+		if record.Object.Name == "" {
+			metadata, _ := m["metadata"].(map[string]interface{})
+			if name, _ := metadata["name"].(string); name != "" {
+				record.Object.Name = name
+			} else if generateName, _ := metadata["generateName"].(string); generateName != "" {
+				record.Object.Name = generateName
+			}
+		}
+		obj = changeableFields(m, record.Object.Subresource)
+	}
+
+	switch record.Action {
+	case ActionCreate:
+		record.Diff = summary(fieldPaths(obj, "", 0))
+	case ActionPatch:
+		if ops, ok := obj.([]interface{}); ok {
+			record.Diff = summary(jsonPatchPaths(ops))
+		} else {
+			record.Diff = summary(fieldPaths(obj, "", 0))
+		}
+	case ActionUpdate:
+		current, err := t.get(req)
+		if err != nil {
+			record.Diff = fmt.Sprintf("failed to get current object: %s", err)
+			return
+		}
+		paths := diffPaths(changeableFields(current, record.Object.Subresource), obj, "", 0)
+		if len(paths) == 0 {
+			record.Diff = "no changes"
+			return
+		}
+		record.Diff = summary(paths)
+	}
+}
+
+// get returns the object which is replaced by the update request
+func (t *transport) get(req *http.Request) (map[string]interface{}, error) {
+	get, err := http.NewRequestWithContext(req.Context(), http.MethodGet, req.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	get.Header = req.Header.Clone()
+	get.Header.Set("Accept", "application/json")
+	get.Header.Del("Content-Type")
+
+	resp, err := t.next.RoundTrip(get)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	return obj, yaml.Unmarshal(data, &obj)
+}
+
+// parsePath returns the object of resource requests, e.g. /apis/apps/v1/namespaces/default/deployments/nginx
+func parsePath(path string) (ObjectRef, bool) {
+	var ref ObjectRef
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(segments) >= 3 && segments[0] == "api":
+		ref.APIVersion, segments = segments[1], segments[2:]
+	case len(segments) >= 4 && segments[0] == "apis":
+		ref.APIVersion, segments = segments[1]+"/"+segments[2], segments[3:]
+	default:
+		return ref, false
+	}
+
+	// namespace itself is /api/v1/namespaces/<name>, its subresources are status and finalize
+	namespaceSubresource := len(segments) == 3 && (segments[2] == "status" || segments[2] == "finalize")
+	if len(segments) >= 3 && segments[0] == "namespaces" && !namespaceSubresource {
+		ref.Namespace, segments = segments[1], segments[2:]
+	}
+
+	ref.Resource = segments[0]
+	if len(segments) > 1 {
+		ref.Name = segments[1]
+	}
+	if len(segments) > 2 {
+		ref.Subresource = strings.Join(segments[2:], "/")
+	}
+	return ref, true
+}
+
+// audited excludes requests which don't change the cluster state, such as access reviews, and
+// frequent bookkeeping writes such as leader election leases and events
+func audited(ref ObjectRef) bool {
+	return !strings.HasSuffix(ref.Resource, "reviews") && ref.Resource != "leases" && ref.Resource != "events"
+}
+
+// changeableFields removes fields which are set by the API server and the fields which are not changed
+// by the request: status is changed only by the status subresource
+func changeableFields(obj map[string]interface{}, subresource string) map[string]interface{} {
+	obj = shallowCopy(obj)
+	delete(obj, "apiVersion")
+	delete(obj, "kind")
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		metadata = shallowCopy(metadata)
+		for _, field := range []string{"resourceVersion", "managedFields", "generation", "creationTimestamp", "uid", "selfLink"} {
+			delete(metadata, field)
+		}
+		obj["metadata"] = metadata
+	}
+	switch subresource {
+	case "":
+		delete(obj, "status")
+	case "status":
+		return map[string]interface{}{"status": obj["status"]}
+	}
+	return obj
+}
+
+func shallowCopy(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// diffPaths returns paths of the fields which differ between old and new objects
+func diffPaths(oldObj, newObj interface{}, path string, depth int) []string {
+	oldMap, oldIsMap := oldObj.(map[string]interface{})
+	newMap, newIsMap := newObj.(map[string]interface{})
+	if depth < maxDepth && (oldIsMap || newIsMap) && (oldIsMap || oldObj == nil) && (newIsMap || newObj == nil) {
+		keys := map[string]bool{}
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		var paths []string
+		for _, k := range sortedKeys(keys) {
+			paths = append(paths, diffPaths(oldMap[k], newMap[k], join(path, k), depth+1)...)
+		}
+		return paths
+	}
+	if reflect.DeepEqual(oldObj, newObj) {
+		return nil
+	}
+	return []string{path}
+}
+
+// fieldPaths returns paths of the fields set in a new object or a merge patch, including fields removed by null
+func fieldPaths(obj interface{}, path string, depth int) []string {
+	m, ok := obj.(map[string]interface{})
+	if !ok || depth >= maxDepth || len(m) == 0 {
+		if path == "" {
+			return nil
+		}
+		return []string{path}
+	}
+	keys := map[string]bool{}
+	for k := range m {
+		keys[k] = true
+	}
+	var paths []string
+	for _, k := range sortedKeys(keys) {
+		paths = append(paths, fieldPaths(m[k], join(path, k), depth+1)...)
+	}
+	return paths
+}
+
+// jsonPatchPaths returns operations of a JSON patch, e.g. "replace /spec/suspend"
+func jsonPatchPaths(ops []interface{}) []string {
+	var paths []string
+	for _, op := range ops {
+		m, _ := op.(map[string]interface{})
+		paths = append(paths, fmt.Sprintf("%v %v", m["op"], m["path"]))
+	}
+	return paths
+}
+
+func summary(paths []string) string {
+	if len(paths) > maxPaths {
+		return fmt.Sprintf("%s and %d more", strings.Join(paths[:maxPaths], ", "), len(paths)-maxPaths)
+	}
+	return strings.Join(paths, ", ")
+}
+
+func sortedKeys(keys map[string]bool) []string {
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
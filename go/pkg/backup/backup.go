@@ -0,0 +1,229 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/viper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// FormatVersion is the version of the backup layout, restore refuses backups of newer versions:
+//
+//	manifest.json            Manifest
+//	capi/<namespace>/        Cluster API objects saved by clusterctl move to directory
+//	flux/bootstrap.yaml      GitRepository and Kustomization created by Flux bootstrap
+//	state/config.yaml        config file the backup was taken with
+//	state/ipam.json          IPAM allocations
+const FormatVersion = 1
+
+const (
+	manifestFile  = "manifest.json"
+	capiDir       = "capi"
+	fluxFile      = "flux/bootstrap.yaml"
+	configFile    = "state/config.yaml"
+	ipamStateFile = "state/ipam.json"
+	archiveSuffix = ".tar.gz"
+
+	// fluxBootstrapName is the name of the GitRepository, the Kustomization and the secret created by Flux bootstrap
+	fluxBootstrapName = "flux-system"
+)
+
+// Manifest describes the backup
+type Manifest struct {
+	FormatVersion     int       `json:"formatVersion"`
+	CreatedAt         time.Time `json:"createdAt"`
+	ManagementCluster string    `json:"managementCluster"`
+	// Namespaces of CAPI Clusters, objects of each namespace are in capi/<namespace>
+	Namespaces []string `json:"namespaces"`
+	// Providers are Cluster API providers configured when the backup was taken
+	Providers config.ProvidersConfig `json:"providers"`
+	Files     []string               `json:"files"`
+}
+
+// fluxBootstrapObjects are created by fluxcd.InstallFluxCD, other Flux objects are applied from the repository.
+// Flux secret is not saved, it is created from the key source in config on restore.
+var fluxBootstrapObjects = []schema.GroupVersionResource{
+	{Group: "source.toolkit.fluxcd.io", Version: "v1", Resource: "gitrepositories"},
+	{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"},
+}
+
+// Backup saves Cluster API objects, Flux bootstrap objects and the state of the tool of the management cluster
+// to a new directory in outputDir, named after the cluster and time. If archive is set, the directory is
+// packed to a tarball. Path of the directory or the tarball is returned.
+func Backup(ctx context.Context, log logr.Logger, cfg *config.Config, managementCluster, outputDir string, archive bool) (path string, err error) {
+	err = metrics.Operation("backup", func() error {
+		return tracing.Run(ctx, "backup", func(ctx context.Context) error {
+			path, err = backup(ctx, log, cfg, managementCluster, outputDir, archive)
+			return err
+		}, tracing.Cluster(managementCluster))
+	})
+	return path, err
+}
+
+func backup(ctx context.Context, log logr.Logger, cfg *config.Config, managementCluster, outputDir string, archive bool) (string, error) {
+	clusterAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, managementCluster)
+	if err != nil {
+		return "", err
+	}
+	mgmtCAPI, err := capi.NewClusterAPI(log, clusterAuth, cfg)
+	if err != nil {
+		return "", fmt.Errorf("error creating Cluster API client: %w", err)
+	}
+
+	manifest := Manifest{
+		FormatVersion:     FormatVersion,
+		CreatedAt:         time.Now().UTC(),
+		ManagementCluster: managementCluster,
+		Providers:         cfg.CAPI.Providers,
+	}
+	dir := filepath.Join(outputDir, managementCluster+"-"+manifest.CreatedAt.Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	if manifest.Namespaces, err = mgmtCAPI.ClusterNamespaces(ctx); err != nil {
+		return "", err
+	}
+	if len(manifest.Namespaces) == 0 {
+		log.Info("No Cluster API clusters found", "cluster", managementCluster)
+	}
+	for _, namespace := range manifest.Namespaces {
+		if err := mgmtCAPI.BackupNamespace(ctx, namespace, filepath.Join(dir, capiDir, namespace)); err != nil {
+			return "", err
+		}
+	}
+
+	log.Info("Saving Flux bootstrap objects", "cluster", managementCluster)
+	saved, err := saveFluxBootstrap(ctx, clusterAuth.Config, filepath.Join(dir, fluxFile))
+	if err != nil {
+		return "", err
+	}
+	if saved {
+		manifest.Files = append(manifest.Files, fluxFile)
+	}
+
+	if configPath := viper.ConfigFileUsed(); configPath != "" {
+		if err := copyFile(configPath, filepath.Join(dir, configFile)); err != nil {
+			return "", fmt.Errorf("failed to save config: %w", err)
+		}
+		manifest.Files = append(manifest.Files, configFile)
+	}
+	if _, err := os.Stat(cfg.IPAM.StatePath); err == nil {
+		if err := copyFile(cfg.IPAM.StatePath, filepath.Join(dir, ipamStateFile)); err != nil {
+			return "", fmt.Errorf("failed to save IPAM state: %w", err)
+		}
+		manifest.Files = append(manifest.Files, ipamStateFile)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+
+	if !archive {
+		log.Info("Backup is complete", "directory", dir, "namespaces", manifest.Namespaces)
+		return dir, nil
+	}
+	tarball := dir + archiveSuffix
+	if err := writeArchive(dir, tarball); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("failed to remove backup directory after archiving: %w", err)
+	}
+	log.Info("Backup is complete", "archive", tarball, "namespaces", manifest.Namespaces)
+	return tarball, nil
+}
+
+// saveFluxBootstrap writes the Flux bootstrap objects without server side fields as a multi-document YAML.
+// It returns false if Flux is not bootstrapped on the cluster.
+func saveFluxBootstrap(ctx context.Context, restConfig *rest.Config, path string) (bool, error) {
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return false, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	var documents []string
+	for _, gvr := range fluxBootstrapObjects {
+		obj, err := dynamicClient.Resource(gvr).Namespace(config.FluxNamespace).Get(ctx, fluxBootstrapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get %s %s: %w", gvr.Resource, fluxBootstrapName, err)
+		}
+		sanitize(obj)
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return false, err
+		}
+		documents = append(documents, string(data))
+	}
+	if len(documents) == 0 {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(documents, "---\n")), 0o600); err != nil {
+		return false, fmt.Errorf("failed to write Flux bootstrap objects: %w", err)
+	}
+	return true, nil
+}
+
+// readManifest reads the manifest of the backup in dir and checks that the backup can be restored
+func readManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a backup: %w", dir, err)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("backup format version %d is not supported, supported versions are 1 to %d", manifest.FormatVersion, FormatVersion)
+	}
+	return manifest, nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o600)
+}
+
+// sanitize removes fields set by the API server, so that the object can be created on another cluster
+func sanitize(obj *unstructured.Unstructured) {
+	for _, field := range []string{"resourceVersion", "uid", "generation", "creationTimestamp", "managedFields", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+}
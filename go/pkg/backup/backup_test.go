@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		manifestFile:                     `{"formatVersion":1}`,
+		"capi/cluster-01/Cluster_x.yaml": "kind: Cluster",
+		fluxFile:                         "kind: GitRepository",
+		ipamStateFile:                    "{}",
+	}
+	for name, content := range files {
+		if err := writeTestFile(filepath.Join(dir, name), content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tarball := filepath.Join(t.TempDir(), "backup"+archiveSuffix)
+	if err := writeArchive(dir, tarball); err != nil {
+		t.Fatalf("writeArchive() error = %v", err)
+	}
+	if info, err := os.Stat(tarball); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("archive mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	out := t.TempDir()
+	if err := extractArchive(tarball, out); err != nil {
+		t.Fatalf("extractArchive() error = %v", err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, content)
+		}
+	}
+}
+
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	for _, name := range []string{"../escape.yaml", "/etc/escape.yaml", "capi/../../escape.yaml"} {
+		tarball := filepath.Join(t.TempDir(), "evil"+archiveSuffix)
+		f, err := os.Create(tarball)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: 1, Typeflag: tar.TypeReg})
+		tw.Write([]byte("x"))
+		tw.Close()
+		gz.Close()
+		f.Close()
+
+		err = extractArchive(tarball, t.TempDir())
+		if err == nil || !strings.Contains(err.Error(), "outside of the backup directory") {
+			t.Errorf("extractArchive() with %s error = %v", name, err)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		version int
+		wantErr bool
+	}{
+		{FormatVersion, false},
+		{FormatVersion + 1, true},
+		{0, true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		data, _ := json.Marshal(Manifest{FormatVersion: tt.version, ManagementCluster: "mgmt"})
+		if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := readManifest(dir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readManifest() version %d error = %v, wantErr %v", tt.version, err, tt.wantErr)
+		}
+		if err == nil && manifest.ManagementCluster != "mgmt" {
+			t.Errorf("readManifest() = %+v", manifest)
+		}
+	}
+	if _, err := readManifest(t.TempDir()); err == nil {
+		t.Error("readManifest() of a directory without manifest succeeded")
+	}
+}
+
+func writeTestFile(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0o600)
+}
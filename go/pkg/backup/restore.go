@@ -0,0 +1,245 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/go-logr/logr"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/kind"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/sops"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// restoreTimeout is how long restore retries clusterctl move, webhooks of freshly installed providers
+// reject objects until their pods are ready
+const restoreTimeout = 5 * time.Minute
+
+// RestoreOptions select what is restored from a backup
+type RestoreOptions struct {
+	// Target is the management cluster the backup is restored to
+	Target string
+	// SkipFlux leaves Flux on the target as it is, e.g. when the target is bootstrapped from its own path
+	// in the repository rather than from the path of the backed up cluster
+	SkipFlux bool
+}
+
+// Restore creates the objects saved by Backup on the target management cluster. src is a backup
+// directory or a tarball. If the target is the kind cluster and it doesn't exist, it is created.
+// Cluster API and Flux are installed on the target if they are missing. Manifest of the restored
+// backup is returned.
+func Restore(ctx context.Context, log logr.Logger, cfg *config.Config, src string, opts RestoreOptions) (manifest *Manifest, err error) {
+	err = metrics.Operation("restore", func() error {
+		return tracing.Run(ctx, "restore", func(ctx context.Context) error {
+			manifest, err = restore(ctx, log, cfg, src, opts)
+			return err
+		}, tracing.Cluster(opts.Target))
+	})
+	return manifest, err
+}
+
+func restore(ctx context.Context, log logr.Logger, cfg *config.Config, src string, opts RestoreOptions) (*Manifest, error) {
+	target := opts.Target
+	dir := src
+	if isArchive(src) {
+		tmp, err := os.MkdirTemp("", "multicluster-restore-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		if err := extractArchive(src, tmp); err != nil {
+			return nil, err
+		}
+		dir = tmp
+	}
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Restoring backup", "managementCluster", manifest.ManagementCluster, "createdAt", manifest.CreatedAt, "target", target)
+
+	if target == config.DefaultKindClusterName {
+		exists, err := utils.HasContext(cfg.KubeconfigPath, config.DefaultKindClusterCtxName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			log.Info("Create `kind` cluster")
+			if err := kind.CreateCluster(cfg.KubeconfigPath); err != nil {
+				return nil, fmt.Errorf("error creating kind cluster: %w", err)
+			}
+		}
+	}
+	clusterAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// CAPI objects are restored before Flux, otherwise Flux would create the Clusters from the repository
+	// before their state (e.g. infrastructure IDs and certificates) is restored
+	if err := restoreClusterAPI(ctx, log, cfg, clusterAuth, dir, manifest); err != nil {
+		return nil, err
+	}
+	if !opts.SkipFlux {
+		if err := restoreFlux(ctx, log, cfg, clusterAuth, dir, manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	if slices.Contains(manifest.Files, ipamStateFile) {
+		if _, err := os.Stat(cfg.IPAM.StatePath); errors.Is(err, os.ErrNotExist) {
+			log.Info("Restoring IPAM state", "path", cfg.IPAM.StatePath)
+			if err := copyFile(filepath.Join(dir, ipamStateFile), cfg.IPAM.StatePath); err != nil {
+				return nil, fmt.Errorf("failed to restore IPAM state: %w", err)
+			}
+		} else {
+			log.Info("IPAM state exists, it is not overwritten", "path", cfg.IPAM.StatePath)
+		}
+	}
+	log.Info("Restore is complete", "target", target, "namespaces", manifest.Namespaces)
+	return manifest, nil
+}
+
+func restoreClusterAPI(ctx context.Context, log logr.Logger, cfg *config.Config, clusterAuth *k8sclient.ClusterAuthInfo, dir string, manifest *Manifest) error {
+	targetCAPI, err := capi.NewClusterAPI(log, clusterAuth, cfg)
+	if err != nil {
+		return fmt.Errorf("error creating Cluster API client: %w", err)
+	}
+	installed, err := targetCAPI.IsInstalled()
+	if err != nil {
+		return err
+	}
+	if !installed {
+		log.Info("Installing Cluster API", "cluster", clusterAuth.ClusterName)
+		if err := targetCAPI.InstallClusterAPI(ctx); err != nil {
+			return fmt.Errorf("error installing Cluster API: %w", err)
+		}
+	}
+	if err := targetCAPI.CreateAWSIdentitySecrets(cfg.AWSIdentities); err != nil {
+		return fmt.Errorf("error creating AWS identity secrets: %w", err)
+	}
+
+	for _, namespace := range manifest.Namespaces {
+		namespaceDir := filepath.Join(dir, capiDir, namespace)
+		deadline := time.Now().Add(restoreTimeout)
+		for {
+			err := targetCAPI.RestoreDirectory(ctx, namespaceDir)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return err
+			}
+			log.Info("Retrying restore of Cluster API objects", "namespace", namespace, "error", err.Error())
+			time.Sleep(15 * time.Second)
+		}
+	}
+	return nil
+}
+
+func restoreFlux(ctx context.Context, log logr.Logger, cfg *config.Config, clusterAuth *k8sclient.ClusterAuthInfo, dir string, manifest *Manifest) error {
+	if cfg.SOPS.Enabled {
+		namespaces := append([]string{config.FluxNamespace}, manifest.Namespaces...)
+		if err := sops.NewSOPS(log, cfg.SOPS).CreateDecryptionSecret(clusterAuth.Clientset, namespaces...); err != nil {
+			return fmt.Errorf("error creating SOPS decryption secret: %w", err)
+		}
+	}
+
+	cluster := cfg.Cluster(clusterAuth.ClusterName)
+	if cluster == nil {
+		cluster = cfg.Cluster(manifest.ManagementCluster)
+	}
+	if cluster == nil {
+		return fmt.Errorf("neither %s nor %s are defined in config, Flux key is unknown", clusterAuth.ClusterName, manifest.ManagementCluster)
+	}
+	flux, err := fluxcd.NewFluxCD(log, cluster.Flux, cfg.Github, clusterAuth)
+	if err != nil {
+		return fmt.Errorf("error creating FluxCD client: %w", err)
+	}
+
+	if !slices.Contains(manifest.Files, fluxFile) {
+		log.Info("Backup has no Flux objects, installing Flux", "cluster", clusterAuth.ClusterName)
+		return flux.InstallFluxCD(ctx)
+	}
+	crdGVR := apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions")
+	installed, err := utils.ResourcesExist(clusterAuth.Config, "", "kustomizations.kustomize.toolkit.fluxcd.io", crdGVR)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		// bootstrap creates the same GitRepository and Kustomization, saved objects are applied below
+		// in case they were changed after bootstrap, e.g. the branch
+		log.Info("Installing Flux", "cluster", clusterAuth.ClusterName)
+		if err := flux.InstallFluxCD(ctx); err != nil {
+			return err
+		}
+	} else if err := flux.CreateFluxSystemSecret(); err != nil {
+		return err
+	}
+	return applyFluxBootstrap(ctx, clusterAuth, filepath.Join(dir, fluxFile))
+}
+
+// applyFluxBootstrap creates or updates the saved Flux bootstrap objects
+func applyFluxBootstrap(ctx context.Context, clusterAuth *k8sclient.ClusterAuthInfo, path string) error {
+	dynamicClient, err := dynamic.NewForConfig(clusterAuth.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		if obj.Object == nil {
+			continue
+		}
+		// each Flux API group has one bootstrap object
+		gvk := obj.GroupVersionKind()
+		index := slices.IndexFunc(fluxBootstrapObjects, func(gvr schema.GroupVersionResource) bool {
+			return gvr.GroupVersion() == gvk.GroupVersion()
+		})
+		if index < 0 {
+			return fmt.Errorf("unexpected object %s %s in %s", gvk, obj.GetName(), path)
+		}
+		client := dynamicClient.Resource(fluxBootstrapObjects[index]).Namespace(obj.GetNamespace())
+		_, err := client.Create(ctx, obj, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			var current *unstructured.Unstructured
+			if current, err = client.Get(ctx, obj.GetName(), metav1.GetOptions{}); err == nil {
+				obj.SetResourceVersion(current.GetResourceVersion())
+				_, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s %s: %w", gvk.Kind, obj.GetName(), err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	capiclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// IsInstalled reports whether Cluster API is installed on the cluster
+func (c *ClusterAPI) IsInstalled() (bool, error) {
+	crdGVR := apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions")
+	return utils.ResourcesExist(c.clusterAuth.Config, "", "clusters."+clusterv1.GroupVersion.Group, crdGVR)
+}
+
+// ClusterNamespaces returns namespaces of the CAPI Clusters managed by this cluster, sorted
+func (c *ClusterAPI) ClusterNamespaces(ctx context.Context) ([]string, error) {
+	clusters := &clusterv1.ClusterList{}
+	if err := c.runtimeClient.List(ctx, clusters); err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %w", err)
+	}
+	seen := map[string]bool{}
+	namespaces := []string{}
+	for _, cluster := range clusters.Items {
+		if !seen[cluster.Namespace] {
+			seen[cluster.Namespace] = true
+			namespaces = append(namespaces, cluster.Namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// BackupNamespace saves Cluster API objects of the namespace to dir by clusterctl move. Unlike pivot, objects
+// stay on this cluster, clusterctl pauses the Clusters while they are saved and resumes them afterwards.
+func (c *ClusterAPI) BackupNamespace(ctx context.Context, namespace, dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	c.log.Info("Saving Cluster API objects", "namespace", namespace, "directory", dir)
+	moveOptions := capiclient.MoveOptions{
+		FromKubeconfig: capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
+		Namespace:      namespace,
+		ToDirectory:    dir,
+	}
+	err := tracing.Run(ctx, "clusterctl move to directory", func(ctx context.Context) error {
+		return c.clusterctlClient.Move(ctx, moveOptions)
+	}, tracing.Cluster(c.clusterAuth.ClusterName), tracing.Namespace(namespace))
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionMove,
+		Cluster: c.clusterAuth.ClusterName,
+		Object:  audit.ObjectRef{Namespace: namespace},
+		Diff:    "paused, saved to " + dir + " and resumed Cluster API objects",
+	}, err)
+	if err != nil {
+		return fmt.Errorf("error saving Cluster API objects of namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// RestoreDirectory creates Cluster API objects saved by BackupNamespace on this cluster and resumes the Clusters.
+// Objects which already exist on the cluster are updated.
+func (c *ClusterAPI) RestoreDirectory(ctx context.Context, dir string) error {
+	c.log.Info("Restoring Cluster API objects", "directory", dir, "cluster", c.clusterAuth.ClusterName)
+	moveOptions := capiclient.MoveOptions{
+		ToKubeconfig:  capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
+		FromDirectory: dir,
+	}
+	err := tracing.Run(ctx, "clusterctl move from directory", func(ctx context.Context) error {
+		return c.clusterctlClient.Move(ctx, moveOptions)
+	}, tracing.Cluster(c.clusterAuth.ClusterName))
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionMove,
+		Cluster: c.clusterAuth.ClusterName,
+		Diff:    "restored Cluster API objects from " + dir,
+	}, err)
+	if err != nil {
+		return fmt.Errorf("error restoring Cluster API objects from %s: %w", dir, err)
+	}
+	return nil
+}
+
+// MachineProviderIDs returns provider IDs of the Machines in the namespaces by "<namespace>/<name>". A Machine which
+// is recreated gets a new provider ID (e.g. EC2 instance ID), so they show whether Machines survived a move.
+// Machines which are not provisioned yet have an empty provider ID.
+func (c *ClusterAPI) MachineProviderIDs(ctx context.Context, namespaces ...string) (map[string]string, error) {
+	providerIDs := map[string]string{}
+	for _, namespace := range namespaces {
+		machines := &clusterv1.MachineList{}
+		if err := c.runtimeClient.List(ctx, machines, runtimeclient.InNamespace(namespace)); err != nil {
+			return nil, fmt.Errorf("failed to list machines in %s: %w", namespace, err)
+		}
+		for _, machine := range machines.Items {
+			providerID := ""
+			if machine.Spec.ProviderID != nil {
+				providerID = *machine.Spec.ProviderID
+			}
+			providerIDs[namespace+"/"+machine.Name] = providerID
+		}
+	}
+	return providerIDs, nil
+}
+
+// ScaleProviders scales controllers of all Cluster API providers on the cluster. Scaled to 0, the cluster
+// stops reconciling the clusters it manages, as if it was lost.
+func (c *ClusterAPI) ScaleProviders(ctx context.Context, replicas int32) error {
+	deployments := c.clusterAuth.Clientset.AppsV1().Deployments("")
+	list, err := deployments.List(ctx, metav1.ListOptions{LabelSelector: clusterv1.ProviderNameLabel})
+	if err != nil {
+		return fmt.Errorf("failed to list provider deployments: %w", err)
+	}
+	for _, deployment := range list.Items {
+		c.log.Info("Scaling provider controller", "namespace", deployment.Namespace, "name", deployment.Name, "replicas", replicas)
+		scale, err := c.clusterAuth.Clientset.AppsV1().Deployments(deployment.Namespace).GetScale(ctx, deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get scale of %s/%s: %w", deployment.Namespace, deployment.Name, err)
+		}
+		scale.Spec.Replicas = replicas
+		if _, err := c.clusterAuth.Clientset.AppsV1().Deployments(deployment.Namespace).UpdateScale(ctx, deployment.Name, scale, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to scale %s/%s: %w", deployment.Namespace, deployment.Name, err)
+		}
+	}
+	return nil
+}
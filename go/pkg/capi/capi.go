@@ -3,21 +3,33 @@ package capi
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
 	capiclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
 	capiconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/mirror"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
 )
 
@@ -26,23 +38,39 @@ type ClusterAPI struct {
 	clusterAuth      *k8sclient.ClusterAuthInfo // TODO - why is this * while in other places it is not? (e.g. flux.go)
 	runtimeClient    runtimeclient.Client
 	clusterctlClient capiclient.Client
+	clusterctlConfig capiconfig.Client
 	kubeconfigPath   string
+	providers        appconfig.ProvidersConfig
+	// AWS_B64ENCODED_CREDENTIALS may be omitted when all clusters use AWS identities
+	awsCredentialsRequired bool
 }
 
 // NewClusterAPI creates a new instance of the ClusterAPI struct. This function initializes
-// the ClusterAPI with the provided logger, authentication information, kubeconfig path,
-// and context name. It returns a pointer to the newly created ClusterAPI instance and
+// the ClusterAPI with the provided logger, authentication information and app config
+// (kubeconfig path and mirror settings are taken from it). It returns a pointer to the newly created ClusterAPI instance and
 // an error if any issues occur during the initialization. Context name has to be provided
 // because it is not part of the authentication information stored in the clusterAuth variable
 // but clusterApi client works with kubeconfig and context name, rather than REST config or clientset
 // Context name is an arbitrary name given to a context inside kubeconfig file. At this stage
-// of CAPI cluster the context for a cluster may not even exist yet in the kubeconfig
-func NewClusterAPI(log logr.Logger, clusterAuth *k8sclient.ClusterAuthInfo, kubeconfigPath string) (*ClusterAPI, error) {
+// of CAPI cluster the context for a cluster may not even exist yet in the kubeconfig.
+// When mirror is configured, providers are installed from the mirror directory instead of GitHub.
+func NewClusterAPI(log logr.Logger, clusterAuth *k8sclient.ClusterAuthInfo, cfg *appconfig.Config) (*ClusterAPI, error) {
 	runtimeScheme := runtime.NewScheme()
 	clusterv1.AddToScheme(runtimeScheme)
+	controlplanev1.AddToScheme(runtimeScheme)
+	clusterctlv1.AddToScheme(runtimeScheme)
 
 	clusterctlConfigPath := utils.RepoRoot() + "/clusters/" + clusterAuth.ClusterName + "/clusterctl.yaml"
 
+	if cfg.Mirror.Path != "" {
+		mirroredConfigPath, err := mirror.WriteClusterctlConfig(clusterctlConfigPath, clusterAuth.ClusterName, cfg.Mirror, cfg.CAPI.Providers)
+		if err != nil {
+			return nil, fmt.Errorf("error creating clusterctl config for mirror: %w", err)
+		}
+		log.Info("Using mirrored providers", "clusterctlConfig", mirroredConfigPath)
+		clusterctlConfigPath = mirroredConfigPath
+	}
+
 	clusterctlConfig, err := capiconfig.New(context.TODO(), clusterctlConfigPath)
 	if err != nil {
 		return nil, fmt.Errorf("error creating clusterctl config: %w", err)
@@ -53,12 +81,7 @@ func NewClusterAPI(log logr.Logger, clusterAuth *k8sclient.ClusterAuthInfo, kube
 		return nil, fmt.Errorf("error creating clusterctl client: %w", err)
 	}
 
-	cfg, err := config.GetConfig()
-	if err != nil {
-		return nil, fmt.Errorf("error getting kubeconfig: %s", err)
-	}
-
-	runtimeClient, err := runtimeclient.New(cfg, runtimeclient.Options{Scheme: runtimeScheme})
+	runtimeClient, err := runtimeclient.New(clusterAuth.Config, runtimeclient.Options{Scheme: runtimeScheme})
 	if err != nil {
 		return nil, fmt.Errorf("error creating client: %s", err)
 	}
@@ -74,27 +97,58 @@ func NewClusterAPI(log logr.Logger, clusterAuth *k8sclient.ClusterAuthInfo, kube
 		clusterAuth:      clusterAuth,
 		runtimeClient:    runtimeClient,
 		clusterctlClient: clusterctlClient,
-		kubeconfigPath:   kubeconfigPath,
+		clusterctlConfig: clusterctlConfig,
+		kubeconfigPath:   cfg.KubeconfigPath,
+		providers:        cfg.CAPI.Providers,
+
+		awsCredentialsRequired: cfg.RequiresAWSControllerCredentials(),
 	}, nil
 }
 
-func (c *ClusterAPI) InstallClusterAPI() error {
-	// Create a clusterctl client
+func (c *ClusterAPI) InstallClusterAPI(ctx context.Context) error {
+	if err := c.ensureAWSCredentials(); err != nil {
+		return err
+	}
 
+	// Versions are pinned in config so that the same versions are installed from GitHub and from the mirror
 	initOptions := capiclient.InitOptions{
 		Kubeconfig:              capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
-		InfrastructureProviders: []string{"aws:v2.3.1"}, // TODO - there is a bug in CAPI init file. infra provider has to be specified explicitely
+		CoreProvider:            c.providers.Core,
+		BootstrapProviders:      c.providers.Bootstrap,
+		ControlPlaneProviders:   c.providers.ControlPlane,
+		InfrastructureProviders: c.providers.Infrastructure, // TODO - there is a bug in CAPI init file. infra provider has to be specified explicitely
+		AddonProviders:          c.providers.Addon,
 	}
 
 	// Install Cluster API components on this cluster.
-	if _, err := c.clusterctlClient.Init(context.TODO(), initOptions); err != nil {
+	err := tracing.Run(ctx, "clusterctl init", func(ctx context.Context) error {
+		_, err := c.clusterctlClient.Init(ctx, initOptions)
+		return err
+	}, tracing.Cluster(c.clusterAuth.ClusterName), attribute.String("capi.infrastructure_providers", strings.Join(c.providers.Infrastructure, ",")))
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionInit,
+		Cluster: c.clusterAuth.ClusterName,
+		Object:  audit.ObjectRef{Kind: "ClusterAPIProviders"},
+		Diff:    "installed providers " + strings.Join(c.providers.Infrastructure, ", "),
+	}, err)
+	if err != nil {
 		return fmt.Errorf("error initializing Cluster API: %w", err)
 	}
 
 	return nil
 }
 
-func (c *ClusterAPI) WaitForWorkloadClusterFullyRunning(name string) error {
+// WaitForWorkloadClusterFullyRunning waits for the cluster to be provisioned and for all CAAPH resources
+// on the management cluster to be Ready. helmChartProxies are names of HelmChartProxies generated for
+// the cluster (CNI and add-ons), a Ready HelmReleaseProxy must exist for each of them.
+func (c *ClusterAPI) WaitForWorkloadClusterFullyRunning(ctx context.Context, name string, helmChartProxies ...string) error {
+	defer metrics.ObserveWait(metrics.WaitClusterRunning, time.Now())
+	return tracing.Run(ctx, "wait cluster running", func(ctx context.Context) error {
+		return c.waitForWorkloadClusterFullyRunning(ctx, name, helmChartProxies...)
+	}, tracing.Cluster(name))
+}
+
+func (c *ClusterAPI) waitForWorkloadClusterFullyRunning(ctx context.Context, name string, helmChartProxies ...string) error {
 
 	// TODO - this name extraction happens twice in the cluster bootstrap.
 	// maybe a workload cluster should maintain a list of its managed clusters
@@ -105,7 +159,7 @@ func (c *ClusterAPI) WaitForWorkloadClusterFullyRunning(name string) error {
 
 	c.log.Info("Wating for CAPI cluster to be provisioned and all system components healthy", "cluster", workloadClusterName)
 
-	err = c.waitForCAPIClusterStateProvisioned(workloadClusterName, workloadClusterName)
+	err = c.waitForCAPIClusterStateProvisioned(ctx, workloadClusterName, workloadClusterName)
 	if err != nil {
 		return fmt.Errorf("error waiting for cluster provisioning: %w", err)
 	}
@@ -125,8 +179,8 @@ func (c *ClusterAPI) WaitForWorkloadClusterFullyRunning(name string) error {
 
 	// TODO - these and other CRDS can be in defaults
 	caaphGVRs := []schema.GroupVersionResource{
-		{Group: "addons.cluster.x-k8s.io", Version: "v1", Resource: "helmchartproxies"},
-		{Group: "addons.cluster.x-k8s.io", Version: "v1", Resource: "helmreleaseproxies"},
+		{Group: "addons.cluster.x-k8s.io", Version: "v1alpha1", Resource: "helmchartproxies"},
+		{Group: "addons.cluster.x-k8s.io", Version: "v1alpha1", Resource: "helmreleaseproxies"},
 	}
 
 	c.log.Info("Wait for CAAPH resources to be Ready")
@@ -136,25 +190,97 @@ func (c *ClusterAPI) WaitForWorkloadClusterFullyRunning(name string) error {
 		return fmt.Errorf("error listing all namespaces with prefix 'cluster-': %w", err)
 	}
 
-	err = utils.WaitAllResourcesReady(*c.clusterAuth, namespaces, caaphGVRs) // TODO - is this blocking?
+	err = utils.WaitAllResourcesReady(ctx, *c.clusterAuth, namespaces, caaphGVRs) // TODO - is this blocking?
 	if err != nil {
 		return fmt.Errorf("error waiting for CAAPH resources to be ready: %w", err)
 	}
 	c.log.Info("All CAAPH resources are ready") // TODO - why this line is never printed?
 
+	// HelmReleaseProxies are created by CAAPH asynchronously, so an add-on which has no
+	// HelmReleaseProxy yet would not be caught by the check above
+	for _, hcp := range helmChartProxies {
+		if err := c.waitForHelmReleaseProxyReady(ctx, workloadClusterName, hcp, 10*time.Minute); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// waitForHelmReleaseProxyReady waits until CAAPH has installed the chart of the HelmChartProxy on the cluster
+func (c *ClusterAPI) waitForHelmReleaseProxyReady(ctx context.Context, clusterName, helmChartProxy string, timeout time.Duration) error {
+	return tracing.Run(ctx, "wait HelmReleaseProxy", func(ctx context.Context) error {
+		return c.pollHelmReleaseProxyReady(ctx, clusterName, helmChartProxy, timeout)
+	}, tracing.Cluster(clusterName), tracing.Namespace(clusterName), tracing.GVR(helmReleaseProxyGVR), attribute.String("caaph.helmchartproxy", helmChartProxy))
+}
+
+func (c *ClusterAPI) pollHelmReleaseProxyReady(ctx context.Context, clusterName, helmChartProxy string, timeout time.Duration) error {
+	c.log.Info("Waiting for HelmReleaseProxy", "cluster", clusterName, "helmChartProxy", helmChartProxy)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for HelmReleaseProxy of %s on cluster %s", helmChartProxy, clusterName)
+		case <-ticker.C:
+			hrps := &unstructured.UnstructuredList{}
+			hrps.SetGroupVersionKind(schema.GroupVersionKind{Group: "addons.cluster.x-k8s.io", Version: "v1alpha1", Kind: "HelmReleaseProxyList"})
+			if err := c.runtimeClient.List(ctx, hrps, runtimeclient.InNamespace(clusterName),
+				runtimeclient.MatchingLabels{
+					clusterv1.ClusterNameLabel: clusterName,
+					helmChartProxyLabel:        helmChartProxy,
+				}); err != nil {
+				return fmt.Errorf("failed to list HelmReleaseProxies: %w", err)
+			}
+
+			for _, hrp := range hrps.Items {
+				if isReady(hrp) {
+					c.log.Info("HelmReleaseProxy is Ready", "name", hrp.GetName())
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// helmChartProxyLabel is set by CAAPH on HelmReleaseProxies created from a HelmChartProxy
+const helmChartProxyLabel = "helmreleaseproxy.addons.cluster.x-k8s.io/helmchartproxy-name"
+
+var (
+	clusterGVR          = clusterv1.GroupVersion.WithResource("clusters")
+	helmReleaseProxyGVR = schema.GroupVersionResource{Group: "addons.cluster.x-k8s.io", Version: "v1alpha1", Resource: "helmreleaseproxies"}
+)
+
+func isReady(obj unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Ready" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
 // waitForCAPIClusterStateProvisioned blocks until the specified cluster reaches the 'Provisioned' state.
 // This function specifically checks the status of the Cluster API custom resource named 'clusterName'
 // within the given 'namespace'. It's important to note that reaching the 'Provisioned' state does not
 // necessarily mean the cluster is fully operational and ready for use. Key components, such as the CNI,
 // might still be in the process of becoming ready. Therefore, additional checks should be performed
 // after this function returns to ensure that all critical components of the cluster are functional.
-func (c *ClusterAPI) waitForCAPIClusterStateProvisioned(clusterName, namespace string) error {
+func (c *ClusterAPI) waitForCAPIClusterStateProvisioned(ctx context.Context, clusterName, namespace string) error {
+	return tracing.Run(ctx, "wait cluster provisioned", func(ctx context.Context) error {
+		return c.pollCAPIClusterStateProvisioned(ctx, clusterName, namespace)
+	}, tracing.Cluster(clusterName), tracing.Namespace(namespace), tracing.GVR(clusterGVR))
+}
+
+func (c *ClusterAPI) pollCAPIClusterStateProvisioned(ctx context.Context, clusterName, namespace string) error {
 	timeout := 15 * time.Minute
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	for {
@@ -177,7 +303,12 @@ func (c *ClusterAPI) waitForCAPIClusterStateProvisioned(clusterName, namespace s
 	}
 }
 
-func (c *ClusterAPI) WaitForAllClustersProvisioning() error {
+func (c *ClusterAPI) WaitForAllClustersProvisioning(ctx context.Context) error {
+	defer metrics.ObserveWait(metrics.WaitCAPIProvisioning, time.Now())
+	return tracing.Run(ctx, "wait all clusters provisioned", c.waitForAllClustersProvisioning, tracing.Cluster(c.clusterAuth.ClusterName))
+}
+
+func (c *ClusterAPI) waitForAllClustersProvisioning(ctx context.Context) error {
 	namespaces, err := utils.ListAllNamespacesWithPrefix(c.clusterAuth.Clientset, "cluster-")
 	if err != nil {
 		return fmt.Errorf("failed to list all namespaces: %w", err)
@@ -192,7 +323,7 @@ func (c *ClusterAPI) WaitForAllClustersProvisioning() error {
 			defer wg.Done()
 			// TODO - need to rework the cluster/namespace relashionship later.
 			//  One namespace should allow more than 1 cluster
-			if err := c.waitForCAPIClusterStateProvisioned(namespace, namespace); err != nil {
+			if err := c.waitForCAPIClusterStateProvisioned(ctx, namespace, namespace); err != nil {
 				errors <- fmt.Errorf("error in namespace %s: %w", namespace, err)
 			}
 		}(ns)
@@ -211,34 +342,85 @@ func (c *ClusterAPI) WaitForAllClustersProvisioning() error {
 	return nil
 }
 
-func (c *ClusterAPI) WaitForClusterDeletion(clusterName, namespace string) error {
-	timeout := 15 * time.Minute
-	c.log.Info("Waiting for cluster to be deleted", "cluster", clusterName, "namespace", namespace)
+// WaitForControlPlaneReady waits for ControlPlaneReady condition of the cluster, from then its API server
+// accepts requests and its kubeconfig can be fetched
+func (c *ClusterAPI) WaitForControlPlaneReady(ctx context.Context, name string) error {
+	defer metrics.ObserveWait(metrics.WaitControlPlane, time.Now())
+	clusterName, _, err := utils.GetCAPIClusterNameAndContext(utils.ClusterNameData{Name: name})
+	if err != nil {
+		return fmt.Errorf("error getting cluster name and context: %w", err)
+	}
+	return tracing.Run(ctx, "wait control plane ready", func(ctx context.Context) error {
+		return c.pollControlPlaneReady(ctx, clusterName, clusterName)
+	}, tracing.Cluster(clusterName), tracing.Namespace(clusterName), tracing.GVR(clusterGVR))
+}
 
-	// Define the timeout for the wait operation
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func (c *ClusterAPI) pollControlPlaneReady(ctx context.Context, clusterName, namespace string) error {
+	c.log.Info("Waiting for control plane to be ready", "cluster", clusterName)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancel()
 
 	for {
+		cluster := &clusterv1.Cluster{}
+		err := c.runtimeClient.Get(ctx, runtimeclient.ObjectKey{Name: clusterName, Namespace: namespace}, cluster)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		// the cluster is not found until Flux applies it from the repository
+		if err == nil && conditions.IsTrue(cluster, clusterv1.ControlPlaneReadyCondition) {
+			return nil
+		}
+
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("timed out waiting for cluster '%s' to be deleted", clusterName)
-		default:
-			cluster := &clusterv1.Cluster{}
-			err := c.runtimeClient.Get(ctx, runtimeclient.ObjectKey{Name: clusterName, Namespace: namespace}, cluster)
-			if err != nil {
-				return nil
-			}
+			return fmt.Errorf("timed out waiting for control plane of cluster '%s' to be ready", clusterName)
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
 
-			// Sleep before the next check
-			time.Sleep(15 * time.Second)
+// DeleteCluster deletes the CAPI Cluster, Cluster API deletes its machines and infrastructure.
+// Use WaitForClusterDeletion to wait until they are deleted.
+func (c *ClusterAPI) DeleteCluster(ctx context.Context, clusterName, namespace string) error {
+	c.log.Info("Deleting cluster", "cluster", clusterName, "namespace", namespace)
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: clusterName, Namespace: namespace}}
+	if err := c.runtimeClient.Delete(ctx, cluster); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete cluster %s: %w", clusterName, err)
+	}
+	return nil
+}
+
+// deletionPollInterval is how often WaitForClusterDeletion checks the cluster
+var deletionPollInterval = 15 * time.Second
+
+// WaitForClusterDeletion waits until the CAPI Cluster is gone. Errors other than NotFound are logged
+// and retried, they must not be mistaken for a deleted cluster.
+func (c *ClusterAPI) WaitForClusterDeletion(ctx context.Context, clusterName, namespace string) error {
+	c.log.Info("Waiting for cluster to be deleted", "cluster", clusterName, "namespace", namespace)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
+
+	for {
+		cluster := &clusterv1.Cluster{}
+		err := c.runtimeClient.Get(ctx, runtimeclient.ObjectKey{Name: clusterName, Namespace: namespace}, cluster)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			c.log.Error(err, "Failed to get cluster, retrying", "cluster", clusterName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for cluster '%s' to be deleted", clusterName)
+		case <-time.After(deletionPollInterval):
 		}
 	}
 }
 
 // GetClusterAuthInfo returns the clientset and rest.Config for the workload cluster.
 // It also updates the kubeconfig with the worklaod cluster config. (TODO - this feels like a side effect, is there a better way to do this?)
-func (c *ClusterAPI) GetClusterAuthInfoForWorkloadCluster(authInfo *k8sclient.ClusterAuthInfo, name string) error {
+func (c *ClusterAPI) GetClusterAuthInfoForWorkloadCluster(ctx context.Context, authInfo *k8sclient.ClusterAuthInfo, name string) error {
 	// translate between this project cluster name (which is more like a role) to the name how CAPI clusters are named
 	workloadClusterName, workloadClusterCtxName, err := utils.GetCAPIClusterNameAndContext(utils.ClusterNameData{Name: name})
 	if err != nil {
@@ -257,7 +439,11 @@ func (c *ClusterAPI) GetClusterAuthInfoForWorkloadCluster(authInfo *k8sclient.Cl
 	c.log.Info("GetClusterAuthInfo for workload cluster", "name", workloadClusterName, "options", getKubeconfigOptions)
 
 	// Get the kubeconfig for the workload cluster
-	workloadKubeconfig, err := c.clusterctlClient.GetKubeconfig(context.TODO(), getKubeconfigOptions)
+	var workloadKubeconfig string
+	err = tracing.Run(ctx, "clusterctl get kubeconfig", func(ctx context.Context) error {
+		workloadKubeconfig, err = c.clusterctlClient.GetKubeconfig(ctx, getKubeconfigOptions)
+		return err
+	}, tracing.Cluster(workloadClusterName), tracing.Namespace(workloadClusterName))
 	if err != nil {
 		c.log.Error(err, "Failed to get kubeconfig")
 		return err
@@ -269,6 +455,7 @@ func (c *ClusterAPI) GetClusterAuthInfoForWorkloadCluster(authInfo *k8sclient.Cl
 		c.log.Error(err, "Failed to create rest.Config from kubeconfig")
 		return err
 	}
+	audit.WrapConfig(restConfig, workloadClusterName)
 
 	// Create a Clientset from the rest.Config
 	clientset, err := kubernetes.NewForConfig(restConfig)
@@ -283,6 +470,12 @@ func (c *ClusterAPI) GetClusterAuthInfoForWorkloadCluster(authInfo *k8sclient.Cl
 	authInfo.ClusterName = workloadClusterName
 
 	err = utils.MergeKubeconfigs(workloadKubeconfig, c.kubeconfigPath)
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionUpdate,
+		Cluster: workloadClusterName,
+		Object:  audit.ObjectRef{Kind: "Kubeconfig", Name: c.kubeconfigPath},
+		Diff:    "merged context " + workloadClusterCtxName,
+	}, err)
 	if err != nil {
 		c.log.Error(err, "Error merging kubeconfig files")
 		return err
@@ -290,26 +483,45 @@ func (c *ClusterAPI) GetClusterAuthInfoForWorkloadCluster(authInfo *k8sclient.Cl
 	return nil
 }
 
-func (c *ClusterAPI) PivotCluster(permClusterAuth *k8sclient.ClusterAuthInfo) error {
+// PivotCluster moves Cluster API objects of the permanent management cluster to it, so that it manages itself
+func (c *ClusterAPI) PivotCluster(ctx context.Context, permClusterAuth *k8sclient.ClusterAuthInfo) error {
+	defer metrics.ObserveWait(metrics.WaitPivot, time.Now())
 	c.log.Info("Pivoting management cluster", "fromContextName", c.clusterAuth.ContextName, "toContextName", permClusterAuth.ContextName)
-	moveOptions := capiclient.MoveOptions{
-		FromKubeconfig: capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
-		ToKubeconfig:   capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: permClusterAuth.ContextName},
-		Namespace:      "cluster-mgmt", // TODO - hardcoded name
-	}
-
-	// Perform the move
-	if err := c.clusterctlClient.Move(context.TODO(), moveOptions); err != nil {
+	// objects of a CAPI cluster are in the namespace named after the cluster
+	if err := c.MoveNamespace(ctx, permClusterAuth, permClusterAuth.ClusterName); err != nil {
 		c.log.Error(err, "Failed to pivot Cluster API components")
 		return err
 	}
 
-	ClusterGVR := schema.GroupVersionResource{
-		Group:    "cluster.x-k8s.io",
-		Version:  "v1beta1",
-		Resource: "clusters",
+	return tracing.Run(ctx, "wait cluster moved", func(context.Context) error {
+		return c.waitForMovedCluster(permClusterAuth)
+	}, tracing.Cluster(permClusterAuth.ClusterName), tracing.Namespace(permClusterAuth.ClusterName), tracing.GVR(clusterGVR))
+}
+
+// MoveNamespace moves Cluster API objects of the namespace to another management cluster by clusterctl move
+func (c *ClusterAPI) MoveNamespace(ctx context.Context, to *k8sclient.ClusterAuthInfo, namespace string) error {
+	moveOptions := capiclient.MoveOptions{
+		FromKubeconfig: capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
+		ToKubeconfig:   capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: to.ContextName},
+		Namespace:      namespace,
+	}
+	err := tracing.Run(ctx, "clusterctl move", func(ctx context.Context) error {
+		return c.clusterctlClient.Move(ctx, moveOptions)
+	}, tracing.Cluster(c.clusterAuth.ClusterName), tracing.Namespace(namespace), attribute.String("capi.move.to_cluster", to.ClusterName))
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionMove,
+		Cluster: c.clusterAuth.ClusterName,
+		Object:  audit.ObjectRef{Namespace: namespace},
+		Diff:    "moved Cluster API objects to " + to.ClusterName,
+	}, err)
+	if err != nil {
+		return fmt.Errorf("error moving Cluster API objects of namespace %s to %s: %w", namespace, to.ClusterName, err)
 	}
+	return nil
+}
 
+// waitForMovedCluster waits for the Cluster resource of the permanent management cluster to appear on it after move
+func (c *ClusterAPI) waitForMovedCluster(permClusterAuth *k8sclient.ClusterAuthInfo) error {
 	timeout := time.After(5 * time.Minute)
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -320,7 +532,7 @@ func (c *ClusterAPI) PivotCluster(permClusterAuth *k8sclient.ClusterAuthInfo) er
 			return fmt.Errorf("timeout waiting for custom resource to be available in the target cluster")
 		case <-ticker.C:
 			// Check if the custom resource exists in the target cluster
-			exists, err := utils.ResourcesExist(permClusterAuth.Config, permClusterAuth.ClusterName, permClusterAuth.ClusterName, ClusterGVR)
+			exists, err := utils.ResourcesExist(permClusterAuth.Config, permClusterAuth.ClusterName, permClusterAuth.ClusterName, clusterGVR)
 			if err != nil {
 				c.log.Error(err, "Error checking custom resource in target cluster")
 				return err
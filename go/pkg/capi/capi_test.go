@@ -1,3 +1,59 @@
 package capi
 
-// TODO: Tests
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestWaitForClusterDeletion(t *testing.T) {
+	deletionPollInterval = time.Millisecond
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "cluster-01", Namespace: "cluster-01"}}
+
+	// a transient error is retried, the cluster is deleted when it is not found
+	gets := 0
+	client := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster.DeepCopy()).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c runtimeclient.WithWatch, key runtimeclient.ObjectKey, obj runtimeclient.Object, opts ...runtimeclient.GetOption) error {
+			gets++
+			switch gets {
+			case 1:
+				return fmt.Errorf("connection refused")
+			case 2:
+				return c.Get(ctx, key, obj, opts...)
+			case 3:
+				if err := c.Delete(ctx, cluster.DeepCopy()); err != nil {
+					return err
+				}
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+	c := &ClusterAPI{log: logr.Discard(), runtimeClient: client}
+	if err := c.WaitForClusterDeletion(context.Background(), "cluster-01", "cluster-01"); err != nil {
+		t.Fatalf("WaitForClusterDeletion() error = %v", err)
+	}
+	if gets != 3 {
+		t.Errorf("WaitForClusterDeletion() gets = %d, want 3", gets)
+	}
+
+	// the cluster which still exists is waited for until ctx is done
+	c.runtimeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster.DeepCopy()).Build()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForClusterDeletion(ctx, "cluster-01", "cluster-01"); err == nil {
+		t.Error("WaitForClusterDeletion() returned nil for an existing cluster")
+	}
+}
@@ -0,0 +1,75 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// awsZeroCredentials is an empty credentials profile, CAPA controller started with it can only
+// create clusters with static or role identities (see `clusterawsadm controller zero-credentials`)
+const awsZeroCredentials = "Cg=="
+
+// awsCredentialsVariable is the clusterctl variable with CAPA controller credentials
+const awsCredentialsVariable = "AWS_B64ENCODED_CREDENTIALS"
+
+// CreateAWSIdentitySecrets creates or updates secrets with credentials of static AWS identities
+// in CAPA namespace. Credentials are read from the environment variables named in config.
+// CAPA must already be installed, identity objects are applied by Flux from the repo.
+func (c *ClusterAPI) CreateAWSIdentitySecrets(identities []appconfig.AWSIdentityConfig) error {
+	for i := range identities {
+		identity := &identities[i]
+		if identity.Type != appconfig.AWSIdentityStatic {
+			continue
+		}
+
+		accessKeyID, secretAccessKey := os.Getenv(identity.AccessKeyIDEnv), os.Getenv(identity.SecretAccessKeyEnv)
+		if accessKeyID == "" || secretAccessKey == "" {
+			return fmt.Errorf("AWS identity %s: environment variables %s and %s must be set", identity.Name, identity.AccessKeyIDEnv, identity.SecretAccessKeyEnv)
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      identity.SecretName(),
+				Namespace: appconfig.CAPANamespace,
+			},
+			StringData: map[string]string{
+				"AccessKeyID":     accessKeyID,
+				"SecretAccessKey": secretAccessKey,
+			},
+		}
+
+		c.log.Info("Creating AWS identity secret", "identity", identity.Name, "secret", secret.Name)
+		secrets := c.clusterAuth.Clientset.CoreV1().Secrets(appconfig.CAPANamespace)
+		_, err := secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("error creating secret for AWS identity %s: %w", identity.Name, err)
+		}
+	}
+	return nil
+}
+
+// ensureAWSCredentials sets zero credentials for CAPA components in clusterctl variables when
+// AWS_B64ENCODED_CREDENTIALS is not set and no cluster needs controller credentials. Otherwise the
+// variable is required. The override only applies to this clusterctl client, not to the process environment.
+func (c *ClusterAPI) ensureAWSCredentials() error {
+	variables := c.clusterctlConfig.Variables()
+	if value, err := variables.Get(awsCredentialsVariable); err == nil && value != "" {
+		return nil
+	}
+	if c.awsCredentialsRequired {
+		return fmt.Errorf("%s must be set when any cluster does not use an AWS identity", awsCredentialsVariable)
+	}
+	c.log.Info("Using zero CAPA controller credentials, all clusters use AWS identities")
+	variables.Set(awsCredentialsVariable, awsZeroCredentials)
+	return nil
+}
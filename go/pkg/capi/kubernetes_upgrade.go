@@ -0,0 +1,524 @@
+package capi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const kubernetesUpgradeTimeout = 60 * time.Minute
+
+// capiKubernetesSupport maps Cluster API minor version to the oldest and newest Kubernetes minor
+// versions it supports on workload clusters, https://cluster-api.sigs.k8s.io/reference/versions
+var capiKubernetesSupport = map[string][2]string{
+	"1.4": {"1.21", "1.27"},
+	"1.5": {"1.22", "1.28"},
+	"1.6": {"1.23", "1.29"},
+	"1.7": {"1.24", "1.30"},
+	"1.8": {"1.25", "1.31"},
+}
+
+// CheckKubernetesUpgrade returns an error if a workload cluster can't be upgraded from current to target
+// Kubernetes version: kubeadm only upgrades control plane one minor version at a time and the
+// target must be in the range supported by the Cluster API version of the management cluster.
+func CheckKubernetesUpgrade(current, target, capiVersion string) error {
+	currentVersion, err := version.ParseSemantic(current)
+	if err != nil {
+		return fmt.Errorf("invalid current Kubernetes version %q: %w", current, err)
+	}
+	targetVersion, err := version.ParseSemantic(target)
+	if err != nil {
+		return fmt.Errorf("invalid target Kubernetes version %q: %w", target, err)
+	}
+
+	if !currentVersion.LessThan(targetVersion) {
+		return fmt.Errorf("target version %s must be newer than current version %s", targetVersion, currentVersion)
+	}
+	if targetVersion.Major() != currentVersion.Major() || targetVersion.Minor() > currentVersion.Minor()+1 {
+		return fmt.Errorf("kubeadm can't upgrade from %s to %s, upgrade one minor version at a time", currentVersion, targetVersion)
+	}
+
+	capi, err := version.ParseSemantic(capiVersion)
+	if err != nil {
+		return fmt.Errorf("invalid Cluster API version %q: %w", capiVersion, err)
+	}
+	supported, ok := capiKubernetesSupport[fmt.Sprintf("%d.%d", capi.Major(), capi.Minor())]
+	if !ok {
+		return fmt.Errorf("supported Kubernetes versions of Cluster API %s are unknown", capiVersion)
+	}
+	minSupported := version.MustParseGeneric(supported[0])
+	maxSupported := version.MustParseGeneric(supported[1])
+	targetMinor := version.MajorMinor(targetVersion.Major(), targetVersion.Minor())
+	if targetMinor.LessThan(minSupported) || maxSupported.LessThan(targetMinor) {
+		return fmt.Errorf("Kubernetes %s is not supported by Cluster API %s on the management cluster (supported %s - %s), upgrade Cluster API providers first",
+			targetVersion, capiVersion, supported[0], supported[1])
+	}
+	return nil
+}
+
+// NodeUpgradeTiming is how long it took to replace a machine with one at the new version
+type NodeUpgradeTiming struct {
+	Machine  string
+	Node     string
+	Created  time.Time
+	Ready    time.Time
+	Duration time.Duration
+}
+
+// KubernetesUpgrade upgrades Kubernetes version of a workload cluster in place by rolling
+// its control plane and machine deployments. Cluster manifests are owned by Flux on the
+// management cluster, so the version is changed in the repo, not on the live objects.
+type KubernetesUpgrade struct {
+	log         logr.Logger
+	cfg         *appconfig.Config
+	cluster     *appconfig.ClusterConfig
+	toVersion   string
+	clusterName string // name and namespace of the CAPI Cluster
+	file        string // manifest in the repo with the cluster version
+	mgmt        *ClusterAPI
+	mgmtFlux    *fluxcd.FluxCD
+	timings     map[string]*NodeUpgradeTiming
+}
+
+func NewKubernetesUpgrade(log logr.Logger, cfg *appconfig.Config, name, toVersion string) (*KubernetesUpgrade, error) {
+	var cluster *appconfig.ClusterConfig
+	for i := range cfg.Clusters {
+		if cfg.Clusters[i].Name == name {
+			cluster = &cfg.Clusters[i]
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %s is not defined in config", name)
+	}
+	if cluster.ManagementCluster == "" {
+		return nil, fmt.Errorf("cluster %s is a management cluster, only workload clusters can be upgraded in place", name)
+	}
+
+	var mgmtCluster *appconfig.ClusterConfig
+	for i := range cfg.Clusters {
+		if cfg.Clusters[i].Name == cluster.ManagementCluster {
+			mgmtCluster = &cfg.Clusters[i]
+		}
+	}
+	if mgmtCluster == nil {
+		return nil, fmt.Errorf("management cluster %s of %s is not defined in config", cluster.ManagementCluster, name)
+	}
+
+	clusterName, _, err := utils.GetCAPIClusterNameAndContext(utils.ClusterNameDataFromName(name))
+	if err != nil {
+		return nil, fmt.Errorf("error getting cluster name and context: %w", err)
+	}
+
+	mgmtAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, mgmtCluster.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for %s: %w", mgmtCluster.Name, err)
+	}
+	mgmt, err := NewClusterAPI(log, mgmtAuth, cfg)
+	if err != nil {
+		return nil, err
+	}
+	mgmtFlux, err := fluxcd.NewFluxCD(log, mgmtCluster.Flux, cfg.Github, mgmtAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubernetesUpgrade{
+		log:         log,
+		cfg:         cfg,
+		cluster:     cluster,
+		toVersion:   strings.TrimPrefix(toVersion, "v"),
+		clusterName: clusterName,
+		file:        filepath.Join(utils.RepoRoot(), "clusters", mgmtCluster.Name, name, "capi-cluster.yaml"),
+		mgmt:        mgmt,
+		mgmtFlux:    mgmtFlux,
+		timings:     map[string]*NodeUpgradeTiming{},
+	}, nil
+}
+
+// Check verifies that the cluster can be upgraded to the target version and returns its current version
+func (u *KubernetesUpgrade) Check() (string, error) {
+	kcp, err := u.controlPlane()
+	if err != nil {
+		return "", err
+	}
+
+	capiVersion, err := u.mgmt.coreProviderVersion()
+	if err != nil {
+		return "", err
+	}
+
+	current := strings.TrimPrefix(kcp.Spec.Version, "v")
+	if err := CheckKubernetesUpgrade(current, u.toVersion, capiVersion); err != nil {
+		return "", fmt.Errorf("cluster %s: %w", u.cluster.Name, err)
+	}
+	return current, nil
+}
+
+// Run changes the cluster version in the repo, waits for Flux to apply it and watches the rollout.
+// When resume is set the version is expected to be already changed and the cluster paused
+// by a previous run, so the cluster is unpaused and the rollout is watched again.
+func (u *KubernetesUpgrade) Run(resume bool) error {
+	if resume {
+		if err := u.setPaused(false); err != nil {
+			return err
+		}
+	} else {
+		current, err := u.Check()
+		if err != nil {
+			return err
+		}
+		u.log.Info("Upgrading Kubernetes", "cluster", u.cluster.Name, "from", current, "to", u.toVersion)
+
+		if err := u.bumpVersionInRepo(current); err != nil {
+			return err
+		}
+		sha, err := utils.GitCommitAndPush([]string{u.file}, fmt.Sprintf("upgrade %s to Kubernetes v%s", u.cluster.Name, u.toVersion), u.cfg.Github.Branch)
+		if err != nil {
+			return err
+		}
+		if err := u.mgmtFlux.SyncRevision(sha); err != nil {
+			return err
+		}
+	}
+
+	if err := u.watchRollout(); err != nil {
+		return err
+	}
+	u.log.Info("Kubernetes upgrade is complete, update kubernetesVersion in config.yaml to match", "cluster", u.cluster.Name, "version", u.toVersion)
+	return nil
+}
+
+// bumpVersionInRepo replaces KubeadmControlPlane and MachineDeployment versions in the cluster manifest
+func (u *KubernetesUpgrade) bumpVersionInRepo(current string) error {
+	data, err := os.ReadFile(u.file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", u.file, err)
+	}
+
+	updated, err := setKubernetesVersion(data, current, u.toVersion)
+	if err != nil {
+		return fmt.Errorf("%s: %w", u.file, err)
+	}
+	if err := os.WriteFile(u.file, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", u.file, err)
+	}
+	return nil
+}
+
+// kubernetesVersionFields are the paths of Kubernetes version in the objects of a cluster manifest
+var kubernetesVersionFields = map[string][]string{
+	"KubeadmControlPlane": {"spec", "version"},
+	"MachineDeployment":   {"spec", "template", "spec", "version"},
+}
+
+// setKubernetesVersion sets the Kubernetes version of KubeadmControlPlane and MachineDeployments in a
+// multi-document manifest. The documents are parsed to find the version fields and only their values
+// are replaced in the text, so that the rest of the generated manifest, e.g. add-on versions, is kept.
+// All versions must match current, otherwise the manifest has drifted from the cluster.
+func setKubernetesVersion(data []byte, current, target string) ([]byte, error) {
+	var fields []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		kind := lookupNode(root, "kind")
+		if kind == nil {
+			continue
+		}
+		path, ok := kubernetesVersionFields[kind.Value]
+		if !ok {
+			continue
+		}
+		field := lookupNode(root, path...)
+		if field == nil || field.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s has no %s", kind.Value, strings.Join(path, "."))
+		}
+		if strings.TrimPrefix(field.Value, "v") != current {
+			return nil, fmt.Errorf("%s version %s doesn't match version %s running on the cluster, reconcile the drift first", kind.Value, field.Value, current)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no KubeadmControlPlane or MachineDeployment found")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, field := range fields {
+		token := field.Value
+		switch field.Style {
+		case yaml.DoubleQuotedStyle:
+			token = `"` + token + `"`
+		case yaml.SingleQuotedStyle:
+			token = "'" + token + "'"
+		}
+		line, column := field.Line-1, field.Column-1
+		if line >= len(lines) || !strings.HasPrefix(lines[line][column:], token) {
+			return nil, fmt.Errorf("version %s is not found at line %d", field.Value, field.Line)
+		}
+		lines[line] = lines[line][:column] + `"` + target + `"` + lines[line][column+len(token):]
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// lookupNode returns the value of the path of mapping keys, or nil if it is not set
+func lookupNode(node *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var value *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				value = node.Content[i+1]
+				break
+			}
+		}
+		if value == nil {
+			return nil
+		}
+		node = value
+	}
+	return node
+}
+
+// watchRollout waits until all machines of the cluster run the new version. If a MachineHealthCheck
+// reports unhealthy machines the cluster is paused, so that no more machines are replaced until
+// someone looks at it, and the upgrade can be continued with Run(true).
+func (u *KubernetesUpgrade) watchRollout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesUpgradeTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		done, err := u.checkRollout(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for cluster %s to roll out Kubernetes v%s", u.cluster.Name, u.toVersion)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (u *KubernetesUpgrade) checkRollout(ctx context.Context) (bool, error) {
+	machines := &clusterv1.MachineList{}
+	if err := u.mgmt.runtimeClient.List(ctx, machines, runtimeclient.InNamespace(u.clusterName),
+		runtimeclient.MatchingLabels{clusterv1.ClusterNameLabel: u.clusterName}); err != nil {
+		return false, fmt.Errorf("error listing machines: %w", err)
+	}
+
+	oldMachines, newMachines, upgradedNodes := 0, 0, 0
+	for i := range machines.Items {
+		machine := &machines.Items[i]
+		if machine.Spec.Version == nil || strings.TrimPrefix(*machine.Spec.Version, "v") != u.toVersion {
+			oldMachines++
+			continue
+		}
+		newMachines++
+
+		if machine.Status.NodeInfo == nil || strings.TrimPrefix(machine.Status.NodeInfo.KubeletVersion, "v") != u.toVersion {
+			continue
+		}
+		upgradedNodes++
+		u.recordTiming(machine)
+	}
+	u.log.Info("Rollout progress", "cluster", u.cluster.Name, "oldMachines", oldMachines, "newMachines", newMachines,
+		"upgradedNodes", upgradedNodes)
+
+	if unhealthy, err := u.unhealthyMachines(ctx); err != nil {
+		return false, err
+	} else if len(unhealthy) > 0 {
+		if err := u.setPaused(true); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("cluster %s is paused, MachineHealthCheck failed for %s. Investigate and continue with --resume",
+			u.cluster.Name, strings.Join(unhealthy, ", "))
+	}
+
+	if oldMachines > 0 || upgradedNodes != newMachines {
+		return false, nil
+	}
+
+	kcp, err := u.controlPlane()
+	if err != nil {
+		return false, err
+	}
+	if !conditions.IsTrue(kcp, clusterv1.ReadyCondition) || kcp.Status.UpdatedReplicas != kcp.Status.Replicas {
+		return false, nil
+	}
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := u.mgmt.runtimeClient.List(ctx, machineDeployments, runtimeclient.InNamespace(u.clusterName),
+		runtimeclient.MatchingLabels{clusterv1.ClusterNameLabel: u.clusterName}); err != nil {
+		return false, fmt.Errorf("error listing machine deployments: %w", err)
+	}
+	for _, md := range machineDeployments.Items {
+		if md.Status.ObservedGeneration < md.Generation || md.Status.UpdatedReplicas != md.Status.Replicas || md.Status.UnavailableReplicas > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// unhealthyMachines returns names of the machines which failed health checks
+func (u *KubernetesUpgrade) unhealthyMachines(ctx context.Context) ([]string, error) {
+	healthChecks := &clusterv1.MachineHealthCheckList{}
+	if err := u.mgmt.runtimeClient.List(ctx, healthChecks, runtimeclient.InNamespace(u.clusterName)); err != nil {
+		return nil, fmt.Errorf("error listing machine health checks: %w", err)
+	}
+
+	if len(healthChecks.Items) == 0 {
+		return nil, nil
+	}
+
+	var unhealthy []string
+	for i := range healthChecks.Items {
+		mhc := &healthChecks.Items[i]
+		if mhc.Spec.ClusterName != u.clusterName {
+			continue
+		}
+		// remediation is short-circuited when too many machines are unhealthy
+		if conditions.IsFalse(mhc, clusterv1.RemediationAllowedCondition) {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (remediation not allowed)", mhc.Name))
+		}
+	}
+
+	machines := &clusterv1.MachineList{}
+	if err := u.mgmt.runtimeClient.List(ctx, machines, runtimeclient.InNamespace(u.clusterName),
+		runtimeclient.MatchingLabels{clusterv1.ClusterNameLabel: u.clusterName}); err != nil {
+		return nil, fmt.Errorf("error listing machines: %w", err)
+	}
+	for i := range machines.Items {
+		if conditions.IsFalse(&machines.Items[i], clusterv1.MachineHealthCheckSucceededCondition) {
+			unhealthy = append(unhealthy, machines.Items[i].Name)
+		}
+	}
+	return unhealthy, nil
+}
+
+func (u *KubernetesUpgrade) recordTiming(machine *clusterv1.Machine) {
+	if _, ok := u.timings[machine.Name]; ok {
+		return
+	}
+
+	ready := time.Now()
+	if condition := conditions.Get(machine, clusterv1.ReadyCondition); condition != nil && condition.Status == "True" {
+		ready = condition.LastTransitionTime.Time
+	}
+	node := ""
+	if machine.Status.NodeRef != nil {
+		node = machine.Status.NodeRef.Name
+	}
+
+	u.timings[machine.Name] = &NodeUpgradeTiming{
+		Machine:  machine.Name,
+		Node:     node,
+		Created:  machine.CreationTimestamp.Time,
+		Ready:    ready,
+		Duration: ready.Sub(machine.CreationTimestamp.Time),
+	}
+}
+
+// Timings returns how long it took to bring up each upgraded node, in the order they were created
+func (u *KubernetesUpgrade) Timings() []NodeUpgradeTiming {
+	timings := make([]NodeUpgradeTiming, 0, len(u.timings))
+	for _, t := range u.timings {
+		timings = append(timings, *t)
+	}
+	sort.Slice(timings, func(i, j int) bool { return timings[i].Created.Before(timings[j].Created) })
+	return timings
+}
+
+// PrintTimings writes per-node upgrade timing as a table
+func (u *KubernetesUpgrade) PrintTimings(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MACHINE\tNODE\tCREATED\tREADY\tDURATION")
+	for _, t := range u.Timings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Machine, t.Node, t.Created.Format(time.TimeOnly), t.Ready.Format(time.TimeOnly), t.Duration.Round(time.Second))
+	}
+	return tw.Flush()
+}
+
+func (u *KubernetesUpgrade) controlPlane() (*controlplanev1.KubeadmControlPlane, error) {
+	cluster := &clusterv1.Cluster{}
+	if err := u.mgmt.runtimeClient.Get(context.TODO(), runtimeclient.ObjectKey{Name: u.clusterName, Namespace: u.clusterName}, cluster); err != nil {
+		return nil, fmt.Errorf("error getting cluster %s: %w", u.clusterName, err)
+	}
+	if cluster.Spec.ControlPlaneRef == nil {
+		return nil, fmt.Errorf("cluster %s has no control plane", u.clusterName)
+	}
+
+	kcp := &controlplanev1.KubeadmControlPlane{}
+	key := runtimeclient.ObjectKey{Name: cluster.Spec.ControlPlaneRef.Name, Namespace: u.clusterName}
+	if err := u.mgmt.runtimeClient.Get(context.TODO(), key, kcp); err != nil {
+		return nil, fmt.Errorf("error getting control plane of cluster %s: %w", u.clusterName, err)
+	}
+	return kcp, nil
+}
+
+// setPaused pauses or unpauses reconciliation of the cluster. spec.paused is not in the
+// manifests in the repo, so Flux leaves it alone.
+func (u *KubernetesUpgrade) setPaused(paused bool) error {
+	u.log.Info("Setting cluster paused", "cluster", u.cluster.Name, "paused", paused)
+	cluster := &clusterv1.Cluster{}
+	cluster.Name = u.clusterName
+	cluster.Namespace = u.clusterName
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"paused":%t}}`, paused))
+	if err := u.mgmt.runtimeClient.Patch(context.TODO(), cluster, runtimeclient.RawPatch(types.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("error patching cluster %s: %w", u.clusterName, err)
+	}
+	return nil
+}
+
+// coreProviderVersion returns version of Cluster API core provider installed on this cluster
+func (c *ClusterAPI) coreProviderVersion() (string, error) {
+	providers := &clusterctlv1.ProviderList{}
+	if err := c.runtimeClient.List(context.TODO(), providers); err != nil {
+		return "", fmt.Errorf("error listing Cluster API providers: %w", err)
+	}
+	for _, p := range providers.Items {
+		if p.Type == string(clusterctlv1.CoreProviderType) {
+			return p.Version, nil
+		}
+	}
+	return "", fmt.Errorf("Cluster API core provider is not installed on %s", c.clusterAuth.ClusterName)
+}
@@ -0,0 +1,99 @@
+package capi
+
+import (
+	"strings"
+	"testing"
+)
+
+const clusterManifest = `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: "cluster-01"
+---
+kind: KubeadmControlPlane
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+metadata:
+  name: "cluster-01-control-plane"
+spec:
+  # comments and formatting are kept
+  replicas: 1
+  version: "1.28.5"
+---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: MachineDeployment
+metadata:
+  name: "cluster-01-md-0"
+spec:
+  template:
+    spec:
+      version: v1.28.5
+---
+apiVersion: addons.cluster.x-k8s.io/v1alpha1
+kind: HelmChartProxy
+metadata:
+  name: metrics-server
+spec:
+  version: 1.28.5
+`
+
+func TestCheckKubernetesUpgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     string
+		target      string
+		capiVersion string
+		wantErr     bool
+	}{
+		{"patch upgrade", "1.28.5", "1.28.6", "v1.6.0", false},
+		{"minor upgrade", "1.28.5", "1.29.1", "v1.6.0", false},
+		{"v prefix", "v1.28.5", "v1.29.0", "v1.6.0", false},
+		{"same version", "1.28.5", "1.28.5", "v1.6.0", true},
+		{"downgrade", "1.28.5", "1.27.9", "v1.6.0", true},
+		{"minor skip", "1.27.9", "1.29.0", "v1.6.0", true},
+		{"major change", "1.28.5", "2.0.0", "v1.6.0", true},
+		{"ahead of management cluster", "1.28.5", "1.29.0", "v1.5.3", true},
+		{"unknown capi version", "1.28.5", "1.29.0", "v1.99.0", true},
+		{"invalid target", "1.28.5", "latest", "v1.6.0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckKubernetesUpgrade(tt.current, tt.target, tt.capiVersion)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckKubernetesUpgrade(%q, %q, %q) error = %v, wantErr %v", tt.current, tt.target, tt.capiVersion, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetKubernetesVersion(t *testing.T) {
+	got, err := setKubernetesVersion([]byte(clusterManifest), "1.28.5", "1.29.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(clusterManifest, `  version: "1.28.5"`, `  version: "1.29.1"`, 1)
+	want = strings.Replace(want, `      version: v1.28.5`, `      version: "1.29.1"`, 1)
+	if string(got) != want {
+		t.Errorf("setKubernetesVersion() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSetKubernetesVersionErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"drift", strings.Replace(clusterManifest, "v1.28.5", "v1.28.4", 1)},
+		{"no version field", strings.Replace(clusterManifest, `  version: "1.28.5"`, "", 1)},
+		{"no control plane or machines", "kind: Cluster\nspec:\n  version: 1.28.5\n"},
+		{"invalid yaml", "kind: [KubeadmControlPlane\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := setKubernetesVersion([]byte(tt.manifest), "1.28.5", "1.29.1"); err == nil {
+				t.Error("setKubernetesVersion() returned no error")
+			}
+		})
+	}
+}
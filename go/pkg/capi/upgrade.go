@@ -0,0 +1,267 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	capiclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// ProviderVersions describes a provider installed on a management cluster:
+// the version that is running, the version pinned in config and the latest
+// versions clusterctl can upgrade to for each API contract.
+type ProviderVersions struct {
+	Type       string
+	Name       string
+	Namespace  string
+	Current    string
+	Configured string
+	Available  map[string]string // contract -> version
+}
+
+// UpgradePlan lists providers of a management cluster with their versions
+type UpgradePlan struct {
+	Contracts []string
+	Providers []ProviderVersions
+}
+
+// PlanUpgrade returns current, configured and available versions of all providers installed on the cluster
+func (c *ClusterAPI) PlanUpgrade() (*UpgradePlan, error) {
+	plans, err := c.clusterctlClient.PlanUpgrade(context.TODO(), capiclient.PlanUpgradeOptions{
+		Kubeconfig: capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error planning Cluster API upgrade: %w", err)
+	}
+
+	configured := configuredVersions(c.providers)
+	plan := &UpgradePlan{}
+	byInstance := map[string]int{}
+
+	for _, p := range plans {
+		plan.Contracts = append(plan.Contracts, p.Contract)
+		for _, item := range p.Providers {
+			key := item.Type + "/" + item.ProviderName
+			i, ok := byInstance[key]
+			if !ok {
+				plan.Providers = append(plan.Providers, ProviderVersions{
+					Type:       item.Type,
+					Name:       item.ProviderName,
+					Namespace:  item.Namespace,
+					Current:    item.Version,
+					Configured: configured[key],
+					Available:  map[string]string{},
+				})
+				i = len(plan.Providers) - 1
+				byInstance[key] = i
+			}
+			plan.Providers[i].Available[p.Contract] = item.NextVersion
+		}
+	}
+
+	sort.SliceStable(plan.Providers, func(i, j int) bool {
+		return providerTypeOrder(plan.Providers[i].Type) < providerTypeOrder(plan.Providers[j].Type)
+	})
+	return plan, nil
+}
+
+// Print writes the plan as a table
+func (p *UpgradePlan) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := "TYPE\tNAME\tNAMESPACE\tCURRENT\tCONFIGURED"
+	for _, contract := range p.Contracts {
+		header += "\tLATEST (" + contract + ")"
+	}
+	fmt.Fprintln(tw, header)
+
+	for _, pv := range p.Providers {
+		row := []string{pv.Type, pv.Name, pv.Namespace, pv.Current, orNone(pv.Configured)}
+		for _, contract := range p.Contracts {
+			// clusterctl leaves next version empty if the provider is already at the latest version
+			row = append(row, orNone(pv.Available[contract]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// ApplyUpgrade upgrades providers on the cluster to the versions pinned in config.
+// If contract is set, all providers are upgraded to the latest versions of that
+// contract instead, in which case config should be updated to match afterwards.
+// Returns false if there was nothing to upgrade.
+func (c *ClusterAPI) ApplyUpgrade(contract string) (bool, error) {
+	if err := c.ensureAWSCredentials(); err != nil {
+		return false, err
+	}
+
+	opts := capiclient.ApplyUpgradeOptions{
+		Kubeconfig:          capiclient.Kubeconfig{Path: c.kubeconfigPath, Context: c.clusterAuth.ContextName},
+		WaitProviders:       true,
+		WaitProviderTimeout: 5 * time.Minute,
+	}
+
+	if contract != "" {
+		opts.Contract = contract
+	} else {
+		plan, err := c.PlanUpgrade()
+		if err != nil {
+			return false, err
+		}
+
+		// only pass providers that need upgrading, clusterctl refuses to "upgrade" to the installed version
+		outdated := map[string]bool{}
+		for _, pv := range plan.Providers {
+			if pv.Configured != "" && pv.Configured != pv.Current {
+				outdated[pv.Type+"/"+pv.Name] = true
+			}
+		}
+		if len(outdated) == 0 {
+			c.log.Info("All providers are at configured versions", "cluster", c.clusterAuth.ClusterName)
+			return false, nil
+		}
+
+		pick := func(providerType string, providers []string) []string {
+			var result []string
+			for _, p := range providers {
+				if outdated[providerType+"/"+providerName(p)] {
+					result = append(result, p)
+				}
+			}
+			return result
+		}
+		if core := pick("CoreProvider", []string{c.providers.Core}); len(core) > 0 {
+			opts.CoreProvider = core[0]
+		}
+		opts.BootstrapProviders = pick("BootstrapProvider", c.providers.Bootstrap)
+		opts.ControlPlaneProviders = pick("ControlPlaneProvider", c.providers.ControlPlane)
+		opts.InfrastructureProviders = pick("InfrastructureProvider", c.providers.Infrastructure)
+		opts.AddonProviders = pick("AddonProvider", c.providers.Addon)
+	}
+
+	c.log.Info("Upgrading Cluster API providers", "cluster", c.clusterAuth.ClusterName, "contract", opts.Contract,
+		"core", opts.CoreProvider, "bootstrap", opts.BootstrapProviders, "controlPlane", opts.ControlPlaneProviders,
+		"infrastructure", opts.InfrastructureProviders, "addon", opts.AddonProviders)
+
+	if err := c.clusterctlClient.ApplyUpgrade(context.TODO(), opts); err != nil {
+		return false, fmt.Errorf("error upgrading Cluster API providers: %w", err)
+	}
+	return true, nil
+}
+
+// WaitForObjectsReconciled blocks until all Clusters, KubeadmControlPlanes and MachineDeployments
+// on this management cluster are Ready and have been reconciled at their latest generation.
+// It is used after provider upgrade to make sure the new controllers work with existing objects.
+func (c *ClusterAPI) WaitForObjectsReconciled(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		pending, err := c.notReconciled(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			c.log.Info("All Cluster API objects are reconciled", "cluster", c.clusterAuth.ClusterName)
+			return nil
+		}
+		c.log.Info("Waiting for Cluster API objects to reconcile", "pending", pending)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for Cluster API objects to reconcile: %s", strings.Join(pending, ", "))
+		case <-ticker.C:
+		}
+	}
+}
+
+// notReconciled returns "<kind> <namespace>/<name>" of objects which are not Ready or not observed at the current generation
+func (c *ClusterAPI) notReconciled(ctx context.Context) ([]string, error) {
+	var pending []string
+	check := func(kind string, obj conditions.Getter, observedGeneration int64) {
+		if observedGeneration < obj.GetGeneration() || !conditions.IsTrue(obj, clusterv1.ReadyCondition) {
+			pending = append(pending, fmt.Sprintf("%s %s/%s", kind, obj.GetNamespace(), obj.GetName()))
+		}
+	}
+
+	clusters := &clusterv1.ClusterList{}
+	if err := c.runtimeClient.List(ctx, clusters); err != nil {
+		return nil, fmt.Errorf("error listing clusters: %w", err)
+	}
+	for i := range clusters.Items {
+		check("Cluster", &clusters.Items[i], clusters.Items[i].Status.ObservedGeneration)
+	}
+
+	controlPlanes := &controlplanev1.KubeadmControlPlaneList{}
+	if err := c.runtimeClient.List(ctx, controlPlanes); err != nil {
+		return nil, fmt.Errorf("error listing control planes: %w", err)
+	}
+	for i := range controlPlanes.Items {
+		check("KubeadmControlPlane", &controlPlanes.Items[i], controlPlanes.Items[i].Status.ObservedGeneration)
+	}
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := c.runtimeClient.List(ctx, machineDeployments); err != nil {
+		return nil, fmt.Errorf("error listing machine deployments: %w", err)
+	}
+	for i := range machineDeployments.Items {
+		check("MachineDeployment", &machineDeployments.Items[i], machineDeployments.Items[i].Status.ObservedGeneration)
+	}
+
+	return pending, nil
+}
+
+// configuredVersions maps "<type>/<name>" to the version pinned in config
+func configuredVersions(providers appconfig.ProvidersConfig) map[string]string {
+	versions := map[string]string{}
+	add := func(providerType string, list []string) {
+		for _, p := range list {
+			if _, version, ok := strings.Cut(p, ":"); ok {
+				versions[providerType+"/"+providerName(p)] = version
+			}
+		}
+	}
+	add("CoreProvider", []string{providers.Core})
+	add("BootstrapProvider", providers.Bootstrap)
+	add("ControlPlaneProvider", providers.ControlPlane)
+	add("InfrastructureProvider", providers.Infrastructure)
+	add("AddonProvider", providers.Addon)
+	return versions
+}
+
+// providerName extracts name from clusterctl provider reference "[namespace/]name[:version]"
+func providerName(ref string) string {
+	name, _, _ := strings.Cut(ref, ":")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func providerTypeOrder(providerType string) int {
+	for i, t := range []string{"CoreProvider", "BootstrapProvider", "ControlPlaneProvider", "InfrastructureProvider", "AddonProvider"} {
+		if t == providerType {
+			return i
+		}
+	}
+	return 100
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
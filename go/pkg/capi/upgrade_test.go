@@ -0,0 +1,287 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	capiclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/cluster"
+	capiconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+)
+
+// fakeClusterctl returns the plans from PlanUpgrade and records options of ApplyUpgrade
+type fakeClusterctl struct {
+	capiclient.Client
+	plans   []capiclient.UpgradePlan
+	applied *capiclient.ApplyUpgradeOptions
+}
+
+func (f *fakeClusterctl) PlanUpgrade(ctx context.Context, options capiclient.PlanUpgradeOptions) ([]capiclient.UpgradePlan, error) {
+	return f.plans, nil
+}
+
+func (f *fakeClusterctl) ApplyUpgrade(ctx context.Context, options capiclient.ApplyUpgradeOptions) error {
+	f.applied = &options
+	return nil
+}
+
+// memoryReader is clusterctl config reader with variables in memory
+type memoryReader map[string]string
+
+func (r memoryReader) Init(ctx context.Context, path string) error { return nil }
+
+func (r memoryReader) Get(key string) (string, error) {
+	if value, ok := r[key]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("%s is not set", key)
+}
+
+func (r memoryReader) Set(key, value string) { r[key] = value }
+
+func (r memoryReader) UnmarshalKey(key string, value interface{}) error { return nil }
+
+var testProviders = appconfig.ProvidersConfig{
+	Core:           "cluster-api:v1.6.0",
+	Bootstrap:      []string{"kubeadm:v1.6.0"},
+	ControlPlane:   []string{"capi-kubeadm-control-plane-system/kubeadm:v1.6.0"},
+	Infrastructure: []string{"aws:v2.3.1"},
+	Addon:          []string{"helm"},
+}
+
+func upgradeItem(providerType, name, version, next string) cluster.UpgradeItem {
+	return cluster.UpgradeItem{
+		Provider: clusterctlv1.Provider{
+			ObjectMeta:   metav1.ObjectMeta{Namespace: "capi-system"},
+			ProviderName: name,
+			Type:         providerType,
+			Version:      version,
+		},
+		NextVersion: next,
+	}
+}
+
+func testClusterAPI(t *testing.T, installed map[string]string) (*ClusterAPI, *fakeClusterctl) {
+	t.Helper()
+	var items []cluster.UpgradeItem
+	for _, p := range []struct{ providerType, name string }{
+		{"AddonProvider", "helm"},
+		{"InfrastructureProvider", "aws"},
+		{"CoreProvider", "cluster-api"},
+		{"BootstrapProvider", "kubeadm"},
+		{"ControlPlaneProvider", "kubeadm"},
+	} {
+		items = append(items, upgradeItem(p.providerType, p.name, installed[p.providerType+"/"+p.name], "v9.9.9"))
+	}
+	clusterctl := &fakeClusterctl{plans: []capiclient.UpgradePlan{{Contract: "v1beta1", Providers: items}}}
+
+	cfg, err := capiconfig.New(context.Background(), "", capiconfig.InjectReader(memoryReader{awsCredentialsVariable: "credentials"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ClusterAPI{
+		log:              logr.Discard(),
+		clusterAuth:      &k8sclient.ClusterAuthInfo{ContextName: "kind-tmp-mgmt", ClusterName: "tmp-mgmt"},
+		clusterctlClient: clusterctl,
+		clusterctlConfig: cfg,
+		providers:        testProviders,
+	}, clusterctl
+}
+
+func TestConfiguredVersions(t *testing.T) {
+	want := map[string]string{
+		"CoreProvider/cluster-api":     "v1.6.0",
+		"BootstrapProvider/kubeadm":    "v1.6.0",
+		"ControlPlaneProvider/kubeadm": "v1.6.0",
+		"InfrastructureProvider/aws":   "v2.3.1",
+	}
+	if got := configuredVersions(testProviders); !reflect.DeepEqual(got, want) {
+		t.Errorf("configuredVersions() = %v, want %v", got, want)
+	}
+}
+
+func TestProviderName(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"aws", "aws"},
+		{"aws:v2.3.1", "aws"},
+		{"capa-system/aws:v2.3.1", "aws"},
+		{"capa-system/aws", "aws"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if got := providerName(tt.ref); got != tt.want {
+				t.Errorf("providerName(%q) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanUpgrade(t *testing.T) {
+	c, _ := testClusterAPI(t, map[string]string{
+		"CoreProvider/cluster-api":     "v1.5.3",
+		"BootstrapProvider/kubeadm":    "v1.5.3",
+		"ControlPlaneProvider/kubeadm": "v1.5.3",
+		"InfrastructureProvider/aws":   "v2.3.1",
+		"AddonProvider/helm":           "v0.1.0",
+	})
+
+	plan, err := c.PlanUpgrade()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan.Contracts, []string{"v1beta1"}) {
+		t.Errorf("Contracts = %v, want [v1beta1]", plan.Contracts)
+	}
+
+	want := []ProviderVersions{
+		{"CoreProvider", "cluster-api", "capi-system", "v1.5.3", "v1.6.0", map[string]string{"v1beta1": "v9.9.9"}},
+		{"BootstrapProvider", "kubeadm", "capi-system", "v1.5.3", "v1.6.0", map[string]string{"v1beta1": "v9.9.9"}},
+		{"ControlPlaneProvider", "kubeadm", "capi-system", "v1.5.3", "v1.6.0", map[string]string{"v1beta1": "v9.9.9"}},
+		{"InfrastructureProvider", "aws", "capi-system", "v2.3.1", "v2.3.1", map[string]string{"v1beta1": "v9.9.9"}},
+		// addon is not pinned in config
+		{"AddonProvider", "helm", "capi-system", "v0.1.0", "", map[string]string{"v1beta1": "v9.9.9"}},
+	}
+	if !reflect.DeepEqual(plan.Providers, want) {
+		t.Errorf("Providers = %+v, want %+v", plan.Providers, want)
+	}
+}
+
+func TestApplyUpgrade(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed map[string]string
+		contract  string
+		want      *capiclient.ApplyUpgradeOptions
+	}{
+		{
+			name: "only outdated providers",
+			installed: map[string]string{
+				"CoreProvider/cluster-api":     "v1.5.3",
+				"BootstrapProvider/kubeadm":    "v1.6.0",
+				"ControlPlaneProvider/kubeadm": "v1.5.3",
+				"InfrastructureProvider/aws":   "v2.3.1",
+				"AddonProvider/helm":           "v0.1.0",
+			},
+			want: &capiclient.ApplyUpgradeOptions{
+				CoreProvider:          "cluster-api:v1.6.0",
+				ControlPlaneProviders: []string{"capi-kubeadm-control-plane-system/kubeadm:v1.6.0"},
+			},
+		},
+		{
+			name: "all providers at configured versions",
+			installed: map[string]string{
+				"CoreProvider/cluster-api":     "v1.6.0",
+				"BootstrapProvider/kubeadm":    "v1.6.0",
+				"ControlPlaneProvider/kubeadm": "v1.6.0",
+				"InfrastructureProvider/aws":   "v2.3.1",
+				"AddonProvider/helm":           "v0.1.0",
+			},
+		},
+		{
+			name:      "contract upgrades all providers",
+			installed: map[string]string{},
+			contract:  "v1beta1",
+			want:      &capiclient.ApplyUpgradeOptions{Contract: "v1beta1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clusterctl := testClusterAPI(t, tt.installed)
+			c.kubeconfigPath = "/tmp/kubeconfig"
+
+			upgraded, err := c.ApplyUpgrade(tt.contract)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if upgraded != (tt.want != nil) {
+				t.Errorf("ApplyUpgrade() = %v, want %v", upgraded, tt.want != nil)
+			}
+			if tt.want == nil {
+				if clusterctl.applied != nil {
+					t.Errorf("ApplyUpgrade() called clusterctl with %+v", clusterctl.applied)
+				}
+				return
+			}
+
+			tt.want.Kubeconfig = capiclient.Kubeconfig{Path: "/tmp/kubeconfig", Context: "kind-tmp-mgmt"}
+			tt.want.WaitProviders = true
+			tt.want.WaitProviderTimeout = 5 * time.Minute
+			if !reflect.DeepEqual(clusterctl.applied, tt.want) {
+				t.Errorf("ApplyUpgrade() options = %+v, want %+v", clusterctl.applied, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForObjectsReconciled(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := controlplanev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	ready := clusterv1.Conditions{{Type: clusterv1.ReadyCondition, Status: corev1.ConditionTrue}}
+	notReady := clusterv1.Conditions{{Type: clusterv1.ReadyCondition, Status: corev1.ConditionFalse}}
+	meta := func(name string, generation int64) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: name, Generation: generation}
+	}
+	cluster := func(name string, generation, observed int64, conditions clusterv1.Conditions) *clusterv1.Cluster {
+		return &clusterv1.Cluster{ObjectMeta: meta(name, generation), Status: clusterv1.ClusterStatus{ObservedGeneration: observed, Conditions: conditions}}
+	}
+	controlPlane := &controlplanev1.KubeadmControlPlane{
+		ObjectMeta: meta("cluster-01", 3),
+		Status:     controlplanev1.KubeadmControlPlaneStatus{ObservedGeneration: 3, Conditions: ready},
+	}
+	machineDeployment := &clusterv1.MachineDeployment{
+		ObjectMeta: meta("cluster-01", 2),
+		Status:     clusterv1.MachineDeploymentStatus{ObservedGeneration: 2, Conditions: ready},
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtimeclient.Object
+		wantErr bool
+	}{
+		{"all reconciled", []runtimeclient.Object{cluster("cluster-01", 1, 1, ready), controlPlane, machineDeployment}, false},
+		{"no objects", nil, false},
+		{"not ready", []runtimeclient.Object{cluster("cluster-01", 1, 1, notReady), controlPlane, machineDeployment}, true},
+		{"stale generation", []runtimeclient.Object{cluster("cluster-01", 2, 1, ready), controlPlane, machineDeployment}, true},
+		{"no conditions", []runtimeclient.Object{cluster("cluster-01", 1, 1, nil)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ClusterAPI{
+				log:           logr.Discard(),
+				clusterAuth:   &k8sclient.ClusterAuthInfo{ClusterName: "tmp-mgmt"},
+				runtimeClient: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build(),
+			}
+			err := c.WaitForObjectsReconciled(20 * time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WaitForObjectsReconciled() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
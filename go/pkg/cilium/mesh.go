@@ -0,0 +1,599 @@
+package cilium
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const (
+	caSecretName      = "cilium-ca"
+	clustermeshSecret = "cilium-clustermesh"
+	remoteCertSecret  = "clustermesh-apiserver-remote-cert"
+	apiserverService  = "clustermesh-apiserver"
+	certgenCronJob    = "clustermesh-apiserver-generate-certs"
+	clustermeshPort   = 2379
+	meshDomain        = "mesh.cilium.io"
+	meshReadyTimeout  = 10 * time.Minute
+	meshPollInterval  = 15 * time.Second
+	// annotation of the CAPI Cluster with remote clusters of the mesh, the Cilium HelmChartProxy
+	// renders it into clustermesh.config.clusters values
+	meshPeersAnnotation = "cilium-mesh-clusters"
+)
+
+var capiClusterGVR = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "clusters"}
+
+// secrets issued by certgen from cilium-ca, they are re-issued when the CA is replaced
+var certgenSecrets = []string{
+	"clustermesh-apiserver-server-cert",
+	"clustermesh-apiserver-admin-cert",
+	"clustermesh-apiserver-client-cert",
+	remoteCertSecret,
+}
+
+// member is a cluster in a mesh
+type member struct {
+	cluster   config.ClusterConfig
+	auth      *k8sclient.ClusterAuthInfo
+	clientset kubernetes.Interface
+	// name of the cluster as known to Cilium (cluster.name Helm value), which is the CAPI cluster name
+	meshName string
+	// clustermesh-apiserver LoadBalancer address and the IPs it resolves to
+	address string
+	ips     []string
+}
+
+// ClusterMesh connects clusters which share the same mesh label in config with Cilium Cluster Mesh.
+// Cilium itself is installed by CAAPH with clustermesh-apiserver enabled (see cilium-helm-chart-proxy.yaml),
+// this sets up trust and connectivity between the clusters, same as `cilium clustermesh connect` would.
+type ClusterMesh struct {
+	log logr.Logger
+	cfg *config.Config
+}
+
+func NewClusterMesh(log logr.Logger, cfg *config.Config) *ClusterMesh {
+	return &ClusterMesh{log: log, cfg: cfg}
+}
+
+// Groups returns names of clusters grouped by mesh label
+func (m *ClusterMesh) Groups() map[string][]string {
+	groups := make(map[string][]string)
+	for _, cluster := range m.cfg.Clusters {
+		if cluster.Mesh.Enabled() {
+			groups[cluster.Mesh.Label] = append(groups[cluster.Mesh.Label], cluster.Name)
+		}
+	}
+	return groups
+}
+
+// Enable connects all clusters with the given mesh label, or clusters of all meshes if label is empty
+func (m *ClusterMesh) Enable(ctx context.Context, label string) error {
+	groups := m.Groups()
+	if label != "" {
+		if _, ok := groups[label]; !ok {
+			return fmt.Errorf("no clusters with mesh label %q in config", label)
+		}
+		groups = map[string][]string{label: groups[label]}
+	}
+
+	for meshLabel, names := range groups {
+		if len(names) < 2 {
+			m.log.Info("Skipping mesh with a single cluster", "label", meshLabel, "cluster", names[0])
+			continue
+		}
+		m.log.Info("Enabling Cluster Mesh", "label", meshLabel, "clusters", names)
+
+		members, err := m.members(names)
+		if err != nil {
+			return err
+		}
+		if err := m.distributeCA(ctx, members); err != nil {
+			return fmt.Errorf("mesh %s: %w", meshLabel, err)
+		}
+		for _, mb := range members {
+			if err := m.resolveEndpoint(ctx, mb); err != nil {
+				return fmt.Errorf("mesh %s: %w", meshLabel, err)
+			}
+		}
+		for _, mb := range members {
+			if err := m.connect(ctx, mb, members); err != nil {
+				return fmt.Errorf("mesh %s: %w", meshLabel, err)
+			}
+		}
+		if err := m.Verify(ctx, meshLabel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks `cilium status` on every cluster of the mesh until it reports all remote clusters ready
+func (m *ClusterMesh) Verify(ctx context.Context, label string) error {
+	names, ok := m.Groups()[label]
+	if !ok {
+		return fmt.Errorf("no clusters with mesh label %q in config", label)
+	}
+	members, err := m.members(names)
+	if err != nil {
+		return err
+	}
+
+	for _, mb := range members {
+		var expected []string
+		for _, other := range members {
+			if other != mb {
+				expected = append(expected, other.meshName)
+			}
+		}
+
+		if err := poll(ctx, meshReadyTimeout, func() (bool, error) {
+			status, err := clustermeshStatus(ctx, m.cfg.KubeconfigPath, mb.auth.ContextName)
+			if err != nil {
+				m.log.Info("Failed to get Cilium status, retrying", "cluster", mb.cluster.Name, "error", err.Error())
+				return false, nil
+			}
+			notReady := status.notReady(expected)
+			if len(notReady) > 0 {
+				m.log.Info("Waiting for remote clusters", "cluster", mb.cluster.Name, "notReady", notReady)
+				return false, nil
+			}
+			return true, nil
+		}); err != nil {
+			return fmt.Errorf("cluster %s is not connected to all clusters of mesh %s: %w", mb.cluster.Name, label, err)
+		}
+		m.log.Info("Cluster Mesh is ready", "cluster", mb.cluster.Name, "remotes", expected)
+	}
+	return nil
+}
+
+func (m *ClusterMesh) members(names []string) ([]*member, error) {
+	var members []*member
+	for _, cluster := range m.cfg.Clusters {
+		found := false
+		for _, name := range names {
+			if cluster.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+
+		auth, err := utils.GetClusterAuthInfoByName(m.cfg.KubeconfigPath, cluster.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kubernetes client for %s: %w", cluster.Name, err)
+		}
+		members = append(members, &member{cluster: cluster, auth: auth, clientset: auth.Clientset, meshName: auth.ClusterName})
+	}
+	return members, nil
+}
+
+// distributeCA makes all clusters share the same cilium-ca. CA of the first cluster
+// which has it is used, otherwise a new CA is generated. On clusters where the CA is
+// replaced, certificates issued by certgen are deleted and issued again from the new CA.
+func (m *ClusterMesh) distributeCA(ctx context.Context, members []*member) error {
+	var ca *corev1.Secret
+	for _, mb := range members {
+		secret, err := mb.clientset.CoreV1().Secrets(config.CiliumNamespace).Get(ctx, caSecretName, metav1.GetOptions{})
+		if err == nil {
+			m.log.Info("Using existing Cilium CA", "cluster", mb.cluster.Name)
+			ca = secret
+			break
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get %s secret on %s: %w", caSecretName, mb.cluster.Name, err)
+		}
+	}
+
+	if ca == nil {
+		m.log.Info("Generating Cilium CA")
+		cert, key, err := generateCA()
+		if err != nil {
+			return err
+		}
+		ca = &corev1.Secret{Data: map[string][]byte{"ca.crt": cert, "ca.key": key}}
+	}
+
+	for _, mb := range members {
+		secrets := mb.clientset.CoreV1().Secrets(config.CiliumNamespace)
+		existing, err := secrets.Get(ctx, caSecretName, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			_, err = secrets.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: caSecretName, Namespace: config.CiliumNamespace},
+				Data:       map[string][]byte{"ca.crt": ca.Data["ca.crt"], "ca.key": ca.Data["ca.key"]},
+			}, metav1.CreateOptions{})
+		case err != nil:
+		case string(existing.Data["ca.crt"]) == string(ca.Data["ca.crt"]):
+			continue
+		default:
+			existing.Data = map[string][]byte{"ca.crt": ca.Data["ca.crt"], "ca.key": ca.Data["ca.key"]}
+			_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s secret on %s: %w", caSecretName, mb.cluster.Name, err)
+		}
+
+		m.log.Info("Cilium CA replaced, re-issuing certificates", "cluster", mb.cluster.Name)
+		if err := reissueCertificates(ctx, mb.clientset); err != nil {
+			return fmt.Errorf("cluster %s: %w", mb.cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+// reissueCertificates deletes certificates issued from the previous CA and runs certgen job
+// from its CronJob, which issues them again from cilium-ca
+func reissueCertificates(ctx context.Context, clientset kubernetes.Interface) error {
+	secrets := clientset.CoreV1().Secrets(config.CiliumNamespace)
+	for _, name := range certgenSecrets {
+		if err := secrets.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s secret: %w", name, err)
+		}
+	}
+
+	cronJob, err := clientset.BatchV1().CronJobs(config.CiliumNamespace).Get(ctx, certgenCronJob, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s CronJob, clustermesh-apiserver TLS must use cronJob method: %w", certgenCronJob, err)
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: certgenCronJob + "-",
+			Namespace:    config.CiliumNamespace,
+			Labels:       cronJob.Spec.JobTemplate.Labels,
+		},
+		Spec: cronJob.Spec.JobTemplate.Spec,
+	}
+	if _, err := clientset.BatchV1().Jobs(config.CiliumNamespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create certgen job: %w", err)
+	}
+
+	return poll(ctx, meshReadyTimeout, func() (bool, error) {
+		_, err := secrets.Get(ctx, remoteCertSecret, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// resolveEndpoint waits for clustermesh-apiserver LoadBalancer and resolves its address
+func (m *ClusterMesh) resolveEndpoint(ctx context.Context, mb *member) error {
+	services := mb.clientset.CoreV1().Services(config.CiliumNamespace)
+
+	err := poll(ctx, meshReadyTimeout, func() (bool, error) {
+		svc, err := services.Get(ctx, apiserverService, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			return false, fmt.Errorf("%s service on %s is %s, it must be LoadBalancer", apiserverService, mb.cluster.Name, svc.Spec.Type)
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				mb.address, mb.ips = ingress.IP, []string{ingress.IP}
+				return true, nil
+			}
+			if ingress.Hostname != "" {
+				// AWS load balancers have a hostname and it takes a while until it resolves
+				ips, err := net.LookupHost(ingress.Hostname)
+				if err != nil || len(ips) == 0 {
+					return false, nil
+				}
+				sort.Strings(ips)
+				mb.address, mb.ips = ingress.Hostname, ips
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("clustermesh-apiserver endpoint of %s is not available: %w", mb.cluster.Name, err)
+	}
+	m.log.Info("Found clustermesh-apiserver endpoint", "cluster", mb.cluster.Name, "address", mb.address, "ips", mb.ips)
+	return nil
+}
+
+// connect points cilium agents of the cluster at clustermesh-apiservers of the remote clusters.
+// Agents reach the remotes as <remote>.mesh.cilium.io, because the server certificates are issued
+// for this name and not for the load balancer address. The Cilium chart renders host aliases for
+// these names and cilium-clustermesh secret with etcd configs and client certificates from
+// clustermesh.config.clusters Helm values, which the HelmChartProxy takes from cilium-mesh-clusters
+// annotation of the CAPI Cluster. The chart is the only writer of the secret, so CAAPH reconciles
+// don't drop the certificates.
+func (m *ClusterMesh) connect(ctx context.Context, mb *member, members []*member) error {
+	peers, err := remotePeers(ctx, mb, members)
+	if err != nil {
+		return err
+	}
+
+	mgmtAuth, err := utils.GetClusterAuthInfoByName(m.cfg.KubeconfigPath, mb.cluster.ManagedBy())
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client for %s: %w", mb.cluster.ManagedBy(), err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(mgmtAuth.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	if err := m.setPeers(ctx, dynamicClient, mb, peers); err != nil {
+		return err
+	}
+
+	m.log.Info("Connected cluster to remote clusters", "cluster", mb.cluster.Name, "remotes", len(peers))
+	return nil
+}
+
+// meshPeer is an entry of clustermesh.config.clusters Helm values of the Cilium chart
+type meshPeer struct {
+	Name string       `json:"name"`
+	Port int          `json:"port"`
+	IPs  []string     `json:"ips"`
+	TLS  *meshPeerTLS `json:"tls,omitempty"`
+}
+
+// meshPeerTLS is the etcd client certificate for the remote clustermesh-apiserver, base64 encoded PEM
+type meshPeerTLS struct {
+	Cert   string `json:"cert"`
+	Key    string `json:"key"`
+	CACert string `json:"caCert"`
+}
+
+// remotePeers returns the remote clusters of the member with client certificates which
+// certgen issued on each remote for connecting to its clustermesh-apiserver
+func remotePeers(ctx context.Context, mb *member, members []*member) ([]meshPeer, error) {
+	var peers []meshPeer
+	for _, remote := range members {
+		if remote == mb {
+			continue
+		}
+
+		cert, err := remote.clientset.CoreV1().Secrets(config.CiliumNamespace).Get(ctx, remoteCertSecret, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s secret on %s: %w", remoteCertSecret, remote.cluster.Name, err)
+		}
+		peers = append(peers, meshPeer{
+			Name: remote.meshName,
+			Port: clustermeshPort,
+			IPs:  remote.ips,
+			TLS: &meshPeerTLS{
+				Cert:   base64.StdEncoding.EncodeToString(cert.Data["tls.crt"]),
+				Key:    base64.StdEncoding.EncodeToString(cert.Data["tls.key"]),
+				CACert: base64.StdEncoding.EncodeToString(cert.Data["ca.crt"]),
+			},
+		})
+	}
+	return peers, nil
+}
+
+// setPeers annotates the CAPI Cluster of the member on its management cluster with the remote clusters
+// and waits until CAAPH upgrades Cilium with them. The annotation is not in the manifests in the repo,
+// so Flux leaves it alone. It holds client keys of the remotes, the management cluster already holds
+// admin kubeconfigs of its clusters.
+func (m *ClusterMesh) setPeers(ctx context.Context, mgmt dynamic.Interface, mb *member, peers []meshPeer) error {
+	value, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{meshPeersAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := mgmt.Resource(capiClusterGVR).Namespace(mb.cluster.Name).Patch(ctx, mb.cluster.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate cluster %s: %w", mb.cluster.Name, err)
+	}
+
+	m.log.Info("Waiting for Cilium to be upgraded with remote clusters", "cluster", mb.cluster.Name)
+	err = poll(ctx, meshReadyTimeout, func() (bool, error) {
+		return peersApplied(ctx, mb.clientset, peers)
+	})
+	if err != nil {
+		return fmt.Errorf("cilium on %s is not configured with the remote clusters: %w", mb.cluster.Name, err)
+	}
+	return nil
+}
+
+// peersApplied checks that the cilium DaemonSet has host aliases of the peers and
+// cilium-clustermesh secret rendered by the chart has their client certificates
+func peersApplied(ctx context.Context, clientset kubernetes.Interface, peers []meshPeer) (bool, error) {
+	ds, err := clientset.AppsV1().DaemonSets(config.CiliumNamespace).Get(ctx, "cilium", metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if !hasHostAliases(ds.Spec.Template.Spec.HostAliases, peers) {
+		return false, nil
+	}
+
+	secret, err := clientset.CoreV1().Secrets(config.CiliumNamespace).Get(ctx, clustermeshSecret, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, peer := range peers {
+		if _, ok := secret.Data[peer.Name]; !ok {
+			return false, nil
+		}
+		if peer.TLS != nil && base64.StdEncoding.EncodeToString(secret.Data[peer.Name+".etcd-client.crt"]) != peer.TLS.Cert {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hasHostAliases checks that every IP of the peers is aliased to <peer>.mesh.cilium.io
+func hasHostAliases(aliases []corev1.HostAlias, peers []meshPeer) bool {
+	for _, peer := range peers {
+		host := peer.Name + "." + meshDomain
+		for _, ip := range peer.IPs {
+			found := false
+			for _, alias := range aliases {
+				if alias.IP == ip && slices.Contains(alias.Hostnames, host) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// status is the part of `cilium status -o json` output which describes Cluster Mesh
+type status struct {
+	ClusterMesh struct {
+		Clusters []struct {
+			Name   string `json:"name"`
+			Ready  bool   `json:"ready"`
+			Status string `json:"status"`
+		} `json:"clusters"`
+	} `json:"cluster-mesh"`
+}
+
+// notReady returns the expected remote clusters which are not connected
+func (s *status) notReady(expected []string) []string {
+	var notReady []string
+	for _, name := range expected {
+		ready := false
+		for _, c := range s.ClusterMesh.Clusters {
+			if c.Name == name && c.Ready {
+				ready = true
+			}
+		}
+		if !ready {
+			notReady = append(notReady, name)
+		}
+	}
+	return notReady
+}
+
+func clustermeshStatus(ctx context.Context, kubeconfigPath, contextName string) (*status, error) {
+	cmd := exec.CommandContext(ctx, "kubectl", "--kubeconfig", kubeconfigPath, "--context", contextName,
+		"-n", config.CiliumNamespace, "exec", "ds/cilium", "-c", "cilium-agent", "--", "cilium", "status", "-o", "json")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cilium status failed: %w", err)
+	}
+
+	s := &status{}
+	if err := json.Unmarshal(out, s); err != nil {
+		return nil, fmt.Errorf("failed to parse cilium status: %w", err)
+	}
+	return s, nil
+}
+
+// generateCA creates a self-signed CA in the same format as cilium certgen does
+func generateCA() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Cilium CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(3, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal CA key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func poll(ctx context.Context, timeout time.Duration, condition func() (bool, error)) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(meshPollInterval)
+	defer ticker.Stop()
+
+	for {
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-deadline:
+			return fmt.Errorf("timed out after %s", timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Summary returns mesh groups in a human readable form
+func (m *ClusterMesh) Summary() string {
+	groups := m.Groups()
+	labels := make([]string, 0, len(groups))
+	for label := range groups {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var b strings.Builder
+	for _, label := range labels {
+		fmt.Fprintf(&b, "%s:\n", label)
+		for _, cluster := range m.cfg.Clusters {
+			if cluster.Mesh.Label == label {
+				fmt.Fprintf(&b, "  %s (id %d)\n", cluster.Name, cluster.Mesh.ClusterID)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,306 @@
+package cilium
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		clusters []config.ClusterConfig
+		want     map[string][]string
+	}{
+		{
+			name: "clusters grouped by label",
+			clusters: []config.ClusterConfig{
+				{Name: "cluster-mgmt", Mesh: config.MeshConfig{Label: "blue", ClusterID: 1}},
+				{Name: "cluster-01", Mesh: config.MeshConfig{Label: "blue", ClusterID: 2}},
+				{Name: "cluster-02", Mesh: config.MeshConfig{Label: "green", ClusterID: 3}},
+			},
+			want: map[string][]string{"blue": {"cluster-mgmt", "cluster-01"}, "green": {"cluster-02"}},
+		},
+		{
+			name: "clusters outside a mesh are skipped",
+			clusters: []config.ClusterConfig{
+				{Name: "cluster-mgmt"},
+				{Name: "cluster-01", Mesh: config.MeshConfig{Label: config.MeshLabelNone}},
+				{Name: "cluster-02", Mesh: config.MeshConfig{Label: "blue", ClusterID: 3}},
+			},
+			want: map[string][]string{"blue": {"cluster-02"}},
+		},
+		{
+			name:     "no meshes",
+			clusters: []config.ClusterConfig{{Name: "cluster-mgmt"}},
+			want:     map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := NewClusterMesh(logr.Discard(), &config.Config{Clusters: tt.clusters})
+			if got := mesh.Groups(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Groups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasHostAliases(t *testing.T) {
+	peers := []meshPeer{
+		{Name: "cluster-01", IPs: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "cluster-02", IPs: []string{"10.0.1.1"}},
+	}
+
+	tests := []struct {
+		name    string
+		aliases []corev1.HostAlias
+		want    bool
+	}{
+		{
+			name: "all peers aliased",
+			aliases: []corev1.HostAlias{
+				{IP: "10.0.0.1", Hostnames: []string{"cluster-01.mesh.cilium.io"}},
+				{IP: "10.0.0.2", Hostnames: []string{"cluster-01.mesh.cilium.io"}},
+				{IP: "10.0.1.1", Hostnames: []string{"other.example.com", "cluster-02.mesh.cilium.io"}},
+			},
+			want: true,
+		},
+		{
+			name: "missing IP of a peer",
+			aliases: []corev1.HostAlias{
+				{IP: "10.0.0.1", Hostnames: []string{"cluster-01.mesh.cilium.io"}},
+				{IP: "10.0.1.1", Hostnames: []string{"cluster-02.mesh.cilium.io"}},
+			},
+			want: false,
+		},
+		{
+			name: "IP aliased to another peer",
+			aliases: []corev1.HostAlias{
+				{IP: "10.0.0.1", Hostnames: []string{"cluster-01.mesh.cilium.io"}},
+				{IP: "10.0.0.2", Hostnames: []string{"cluster-01.mesh.cilium.io"}},
+				{IP: "10.0.1.1", Hostnames: []string{"cluster-01.mesh.cilium.io"}},
+			},
+			want: false,
+		},
+		{
+			name: "no aliases",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasHostAliases(tt.aliases, peers); got != tt.want {
+				t.Errorf("hasHostAliases() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !hasHostAliases(nil, nil) {
+		t.Error("hasHostAliases() without peers = false, want true")
+	}
+}
+
+func TestStatusNotReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []string
+		want     []string
+	}{
+		{
+			name:     "all ready",
+			output:   `{"cluster-mesh":{"clusters":[{"name":"cluster-01","ready":true},{"name":"cluster-02","ready":true}]}}`,
+			expected: []string{"cluster-01", "cluster-02"},
+		},
+		{
+			name:     "remote not ready",
+			output:   `{"cluster-mesh":{"clusters":[{"name":"cluster-01","ready":true},{"name":"cluster-02","ready":false,"status":"etcd: connecting"}]}}`,
+			expected: []string{"cluster-01", "cluster-02"},
+			want:     []string{"cluster-02"},
+		},
+		{
+			name:     "remote not known to the agent",
+			output:   `{"cluster-mesh":{"clusters":[{"name":"cluster-01","ready":true}]}}`,
+			expected: []string{"cluster-01", "cluster-02"},
+			want:     []string{"cluster-02"},
+		},
+		{
+			name:     "cluster mesh not configured",
+			output:   `{}`,
+			expected: []string{"cluster-01"},
+			want:     []string{"cluster-01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &status{}
+			if err := json.Unmarshal([]byte(tt.output), s); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.notReady(tt.expected); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func remoteCert(cert, key, ca string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: remoteCertSecret, Namespace: config.CiliumNamespace},
+		Data:       map[string][]byte{"tls.crt": []byte(cert), "tls.key": []byte(key), "ca.crt": []byte(ca)},
+	}
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestRemotePeers(t *testing.T) {
+	local := &member{cluster: config.ClusterConfig{Name: "cluster-mgmt"}, meshName: "cluster-mgmt", clientset: fake.NewSimpleClientset()}
+	remote1 := &member{
+		cluster:   config.ClusterConfig{Name: "cluster-01"},
+		meshName:  "cluster-01",
+		ips:       []string{"10.0.0.1", "10.0.0.2"},
+		clientset: fake.NewSimpleClientset(remoteCert("cert-01", "key-01", "ca")),
+	}
+	remote2 := &member{
+		cluster:   config.ClusterConfig{Name: "cluster-02"},
+		meshName:  "cluster-02",
+		ips:       []string{"10.0.1.1"},
+		clientset: fake.NewSimpleClientset(remoteCert("cert-02", "key-02", "ca")),
+	}
+
+	peers, err := remotePeers(context.Background(), local, []*member{local, remote1, remote2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []meshPeer{
+		{Name: "cluster-01", Port: 2379, IPs: []string{"10.0.0.1", "10.0.0.2"}, TLS: &meshPeerTLS{Cert: b64("cert-01"), Key: b64("key-01"), CACert: b64("ca")}},
+		{Name: "cluster-02", Port: 2379, IPs: []string{"10.0.1.1"}, TLS: &meshPeerTLS{Cert: b64("cert-02"), Key: b64("key-02"), CACert: b64("ca")}},
+	}
+	if !reflect.DeepEqual(peers, want) {
+		t.Errorf("remotePeers() = %+v, want %+v", peers, want)
+	}
+
+	// certgen has not issued the certificate on the remote yet
+	remote2.clientset = fake.NewSimpleClientset()
+	if _, err := remotePeers(context.Background(), local, []*member{local, remote1, remote2}); err == nil {
+		t.Error("remotePeers() without remote certificate secret succeeded, want error")
+	}
+}
+
+// ciliumObjects returns cilium DaemonSet and cilium-clustermesh secret as the chart renders them for the peers
+func ciliumObjects(peers []meshPeer) []runtime.Object {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "cilium", Namespace: config.CiliumNamespace}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: clustermeshSecret, Namespace: config.CiliumNamespace},
+		Data:       map[string][]byte{},
+	}
+	for _, peer := range peers {
+		for _, ip := range peer.IPs {
+			ds.Spec.Template.Spec.HostAliases = append(ds.Spec.Template.Spec.HostAliases, corev1.HostAlias{IP: ip, Hostnames: []string{peer.Name + ".mesh.cilium.io"}})
+		}
+		cert, _ := base64.StdEncoding.DecodeString(peer.TLS.Cert)
+		secret.Data[peer.Name] = []byte("endpoints:\n- https://" + peer.Name + ".mesh.cilium.io:2379\n")
+		secret.Data[peer.Name+".etcd-client.crt"] = cert
+	}
+	return []runtime.Object{ds, secret}
+}
+
+func TestPeersApplied(t *testing.T) {
+	peers := []meshPeer{
+		{Name: "cluster-01", Port: 2379, IPs: []string{"10.0.0.1"}, TLS: &meshPeerTLS{Cert: b64("cert-01"), Key: b64("key-01"), CACert: b64("ca")}},
+	}
+	reissued := []meshPeer{
+		{Name: "cluster-01", Port: 2379, IPs: []string{"10.0.0.1"}, TLS: &meshPeerTLS{Cert: b64("cert-01-old"), Key: b64("key-01"), CACert: b64("ca")}},
+	}
+	moved := []meshPeer{
+		{Name: "cluster-01", Port: 2379, IPs: []string{"10.0.9.9"}, TLS: peers[0].TLS},
+	}
+	rendered := ciliumObjects(peers)
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    bool
+		wantErr bool
+	}{
+		{"rendered for the peers", ciliumObjects(peers), true, false},
+		{"secret has certificate issued before", ciliumObjects(reissued), false, false},
+		{"host aliases of old address", ciliumObjects(moved), false, false},
+		{"secret is not rendered yet", rendered[:1], false, false},
+		{"no cilium DaemonSet", nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := peersApplied(context.Background(), fake.NewSimpleClientset(tt.objects...), peers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("peersApplied() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("peersApplied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPeers(t *testing.T) {
+	cluster := &unstructured.Unstructured{}
+	cluster.SetAPIVersion("cluster.x-k8s.io/v1beta1")
+	cluster.SetKind("Cluster")
+	cluster.SetName("cluster-01")
+	cluster.SetNamespace("cluster-01")
+	cluster.SetAnnotations(map[string]string{"keep": "me"})
+
+	scheme := runtime.NewScheme()
+	mgmt := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{capiClusterGVR: "ClusterList"}, cluster)
+
+	peers := []meshPeer{
+		{Name: "cluster-mgmt", Port: 2379, IPs: []string{"10.0.0.1"}, TLS: &meshPeerTLS{Cert: b64("cert"), Key: b64("key"), CACert: b64("ca")}},
+	}
+	mb := &member{
+		cluster:   config.ClusterConfig{Name: "cluster-01"},
+		meshName:  "cluster-01",
+		clientset: fake.NewSimpleClientset(ciliumObjects(peers)...),
+	}
+
+	mesh := NewClusterMesh(logr.Discard(), &config.Config{})
+	if err := mesh.setPeers(context.Background(), mgmt, mb, peers); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := mgmt.Resource(capiClusterGVR).Namespace("cluster-01").Get(context.Background(), "cluster-01", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations := got.GetAnnotations()
+	if annotations["keep"] != "me" {
+		t.Errorf("annotations = %v, other annotations must be kept", annotations)
+	}
+
+	// the annotation is rendered as clustermesh.config.clusters Helm values
+	want := `[{"name":"cluster-mgmt","port":2379,"ips":["10.0.0.1"],"tls":{"cert":"Y2VydA==","key":"a2V5","caCert":"Y2E="}}]`
+	if annotations[meshPeersAnnotation] != want {
+		t.Errorf("%s annotation = %s, want %s", meshPeersAnnotation, annotations[meshPeersAnnotation], want)
+	}
+}
@@ -0,0 +1,286 @@
+package components
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/mirror"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const fluxKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - gotk-components.yaml
+`
+
+// FluxDir returns the directory where Flux manifests of the given version are vendored
+func FluxDir(version string) string {
+	return filepath.Join(utils.RepoRoot(), "k8s-platform", "flux", "v"+strings.TrimPrefix(version, "v"))
+}
+
+// VendorOptions select where components are vendored from
+type VendorOptions struct {
+	// Source is gotk-components.yaml to vendor, MirrorPath is used if it is not set
+	Source     string
+	MirrorPath string
+	// Force overwrites the version if it is already vendored
+	Force bool
+}
+
+// VendorFlux generates gotk-components.yaml and kustomization.yaml under k8s-platform/flux/v<version>.
+// Components are taken from the source file if provided, otherwise from the mirror directory
+// (see `mirror` command). A vendored version is not overwritten unless opts.Force is set. It returns
+// the diff against the closest previous vendored version, which is nil if there is no previous version.
+func VendorFlux(log logr.Logger, fluxVersion string, opts VendorOptions) (*FluxDiff, error) {
+	fluxVersion = strings.TrimPrefix(fluxVersion, "v")
+	if _, err := version.ParseSemantic(fluxVersion); err != nil {
+		return nil, fmt.Errorf("invalid Flux version %q: %w", fluxVersion, err)
+	}
+
+	dir := FluxDir(fluxVersion)
+	if _, err := os.Stat(dir); err == nil && !opts.Force {
+		return nil, fmt.Errorf("Flux v%s is already vendored in %s, use --force to overwrite it", fluxVersion, dir)
+	}
+
+	source := opts.Source
+	if source == "" {
+		if opts.MirrorPath == "" {
+			return nil, fmt.Errorf("no source provided and mirror path is not set in config")
+		}
+		source = mirror.FluxComponentsPath(opts.MirrorPath, fluxVersion)
+	}
+
+	components, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Flux components from %s: %w", source, err)
+	}
+
+	log.Info("Vendoring Flux components", "version", fluxVersion, "source", source, "path", dir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gotk-components.yaml"), components, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write gotk-components.yaml: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(fluxKustomization), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write kustomization.yaml: %w", err)
+	}
+
+	previous, err := previousFluxVersion(fluxVersion)
+	if err != nil {
+		return nil, err
+	}
+	if previous == "" {
+		log.Info("No previous Flux version is vendored, skipping diff")
+		return nil, nil
+	}
+
+	previousComponents, err := os.ReadFile(filepath.Join(FluxDir(previous), "gotk-components.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Flux v%s components: %w", previous, err)
+	}
+
+	return DiffFluxComponents(previous, previousComponents, fluxVersion, components)
+}
+
+// previousFluxVersion returns the highest vendored Flux version which is lower than the given one
+func previousFluxVersion(fluxVersion string) (string, error) {
+	current, err := version.ParseSemantic(fluxVersion)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(FluxDir(fluxVersion)))
+	if err != nil {
+		return "", fmt.Errorf("failed to list vendored Flux versions: %w", err)
+	}
+
+	var previous *version.Version
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := version.ParseSemantic(strings.TrimPrefix(entry.Name(), "v"))
+		if err != nil || !v.LessThan(current) {
+			continue
+		}
+		if previous == nil || previous.LessThan(v) {
+			previous = v
+		}
+	}
+
+	if previous == nil {
+		return "", nil
+	}
+	return previous.String(), nil
+}
+
+// crdVersions describes API versions of a CRD
+type crdVersions struct {
+	Served  []string
+	Storage string
+}
+
+// CRDVersionChange describes changes to API versions of a CRD which exists in both Flux versions
+type CRDVersionChange struct {
+	Name            string
+	AddedVersions   []string
+	RemovedVersions []string
+	PreviousStorage string
+	Storage         string
+}
+
+// FluxDiff summarises CRD changes between two Flux versions
+type FluxDiff struct {
+	PreviousVersion string
+	Version         string
+	AddedCRDs       []string
+	RemovedCRDs     []string
+	VersionChanges  []CRDVersionChange
+}
+
+// DiffFluxComponents compares CRDs in two gotk-components manifests
+func DiffFluxComponents(previousVersion string, previous []byte, newVersion string, current []byte) (*FluxDiff, error) {
+	previousCRDs, err := parseCRDs(previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Flux v%s components: %w", previousVersion, err)
+	}
+	currentCRDs, err := parseCRDs(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Flux v%s components: %w", newVersion, err)
+	}
+
+	diff := &FluxDiff{PreviousVersion: previousVersion, Version: newVersion}
+
+	for name, versions := range currentCRDs {
+		previousVersions, ok := previousCRDs[name]
+		if !ok {
+			diff.AddedCRDs = append(diff.AddedCRDs, name)
+			continue
+		}
+
+		change := CRDVersionChange{
+			Name:            name,
+			AddedVersions:   subtract(versions.Served, previousVersions.Served),
+			RemovedVersions: subtract(previousVersions.Served, versions.Served),
+			PreviousStorage: previousVersions.Storage,
+			Storage:         versions.Storage,
+		}
+		if len(change.AddedVersions) > 0 || len(change.RemovedVersions) > 0 || change.PreviousStorage != change.Storage {
+			diff.VersionChanges = append(diff.VersionChanges, change)
+		}
+	}
+
+	for name := range previousCRDs {
+		if _, ok := currentCRDs[name]; !ok {
+			diff.RemovedCRDs = append(diff.RemovedCRDs, name)
+		}
+	}
+
+	sort.Strings(diff.AddedCRDs)
+	sort.Strings(diff.RemovedCRDs)
+	sort.Slice(diff.VersionChanges, func(i, j int) bool { return diff.VersionChanges[i].Name < diff.VersionChanges[j].Name })
+
+	return diff, nil
+}
+
+// String renders the diff in a human readable form
+func (d *FluxDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Flux v%s -> v%s\n", d.PreviousVersion, d.Version)
+
+	if len(d.AddedCRDs) == 0 && len(d.RemovedCRDs) == 0 && len(d.VersionChanges) == 0 {
+		b.WriteString("  no CRD changes\n")
+		return b.String()
+	}
+
+	for _, name := range d.AddedCRDs {
+		fmt.Fprintf(&b, "  + CRD %s\n", name)
+	}
+	for _, name := range d.RemovedCRDs {
+		fmt.Fprintf(&b, "  - CRD %s\n", name)
+	}
+	for _, change := range d.VersionChanges {
+		fmt.Fprintf(&b, "  ~ CRD %s\n", change.Name)
+		for _, v := range change.AddedVersions {
+			fmt.Fprintf(&b, "      + version %s\n", v)
+		}
+		for _, v := range change.RemovedVersions {
+			fmt.Fprintf(&b, "      - version %s\n", v)
+		}
+		if change.PreviousStorage != change.Storage {
+			fmt.Fprintf(&b, "      storage version %s -> %s\n", change.PreviousStorage, change.Storage)
+		}
+	}
+	return b.String()
+}
+
+func parseCRDs(manifests []byte) (map[string]crdVersions, error) {
+	crds := make(map[string]crdVersions)
+
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifests), 4096)
+	for {
+		var obj unstructured.Unstructured
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode YAML document: %w", err)
+		}
+		if obj.Object == nil || obj.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+
+		versions, _, err := unstructured.NestedSlice(obj.Object, "spec", "versions")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read versions of CRD %s: %w", obj.GetName(), err)
+		}
+
+		var crd crdVersions
+		for _, v := range versions {
+			apiVersion, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := apiVersion["name"].(string)
+			if served, _ := apiVersion["served"].(bool); served {
+				crd.Served = append(crd.Served, name)
+			}
+			if storage, _ := apiVersion["storage"].(bool); storage {
+				crd.Storage = name
+			}
+		}
+		crds[obj.GetName()] = crd
+	}
+	return crds, nil
+}
+
+// subtract returns elements of a which are not in b
+func subtract(a, b []string) []string {
+	var result []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, x)
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package components
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func crd(name string, versions ...string) string {
+	var b strings.Builder
+	b.WriteString("---\napiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: " + name + "\nspec:\n  versions:\n")
+	for i, v := range versions {
+		// the last version is the storage version
+		b.WriteString("  - name: " + v + "\n    served: true\n")
+		if i == len(versions)-1 {
+			b.WriteString("    storage: true\n")
+		} else {
+			b.WriteString("    storage: false\n")
+		}
+	}
+	return b.String()
+}
+
+func TestDiffFluxComponents(t *testing.T) {
+	deployment := "---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: source-controller\n"
+	previous := crd("gitrepositories.source.toolkit.fluxcd.io", "v1beta2", "v1") +
+		crd("kustomizations.kustomize.toolkit.fluxcd.io", "v1beta2") +
+		crd("alerts.notification.toolkit.fluxcd.io", "v1beta2") + deployment
+	current := crd("gitrepositories.source.toolkit.fluxcd.io", "v1beta2", "v1") +
+		crd("kustomizations.kustomize.toolkit.fluxcd.io", "v1beta2", "v1") +
+		crd("ocirepositories.source.toolkit.fluxcd.io", "v1beta2") + deployment
+
+	diff, err := DiffFluxComponents("2.1.1", []byte(previous), "2.2.2", []byte(current))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &FluxDiff{
+		PreviousVersion: "2.1.1",
+		Version:         "2.2.2",
+		AddedCRDs:       []string{"ocirepositories.source.toolkit.fluxcd.io"},
+		RemovedCRDs:     []string{"alerts.notification.toolkit.fluxcd.io"},
+		VersionChanges: []CRDVersionChange{{
+			Name:            "kustomizations.kustomize.toolkit.fluxcd.io",
+			AddedVersions:   []string{"v1"},
+			PreviousStorage: "v1beta2",
+			Storage:         "v1",
+		}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("DiffFluxComponents() = %+v, want %+v", diff, want)
+	}
+	for _, line := range []string{"+ CRD ocirepositories", "- CRD alerts", "+ version v1", "storage version v1beta2 -> v1"} {
+		if !strings.Contains(diff.String(), line) {
+			t.Errorf("diff output doesn't contain %q:\n%s", line, diff)
+		}
+	}
+}
+
+func TestDiffFluxComponentsNoChanges(t *testing.T) {
+	components := []byte(crd("gitrepositories.source.toolkit.fluxcd.io", "v1"))
+	diff, err := DiffFluxComponents("2.2.1", components, "2.2.2", components)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff.String(), "no CRD changes") {
+		t.Errorf("diff = %q, want no CRD changes", diff)
+	}
+}
+
+func TestDiffFluxComponentsInvalid(t *testing.T) {
+	if _, err := DiffFluxComponents("2.1.1", []byte("a: [b"), "2.2.2", nil); err == nil {
+		t.Error("DiffFluxComponents() of invalid YAML returned no error")
+	}
+}
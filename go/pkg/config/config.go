@@ -3,15 +3,39 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
 )
 
 type Config struct {
 	Clusters       []ClusterConfig
 	Github         GithubConfig `mapstructure:"github"`
 	KubeconfigPath string       `mapstructure:"kubeconfigPath"`
+	Mirror         MirrorConfig `mapstructure:"mirror"`
+	CAPI           CAPIConfig   `mapstructure:"capi"`
+	IPAM           IPAMConfig   `mapstructure:"ipam"`
+	// AWSIdentities are AWS accounts or roles which clusters are created with, see AWSConfig.Identity
+	AWSIdentities []AWSIdentityConfig `mapstructure:"awsIdentities"`
+	SOPS          SOPSConfig          `mapstructure:"sops"`
+	// AddonGroups are sets of add-ons shared by clusters, referenced by name from ClusterConfig.AddonGroups
+	AddonGroups []AddonGroupConfig `mapstructure:"addonGroups"`
+	// Tenants define sizing of tenants onboarded with `tenant add`, tenants which are not listed get defaults
+	Tenants  []TenantConfig `mapstructure:"tenants"`
+	Operator OperatorConfig `mapstructure:"operator"`
+}
+
+// OperatorConfig enables the in-cluster operator. When Image is set, deploy installs the operator on the
+// permanent management cluster and hands the fleet over to it, see `operator` command
+type OperatorConfig struct {
+	Image string `mapstructure:"image"`
 }
 
 // TODO - URL and GithubKnownHosts are not expected to be provided by the user
@@ -31,64 +55,447 @@ type ClusterConfig struct {
 	Provider          string     `mapstructure:"provider"`
 	KubernetesVersion string     `mapstructure:"kubernetesVersion"`
 	PodCIDR           string     `mapstructure:"podCIDR"`
+	ServiceCIDR       string     `mapstructure:"serviceCIDR"`
 	ManagementCluster string     `mapstructure:"managementCluster"`
 	Flux              FluxConfig `mapstructure:"flux"`
 	CNI               CNIConfig  `mapstructure:"cni"`
 	AWS               AWSConfig  `mapstructure:"aws"`
+	Mesh              MeshConfig `mapstructure:"mesh"`
+	// Labels are used to select clusters, e.g. by `tenant add --clusters`
+	Labels map[string]string `mapstructure:"labels"`
+	// Add-ons installed by CAAPH, Addons of the cluster override add-ons with the same name from AddonGroups
+	AddonGroups []string      `mapstructure:"addonGroups"`
+	Addons      []AddonConfig `mapstructure:"addons"`
+	// Number of machines, used only when generating cluster manifests
+	ControlPlaneMachineCount int `mapstructure:"controlPlaneMachineCount"`
+	WorkerMachineCount       int `mapstructure:"workerMachineCount"`
 }
 
 type FluxConfig struct {
-	KeyPath   string `mapstructure:"keyPath"`
+	// KeyPath is a shortcut for KeySource of "file" type
+	KeyPath   string             `mapstructure:"keyPath"`
+	KeySource SecretSourceConfig `mapstructure:"keySource"`
+	Version   string             `mapstructure:"version"`
+	Namespace string             `mapstructure:"namespace"`
+	// ImageRegistry is not set by the user, it is copied from MirrorConfig.Registry
+	ImageRegistry string
+	// Decryption is not set by the user, it is copied from SOPSConfig.Enabled
+	Decryption bool
+}
+
+// SecretSourceConfig defines where a secret is read from:
+//   - "file": Path
+//   - "env": environment variable Env
+//   - "command": stdout of Command, e.g. a password manager CLI
+//   - "kubernetes": Key of the secret Namespace/Name on Cluster from config
+type SecretSourceConfig struct {
+	Type      string   `mapstructure:"type"`
+	Path      string   `mapstructure:"path"`
+	Env       string   `mapstructure:"env"`
+	Command   []string `mapstructure:"command"`
+	Cluster   string   `mapstructure:"cluster"`
+	Namespace string   `mapstructure:"namespace"`
+	Name      string   `mapstructure:"name"`
+	Key       string   `mapstructure:"key"`
+	// KubeconfigPath is not set by the user, it is copied from Config.KubeconfigPath
+	KubeconfigPath string
+}
+
+// CNIConfig defines CNI installed by CAAPH on the cluster. Type is "cilium" or "calico",
+// Version must be vendored in k8s-platform/cni-caaph-resource/<type>/v<version>.
+// Values is a YAML document with Helm values merged over the vendored defaults. It is kept
+// as a string, same as in the MultiCluster spec, because it may use CAAPH template actions
+// which are not valid YAML until CAAPH renders them, e.g. {{ .Cluster.metadata.name }}.
+type CNIConfig struct {
+	Type    string `mapstructure:"type"`
+	Version string `mapstructure:"version"`
+	Values  string `mapstructure:"values"`
+}
+
+// AddonConfig is a Helm chart installed on the cluster by CAAPH. Namespace is the namespace of
+// the release, it defaults to the add-on name. Values is a YAML string like CNIConfig.Values
+// and may use CAAPH template actions, e.g. {{ .Cluster.metadata.name }}
+type AddonConfig struct {
+	Name      string `mapstructure:"name"`
+	RepoURL   string `mapstructure:"repoURL"`
+	Chart     string `mapstructure:"chart"`
 	Version   string `mapstructure:"version"`
 	Namespace string `mapstructure:"namespace"`
+	Values    string `mapstructure:"values"`
 }
 
-type CNIConfig struct {
-	Type   string `mapstructure:"type"`
-	Config string `mapstructure:"config"`
+type AddonGroupConfig struct {
+	Name   string        `mapstructure:"name"`
+	Addons []AddonConfig `mapstructure:"addons"`
+}
+
+// TenantConfig sets guardrails of the tenant namespace: ResourceQuota of the namespace and LimitRange
+// defaults for containers which don't set requests and limits. Values are Kubernetes quantities.
+type TenantConfig struct {
+	Name   string             `mapstructure:"name"`
+	Quota  TenantQuotaConfig  `mapstructure:"quota"`
+	Limits TenantLimitsConfig `mapstructure:"limits"`
+	// KeySource is the SSH key of the tenant repository, required when it is pulled over ssh://
+	KeySource SecretSourceConfig `mapstructure:"keySource"`
+}
+
+type TenantQuotaConfig struct {
+	CPU    string `mapstructure:"cpu"`
+	Memory string `mapstructure:"memory"`
+	Pods   string `mapstructure:"pods"`
+}
+
+type TenantLimitsConfig struct {
+	DefaultCPU           string `mapstructure:"defaultCPU"`
+	DefaultMemory        string `mapstructure:"defaultMemory"`
+	DefaultRequestCPU    string `mapstructure:"defaultRequestCPU"`
+	DefaultRequestMemory string `mapstructure:"defaultRequestMemory"`
+}
+
+// MeshConfig defines Cilium Cluster Mesh membership. Clusters with the same Label are
+// connected to each other, "none" or empty label means the cluster is not in a mesh.
+// ClusterID must be unique across meshed clusters and in the range 1-255 (Cilium requirement)
+type MeshConfig struct {
+	Label     string `mapstructure:"label"`
+	ClusterID int    `mapstructure:"clusterID"`
+}
+
+// Enabled returns true if the cluster is a member of a mesh
+func (m MeshConfig) Enabled() bool {
+	return m.Label != "" && m.Label != MeshLabelNone
 }
 
 type AWSConfig struct {
-	SSHKeyName string `mapstructure:"sshKeyName"`
-	Region     string `mapstructure:"region"`
+	SSHKeyName              string `mapstructure:"sshKeyName"`
+	Region                  string `mapstructure:"region"`
+	ControlPlaneMachineType string `mapstructure:"controlPlaneMachineType"`
+	NodeMachineType         string `mapstructure:"nodeMachineType"`
+	// Identity is a name from Config.AWSIdentities. If it is not set, CAPA controller credentials
+	// from AWS_B64ENCODED_CREDENTIALS are used.
+	Identity string `mapstructure:"identity"`
 }
 
+// AWSIdentityConfig is realised as CAPA AWSClusterStaticIdentity ("static") or AWSClusterRoleIdentity
+// ("role"). Static identity credentials are read from the environment variables named in config and
+// stored in a secret in CAPA namespace, they are never written to the repo. Role identity assumes
+// RoleARN using SourceIdentity, which is another identity from config or the CAPA controller
+// identity if it is not set.
+type AWSIdentityConfig struct {
+	Name               string `mapstructure:"name"`
+	Type               string `mapstructure:"type"`
+	AccessKeyIDEnv     string `mapstructure:"accessKeyIDEnv"`
+	SecretAccessKeyEnv string `mapstructure:"secretAccessKeyEnv"`
+	RoleARN            string `mapstructure:"roleARN"`
+	ExternalID         string `mapstructure:"externalID"`
+	SourceIdentity     string `mapstructure:"sourceIdentity"`
+}
+
+// SecretName returns name of the secret in CAPA namespace with static identity credentials
+func (i *AWSIdentityConfig) SecretName() string {
+	return i.Name + "-credentials"
+}
+
+// ManagedBy returns name of the management cluster of the cluster, management cluster is created
+// from the temporary kind cluster
+func (c *ClusterConfig) ManagedBy() string {
+	if c.ManagementCluster == "" {
+		return DefaultKindClusterName
+	}
+	return c.ManagementCluster
+}
+
+// ManagedClusters returns names of the clusters managed by the management cluster
+func (c *Config) ManagedClusters(managementCluster string) []string {
+	var names []string
+	for i := range c.Clusters {
+		if c.Clusters[i].Provider != "kind" && c.Clusters[i].ManagedBy() == managementCluster {
+			names = append(names, c.Clusters[i].Name)
+		}
+	}
+	return names
+}
+
+// PermanentManagementCluster returns the cluster created by the temporary kind cluster, which
+// manages the workload clusters after the pivot. Nil if there is no such cluster in config.
+func (c *Config) PermanentManagementCluster() *ClusterConfig {
+	for i := range c.Clusters {
+		if c.Clusters[i].Provider != "kind" && c.Clusters[i].ManagementCluster == "" {
+			return &c.Clusters[i]
+		}
+	}
+	return nil
+}
+
+// ManagementClusterNames returns the temporary kind cluster and all clusters which manage other clusters
+func (c *Config) ManagementClusterNames() []string {
+	names := []string{DefaultKindClusterName}
+	for _, cluster := range c.Clusters {
+		if cluster.ManagementCluster != "" && !slices.Contains(names, cluster.ManagementCluster) {
+			names = append(names, cluster.ManagementCluster)
+		}
+	}
+	return names
+}
+
+// SelectWorkloadClusters returns workload clusters matching the selector, which is either a comma separated
+// list of cluster names or a label selector, e.g. "env=dev,team in (a,b)"
+func (c *Config) SelectWorkloadClusters(selector string) ([]*ClusterConfig, error) {
+	var selected []*ClusterConfig
+	if !strings.ContainsAny(selector, "=!()") {
+		for _, name := range strings.Split(selector, ",") {
+			name = strings.TrimSpace(name)
+			cluster := c.Cluster(name)
+			if cluster == nil {
+				return nil, fmt.Errorf("cluster %s is not defined in config", name)
+			}
+			if cluster.Provider == "kind" || cluster.ManagementCluster == "" {
+				return nil, fmt.Errorf("cluster %s is not a workload cluster", name)
+			}
+			selected = append(selected, cluster)
+		}
+		return selected, nil
+	}
+
+	labelSelector, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster selector %q: %w", selector, err)
+	}
+	for i := range c.Clusters {
+		cluster := &c.Clusters[i]
+		if cluster.Provider == "kind" || cluster.ManagementCluster == "" {
+			continue
+		}
+		if labelSelector.Matches(labels.Set(cluster.Labels)) {
+			selected = append(selected, cluster)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no workload clusters match %q", selector)
+	}
+	return selected, nil
+}
+
+// Cluster returns the cluster from config, nil if there is no cluster with the name
+func (c *Config) Cluster(name string) *ClusterConfig {
+	for i := range c.Clusters {
+		if c.Clusters[i].Name == name {
+			return &c.Clusters[i]
+		}
+	}
+	return nil
+}
+
+// Tenant returns sizing of the tenant, defaults are returned for tenants which are not in config
+func (c *Config) Tenant(name string) TenantConfig {
+	for _, tenant := range c.Tenants {
+		if tenant.Name == name {
+			return tenant
+		}
+	}
+	tenant := TenantConfig{Name: name}
+	setTenantDefaults(&tenant)
+	return tenant
+}
+
+// AWSIdentity returns identity by name
+func (c *Config) AWSIdentity(name string) (*AWSIdentityConfig, bool) {
+	for i := range c.AWSIdentities {
+		if c.AWSIdentities[i].Name == name {
+			return &c.AWSIdentities[i], true
+		}
+	}
+	return nil, false
+}
+
+// RequiresAWSControllerCredentials returns true if any AWS cluster or role identity uses
+// CAPA controller credentials, which are provided in AWS_B64ENCODED_CREDENTIALS
+func (c *Config) RequiresAWSControllerCredentials() bool {
+	for _, cluster := range c.Clusters {
+		if cluster.Provider == "aws" && cluster.AWS.Identity == "" {
+			return true
+		}
+	}
+	for _, identity := range c.AWSIdentities {
+		if identity.Type == AWSIdentityRole && identity.SourceIdentity == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// CAPIConfig defines Cluster API providers installed on management clusters.
+// Providers are in clusterctl format "name:version", e.g. "aws:v2.3.1"
+type CAPIConfig struct {
+	Providers ProvidersConfig `mapstructure:"providers"`
+}
+
+type ProvidersConfig struct {
+	Core           string   `mapstructure:"core"`
+	Bootstrap      []string `mapstructure:"bootstrap"`
+	ControlPlane   []string `mapstructure:"controlPlane"`
+	Infrastructure []string `mapstructure:"infrastructure"`
+	Addon          []string `mapstructure:"addon"`
+}
+
+// IPAMConfig defines address pools from which pod and service CIDRs are allocated to
+// clusters which don't set them explicitly (see `ipam` command). Allocations are stored
+// in StatePath so that a cluster keeps its CIDRs across runs.
+type IPAMConfig struct {
+	PodCIDRPool         string `mapstructure:"podCIDRPool"`
+	PodPrefixLength     int    `mapstructure:"podPrefixLength"`
+	ServiceCIDRPool     string `mapstructure:"serviceCIDRPool"`
+	ServicePrefixLength int    `mapstructure:"servicePrefixLength"`
+	StatePath           string `mapstructure:"statePath"`
+}
+
+// SOPSConfig enables decryption of SOPS encrypted manifests by Flux. AgeKeyPath is the age
+// private key, it is generated if it does not exist. The key is never committed, it is stored
+// in SOPSSecretName secret on management clusters.
+type SOPSConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	AgeKeyPath string `mapstructure:"ageKeyPath"`
+}
+
+// MirrorConfig points the app at a local copy of everything that is normally
+// downloaded from the internet during deploy (see `mirror` command).
+// Path is a directory populated by `mirror`, Registry is an optional container
+// registry where mirrored images and charts are pushed to, e.g. "registry.local:5000"
+type MirrorConfig struct {
+	Path     string `mapstructure:"path"`
+	Registry string `mapstructure:"registry"`
+}
+
+// LoadConfig loads config.yaml from the path. The file is either unversioned config or a MultiCluster
+// resource, both are decoded by v1alpha1.Load, which rejects unknown fields.
 func LoadConfig(path string) (*Config, error) {
-	var config Config
+	multiCluster, err := loadMultiCluster(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromSpec(&multiCluster.Spec)
+}
+
+// LoadMirrorConfig loads only the mirror section of config, for commands which work with local
+// files and don't need clusters, credentials or kubeconfig
+func LoadMirrorConfig(path string) (*MirrorConfig, error) {
+	var mirror MirrorConfig
+
+	multiCluster, err := loadMultiCluster(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeSpec(&multiCluster.Spec.Mirror, &mirror); err != nil {
+		return nil, err
+	}
+	if err := ensureSafePath(&mirror.Path); err != nil {
+		return nil, err
+	}
+	return &mirror, nil
+}
 
+// loadMultiCluster finds config.yaml in the path, the file is available from viper.ConfigFileUsed
+func loadMultiCluster(path string) (*v1alpha1.MultiCluster, error) {
 	viper.AddConfigPath(path)
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 
-	viper.AutomaticEnv()
-
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
 
-	if err := viper.Unmarshal(&config); err != nil {
+	data, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+	return v1alpha1.Load(data)
+}
+
+// FromSpec converts the spec of MultiCluster resource to config, sets defaults and validates it.
+// It is used for the config file and for the resource reconciled by the operator.
+func FromSpec(spec *v1alpha1.MultiClusterSpec) (*Config, error) {
+	var config Config
+
+	if err := decodeSpec(spec, &config); err != nil {
 		return nil, err
 	}
 
-	setDefaults(&config)
+	if err := setDefaults(&config); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
 
+// decodeSpec decodes a part of the spec into config, which has the same field names.
+// A spec field which config doesn't have is an error, so that the types don't drift apart.
+func decodeSpec(spec interface{}, out interface{}) error {
+	values, err := runtime.DefaultUnstructuredConverter.ToUnstructured(spec)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s spec: %w", MultiClusterKind, err)
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{Result: out, ErrorUnused: true})
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(values); err != nil {
+		return fmt.Errorf("failed to decode %s spec: %w", MultiClusterKind, err)
+	}
+	return nil
+}
+
 func setDefaults(config *Config) error {
 	// has to be accessed by index, so that paths are replaced by passing
 	// to the relevant fields
+	if err := validateClusters(config.Clusters); err != nil {
+		return err
+	}
+
 	for i := range config.Clusters {
 		if config.Clusters[i].Flux.Namespace == "" {
 			config.Clusters[i].Flux.Namespace = FluxNamespace
 		}
 
-		err := ensureSafePath(&config.Clusters[i].Flux.KeyPath)
-		if err != nil {
+		if err := setKeySourceDefaults(&config.Clusters[i].Flux); err != nil {
+			return fmt.Errorf("cluster %s: flux key: %w", config.Clusters[i].Name, err)
+		}
+
+		if err := setClusterDefaults(&config.Clusters[i]); err != nil {
+			return err
+		}
+	}
+	kindCluster, err := kindClusterConfig(DefaultKindClusterName, config.Clusters)
+	if err != nil {
+		return err
+	}
+	config.Clusters = append(config.Clusters, kindCluster)
+
+	setProvidersDefaults(&config.CAPI.Providers)
+
+	if config.IPAM.StatePath == "" {
+		config.IPAM.StatePath = DefaultIPAMStatePath
+	}
+	if err := ensureSafePath(&config.IPAM.StatePath); err != nil {
+		return err
+	}
+
+	if config.Mirror.Path != "" {
+		if err := ensureSafePath(&config.Mirror.Path); err != nil {
 			return err
 		}
+	} else if config.Mirror.Registry != "" {
+		return fmt.Errorf("mirror path is required when mirror registry is set, charts are read from it")
+	}
+	if config.SOPS.AgeKeyPath == "" {
+		config.SOPS.AgeKeyPath = DefaultAgeKeyPath
+	}
+	if err := ensureSafePath(&config.SOPS.AgeKeyPath); err != nil {
+		return err
+	}
+
+	for i := range config.Clusters {
+		config.Clusters[i].Flux.ImageRegistry = config.Mirror.Registry
+		config.Clusters[i].Flux.Decryption = config.SOPS.Enabled
 	}
-	config.Clusters = append(config.Clusters, kindClusterConfig(DefaultKindClusterName))
 
 	// if kubeconfigPath is not set, use K8S_MULTI_KUBECONFIG environment variable.
 	// kubeconfig path MUST be provided by the user explicitely in one of these two ways
@@ -102,10 +509,15 @@ func setDefaults(config *Config) error {
 		}
 	}
 
-	err := ensureSafePath(&config.KubeconfigPath)
-	if err != nil {
+	if err := ensureSafePath(&config.KubeconfigPath); err != nil {
 		return err
 	}
+	for i := range config.Clusters {
+		config.Clusters[i].Flux.KeySource.KubeconfigPath = config.KubeconfigPath
+	}
+	for i := range config.Tenants {
+		config.Tenants[i].KeySource.KubeconfigPath = config.KubeconfigPath
+	}
 	// Validate Github config
 	if config.Github.User == "" || config.Github.RepoName == "" {
 		return fmt.Errorf("github user and repo are not set")
@@ -118,9 +530,331 @@ func setDefaults(config *Config) error {
 	config.Github.URL = "ssh://git@github.com/" + config.Github.User + "/" + config.Github.RepoName
 	config.Github.GithubKnownHosts = GithubKnownHosts
 
+	if err := validateAddons(config); err != nil {
+		return err
+	}
+
+	if err := validateTenants(config.Tenants); err != nil {
+		return err
+	}
+
+	if err := validateAWSIdentities(config); err != nil {
+		return err
+	}
+
+	return validateMesh(config.Clusters)
+}
+
+// validateClusters checks that cluster names are unique and management clusters are defined
+func validateClusters(clusters []ClusterConfig) error {
+	names := make(map[string]bool)
+	for _, cluster := range clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("cluster name is not set")
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("cluster %s is defined more than once", cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	for _, cluster := range clusters {
+		if cluster.ManagementCluster != "" && (!names[cluster.ManagementCluster] || cluster.ManagementCluster == cluster.Name) {
+			return fmt.Errorf("cluster %s: management cluster %s is not defined", cluster.Name, cluster.ManagementCluster)
+		}
+	}
 	return nil
 }
 
+// validateAWSIdentities checks identity definitions and references to them
+func validateAWSIdentities(config *Config) error {
+	names := make(map[string]bool)
+	for _, identity := range config.AWSIdentities {
+		if identity.Name == "" {
+			return fmt.Errorf("AWS identity name is not set")
+		}
+		if names[identity.Name] {
+			return fmt.Errorf("AWS identity %s is defined more than once", identity.Name)
+		}
+		names[identity.Name] = true
+
+		switch identity.Type {
+		case AWSIdentityStatic:
+			if identity.AccessKeyIDEnv == "" || identity.SecretAccessKeyEnv == "" {
+				return fmt.Errorf("AWS identity %s: accessKeyIDEnv and secretAccessKeyEnv are required", identity.Name)
+			}
+		case AWSIdentityRole:
+			if identity.RoleARN == "" {
+				return fmt.Errorf("AWS identity %s: roleARN is required", identity.Name)
+			}
+		default:
+			return fmt.Errorf("AWS identity %s: unsupported type %q, supported: %s, %s", identity.Name, identity.Type, AWSIdentityStatic, AWSIdentityRole)
+		}
+	}
+
+	for _, identity := range config.AWSIdentities {
+		if identity.SourceIdentity == "" {
+			continue
+		}
+		if !names[identity.SourceIdentity] || identity.SourceIdentity == identity.Name {
+			return fmt.Errorf("AWS identity %s: source identity %s is not defined", identity.Name, identity.SourceIdentity)
+		}
+	}
+	for _, cluster := range config.Clusters {
+		if cluster.AWS.Identity != "" && !names[cluster.AWS.Identity] {
+			return fmt.Errorf("cluster %s: AWS identity %s is not defined", cluster.Name, cluster.AWS.Identity)
+		}
+	}
+	return nil
+}
+
+// ClusterAddons returns add-ons of the cluster: add-ons of its groups in the order the groups
+// are listed, followed by the cluster own add-ons. Add-ons with the same name are replaced
+// by the later definition.
+func (c *Config) ClusterAddons(cluster *ClusterConfig) []AddonConfig {
+	var addons []AddonConfig
+	index := make(map[string]int)
+	add := func(addon AddonConfig) {
+		if i, ok := index[addon.Name]; ok {
+			addons[i] = addon
+			return
+		}
+		index[addon.Name] = len(addons)
+		addons = append(addons, addon)
+	}
+
+	for _, groupName := range cluster.AddonGroups {
+		for _, group := range c.AddonGroups {
+			if group.Name != groupName {
+				continue
+			}
+			for _, addon := range group.Addons {
+				add(addon)
+			}
+		}
+	}
+	for _, addon := range cluster.Addons {
+		add(addon)
+	}
+	return addons
+}
+
+// validateAddons checks add-on definitions and group references and sets the default namespace
+func validateAddons(config *Config) error {
+	groups := make(map[string]bool)
+	for i := range config.AddonGroups {
+		group := &config.AddonGroups[i]
+		if group.Name == "" {
+			return fmt.Errorf("addon group name is not set")
+		}
+		if groups[group.Name] {
+			return fmt.Errorf("addon group %s is defined more than once", group.Name)
+		}
+		groups[group.Name] = true
+		if err := validateAddonList(group.Addons); err != nil {
+			return fmt.Errorf("addon group %s: %w", group.Name, err)
+		}
+	}
+
+	for i := range config.Clusters {
+		cluster := &config.Clusters[i]
+		for _, groupName := range cluster.AddonGroups {
+			if !groups[groupName] {
+				return fmt.Errorf("cluster %s: addon group %s is not defined", cluster.Name, groupName)
+			}
+		}
+		if err := validateAddonList(cluster.Addons); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateAddonList(addons []AddonConfig) error {
+	names := make(map[string]bool)
+	for i := range addons {
+		addon := &addons[i]
+		if addon.Name == "" || addon.RepoURL == "" || addon.Chart == "" || addon.Version == "" {
+			return fmt.Errorf("addon %q: name, repoURL, chart and version are required", addon.Name)
+		}
+		if names[addon.Name] {
+			return fmt.Errorf("addon %s is defined more than once", addon.Name)
+		}
+		names[addon.Name] = true
+		if addon.Namespace == "" {
+			addon.Namespace = addon.Name
+		}
+	}
+	return nil
+}
+
+// validateTenants sets defaults of tenant sizing and checks that values are valid quantities
+func validateTenants(tenants []TenantConfig) error {
+	names := make(map[string]bool)
+	for i := range tenants {
+		tenant := &tenants[i]
+		if tenant.Name == "" {
+			return fmt.Errorf("tenant name is not set")
+		}
+		if names[tenant.Name] {
+			return fmt.Errorf("tenant %s is defined more than once", tenant.Name)
+		}
+		names[tenant.Name] = true
+
+		setTenantDefaults(tenant)
+		if tenant.KeySource.Type != "" {
+			if err := validateSecretSource(&tenant.KeySource); err != nil {
+				return fmt.Errorf("tenant %s keySource: %w", tenant.Name, err)
+			}
+		}
+		for _, value := range []string{tenant.Quota.CPU, tenant.Quota.Memory, tenant.Quota.Pods,
+			tenant.Limits.DefaultCPU, tenant.Limits.DefaultMemory, tenant.Limits.DefaultRequestCPU, tenant.Limits.DefaultRequestMemory} {
+			if _, err := resource.ParseQuantity(value); err != nil {
+				return fmt.Errorf("tenant %s: invalid quantity %q: %w", tenant.Name, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setTenantDefaults(tenant *TenantConfig) {
+	defaults := map[*string]string{
+		&tenant.Quota.CPU:                   DefaultTenantQuotaCPU,
+		&tenant.Quota.Memory:                DefaultTenantQuotaMemory,
+		&tenant.Quota.Pods:                  DefaultTenantQuotaPods,
+		&tenant.Limits.DefaultCPU:           DefaultTenantLimitCPU,
+		&tenant.Limits.DefaultMemory:        DefaultTenantLimitMemory,
+		&tenant.Limits.DefaultRequestCPU:    DefaultTenantRequestCPU,
+		&tenant.Limits.DefaultRequestMemory: DefaultTenantRequestMemory,
+	}
+	for field, value := range defaults {
+		if *field == "" {
+			*field = value
+		}
+	}
+}
+
+// validateMesh checks that Cilium cluster IDs are valid and unique among meshed clusters
+func validateMesh(clusters []ClusterConfig) error {
+	ids := make(map[int]string)
+	for _, cluster := range clusters {
+		if !cluster.Mesh.Enabled() {
+			continue
+		}
+		if cluster.CNI.Type != CNICilium {
+			return fmt.Errorf("cluster %s: mesh requires cilium CNI, got %s", cluster.Name, cluster.CNI.Type)
+		}
+		if cluster.Mesh.ClusterID < 1 || cluster.Mesh.ClusterID > 255 {
+			return fmt.Errorf("cluster %s: mesh clusterID must be in range 1-255, got %d", cluster.Name, cluster.Mesh.ClusterID)
+		}
+		if other, ok := ids[cluster.Mesh.ClusterID]; ok {
+			return fmt.Errorf("clusters %s and %s have the same mesh clusterID %d", other, cluster.Name, cluster.Mesh.ClusterID)
+		}
+		ids[cluster.Mesh.ClusterID] = cluster.Name
+	}
+	return nil
+}
+
+// setKeySourceDefaults converts KeyPath to a file source and validates the source
+func setKeySourceDefaults(flux *FluxConfig) error {
+	source := &flux.KeySource
+	if source.Type == "" && flux.KeyPath != "" {
+		source.Type, source.Path = SecretSourceFile, flux.KeyPath
+	}
+
+	if source.Type == "" {
+		return fmt.Errorf("keyPath or keySource must be set")
+	}
+	if err := validateSecretSource(source); err != nil {
+		return err
+	}
+	if source.Type == SecretSourceFile {
+		flux.KeyPath = source.Path
+	}
+	return nil
+}
+
+// validateSecretSource checks that the fields of the source type are set and sets defaults
+func validateSecretSource(source *SecretSourceConfig) error {
+	switch source.Type {
+	case SecretSourceFile:
+		if source.Path == "" {
+			return fmt.Errorf("path is required for %s source", source.Type)
+		}
+		if err := ensureSafePath(&source.Path); err != nil {
+			return err
+		}
+	case SecretSourceEnv:
+		if source.Env == "" {
+			return fmt.Errorf("env is required for %s source", source.Type)
+		}
+	case SecretSourceCommand:
+		if len(source.Command) == 0 {
+			return fmt.Errorf("command is required for %s source", source.Type)
+		}
+	case SecretSourceKubernetes:
+		if source.Cluster == "" || source.Name == "" {
+			return fmt.Errorf("cluster and name are required for %s source", source.Type)
+		}
+		if source.Namespace == "" {
+			source.Namespace = FluxNamespace
+		}
+		if source.Key == "" {
+			source.Key = "identity"
+		}
+	default:
+		return fmt.Errorf("unsupported source type %q, supported: %s, %s, %s, %s", source.Type,
+			SecretSourceFile, SecretSourceEnv, SecretSourceCommand, SecretSourceKubernetes)
+	}
+	return nil
+}
+
+func setClusterDefaults(cluster *ClusterConfig) error {
+	switch cluster.CNI.Type {
+	case "", CNICilium:
+		cluster.CNI.Type = CNICilium
+		if cluster.CNI.Version == "" {
+			cluster.CNI.Version = CiliumVersion
+		}
+	case CNICalico:
+		if cluster.CNI.Version == "" {
+			cluster.CNI.Version = CalicoVersion
+		}
+	default:
+		return fmt.Errorf("cluster %s: unsupported CNI type %q, supported: %s, %s", cluster.Name, cluster.CNI.Type, CNICilium, CNICalico)
+	}
+	cluster.CNI.Version = strings.TrimPrefix(cluster.CNI.Version, "v")
+
+	if cluster.AWS.ControlPlaneMachineType == "" {
+		cluster.AWS.ControlPlaneMachineType = DefaultAWSMachineType
+	}
+	if cluster.AWS.NodeMachineType == "" {
+		cluster.AWS.NodeMachineType = DefaultAWSMachineType
+	}
+	if cluster.ControlPlaneMachineCount == 0 {
+		cluster.ControlPlaneMachineCount = 1
+	}
+	if cluster.WorkerMachineCount == 0 {
+		cluster.WorkerMachineCount = 1
+	}
+	return nil
+}
+
+func setProvidersDefaults(providers *ProvidersConfig) {
+	if providers.Core == "" {
+		providers.Core = "cluster-api:" + CAPIVersion
+	}
+	if len(providers.Bootstrap) == 0 {
+		providers.Bootstrap = []string{"kubeadm:" + CAPIVersion}
+	}
+	if len(providers.ControlPlane) == 0 {
+		providers.ControlPlane = []string{"kubeadm:" + CAPIVersion}
+	}
+	if len(providers.Infrastructure) == 0 {
+		providers.Infrastructure = []string{"aws:" + CAPAVersion}
+	}
+	// Addon providers are not installed by default, CAAPH is installed by Flux
+}
+
 func ensureSafePath(pathPtr *string) error {
 
 	home, err := os.UserHomeDir()
@@ -139,22 +873,36 @@ func ensureSafePath(pathPtr *string) error {
 	return nil
 }
 
-func kindClusterConfig(clusterName string) ClusterConfig {
-	// TODO - re-think implicit kind config. We shouldn't require flux key here.
-	fluxcdKey := os.Getenv("FLUXCD_KEY_PATH")
-	if fluxcdKey == "" {
-		// TODO - for now all clusters will share the same key.
-		// TODO - change function so that we don't have to panic here
-		panic("FLUXCD_KEY_PATH environment variable is not set")
+// kindClusterConfig returns implicit config of the temporary cluster. Its Flux key is read from
+// FLUXCD_KEY_PATH if it is set, otherwise it shares the key source of the cluster it creates.
+func kindClusterConfig(clusterName string, clusters []ClusterConfig) (ClusterConfig, error) {
+	// TODO - re-think implicit kind config.
+	flux := FluxConfig{
+		Version:   KindFluxVersion,
+		Namespace: FluxNamespace,
+	}
+
+	if fluxcdKey := os.Getenv("FLUXCD_KEY_PATH"); fluxcdKey != "" {
+		flux.KeyPath = fluxcdKey
+		if err := setKeySourceDefaults(&flux); err != nil {
+			return ClusterConfig{}, err
+		}
+	} else {
+		for _, cluster := range clusters {
+			if cluster.ManagementCluster == "" {
+				flux.KeyPath, flux.KeySource = cluster.Flux.KeyPath, cluster.Flux.KeySource
+				break
+			}
+		}
+		if flux.KeySource.Type == "" {
+			return ClusterConfig{}, fmt.Errorf("FLUXCD_KEY_PATH environment variable is not set and there is no cluster created by %s", clusterName)
+		}
 	}
+
 	return ClusterConfig{
 		Name:              clusterName,
 		Provider:          "kind",
 		ManagementCluster: "",
-		Flux: FluxConfig{
-			KeyPath:   fluxcdKey,
-			Version:   KindFluxVersion,
-			Namespace: FluxNamespace,
-		},
-	}
+		Flux:              flux,
+	}, nil
 }
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+)
+
+func TestValidateAWSIdentities(t *testing.T) {
+	static := AWSIdentityConfig{Name: "static", Type: AWSIdentityStatic, AccessKeyIDEnv: "AWS_ACCESS_KEY_ID", SecretAccessKeyEnv: "AWS_SECRET_ACCESS_KEY"}
+	role := AWSIdentityConfig{Name: "role", Type: AWSIdentityRole, RoleARN: "arn:aws:iam::123456789012:role/capa", SourceIdentity: "static"}
+
+	tests := []struct {
+		name       string
+		identities []AWSIdentityConfig
+		identity   string
+		wantErr    bool
+	}{
+		{"no identities", nil, "", false},
+		{"static and role", []AWSIdentityConfig{static, role}, "role", false},
+		{"role with controller identity", []AWSIdentityConfig{{Name: "role", Type: AWSIdentityRole, RoleARN: "arn"}}, "role", false},
+		{"no name", []AWSIdentityConfig{{Type: AWSIdentityRole, RoleARN: "arn"}}, "", true},
+		{"duplicate", []AWSIdentityConfig{static, static}, "", true},
+		{"static without env", []AWSIdentityConfig{{Name: "static", Type: AWSIdentityStatic, AccessKeyIDEnv: "AWS_ACCESS_KEY_ID"}}, "", true},
+		{"role without ARN", []AWSIdentityConfig{{Name: "role", Type: AWSIdentityRole}}, "", true},
+		{"unsupported type", []AWSIdentityConfig{{Name: "web", Type: "webIdentity"}}, "", true},
+		{"undefined source", []AWSIdentityConfig{role}, "", true},
+		{"self source", []AWSIdentityConfig{{Name: "role", Type: AWSIdentityRole, RoleARN: "arn", SourceIdentity: "role"}}, "", true},
+		{"undefined cluster identity", []AWSIdentityConfig{static}, "missing", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				AWSIdentities: tt.identities,
+				Clusters:      []ClusterConfig{{Name: "cluster-01", Provider: "aws", AWS: AWSConfig{Identity: tt.identity}}},
+			}
+			err := validateAWSIdentities(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAWSIdentities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequiresAWSControllerCredentials(t *testing.T) {
+	identities := []AWSIdentityConfig{
+		{Name: "static", Type: AWSIdentityStatic},
+		{Name: "role", Type: AWSIdentityRole, SourceIdentity: "static"},
+		{Name: "controller-role", Type: AWSIdentityRole},
+	}
+
+	tests := []struct {
+		name     string
+		clusters []ClusterConfig
+		sources  []AWSIdentityConfig
+		want     bool
+	}{
+		{"all clusters use identities", []ClusterConfig{
+			{Name: "cluster-01", Provider: "aws", AWS: AWSConfig{Identity: "static"}},
+			{Name: "cluster-02", Provider: "aws", AWS: AWSConfig{Identity: "role"}},
+		}, identities[:2], false},
+		{"cluster without identity", []ClusterConfig{
+			{Name: "cluster-01", Provider: "aws", AWS: AWSConfig{Identity: "static"}},
+			{Name: "cluster-02", Provider: "aws"},
+		}, identities[:2], true},
+		{"role sourced from controller", []ClusterConfig{
+			{Name: "cluster-01", Provider: "aws", AWS: AWSConfig{Identity: "controller-role"}},
+		}, identities, true},
+		{"kind cluster", []ClusterConfig{{Name: DefaultKindClusterName, Provider: "kind"}}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Clusters: tt.clusters, AWSIdentities: tt.sources}
+			if got := cfg.RequiresAWSControllerCredentials(); got != tt.want {
+				t.Errorf("RequiresAWSControllerCredentials() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectWorkloadClusters(t *testing.T) {
+	cfg := &Config{
+		Clusters: []ClusterConfig{
+			{Name: "kind", Provider: "kind"},
+			{Name: "cluster-mgmt", Provider: "aws", Labels: map[string]string{"env": "dev"}},
+			{Name: "cluster-01", Provider: "aws", ManagementCluster: "cluster-mgmt", Labels: map[string]string{"env": "dev", "team": "a"}},
+			{Name: "cluster-02", Provider: "aws", ManagementCluster: "cluster-mgmt", Labels: map[string]string{"env": "prod", "team": "b"}},
+		},
+	}
+
+	tests := []struct {
+		selector string
+		want     []string
+		wantErr  bool
+	}{
+		{"cluster-01", []string{"cluster-01"}, false},
+		{"cluster-02, cluster-01", []string{"cluster-02", "cluster-01"}, false},
+		{"env=dev", []string{"cluster-01"}, false},
+		{"team in (a,b)", []string{"cluster-01", "cluster-02"}, false},
+		{"env!=dev", []string{"cluster-02"}, false},
+		{"cluster-mgmt", nil, true},
+		{"kind", nil, true},
+		{"cluster-03", nil, true},
+		{"env=staging", nil, true},
+		{"team in (a", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			clusters, err := cfg.SelectWorkloadClusters(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectWorkloadClusters(%q) error = %v, wantErr %v", tt.selector, err, tt.wantErr)
+			}
+			var got []string
+			for _, cluster := range clusters {
+				got = append(got, cluster.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectWorkloadClusters(%q) = %v, want %v", tt.selector, got, tt.want)
+			}
+		})
+	}
+}
+
+const unversioned = `
+clusters:
+  - name: "cluster-mgmt"
+    provider: "aws"
+    kubernetesVersion: "1.28.5"
+    flux:
+      keyPath: "/keys/flux"
+      version: "2.2.2"
+    aws:
+      region: "ap-southeast-2"
+  - name: "cluster-01"
+    managementCluster: "cluster-mgmt"
+    provider: "aws"
+    flux:
+      keyPath: "/keys/flux"
+    labels:
+      env: "dev"
+github:
+  user: "olga-mir"
+  repoName: "k8s-multi-cluster"
+kubeconfigPath: "/tmp/kubeconfig"
+tenants:
+  - name: "team-1"
+    quota:
+      cpu: "8"
+`
+
+func TestFromSpec(t *testing.T) {
+	multiCluster, err := v1alpha1.Load([]byte(unversioned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiCluster.Spec.Clusters[1].Labels["costCenter"] = "platform"
+	multiCluster.Spec.Clusters[1].CNI.Values = "hubble:\n  relay:\n    enabled: true\nipv4NativeRoutingCIDR: 10.0.0.0/8\n"
+
+	cfg, err := FromSpec(&multiCluster.Spec)
+	if err != nil {
+		t.Fatalf("FromSpec() error = %v", err)
+	}
+	// the temporary kind cluster is added implicitly
+	if len(cfg.Clusters) != 3 {
+		t.Fatalf("FromSpec() clusters = %d, want 3", len(cfg.Clusters))
+	}
+	workload := cfg.Clusters[1]
+	if workload.ManagementCluster != "cluster-mgmt" || workload.Labels["env"] != "dev" || workload.Flux.KeySource.Path != "/keys/flux" {
+		t.Errorf("FromSpec() cluster-01 = %+v", workload)
+	}
+	// label keys and Helm values are case sensitive
+	if workload.Labels["costCenter"] != "platform" || !strings.Contains(workload.CNI.Values, "ipv4NativeRoutingCIDR: 10.0.0.0/8") {
+		t.Errorf("FromSpec() cluster-01 labels = %v, cni values = %q", workload.Labels, workload.CNI.Values)
+	}
+	if workload.CNI.Type != CNICilium || workload.Flux.Namespace != FluxNamespace {
+		t.Errorf("FromSpec() cluster-01 defaults = %+v, %+v", workload.CNI, workload.Flux)
+	}
+	if cfg.Github.Branch != "main" || cfg.KubeconfigPath != "/tmp/kubeconfig" {
+		t.Errorf("FromSpec() github = %+v, kubeconfig = %s", cfg.Github, cfg.KubeconfigPath)
+	}
+	if tenant := cfg.Tenant("team-1"); tenant.Quota.CPU != "8" || tenant.Quota.Memory != DefaultTenantQuotaMemory {
+		t.Errorf("FromSpec() tenant = %+v", tenant)
+	}
+}
+
+func TestFromSpecValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*v1alpha1.MultiClusterSpec)
+		want   string
+	}{
+		{"valid", func(*v1alpha1.MultiClusterSpec) {}, ""},
+		{"duplicate cluster", func(s *v1alpha1.MultiClusterSpec) { s.Clusters[1].Name = "cluster-mgmt" },
+			"cluster cluster-mgmt is defined more than once"},
+		{"unknown management cluster", func(s *v1alpha1.MultiClusterSpec) { s.Clusters[1].ManagementCluster = "cluster-02" },
+			"management cluster cluster-02 is not defined"},
+		{"mesh without cilium", func(s *v1alpha1.MultiClusterSpec) {
+			s.Clusters[1].CNI.Type = CNICalico
+			s.Clusters[1].Mesh = v1alpha1.MeshSpec{Label: "dev", ClusterID: 1}
+		}, "mesh requires cilium CNI"},
+		{"unsupported cni", func(s *v1alpha1.MultiClusterSpec) { s.Clusters[1].CNI.Type = "flannel" },
+			"unsupported CNI type"},
+		{"invalid quota", func(s *v1alpha1.MultiClusterSpec) { s.Tenants[0].Quota.Memory = "lots" },
+			"invalid quantity \"lots\""},
+		{"missing key", func(s *v1alpha1.MultiClusterSpec) { s.Clusters[0].Flux = v1alpha1.FluxSpec{} },
+			"keyPath or keySource must be set"},
+		{"missing github", func(s *v1alpha1.MultiClusterSpec) { s.Github.User = "" },
+			"github user and repo are not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multiCluster, err := v1alpha1.Load([]byte(unversioned))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(&multiCluster.Spec)
+			_, err = FromSpec(&multiCluster.Spec)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("FromSpec() error = %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("FromSpec() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,12 @@
 package config
 
+import "github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+
 const (
+	// Config file can be a MultiCluster resource of this API group, see pkg/apis/multicluster
+	APIGroup         = v1alpha1.GroupName
+	MultiClusterKind = v1alpha1.Kind
+
 	KindFluxVersion = "2.2.2"
 	FluxNamespace   = "flux-system"
 
@@ -17,6 +23,52 @@ const (
 	DefaultKindClusterCtxName = "kind-tmp-mgmt"
 	DefaultCAPIClusterNameTpl = "cluster-{{.Name}}"
 	DefaultCAPIContextNameTpl = "cluster-{{.Name}}-admin@cluster-{{.Name}}"
+
+	// Versions of the components which are fetched from the internet during deploy.
+	// They are pinned so that `mirror` downloads exactly what `deploy` installs.
+	// CAPI and CAPA versions are defaults, the versions are set in config.yaml `capi.providers`
+	CAPIVersion        = "v1.6.0"
+	CAPAVersion        = "v2.3.1"
+	CAAPHVersion       = "v0.1.0-alpha.10"
+	CertManagerVersion = "v1.13.2" // default cert-manager for CAPI v1.6.0
+	CiliumVersion      = "1.12.3"
+	CalicoVersion      = "3.26.4"
+
+	// Cilium is installed by CAAPH into this namespace, see k8s-platform/cni-caaph-resource/cilium
+	CiliumNamespace = "cilium"
+	MeshLabelNone   = "none"
+
+	CNICilium = "cilium"
+	CNICalico = "calico"
+
+	DefaultAWSMachineType = "t3.medium"
+
+	// CAPA controller namespace, static identity secrets must be in this namespace
+	CAPANamespace     = "capa-system"
+	AWSIdentityStatic = "static"
+	AWSIdentityRole   = "role"
+
+	// Kubeadm default, used when neither serviceCIDR nor ipam.serviceCIDRPool is set
+	DefaultServiceCIDR   = "10.96.0.0/12"
+	DefaultIPAMStatePath = "$HOME/.multicluster-demo/ipam.json"
+
+	DefaultAgeKeyPath = "$HOME/.multicluster-demo/age.agekey"
+
+	SecretSourceFile       = "file"
+	SecretSourceEnv        = "env"
+	SecretSourceCommand    = "command"
+	SecretSourceKubernetes = "kubernetes"
+	// Flux Kustomizations read the age key from this secret in their namespace
+	SOPSSecretName = "sops-age"
+
+	// Guardrails of tenants which don't have sizing in config
+	DefaultTenantQuotaCPU      = "4"
+	DefaultTenantQuotaMemory   = "8Gi"
+	DefaultTenantQuotaPods     = "50"
+	DefaultTenantLimitCPU      = "500m"
+	DefaultTenantLimitMemory   = "512Mi"
+	DefaultTenantRequestCPU    = "100m"
+	DefaultTenantRequestMemory = "128Mi"
 )
 
 var ProjectNamespaces = []string{FluxNamespace, "caaph-system"}
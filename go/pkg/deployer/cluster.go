@@ -0,0 +1,236 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/ipam"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// AddCluster creates a workload cluster defined in config and finalises it: manifests are generated and pushed,
+// and when its control plane is Ready, its kubeconfig is merged and the Flux secret is created on it. It is
+// done when Flux on the cluster is Ready. It can be run again for a cluster which already exists, then only
+// the finalisation steps are repeated.
+func AddCluster(log logr.Logger, cfg *config.Config, name string) error {
+	return metrics.Operation("cluster_add", func() error {
+		return tracing.Run(context.Background(), "cluster add", func(ctx context.Context) error {
+			return addCluster(ctx, log, cfg, name)
+		}, tracing.Cluster(name))
+	})
+}
+
+func addCluster(ctx context.Context, log logr.Logger, cfg *config.Config, name string) error {
+	cluster, mgmtConfig, err := workloadClusterConfig(cfg, name)
+	if err != nil {
+		return err
+	}
+	mgmtAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, cluster.ManagementCluster)
+	if err != nil {
+		return err
+	}
+	mgmtCAPI, err := capi.NewClusterAPI(log, mgmtAuth, cfg)
+	if err != nil {
+		return fmt.Errorf("error creating Cluster API client: %w", err)
+	}
+	mgmtFluxCD, err := fluxcd.NewFluxCD(log, mgmtConfig.Flux, cfg.Github, mgmtAuth)
+	if err != nil {
+		return fmt.Errorf("error creating FluxCD client: %w", err)
+	}
+
+	err = tracing.Run(ctx, "generate", func(context.Context) error {
+		if _, err := ipam.AssignCIDRs(log, cfg); err != nil {
+			return err
+		}
+		sha, err := pushClusterManifests(log, cfg, cluster)
+		if err != nil || sha == "" {
+			return err
+		}
+		return mgmtFluxCD.SyncRevision(sha)
+	}, tracing.Cluster(name))
+	if err != nil {
+		return fmt.Errorf("error generating manifests of %s: %w", name, err)
+	}
+
+	shortName := utils.ClusterNameDataFromName(name).Name
+	if err := mgmtCAPI.WaitForControlPlaneReady(ctx, shortName); err != nil {
+		return err
+	}
+
+	clusterAuth := &k8sclient.ClusterAuthInfo{}
+	if err := mgmtCAPI.GetClusterAuthInfoForWorkloadCluster(ctx, clusterAuth, shortName); err != nil {
+		return fmt.Errorf("error getting kubeconfig for %s: %w", name, err)
+	}
+
+	// Flux secret is not in the repository, Flux installed by flux-remote Kustomization needs it to pull the repository
+	fluxCD, err := fluxcd.NewFluxCD(log, cluster.Flux, cfg.Github, clusterAuth)
+	if err != nil {
+		return fmt.Errorf("error creating FluxCD client: %w", err)
+	}
+	if err := fluxCD.CreateFluxSystemSecret(); err != nil {
+		return err
+	}
+
+	// Flux pods are scheduled when CNI is installed by CAAPH
+	if err := mgmtCAPI.WaitForWorkloadClusterFullyRunning(ctx, shortName, generator.HelmChartProxyNames(cfg, cluster)...); err != nil {
+		return fmt.Errorf("error waiting for %s: %w", name, err)
+	}
+	if err := fluxCD.WaitForFluxReady(ctx); err != nil {
+		return fmt.Errorf("error waiting for Flux on %s: %w", name, err)
+	}
+
+	log.Info("Cluster is ready", "cluster", name, "context", clusterAuth.ContextName)
+	return nil
+}
+
+// pushClusterManifests generates manifests of the cluster and pushes them. Only paths of this cluster are
+// committed, other changes in the repository are left to the user. It returns SHA of the commit, or an
+// empty string when the manifests have not changed.
+func pushClusterManifests(log logr.Logger, cfg *config.Config, cluster *config.ClusterConfig) (string, error) {
+	gen := generator.NewGenerator(log, cfg)
+	if err := gen.Generate(cluster.Name); err != nil {
+		return "", err
+	}
+	paths, err := gen.ClusterPaths(cluster)
+	if err != nil {
+		return "", err
+	}
+	changed, err := utils.GitHasChanges(paths...)
+	if err != nil || !changed {
+		return "", err
+	}
+	return utils.GitCommitAndPush(paths, fmt.Sprintf("add cluster %s", cluster.Name), cfg.Github.Branch)
+}
+
+// DeleteCluster removes one workload cluster: its Flux Kustomizations are suspended, the CAPI Cluster
+// is deleted, its manifests are removed from the repository and its context from kubeconfig
+func DeleteCluster(log logr.Logger, cfg *config.Config, name string) error {
+	return metrics.Operation("cluster_delete", func() error {
+		return tracing.Run(context.Background(), "cluster delete", func(ctx context.Context) error {
+			return deleteCluster(ctx, log, cfg, name)
+		}, tracing.Cluster(name))
+	})
+}
+
+func deleteCluster(ctx context.Context, log logr.Logger, cfg *config.Config, name string) error {
+	cluster, mgmtConfig, err := workloadClusterConfig(cfg, name)
+	if err != nil {
+		return err
+	}
+	if managed := cfg.ManagedClusters(name); len(managed) > 0 {
+		return fmt.Errorf("cluster %s manages clusters %v, delete them first", name, managed)
+	}
+
+	// objects of a CAPI cluster are in the namespace named after the cluster
+	capiName, contextName, err := utils.GetCAPIClusterNameAndContext(utils.ClusterNameDataFromName(name))
+	if err != nil {
+		return fmt.Errorf("error getting cluster name and context: %w", err)
+	}
+
+	mgmtAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, cluster.ManagementCluster)
+	if err != nil {
+		return err
+	}
+	mgmtCAPI, err := capi.NewClusterAPI(log, mgmtAuth, cfg)
+	if err != nil {
+		return fmt.Errorf("error creating Cluster API client: %w", err)
+	}
+	mgmtFluxCD, err := fluxcd.NewFluxCD(log, mgmtConfig.Flux, cfg.Github, mgmtAuth)
+	if err != nil {
+		return fmt.Errorf("error creating FluxCD client: %w", err)
+	}
+
+	// Flux remote Kustomizations would keep applying manifests to the cluster while it is deleted,
+	// and flux-system of the management cluster would recreate the Cluster until it is removed from the repo
+	err = tracing.Run(ctx, "suspend flux", func(ctx context.Context) error {
+		suspended, err := mgmtFluxCD.SuspendNamespaceKustomizations(ctx, capiName)
+		if err != nil {
+			return err
+		}
+		log.Info("Suspended Flux Kustomizations of the cluster", "namespace", capiName, "kustomizations", suspended)
+		return mgmtFluxCD.SuspendKustomization("flux-system")
+	}, tracing.Cluster(cluster.ManagementCluster), tracing.Namespace(capiName))
+	// flux-system is resumed when the cluster is removed from the repository, if deletion fails before
+	// that, it is resumed here, otherwise the management cluster stops reconciling the repository
+	resumed := false
+	defer func() {
+		if resumed {
+			return
+		}
+		if resumeErr := mgmtFluxCD.ResumeKustomization("flux-system"); resumeErr != nil {
+			log.Error(resumeErr, "Failed to resume flux-system Kustomization", "cluster", cluster.ManagementCluster)
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("error suspending Flux: %w", err)
+	}
+
+	if err := mgmtCAPI.DeleteCluster(ctx, capiName, capiName); err != nil {
+		return err
+	}
+	err = tracing.Run(ctx, "wait cluster deleted", func(context.Context) error {
+		return mgmtCAPI.WaitForClusterDeletion(ctx, capiName, capiName)
+	}, tracing.Cluster(capiName), tracing.Namespace(capiName))
+	if err != nil {
+		return err
+	}
+
+	paths, err := generator.NewGenerator(log, cfg).RemoveCluster(cluster)
+	if err != nil {
+		return err
+	}
+	sha, err := utils.GitCommitAndPush(paths, fmt.Sprintf("delete cluster %s", name), cfg.Github.Branch)
+	if err != nil {
+		return err
+	}
+	// Flux prunes the namespace and the Kustomizations of the cluster when it applies the commit
+	resumed = true
+	if err := mgmtFluxCD.ResumeKustomization("flux-system"); err != nil {
+		return err
+	}
+	if err := mgmtFluxCD.SyncRevision(sha); err != nil {
+		return err
+	}
+
+	if err := utils.RemoveKubeconfigContext(cfg.KubeconfigPath, contextName); err != nil {
+		return err
+	}
+
+	allocator, err := ipam.NewAllocator(log, cfg.IPAM)
+	if err != nil {
+		return err
+	}
+	if _, ok := allocator.Allocations()[name]; ok {
+		if err := allocator.Release(name); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Cluster is deleted, remove it from config.yaml", "cluster", name)
+	return nil
+}
+
+// workloadClusterConfig returns config of the workload cluster and of its management cluster
+func workloadClusterConfig(cfg *config.Config, name string) (*config.ClusterConfig, *config.ClusterConfig, error) {
+	cluster := cfg.Cluster(name)
+	if cluster == nil {
+		return nil, nil, fmt.Errorf("cluster %s is not defined in config", name)
+	}
+	if cluster.Provider == "kind" || cluster.ManagementCluster == "" {
+		return nil, nil, fmt.Errorf("cluster %s is not a workload cluster", name)
+	}
+	mgmtConfig := cfg.Cluster(cluster.ManagementCluster)
+	if mgmtConfig == nil {
+		return nil, nil, fmt.Errorf("management cluster %s of %s is not defined in config", cluster.ManagementCluster, name)
+	}
+	return cluster, mgmtConfig, nil
+}
@@ -0,0 +1,191 @@
+package deployer
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+const testConfig = `
+clusters:
+  - name: cluster-mgmt
+    provider: aws
+    kubernetesVersion: 1.28.5
+    podCIDR: 192.168.0.0/20
+    serviceCIDR: 10.96.0.0/24
+    flux:
+      keyPath: /keys/flux
+      version: 2.2.2
+    aws:
+      region: us-west-2
+  - name: cluster-01
+    managementCluster: cluster-mgmt
+    provider: aws
+    kubernetesVersion: 1.28.5
+    podCIDR: 192.168.16.0/20
+    serviceCIDR: 10.96.1.0/24
+    flux:
+      keyPath: /keys/flux
+      version: 2.2.2
+    aws:
+      region: us-west-2
+  - name: cluster-02
+    managementCluster: cluster-01
+    provider: aws
+    flux:
+      keyPath: /keys/flux
+github:
+  user: olga-mir
+  repoName: k8s-multi-cluster
+kubeconfigPath: /tmp/kubeconfig
+`
+
+func testClusterConfig(t *testing.T) *config.Config {
+	t.Helper()
+	multiCluster, err := v1alpha1.Load([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.FromSpec(&multiCluster.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestWorkloadClusterConfig(t *testing.T) {
+	cfg := testClusterConfig(t)
+	cfg.Clusters = append(cfg.Clusters, config.ClusterConfig{Name: "cluster-03", Provider: "aws", ManagementCluster: "cluster-04"})
+
+	tests := []struct {
+		name     string
+		wantMgmt string
+		wantErr  string
+	}{
+		{"cluster-01", "cluster-mgmt", ""},
+		{"cluster-02", "cluster-01", ""},
+		{"cluster-mgmt", "", "is not a workload cluster"},
+		{config.DefaultKindClusterName, "", "is not a workload cluster"},
+		{"cluster-03", "", "management cluster cluster-04 of cluster-03 is not defined"},
+		{"cluster-09", "", "cluster cluster-09 is not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, mgmt, err := workloadClusterConfig(cfg, tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("workloadClusterConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("workloadClusterConfig() error = %v", err)
+			}
+			if cluster.Name != tt.name || mgmt.Name != tt.wantMgmt {
+				t.Errorf("workloadClusterConfig() = %s, %s, want %s, %s", cluster.Name, mgmt.Name, tt.name, tt.wantMgmt)
+			}
+		})
+	}
+}
+
+// gitRepo creates a repository with templates/ and k8s-platform/ of this repo and a bare origin,
+// and makes it the working directory
+func gitRepo(t *testing.T) (string, string) {
+	t.Helper()
+	root, origin := t.TempDir(), t.TempDir()
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(env, "test")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+
+	git(t, origin, "init", "--bare", "-q")
+	git(t, root, "init", "-q", "-b", "main")
+	git(t, root, "remote", "add", "origin", origin)
+	for _, dir := range []string{"templates", "k8s-platform"} {
+		target, err := filepath.Abs(filepath.Join("..", "..", "..", dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(root, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "clusters", "cluster-mgmt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "clusters", "cluster-mgmt", "kustomization.yaml"), []byte("resources:\n- platform.yaml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "add", ".")
+	git(t, root, "commit", "-q", "-m", "init")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return root, origin
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestPushClusterManifests(t *testing.T) {
+	root, origin := gitRepo(t)
+	cfg := testClusterConfig(t)
+
+	// unrelated change under clusters/ is not pushed with the cluster
+	unrelated := filepath.Join("clusters", "cluster-mgmt", "notes.yaml")
+	if err := os.WriteFile(filepath.Join(root, unrelated), []byte("draft: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sha, err := pushClusterManifests(logr.Discard(), cfg, cfg.Cluster("cluster-01"))
+	if err != nil {
+		t.Fatalf("pushClusterManifests() error = %v", err)
+	}
+	if sha == "" || git(t, origin, "rev-parse", "main") != sha {
+		t.Fatalf("pushClusterManifests() sha = %q is not pushed to origin", sha)
+	}
+
+	files := strings.Split(git(t, root, "show", "--name-only", "--format=", sha), "\n")
+	for _, file := range files {
+		if !strings.HasPrefix(file, "clusters/cluster-mgmt/cluster-01/") && file != "clusters/cluster-mgmt/kustomization.yaml" {
+			t.Errorf("pushClusterManifests() committed %s", file)
+		}
+	}
+	if status := git(t, root, "status", "--porcelain", "--", unrelated); !strings.HasPrefix(status, "??") {
+		t.Errorf("unrelated file status = %q, want untracked", status)
+	}
+	resources := git(t, root, "show", sha+":clusters/cluster-mgmt/kustomization.yaml")
+	if !strings.Contains(resources, "- cluster-01") {
+		t.Errorf("parent kustomization does not include cluster-01:\n%s", resources)
+	}
+
+	// nothing changed, nothing is pushed
+	sha, err = pushClusterManifests(logr.Discard(), cfg, cfg.Cluster("cluster-01"))
+	if err != nil || sha != "" {
+		t.Errorf("pushClusterManifests() of unchanged cluster = %q, %v, want no commit", sha, err)
+	}
+	if got := strings.Split(git(t, root, "log", "--format=%s"), "\n"); !reflect.DeepEqual(got, []string{"add cluster cluster-01", "init"}) {
+		t.Errorf("commits = %v", got)
+	}
+}
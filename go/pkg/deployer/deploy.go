@@ -1,16 +1,29 @@
 package deployer
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/kind"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/operator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/sops"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tenant"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// operatorReadyTimeout is how long deploy waits for the operator to bring up the workload clusters
+const operatorReadyTimeout = 45 * time.Minute
+
 // KubernetesClients represents a collection of Kubernetes clients for different clusters.
 // ClusterAuthInfo contains REST Config and clientset. Clientset can't be easily used with
 // custom resources and clients used by Cluster API and FluxCD
@@ -21,10 +34,34 @@ type KubernetesClients struct {
 	WorkloadClusters      map[string]*k8sclient.ClusterAuthInfo // Map of workload clusters
 }
 
+// Deploy creates the temporary and the permanent management clusters and workload clusters, phases
+// of deploy are recorded in metrics and traced
 func Deploy(log logr.Logger, cfg *config.Config) error {
+	return metrics.Operation("deploy", func() error {
+		return tracing.Run(context.Background(), "deploy", func(ctx context.Context) error {
+			return deploy(ctx, log, cfg)
+		})
+	})
+}
+
+// runPhase runs a phase of deploy in a span, its duration and failure are recorded in metrics
+func runPhase(ctx context.Context, phase string, f func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	return metrics.Phase("deploy", phase, func() error {
+		return tracing.Run(ctx, "deploy "+phase, f, attrs...)
+	})
+}
+
+func deploy(ctx context.Context, log logr.Logger, cfg *config.Config) error {
+	mgmtConfig := cfg.PermanentManagementCluster()
+	if mgmtConfig == nil {
+		return fmt.Errorf("no permanent management cluster in config")
+	}
+
 	// Create a kind cluster and get its kubeconfig
 	log.Info("Create `kind` cluster")
-	err := kind.CreateCluster(cfg.KubeconfigPath)
+	err := runPhase(ctx, "kind_cluster", func(context.Context) error {
+		return kind.CreateCluster(cfg.KubeconfigPath)
+	}, tracing.Cluster(config.DefaultKindClusterName))
 	if err != nil {
 		return fmt.Errorf("error creating kind cluster: %v", err)
 	}
@@ -43,15 +80,33 @@ func Deploy(log logr.Logger, cfg *config.Config) error {
 	// Install Cluster API on the kind cluster. kind is a temporary "CAPI management cluster" which will be used to provision
 	// a cluster in the cloud which will be used as a permanent "CAPI management cluster" for the workload clusters.
 	log.Info("Installing Cluster API on `kind` cluster")
-	tmpMgmtCAPI, err := capi.NewClusterAPI(log, kubeClients.TempManagementCluster, cfg.KubeconfigPath)
+	tmpMgmtCAPI, err := capi.NewClusterAPI(log, kubeClients.TempManagementCluster, cfg)
 	if err != nil {
 		return fmt.Errorf("error creating Cluster API client: %v", err)
 	}
 
-	if err := tmpMgmtCAPI.InstallClusterAPI(); err != nil {
+	if err := runPhase(ctx, "capi_install_tmp", tmpMgmtCAPI.InstallClusterAPI, tracing.Cluster(config.DefaultKindClusterName)); err != nil {
 		return fmt.Errorf("error installing Cluster API: %v", err)
 	}
 
+	if err := tmpMgmtCAPI.CreateAWSIdentitySecrets(cfg.AWSIdentities); err != nil {
+		return fmt.Errorf("error creating AWS identity secrets: %v", err)
+	}
+
+	// Decryption secret must be in the namespace of each Kustomization which decrypts manifests: flux-system
+	// and the namespaces of the managed clusters where their Flux Kustomizations are
+	var decryption *sops.SOPS
+	if cfg.SOPS.Enabled {
+		decryption = sops.NewSOPS(log, cfg.SOPS)
+		if _, err := decryption.EnsureAgeKey(); err != nil {
+			return fmt.Errorf("error preparing age key: %v", err)
+		}
+		namespaces := append([]string{config.FluxNamespace}, cfg.ManagedClusters(config.DefaultKindClusterName)...)
+		if err := decryption.CreateDecryptionSecret(kubeClients.TempManagementCluster.Clientset, namespaces...); err != nil {
+			return fmt.Errorf("error creating SOPS decryption secret: %v", err)
+		}
+	}
+
 	// Install FluxCD on the kind cluster
 	log.Info("Installing FluxCD on `kind` cluster")
 	kindFluxCD, err := fluxcd.NewFluxCD(log, clusterConfigByName(config.DefaultKindClusterName, cfg).Flux, cfg.Github, kubeClients.TempManagementCluster)
@@ -59,7 +114,7 @@ func Deploy(log logr.Logger, cfg *config.Config) error {
 		return fmt.Errorf("error creating FluxCD client: %v", err)
 	}
 
-	if err := kindFluxCD.InstallFluxCD(); err != nil {
+	if err := runPhase(ctx, "flux_install_tmp", kindFluxCD.InstallFluxCD, tracing.Cluster(config.DefaultKindClusterName)); err != nil {
 		return fmt.Errorf("error installing FluxCD: %v", err)
 	}
 
@@ -79,17 +134,22 @@ func Deploy(log logr.Logger, cfg *config.Config) error {
 	*/
 
 	log.Info("Waiting for all Flux resources to become Ready")
-	err = kindFluxCD.WaitForFluxResources()
+	err = runPhase(ctx, "flux_reconcile_tmp", kindFluxCD.WaitForFluxResources, tracing.Cluster(config.DefaultKindClusterName))
 	if err != nil {
 		return fmt.Errorf("error waiting for Flux resources: %v", err)
 	}
 
 	// Now Flux has applied cluster manifests from the repo and we should wait for the cluster(s) to be ready
-	tmpMgmtCAPI.WaitForWorkloadClusterFullyRunning("mgmt")
+	err = runPhase(ctx, "mgmt_cluster", func(ctx context.Context) error {
+		return tmpMgmtCAPI.WaitForWorkloadClusterFullyRunning(ctx, "mgmt", generator.HelmChartProxyNames(cfg, mgmtConfig)...)
+	}, tracing.Cluster("cluster-mgmt"))
+	if err != nil {
+		return fmt.Errorf("error waiting for cluster-mgmt: %v", err)
+	}
 
 	// After cluster is ready we need to get its kubeconfig, then suspend flux and pivot management cluster
 	kubeClients.PermManagementCluster = &k8sclient.ClusterAuthInfo{}
-	err = tmpMgmtCAPI.GetClusterAuthInfoForWorkloadCluster(kubeClients.PermManagementCluster, "mgmt")
+	err = tmpMgmtCAPI.GetClusterAuthInfoForWorkloadCluster(ctx, kubeClients.PermManagementCluster, "mgmt")
 	if err != nil {
 		return fmt.Errorf("error getting kubeconfig for cluster-mgmt: %v", err)
 	}
@@ -100,18 +160,25 @@ func Deploy(log logr.Logger, cfg *config.Config) error {
 	}
 
 	// install Cluster API on permanent management cluster
-	mgmtCAPI, err := capi.NewClusterAPI(log, kubeClients.PermManagementCluster, cfg.KubeconfigPath)
+	mgmtCAPI, err := capi.NewClusterAPI(log, kubeClients.PermManagementCluster, cfg)
 	if err != nil {
 		return fmt.Errorf("error creating Cluster API client: %v", err)
 	}
 
 	log.Info("Installing Cluster API on the permanent management cluster")
-	if err := mgmtCAPI.InstallClusterAPI(); err != nil {
+	if err := runPhase(ctx, "capi_install_mgmt", mgmtCAPI.InstallClusterAPI, tracing.Cluster("cluster-mgmt")); err != nil {
 		return fmt.Errorf("error installing Cluster API: %v", err)
 	}
 
+	if err := mgmtCAPI.CreateAWSIdentitySecrets(cfg.AWSIdentities); err != nil {
+		return fmt.Errorf("error creating AWS identity secrets: %v", err)
+	}
+
 	// Pivot to the permanent management cluster
-	if err := tmpMgmtCAPI.PivotCluster(kubeClients.PermManagementCluster); err != nil {
+	err = runPhase(ctx, "pivot", func(ctx context.Context) error {
+		return tmpMgmtCAPI.PivotCluster(ctx, kubeClients.PermManagementCluster)
+	}, tracing.Cluster("cluster-mgmt"))
+	if err != nil {
 		return fmt.Errorf("error pivoting to permanent cluster: %v", err)
 	}
 
@@ -127,10 +194,61 @@ func Deploy(log logr.Logger, cfg *config.Config) error {
 		return fmt.Errorf("error creating FluxCD secret: %v", err)
 	}
 
-	if err := mgmtCAPI.WaitForAllClustersProvisioning(); err != nil {
-		fmt.Printf("Error waiting for clusters to be provisioned: %s\n", err)
+	if decryption != nil {
+		namespaces := append([]string{config.FluxNamespace}, cfg.ManagedClusters(mgmtConfig.Name)...)
+		if err := decryption.CreateDecryptionSecret(kubeClients.PermManagementCluster.Clientset, namespaces...); err != nil {
+			return fmt.Errorf("error creating SOPS decryption secret: %v", err)
+		}
+	}
+
+	// From here the fleet is reconciled by the operator on the permanent management cluster
+	if cfg.Operator.Image != "" {
+		return runPhase(ctx, "operator_handover", func(ctx context.Context) error {
+			if err := operator.Install(ctx, log, kubeClients.PermManagementCluster, cfg); err != nil {
+				return fmt.Errorf("error installing operator: %v", err)
+			}
+			log.Info("Handing over to the operator, waiting for the fleet to become ready")
+			if err := operator.WaitForReady(ctx, log, kubeClients.PermManagementCluster, operatorReadyTimeout); err != nil {
+				return fmt.Errorf("error waiting for operator: %v", err)
+			}
+			return nil
+		}, tracing.Cluster("cluster-mgmt"))
 	}
 
+	return runPhase(ctx, "workload_clusters", func(ctx context.Context) error {
+		if err := mgmtCAPI.WaitForAllClustersProvisioning(ctx); err != nil {
+			fmt.Printf("Error waiting for clusters to be provisioned: %s\n", err)
+		}
+
+		// CNI and add-ons of workload clusters are installed by CAAPH on the permanent management cluster
+		for _, clusterName := range cfg.ManagedClusters(mgmtConfig.Name) {
+			cluster := cfg.Cluster(clusterName)
+			name := utils.ClusterNameDataFromName(cluster.Name).Name
+			if err := mgmtCAPI.WaitForWorkloadClusterFullyRunning(ctx, name, generator.HelmChartProxyNames(cfg, cluster)...); err != nil {
+				return fmt.Errorf("error waiting for %s: %v", cluster.Name, err)
+			}
+			if err := createTenantSecrets(ctx, log, mgmtCAPI, cfg, cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createTenantSecrets creates the repository and decryption secrets on the workload cluster in namespaces
+// of its tenants, tenant sync objects are reconciled by Flux on the workload cluster itself
+func createTenantSecrets(ctx context.Context, log logr.Logger, mgmtCAPI *capi.ClusterAPI, cfg *config.Config, cluster *config.ClusterConfig) error {
+	tenants, err := generator.NewGenerator(log, cfg).ClusterTenants(cluster)
+	if err != nil || len(tenants) == 0 {
+		return err
+	}
+	clusterAuth := &k8sclient.ClusterAuthInfo{}
+	if err := mgmtCAPI.GetClusterAuthInfoForWorkloadCluster(ctx, clusterAuth, utils.ClusterNameDataFromName(cluster.Name).Name); err != nil {
+		return fmt.Errorf("error getting kubeconfig for %s: %v", cluster.Name, err)
+	}
+	if err := tenant.CreateSecrets(log, cfg, clusterAuth.Clientset, tenants); err != nil {
+		return fmt.Errorf("error creating tenant secrets on %s: %v", cluster.Name, err)
+	}
 	return nil
 }
 
@@ -148,6 +266,12 @@ func clusterConfigByName(name string, cfg *config.Config) *config.ClusterConfig
 }
 
 func Uninstall(log logr.Logger, cfg *config.Config) error {
+	return metrics.Operation("uninstall", func() error {
+		return uninstall(log, cfg)
+	})
+}
+
+func uninstall(log logr.Logger, cfg *config.Config) error {
 	log.Info("Suspending all FluxCD Kustomizations and HelmReleases")
 	/*
 		if err := fluxcdClientTODO.SuspendAll(); err != nil {
@@ -0,0 +1,316 @@
+package drift
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// Difference is a field of a cluster which doesn't match config
+type Difference struct {
+	Cluster string `json:"cluster"`
+	Field   string `json:"field"`
+	Config  string `json:"config"`
+	Live    string `json:"live"`
+}
+
+// Report is the result of comparing config with live CAPI objects on management clusters
+type Report struct {
+	Differences []Difference `json:"differences"`
+	// clusters defined in config which don't exist on their management cluster
+	MissingClusters []string `json:"missingClusters"`
+	// CAPI clusters which are not defined in config
+	UnknownClusters []string `json:"unknownClusters"`
+	// management clusters which could not be checked
+	Unreachable []string `json:"unreachableManagementClusters"`
+}
+
+// HasDrift returns true if anything doesn't match config or could not be checked
+func (r *Report) HasDrift() bool {
+	return len(r.Differences) > 0 || len(r.MissingClusters) > 0 || len(r.UnknownClusters) > 0 || len(r.Unreachable) > 0
+}
+
+// Print writes the report as a table
+func (r *Report) Print(w io.Writer) error {
+	if !r.HasDrift() {
+		_, err := fmt.Fprintln(w, "No drift detected")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tFIELD\tCONFIG\tLIVE")
+	for _, d := range r.Differences {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Cluster, d.Field, orNone(d.Config), orNone(d.Live))
+	}
+	for _, name := range r.MissingClusters {
+		fmt.Fprintf(tw, "%s\tcluster\tdefined\tnot found\n", name)
+	}
+	for _, name := range r.UnknownClusters {
+		fmt.Fprintf(tw, "%s\tcluster\tnot defined\tfound\n", name)
+	}
+	for _, name := range r.Unreachable {
+		fmt.Fprintf(tw, "%s\tmanagement cluster\treachable\tunreachable\n", name)
+	}
+	return tw.Flush()
+}
+
+// liveCluster holds the fields of a cluster which are compared with config
+type liveCluster struct {
+	KubernetesVersion    string
+	PodCIDR              string
+	Region               string
+	SSHKeyName           string
+	ControlPlaneReplicas int
+	WorkerReplicas       int
+	CNIVersion           string
+}
+
+var (
+	awsClusterGVK     = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta2", Kind: "AWSClusterList"}
+	helmChartProxyGVK = schema.GroupVersionKind{Group: "addons.cluster.x-k8s.io", Version: "v1alpha1", Kind: "HelmChartProxyList"}
+)
+
+// Detector compares clusters in config with CAPI objects on their management clusters
+type Detector struct {
+	log logr.Logger
+	cfg *config.Config
+}
+
+func NewDetector(log logr.Logger, cfg *config.Config) *Detector {
+	return &Detector{log: log, cfg: cfg}
+}
+
+// Detect checks all management clusters of clusters in config. The permanent management cluster is
+// pivoted onto itself by deploy, so clusters are looked up on all management clusters rather than
+// only on the one they are initially managed by.
+func (d *Detector) Detect() *Report {
+	// empty lists rather than nulls in JSON
+	report := &Report{Differences: []Difference{}, MissingClusters: []string{}, UnknownClusters: []string{}, Unreachable: []string{}}
+
+	live := make(map[string]liveCluster)
+	var checked []string
+	for _, mgmt := range managementClusters(d.cfg) {
+		clusterAuth, err := utils.GetClusterAuthInfoByName(d.cfg.KubeconfigPath, mgmt)
+		if err == nil {
+			var clusters map[string]liveCluster
+			if clusters, err = liveClusters(clusterAuth.Config, d.cfg); err == nil {
+				for name, lc := range clusters {
+					live[name] = lc
+				}
+				checked = append(checked, mgmt)
+				continue
+			}
+		}
+		// temporary kind cluster is deleted after the permanent management cluster is pivoted
+		if mgmt == config.DefaultKindClusterName {
+			d.log.Info("Temporary management cluster is not available", "reason", err.Error())
+			continue
+		}
+		d.log.Info("Management cluster is not available", "cluster", mgmt, "reason", err.Error())
+		report.Unreachable = append(report.Unreachable, mgmt)
+	}
+
+	for i := range d.cfg.Clusters {
+		cluster := &d.cfg.Clusters[i]
+		if cluster.Provider == "kind" {
+			continue
+		}
+		actual, ok := live[cluster.Name]
+		if !ok {
+			// can't tell if the cluster exists when its management cluster is not available,
+			// permanent management cluster may be on the temporary cluster or on itself
+			if slices.Contains(checked, cluster.ManagedBy()) || (cluster.ManagementCluster == "" && slices.Contains(checked, cluster.Name)) {
+				report.MissingClusters = append(report.MissingClusters, cluster.Name)
+			}
+			continue
+		}
+		report.Differences = append(report.Differences, compare(cluster, actual)...)
+	}
+	for name := range live {
+		if findCluster(d.cfg, name) == nil {
+			report.UnknownClusters = append(report.UnknownClusters, name)
+		}
+	}
+	sort.Strings(report.UnknownClusters)
+	return report
+}
+
+// compare returns fields of the live cluster which differ from config. Pod CIDR is only
+// compared when it is set in config, otherwise it is managed by ipam.Allocator
+func compare(cluster *config.ClusterConfig, live liveCluster) []Difference {
+	var diffs []Difference
+	check := func(field, expected, actual string) {
+		if expected != actual {
+			diffs = append(diffs, Difference{Cluster: cluster.Name, Field: field, Config: expected, Live: actual})
+		}
+	}
+
+	check("kubernetesVersion", strings.TrimPrefix(cluster.KubernetesVersion, "v"), strings.TrimPrefix(live.KubernetesVersion, "v"))
+	if cluster.PodCIDR != "" {
+		check("podCIDR", cluster.PodCIDR, live.PodCIDR)
+	}
+	if cluster.Provider == "aws" {
+		check("aws.region", cluster.AWS.Region, live.Region)
+		check("aws.sshKeyName", cluster.AWS.SSHKeyName, live.SSHKeyName)
+	}
+	check("controlPlaneMachineCount", strconv.Itoa(cluster.ControlPlaneMachineCount), strconv.Itoa(live.ControlPlaneReplicas))
+	check("workerMachineCount", strconv.Itoa(cluster.WorkerMachineCount), strconv.Itoa(live.WorkerReplicas))
+	check("cni.version", cluster.CNI.Version, strings.TrimPrefix(live.CNIVersion, "v"))
+	return diffs
+}
+
+// liveClusters reads CAPI clusters and the objects compared with config from a management cluster
+func liveClusters(restConfig *rest.Config, cfg *config.Config) (map[string]liveCluster, error) {
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := controlplanev1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	client, err := runtimeclient.New(restConfig, runtimeclient.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	ctx := context.TODO()
+
+	clusters := &clusterv1.ClusterList{}
+	if err := client.List(ctx, clusters); err != nil {
+		return nil, fmt.Errorf("failed to list CAPI clusters: %w", err)
+	}
+	live := make(map[string]liveCluster)
+	// control plane and infrastructure cluster "<kind>/<namespace>/<name>" to cluster name
+	owners := make(map[string]string)
+	for _, c := range clusters.Items {
+		lc := liveCluster{}
+		if n := c.Spec.ClusterNetwork; n != nil && n.Pods != nil && len(n.Pods.CIDRBlocks) > 0 {
+			lc.PodCIDR = n.Pods.CIDRBlocks[0]
+		}
+		live[c.Name] = lc
+		for _, ref := range []*corev1.ObjectReference{c.Spec.ControlPlaneRef, c.Spec.InfrastructureRef} {
+			if ref != nil {
+				owners[ref.Kind+"/"+c.Namespace+"/"+ref.Name] = c.Name
+			}
+		}
+	}
+
+	controlPlanes := &controlplanev1.KubeadmControlPlaneList{}
+	if err := client.List(ctx, controlPlanes); err != nil {
+		return nil, fmt.Errorf("failed to list control planes: %w", err)
+	}
+	for _, cp := range controlPlanes.Items {
+		update(live, owners["KubeadmControlPlane/"+cp.Namespace+"/"+cp.Name], func(lc *liveCluster) {
+			lc.KubernetesVersion = cp.Spec.Version
+			if cp.Spec.Replicas != nil {
+				lc.ControlPlaneReplicas += int(*cp.Spec.Replicas)
+			}
+		})
+	}
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := client.List(ctx, machineDeployments); err != nil {
+		return nil, fmt.Errorf("failed to list machine deployments: %w", err)
+	}
+	for _, md := range machineDeployments.Items {
+		update(live, md.Spec.ClusterName, func(lc *liveCluster) {
+			if md.Spec.Replicas != nil {
+				lc.WorkerReplicas += int(*md.Spec.Replicas)
+			}
+		})
+	}
+
+	awsClusters := &unstructured.UnstructuredList{}
+	awsClusters.SetGroupVersionKind(awsClusterGVK)
+	if err := client.List(ctx, awsClusters); err != nil {
+		return nil, fmt.Errorf("failed to list AWS clusters: %w", err)
+	}
+	for _, ac := range awsClusters.Items {
+		update(live, owners["AWSCluster/"+ac.GetNamespace()+"/"+ac.GetName()], func(lc *liveCluster) {
+			lc.Region, _, _ = unstructured.NestedString(ac.Object, "spec", "region")
+			lc.SSHKeyName, _, _ = unstructured.NestedString(ac.Object, "spec", "sshKeyName")
+		})
+	}
+
+	helmChartProxies := &unstructured.UnstructuredList{}
+	helmChartProxies.SetGroupVersionKind(helmChartProxyGVK)
+	if err := client.List(ctx, helmChartProxies); err != nil {
+		return nil, fmt.Errorf("failed to list HelmChartProxies: %w", err)
+	}
+	for _, hcp := range helmChartProxies.Items {
+		// CNI HelmChartProxy is in the cluster namespace, which is named after the cluster
+		cluster := findCluster(cfg, hcp.GetNamespace())
+		if cluster == nil || hcp.GetName() != generator.CNIHelmChartProxyName(cluster) {
+			continue
+		}
+		update(live, cluster.Name, func(lc *liveCluster) {
+			lc.CNIVersion, _, _ = unstructured.NestedString(hcp.Object, "spec", "version")
+		})
+	}
+
+	return live, nil
+}
+
+// update modifies the live cluster if it exists, objects of clusters which are being deleted are ignored
+func update(live map[string]liveCluster, name string, f func(*liveCluster)) {
+	lc, ok := live[name]
+	if !ok {
+		return
+	}
+	f(&lc)
+	live[name] = lc
+}
+
+func findCluster(cfg *config.Config, name string) *config.ClusterConfig {
+	for i := range cfg.Clusters {
+		if cfg.Clusters[i].Name == name {
+			return &cfg.Clusters[i]
+		}
+	}
+	return nil
+}
+
+// managementClusters returns management clusters of all clusters in config, including the permanent
+// management cluster which manages itself after pivot
+func managementClusters(cfg *config.Config) []string {
+	var names []string
+	for _, cluster := range cfg.Clusters {
+		if cluster.Provider == "kind" {
+			continue
+		}
+		for _, name := range []string{cluster.ManagedBy(), cluster.Name} {
+			if cluster.ManagementCluster != "" && name == cluster.Name {
+				continue
+			}
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
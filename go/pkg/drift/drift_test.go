@@ -0,0 +1,63 @@
+package drift
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestCompare(t *testing.T) {
+	cluster := &config.ClusterConfig{
+		Name:                     "cluster-01",
+		Provider:                 "aws",
+		KubernetesVersion:        "1.28.5",
+		PodCIDR:                  "192.168.16.0/20",
+		CNI:                      config.CNIConfig{Type: config.CNICilium, Version: "1.14.5"},
+		AWS:                      config.AWSConfig{Region: "us-west-2", SSHKeyName: "aws"},
+		ControlPlaneMachineCount: 1,
+		WorkerMachineCount:       2,
+	}
+	inSync := liveCluster{
+		KubernetesVersion:    "v1.28.5",
+		PodCIDR:              "192.168.16.0/20",
+		Region:               "us-west-2",
+		SSHKeyName:           "aws",
+		ControlPlaneReplicas: 1,
+		WorkerReplicas:       2,
+		CNIVersion:           "1.14.5",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*liveCluster)
+		want   []Difference
+	}{
+		{"in sync", func(*liveCluster) {}, nil},
+		{"kubernetes version", func(l *liveCluster) { l.KubernetesVersion = "v1.27.3" },
+			[]Difference{{"cluster-01", "kubernetesVersion", "1.28.5", "1.27.3"}}},
+		{"scaled and moved", func(l *liveCluster) { l.WorkerReplicas = 3; l.Region = "ap-southeast-2" },
+			[]Difference{{"cluster-01", "aws.region", "us-west-2", "ap-southeast-2"}, {"cluster-01", "workerMachineCount", "2", "3"}}},
+		{"CNI not installed", func(l *liveCluster) { l.CNIVersion = "" },
+			[]Difference{{"cluster-01", "cni.version", "1.14.5", ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := inSync
+			tt.modify(&live)
+			if got := compare(cluster, live); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// pod CIDR allocated by IPAM is not in config and is not compared
+	allocated := *cluster
+	allocated.PodCIDR = ""
+	live := inSync
+	live.PodCIDR = "192.168.32.0/20"
+	if got := compare(&allocated, live); got != nil {
+		t.Errorf("compare() with allocated pod CIDR = %v, want no differences", got)
+	}
+}
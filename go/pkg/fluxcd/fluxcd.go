@@ -3,8 +3,6 @@ package fluxcd
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"path/filepath"
 	"time"
 
@@ -12,19 +10,27 @@ import (
 	"github.com/fluxcd/pkg/apis/meta"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 	"github.com/go-logr/logr"
+	"golang.org/x/crypto/ssh"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/mirror"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/secrets"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
 )
 
+// fluxCRDs must be established before Flux objects are created
+var fluxCRDs = []string{"kustomizations.kustomize.toolkit.fluxcd.io", "gitrepositories.source.toolkit.fluxcd.io"}
+
 // FluxCD handles the installation of FluxCD
 type FluxCD struct {
 	log           logr.Logger
@@ -42,13 +48,10 @@ func NewFluxCD(log logr.Logger, fluxConfig appconfig.FluxConfig, githubConfig ap
 	sourcev1.AddToScheme(runtimeScheme)
 	kustomizev1.AddToScheme(runtimeScheme)
 
-	cfg, err := config.GetConfig()
-	if err != nil {
-		return nil, fmt.Errorf("error getting kubeconfig: %s", err)
-	}
-
-	// Create a new client to interact with cluster and host specific information
-	runtimeClient, err := runtimeclient.New(cfg, runtimeclient.Options{Scheme: runtimeScheme})
+	// Create a new client to interact with cluster and host specific information.
+	// It must talk to the same cluster as the clientset, not to the default kubeconfig context,
+	// because Flux resources are managed on many clusters by the same process.
+	runtimeClient, err := runtimeclient.New(clusterAuth.Config, runtimeclient.Options{Scheme: runtimeScheme})
 	if err != nil {
 		return nil, fmt.Errorf("error creating client: %s", err)
 	}
@@ -62,44 +65,51 @@ func NewFluxCD(log logr.Logger, fluxConfig appconfig.FluxConfig, githubConfig ap
 	}, nil
 }
 
-func (f *FluxCD) InstallFluxCD() error {
-	dynamicClient, err := dynamic.NewForConfig(f.clusterAuth.Config)
-	if err != nil {
-		return fmt.Errorf("failed to create dynamic client: %w", err)
-	}
-
-	manifestPath := utils.RepoRoot() + "/k8s-platform/flux/" + "v" + f.fluxConfig.Version
-
+func (f *FluxCD) InstallFluxCD(ctx context.Context) error {
 	// Apply gotk-components.yaml first
-	f.log.Info("Applying gotk-components")
-	if err := utils.ApplyManifestsFile(dynamicClient, filepath.Join(manifestPath, "gotk-components.yaml")); err != nil {
+	if err := f.ApplyComponents(f.fluxConfig.Version); err != nil {
 		return err
 	}
 
 	// Wait for CRDs to be established
-	fluxCRDs := []string{"kustomizations.kustomize.toolkit.fluxcd.io", "gitrepositories.source.toolkit.fluxcd.io"}
 	f.log.Info("Waiting for Flux CRDs to become established")
-	if err := utils.WaitForCRDs(f.clusterAuth.Config, fluxCRDs); err != nil {
+	if err := utils.WaitForCRDs(ctx, f.clusterAuth.Config, fluxCRDs); err != nil {
 		return err
 	}
 
-	f.CreateFluxSystemSecret()
+	if err := f.CreateFluxSystemSecret(); err != nil {
+		return err
+	}
 
 	if err := f.createGitRepository(); err != nil {
-		log.Fatalf("Error creating GitRepository: %s", err)
+		return err
 	}
 
 	if err := f.createKustomization(); err != nil {
-		log.Fatalf("Error creating Kustomization: %s", err)
+		return err
 	}
 
 	// TODO. We need to add a wait here because next step in `builder` will be calling to wait for all
 	// FluxCD resources. I think it is being called too early when there are still no resources. Then
 	// it also needs to wait for the Flux resources which are applied from the repo.
 	f.log.Info("Sleeping for 3 min to allow Flux to apply resources from the repository")
-	time.Sleep(3 * time.Minute)
+	return tracing.Run(ctx, "wait flux apply", func(context.Context) error {
+		time.Sleep(3 * time.Minute)
+		return nil
+	}, tracing.Cluster(f.clusterAuth.ClusterName))
+}
 
-	return nil
+// ApplyComponents applies gotk-components.yaml of the vendored Flux version directly, without Flux.
+// In air-gapped setup images are pulled from the mirror registry.
+func (f *FluxCD) ApplyComponents(version string) error {
+	dynamicClient, err := dynamic.NewForConfig(f.clusterAuth.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	f.log.Info("Applying gotk-components", "cluster", f.clusterAuth.ClusterName, "version", version)
+	manifestPath := filepath.Join(utils.RepoRoot(), "k8s-platform", "flux", "v"+version, "gotk-components.yaml")
+	return utils.ApplyManifestsFile(dynamicClient, manifestPath, mirror.RewriteImages(f.fluxConfig.ImageRegistry))
 }
 
 func (f *FluxCD) createGitRepository() error {
@@ -143,30 +153,27 @@ func (f *FluxCD) createKustomization() error {
 		},
 	}
 
+	if f.fluxConfig.Decryption {
+		kustomization.Spec.Decryption = &kustomizev1.Decryption{
+			Provider:  "sops",
+			SecretRef: &meta.LocalObjectReference{Name: appconfig.SOPSSecretName},
+		}
+	}
+
 	if err := f.runtimeClient.Create(context.TODO(), kustomization); err != nil {
 		return fmt.Errorf("failed to create Kustomization: %w", err)
 	}
 	return nil
 }
 
+// CreateFluxSystemSecret creates or updates the secret with the key Flux uses to access the repository
 func (f *FluxCD) CreateFluxSystemSecret() error {
 	f.log.Info("Creating secret for Flux")
 
-	secretData := make(map[string][]byte)
-
-	key, err := os.ReadFile(f.fluxConfig.KeyPath)
+	secretData, err := SSHSecretData(f.fluxConfig.KeySource)
 	if err != nil {
-		log.Fatalf("Error reading key file: %s", err.Error())
+		return fmt.Errorf("error reading Flux key: %w", err)
 	}
-	secretData["identity"] = key
-
-	keyPub, err := os.ReadFile(f.fluxConfig.KeyPath + ".pub")
-	if err != nil {
-		log.Fatalf("Error reading key pub file: %s", err.Error())
-	}
-	secretData["identity.pub"] = keyPub
-
-	secretData["known_hosts"] = []byte(appconfig.GithubKnownHosts)
 
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -176,7 +183,21 @@ func (f *FluxCD) CreateFluxSystemSecret() error {
 		Data: secretData,
 	}
 
-	_, err = f.clusterAuth.Clientset.CoreV1().Secrets(f.fluxConfig.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	// on workload clusters the secret is created before flux-remote Kustomization installs Flux
+	namespaces := f.clusterAuth.Clientset.CoreV1().Namespaces()
+	if _, err := namespaces.Get(context.TODO(), f.fluxConfig.Namespace, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: f.fluxConfig.Namespace}}
+		if _, err := namespaces.Create(context.TODO(), namespace, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error creating namespace %s: %w", f.fluxConfig.Namespace, err)
+		}
+	}
+
+	// the secret is updated when it exists, so that the key can be rotated by the operator
+	secrets := f.clusterAuth.Clientset.CoreV1().Secrets(f.fluxConfig.Namespace)
+	_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	}
 	if err != nil {
 		return fmt.Errorf("error creating secret: %s", err)
 	}
@@ -184,7 +205,45 @@ func (f *FluxCD) CreateFluxSystemSecret() error {
 	return nil
 }
 
-func (f *FluxCD) WaitForFluxResources() error {
+// SSHSecretData returns data of a Flux SSH git secret with the private key read from the source
+func SSHSecretData(keySource appconfig.SecretSourceConfig) (map[string][]byte, error) {
+	source, err := secrets.NewSource(keySource)
+	if err != nil {
+		return nil, err
+	}
+	key, err := source.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	// public key is derived from the private key, so that sources only need to provide one value
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key from %s: %w", source, err)
+	}
+	return map[string][]byte{
+		"identity":     key,
+		"identity.pub": ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		"known_hosts":  []byte(appconfig.GithubKnownHosts),
+	}, nil
+}
+
+// WaitForFluxReady waits for Flux which is installed on the cluster by another Flux, e.g. by flux-remote
+// Kustomization on the management cluster: for its CRDs to be established and its resources to be Ready
+func (f *FluxCD) WaitForFluxReady(ctx context.Context) error {
+	f.log.Info("Waiting for Flux to become Ready", "cluster", f.clusterAuth.ClusterName)
+	if err := utils.WaitForCRDs(ctx, f.clusterAuth.Config, fluxCRDs); err != nil {
+		return err
+	}
+	return f.WaitForFluxResources(ctx)
+}
+
+func (f *FluxCD) WaitForFluxResources(ctx context.Context) error {
+	defer metrics.ObserveWait(metrics.WaitFluxResources, time.Now())
+	return tracing.Run(ctx, "wait flux resources", f.waitForFluxResources, tracing.Cluster(f.clusterAuth.ClusterName))
+}
+
+func (f *FluxCD) waitForFluxResources(ctx context.Context) error {
 	// Define the GVRs for Flux resources
 	fluxGVRs := []schema.GroupVersionResource{
 		{Group: "source.toolkit.fluxcd.io", Version: "v1", Resource: "gitrepositories"},
@@ -200,7 +259,7 @@ func (f *FluxCD) WaitForFluxResources() error {
 	}
 	namespaces := append(clusterNamespaces, appconfig.ProjectNamespaces...)
 
-	err = utils.WaitAllResourcesReady(f.clusterAuth, namespaces, fluxGVRs)
+	err = utils.WaitAllResourcesReady(ctx, f.clusterAuth, namespaces, fluxGVRs)
 	if err != nil {
 		return err
 	}
@@ -213,6 +272,15 @@ func (f *FluxCD) SuspendAll() error {
 }
 
 func (f *FluxCD) SuspendKustomization(name string) error {
+	return f.setKustomizationSuspend(name, true)
+}
+
+// ResumeKustomization is the reverse of SuspendKustomization
+func (f *FluxCD) ResumeKustomization(name string) error {
+	return f.setKustomizationSuspend(name, false)
+}
+
+func (f *FluxCD) setKustomizationSuspend(name string, suspend bool) error {
 	// There is no suspend method in the Kustomization API, so we need to suspend the Kustomization
 	// https://pkg.go.dev/github.com/fluxcd/kustomize-controller/api@v1.2.1/v1#pkg-functions
 	// TODO - verify that there is no method
@@ -226,13 +294,36 @@ func (f *FluxCD) SuspendKustomization(name string) error {
 		return fmt.Errorf("failed to get kustomization: %w", err)
 	}
 
-	// Suspend the Kustomization
-	kustomization.Spec.Suspend = true
+	kustomization.Spec.Suspend = suspend
 	if err := f.runtimeClient.Update(context.TODO(), kustomization); err != nil {
-		return fmt.Errorf("failed to suspend kustomization: %w", err)
+		return fmt.Errorf("failed to update suspend of kustomization: %w", err)
 	}
 
-	f.log.Info("Suspended kustomization", "name", name, "namespace", f.fluxConfig.Namespace)
+	f.log.Info("Updated kustomization", "name", name, "namespace", f.fluxConfig.Namespace, "suspend", suspend)
 
 	return nil
 }
+
+// SuspendNamespaceKustomizations suspends all Kustomizations in the namespace, e.g. Kustomizations which apply
+// manifests to a workload cluster from its namespace on the management cluster. Names of the Kustomizations
+// are returned.
+func (f *FluxCD) SuspendNamespaceKustomizations(ctx context.Context, namespace string) ([]string, error) {
+	kustomizations := &kustomizev1.KustomizationList{}
+	if err := f.runtimeClient.List(ctx, kustomizations, runtimeclient.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list kustomizations in %s: %w", namespace, err)
+	}
+	var names []string
+	for i := range kustomizations.Items {
+		kustomization := &kustomizations.Items[i]
+		names = append(names, kustomization.Name)
+		if kustomization.Spec.Suspend {
+			continue
+		}
+		kustomization.Spec.Suspend = true
+		if err := f.runtimeClient.Update(ctx, kustomization); err != nil {
+			return nil, fmt.Errorf("failed to suspend kustomization %s/%s: %w", namespace, kustomization.Name, err)
+		}
+		f.log.Info("Suspended kustomization", "name", kustomization.Name, "namespace", namespace)
+	}
+	return names, nil
+}
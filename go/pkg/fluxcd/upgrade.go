@@ -0,0 +1,441 @@
+package fluxcd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const (
+	// Kustomization which installs Flux on a workload cluster from its management cluster, see templates/platform.yaml
+	remoteKustomizationName = "flux-remote"
+
+	fluxUpgradeTimeout = 10 * time.Minute
+)
+
+var fluxPathRegexp = regexp.MustCompile(`k8s-platform/flux/v[0-9]+\.[0-9]+\.[0-9]+`)
+
+// RequestReconcile asks Flux to reconcile the object now rather than at the next interval
+func (f *FluxCD) RequestReconcile(gvk schema.GroupVersionKind, namespace, name string) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, meta.ReconcileRequestAnnotation, time.Now().Format(time.RFC3339Nano)))
+	if err := f.runtimeClient.Patch(context.TODO(), obj, runtimeclient.RawPatch(types.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("failed to request reconcile of %s %s/%s: %w", gvk.Kind, namespace, name, err)
+	}
+	return nil
+}
+
+// WaitForGitRepositoryRevision waits until the flux-system GitRepository has fetched the given commit
+func (f *FluxCD) WaitForGitRepositoryRevision(sha string) error {
+	return poll(fluxUpgradeTimeout, func() (bool, error) {
+		repo := &unstructured.Unstructured{}
+		repo.SetGroupVersionKind(schema.GroupVersionKind{Group: "source.toolkit.fluxcd.io", Version: "v1", Kind: "GitRepository"})
+		if err := f.runtimeClient.Get(context.TODO(), runtimeclient.ObjectKey{Name: "flux-system", Namespace: f.fluxConfig.Namespace}, repo); err != nil {
+			return false, fmt.Errorf("failed to get GitRepository: %w", err)
+		}
+		revision, _, _ := unstructured.NestedString(repo.Object, "status", "artifact", "revision")
+		return strings.HasSuffix(revision, sha), nil
+	}, fmt.Sprintf("timeout waiting for GitRepository to fetch revision %s", sha))
+}
+
+// WaitForKustomizationRevision waits until the Kustomization is Ready and has applied the given commit
+func (f *FluxCD) WaitForKustomizationRevision(namespace, name, sha string) error {
+	return poll(fluxUpgradeTimeout, func() (bool, error) {
+		kustomization := &kustomizev1.Kustomization{}
+		if err := f.runtimeClient.Get(context.TODO(), runtimeclient.ObjectKey{Name: name, Namespace: namespace}, kustomization); err != nil {
+			return false, fmt.Errorf("failed to get kustomization %s/%s: %w", namespace, name, err)
+		}
+		if kustomization.Status.ObservedGeneration < kustomization.Generation {
+			return false, nil
+		}
+		if !strings.HasSuffix(kustomization.Status.LastAppliedRevision, sha) {
+			return false, nil
+		}
+		return apimeta.IsStatusConditionTrue(kustomization.Status.Conditions, meta.ReadyCondition), nil
+	}, fmt.Sprintf("timeout waiting for kustomization %s/%s to apply revision %s", namespace, name, sha))
+}
+
+// WaitForControllersVersion waits until all Flux controllers are rolled out at the given Flux version
+func (f *FluxCD) WaitForControllersVersion(version string) error {
+	expected := "v" + strings.TrimPrefix(version, "v")
+
+	return poll(fluxUpgradeTimeout, func() (bool, error) {
+		deployments, err := f.clusterAuth.Clientset.AppsV1().Deployments(f.fluxConfig.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: "app.kubernetes.io/part-of=flux",
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to list Flux controllers: %w", err)
+		}
+		if len(deployments.Items) == 0 {
+			return false, nil
+		}
+
+		for _, d := range deployments.Items {
+			if d.Labels["app.kubernetes.io/version"] != expected || !deploymentRolledOut(&d) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, fmt.Sprintf("timeout waiting for Flux controllers on %s to be rolled out at %s", f.clusterAuth.ClusterName, expected))
+}
+
+func deploymentRolledOut(d *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas == replicas &&
+		d.Status.AvailableReplicas == replicas
+}
+
+func poll(timeout time.Duration, condition func() (bool, error), timeoutMessage string) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("%s", timeoutMessage)
+		case <-ticker.C:
+			done, err := condition()
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+	}
+}
+
+// upgradeTarget is a cluster whose Flux version is pinned in a file in the repo
+type upgradeTarget struct {
+	cluster *appconfig.ClusterConfig
+	// file in the repo which pins Flux version of this cluster
+	file string
+	// original content of the file, used for rollback
+	original []byte
+	// Flux version referenced by the original file
+	previousVersion string
+}
+
+// FleetUpgrade moves Flux on all clusters from config to a new version.
+// Management cluster is upgraded first, then workload clusters in waves of WaveSize clusters.
+// Every stage is a git commit which is pushed to the branch Flux is syncing from.
+type FleetUpgrade struct {
+	log       logr.Logger
+	cfg       *appconfig.Config
+	toVersion string
+	waveSize  int
+	// wait for a target after the upgrade and after the rollback was pushed, replaced in tests
+	waitForUpgrade  func(target *upgradeTarget, sha string) error
+	waitForRollback func(target *upgradeTarget, sha string) error
+}
+
+func NewFleetUpgrade(log logr.Logger, cfg *appconfig.Config, toVersion string, waveSize int) (*FleetUpgrade, error) {
+	toVersion = strings.TrimPrefix(toVersion, "v")
+	manifests := filepath.Join(utils.RepoRoot(), "k8s-platform", "flux", "v"+toVersion)
+	if _, err := os.Stat(manifests); err != nil {
+		return nil, fmt.Errorf("flux v%s is not vendored in %s, run `components vendor flux %s` first", toVersion, manifests, toVersion)
+	}
+	if waveSize < 1 {
+		return nil, fmt.Errorf("wave size must be at least 1, got %d", waveSize)
+	}
+
+	u := &FleetUpgrade{
+		log:       log,
+		cfg:       cfg,
+		toVersion: toVersion,
+		waveSize:  waveSize,
+	}
+	u.waitForUpgrade, u.waitForRollback = u.waitForTarget, u.rollbackTarget
+	return u, nil
+}
+
+// Stages returns clusters grouped by upgrade stage: management clusters first, then workload clusters in waves
+func (u *FleetUpgrade) Stages() ([][]*upgradeTarget, error) {
+	var mgmt, workload []*upgradeTarget
+	repoRoot := utils.RepoRoot()
+
+	for i := range u.cfg.Clusters {
+		cluster := &u.cfg.Clusters[i]
+		switch {
+		case cluster.Provider == "kind":
+			// temporary management cluster is not upgraded, it is suspended after pivot
+			continue
+		case cluster.ManagementCluster == "":
+			mgmt = append(mgmt, &upgradeTarget{
+				cluster: cluster,
+				file:    filepath.Join(repoRoot, "clusters", cluster.Name, "flux-system", "kustomization.yaml"),
+			})
+		default:
+			workload = append(workload, &upgradeTarget{
+				cluster: cluster,
+				file:    filepath.Join(repoRoot, "clusters", cluster.ManagementCluster, cluster.Name, "platform.yaml"),
+			})
+		}
+	}
+
+	var stages [][]*upgradeTarget
+	if len(mgmt) > 0 {
+		stages = append(stages, mgmt)
+	}
+	for start := 0; start < len(workload); start += u.waveSize {
+		end := start + u.waveSize
+		if end > len(workload) {
+			end = len(workload)
+		}
+		stages = append(stages, workload[start:end])
+	}
+
+	for _, stage := range stages {
+		for _, target := range stage {
+			content, err := os.ReadFile(target.file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Flux version pin of %s: %w", target.cluster.Name, err)
+			}
+			if !fluxPathRegexp.Match(content) {
+				return nil, fmt.Errorf("%s does not reference k8s-platform/flux/v<version>", target.file)
+			}
+			target.original = content
+			target.previousVersion = strings.TrimPrefix(path.Base(string(fluxPathRegexp.Find(content))), "v")
+		}
+	}
+	return stages, nil
+}
+
+// Run upgrades all stages in order and stops on the first stage which fails.
+// Clusters which failed to become ready are rolled back to their previous Flux path.
+func (u *FleetUpgrade) Run() error {
+	stages, err := u.Stages()
+	if err != nil {
+		return err
+	}
+
+	for i, stage := range stages {
+		u.log.Info("Upgrading Flux", "stage", i+1, "of", len(stages), "clusters", targetNames(stage), "version", u.toVersion)
+		if err := u.upgradeStage(stage); err != nil {
+			return fmt.Errorf("stage %d failed: %w", i+1, err)
+		}
+	}
+
+	u.log.Info("Flux upgrade complete. Update flux.version of the clusters in config.yaml", "version", u.toVersion)
+	return nil
+}
+
+func (u *FleetUpgrade) upgradeStage(stage []*upgradeTarget) error {
+	newPath := "k8s-platform/flux/v" + u.toVersion
+
+	var files []string
+	for _, target := range stage {
+		updated := fluxPathRegexp.ReplaceAll(target.original, []byte(newPath))
+		if err := os.WriteFile(target.file, updated, 0644); err != nil {
+			return fmt.Errorf("failed to update %s: %w", target.file, err)
+		}
+		files = append(files, target.file)
+	}
+
+	sha, err := utils.GitCommitAndPush(files, fmt.Sprintf("chore: upgrade flux to v%s on %s", u.toVersion, strings.Join(targetNames(stage), ", ")), u.cfg.Github.Branch)
+	if err != nil {
+		return err
+	}
+	u.log.Info("Pushed Flux version change", "revision", sha)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []*upgradeTarget
+	var errs []string
+
+	for _, target := range stage {
+		wg.Add(1)
+		go func(target *upgradeTarget) {
+			defer wg.Done()
+			if err := u.waitForUpgrade(target, sha); err != nil {
+				u.log.Error(err, "Flux upgrade failed", "cluster", target.cluster.Name)
+				mu.Lock()
+				failed = append(failed, target)
+				errs = append(errs, fmt.Sprintf("%s: %v", target.cluster.Name, err))
+				mu.Unlock()
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if err := u.rollback(failed); err != nil {
+		errs = append(errs, fmt.Sprintf("rollback: %v", err))
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// waitForTarget waits until the management cluster of the target has applied the revision
+// and Flux on the target cluster runs the new version with all Kustomizations Ready
+func (u *FleetUpgrade) waitForTarget(target *upgradeTarget, sha string) error {
+	mgmtName := target.cluster.ManagementCluster
+	if mgmtName == "" {
+		// management cluster manages its own Flux
+		mgmtName = target.cluster.Name
+	}
+
+	mgmtFlux, err := u.fluxForCluster(mgmtName)
+	if err != nil {
+		return err
+	}
+
+	if err := mgmtFlux.SyncRevision(sha); err != nil {
+		return err
+	}
+
+	if target.cluster.ManagementCluster != "" {
+		if err := mgmtFlux.WaitForKustomizationRevision(target.cluster.Name, remoteKustomizationName, sha); err != nil {
+			return err
+		}
+	}
+
+	targetFlux, err := u.fluxForCluster(target.cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := targetFlux.WaitForControllersVersion(u.toVersion); err != nil {
+		return err
+	}
+	return targetFlux.WaitForFluxResources(context.TODO())
+}
+
+// SyncRevision makes Flux on this cluster fetch and apply the given commit without waiting for the intervals
+func (f *FluxCD) SyncRevision(sha string) error {
+	if err := f.RequestReconcile(schema.GroupVersionKind{Group: "source.toolkit.fluxcd.io", Version: "v1", Kind: "GitRepository"}, f.fluxConfig.Namespace, "flux-system"); err != nil {
+		return err
+	}
+	if err := f.WaitForGitRepositoryRevision(sha); err != nil {
+		return err
+	}
+	if err := f.RequestReconcile(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind), f.fluxConfig.Namespace, "flux-system"); err != nil {
+		return err
+	}
+	return f.WaitForKustomizationRevision(f.fluxConfig.Namespace, "flux-system", sha)
+}
+
+// rollback restores the previous Flux path of the failed clusters and waits until every Kustomization
+// which applied the upgrade has applied the revert and Flux controllers run the previous version again
+func (u *FleetUpgrade) rollback(failed []*upgradeTarget) error {
+	u.log.Info("Rolling back Flux version", "clusters", targetNames(failed))
+
+	var files []string
+	for _, target := range failed {
+		if err := os.WriteFile(target.file, target.original, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target.file, err)
+		}
+		files = append(files, target.file)
+	}
+
+	sha, err := utils.GitCommitAndPush(files, fmt.Sprintf("revert: flux v%s upgrade on %s", u.toVersion, strings.Join(targetNames(failed), ", ")), u.cfg.Github.Branch)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, target := range failed {
+		if err := u.waitForRollback(target, sha); err != nil {
+			errs = append(errs, fmt.Sprintf("rollback of %s was pushed but not applied: %v", target.cluster.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (u *FleetUpgrade) rollbackTarget(target *upgradeTarget, sha string) error {
+	targetFlux, err := u.fluxForCluster(target.cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	if target.cluster.ManagementCluster == "" {
+		// Flux of a management cluster applies its own components, the failed version may not be able
+		// to apply the revert, so the previous components are applied directly before the sync
+		if err := targetFlux.ApplyComponents(target.previousVersion); err != nil {
+			return err
+		}
+		if err := targetFlux.SyncRevision(sha); err != nil {
+			return err
+		}
+	} else {
+		mgmtFlux, err := u.fluxForCluster(target.cluster.ManagementCluster)
+		if err != nil {
+			return err
+		}
+		if err := mgmtFlux.SyncRevision(sha); err != nil {
+			return err
+		}
+		if err := mgmtFlux.RequestReconcile(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind), target.cluster.Name, remoteKustomizationName); err != nil {
+			return err
+		}
+		if err := mgmtFlux.WaitForKustomizationRevision(target.cluster.Name, remoteKustomizationName, sha); err != nil {
+			return err
+		}
+	}
+	return targetFlux.WaitForControllersVersion(target.previousVersion)
+}
+
+func (u *FleetUpgrade) fluxForCluster(name string) (*FluxCD, error) {
+	var clusterConfig *appconfig.ClusterConfig
+	for i := range u.cfg.Clusters {
+		if u.cfg.Clusters[i].Name == name {
+			clusterConfig = &u.cfg.Clusters[i]
+		}
+	}
+	if clusterConfig == nil {
+		return nil, fmt.Errorf("cluster %s is not defined in config", name)
+	}
+
+	clusterAuth, err := utils.GetClusterAuthInfoByName(u.cfg.KubeconfigPath, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for %s: %w", name, err)
+	}
+	return NewFluxCD(u.log, clusterConfig.Flux, u.cfg.Github, clusterAuth)
+}
+
+// StageClusterNames returns names of the clusters in an upgrade stage
+func StageClusterNames(stage []*upgradeTarget) []string {
+	return targetNames(stage)
+}
+
+func targetNames(targets []*upgradeTarget) []string {
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		names = append(names, t.cluster.Name)
+	}
+	return names
+}
@@ -0,0 +1,230 @@
+package fluxcd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	appconfig "github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func testUpgradeConfig() *appconfig.Config {
+	return &appconfig.Config{
+		Clusters: []appconfig.ClusterConfig{
+			{Name: appconfig.DefaultKindClusterName, Provider: "kind"},
+			{Name: "cluster-mgmt", Provider: "aws"},
+			{Name: "cluster-01", Provider: "aws", ManagementCluster: "cluster-mgmt"},
+			{Name: "cluster-02", Provider: "aws", ManagementCluster: "cluster-mgmt"},
+			{Name: "cluster-03", Provider: "aws", ManagementCluster: "cluster-mgmt"},
+		},
+		Github: appconfig.GithubConfig{Branch: "main"},
+	}
+}
+
+// fleetRepo creates a repository with Flux version pins of the clusters of testUpgradeConfig at v2.1.1,
+// vendored Flux v2.2.2 and a bare origin, and makes it the working directory
+func fleetRepo(t *testing.T) string {
+	t.Helper()
+	root, origin := t.TempDir(), t.TempDir()
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(env, "test")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+
+	git(t, origin, "init", "--bare", "-q")
+	git(t, root, "init", "-q", "-b", "main")
+	git(t, root, "remote", "add", "origin", origin)
+
+	files := map[string]string{
+		"k8s-platform/flux/v2.2.2/gotk-components.yaml":        "",
+		"clusters/cluster-mgmt/flux-system/kustomization.yaml": "resources:\n- ../../../k8s-platform/flux/v2.1.1\n",
+	}
+	for _, name := range []string{"cluster-01", "cluster-02", "cluster-03"} {
+		files["clusters/cluster-mgmt/"+name+"/platform.yaml"] = "spec:\n  path: ./k8s-platform/flux/v2.1.1\n"
+	}
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, root, "add", ".")
+	git(t, root, "commit", "-q", "-m", "init")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return root
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestStages(t *testing.T) {
+	tests := []struct {
+		waveSize int
+		want     [][]string
+	}{
+		{1, [][]string{{"cluster-mgmt"}, {"cluster-01"}, {"cluster-02"}, {"cluster-03"}}},
+		{2, [][]string{{"cluster-mgmt"}, {"cluster-01", "cluster-02"}, {"cluster-03"}}},
+		{5, [][]string{{"cluster-mgmt"}, {"cluster-01", "cluster-02", "cluster-03"}}},
+	}
+
+	fleetRepo(t)
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("wave size %d", tt.waveSize), func(t *testing.T) {
+			upgrade, err := NewFleetUpgrade(logr.Discard(), testUpgradeConfig(), "v2.2.2", tt.waveSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stages, err := upgrade.Stages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got [][]string
+			for _, stage := range stages {
+				got = append(got, StageClusterNames(stage))
+				for _, target := range stage {
+					if target.previousVersion != "2.1.1" {
+						t.Errorf("previous version of %s = %q, want 2.1.1", target.cluster.Name, target.previousVersion)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Stages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStagesErrors(t *testing.T) {
+	root := fleetRepo(t)
+
+	if _, err := NewFleetUpgrade(logr.Discard(), testUpgradeConfig(), "2.3.0", 1); err == nil {
+		t.Error("NewFleetUpgrade() to a version which is not vendored succeeded")
+	}
+	if _, err := NewFleetUpgrade(logr.Discard(), testUpgradeConfig(), "2.2.2", 0); err == nil {
+		t.Error("NewFleetUpgrade() with wave size 0 succeeded")
+	}
+
+	upgrade, err := NewFleetUpgrade(logr.Discard(), testUpgradeConfig(), "2.2.2", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "clusters", "cluster-mgmt", "cluster-02", "platform.yaml"), []byte("spec: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upgrade.Stages(); err == nil || !strings.Contains(err.Error(), "cluster-02") {
+		t.Errorf("Stages() with a file without Flux path error = %v, want error about cluster-02", err)
+	}
+}
+
+func TestUpgradeStageRollback(t *testing.T) {
+	root := fleetRepo(t)
+	upgrade, err := NewFleetUpgrade(logr.Discard(), testUpgradeConfig(), "2.2.2", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var rolledBack []string
+	upgrade.waitForUpgrade = func(target *upgradeTarget, sha string) error {
+		if target.cluster.Name == "cluster-02" {
+			return fmt.Errorf("flux controllers are not ready")
+		}
+		return nil
+	}
+	upgrade.waitForRollback = func(target *upgradeTarget, sha string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		rolledBack = append(rolledBack, target.cluster.Name)
+		if git(t, root, "rev-parse", "HEAD") != sha {
+			t.Errorf("rollback of %s waits for %s, want HEAD", target.cluster.Name, sha)
+		}
+		return nil
+	}
+
+	stages, err := upgrade.Stages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = upgrade.upgradeStage(stages[1])
+	if err == nil || !strings.Contains(err.Error(), "cluster-02") {
+		t.Fatalf("upgradeStage() error = %v, want error about cluster-02", err)
+	}
+
+	// only the failed cluster is rolled back
+	sort.Strings(rolledBack)
+	if !reflect.DeepEqual(rolledBack, []string{"cluster-02"}) {
+		t.Errorf("rolled back clusters = %v, want [cluster-02]", rolledBack)
+	}
+	reverted := git(t, root, "show", "--name-only", "--format=", "HEAD")
+	if reverted != "clusters/cluster-mgmt/cluster-02/platform.yaml" {
+		t.Errorf("revert commit changed %q, want only cluster-02 platform.yaml", reverted)
+	}
+	for name, want := range map[string]string{"cluster-01": "v2.2.2", "cluster-02": "v2.1.1", "cluster-03": "v2.2.2"} {
+		content, err := os.ReadFile(filepath.Join(root, "clusters", "cluster-mgmt", name, "platform.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "k8s-platform/flux/"+want) {
+			t.Errorf("%s platform.yaml = %q, want flux %s", name, content, want)
+		}
+	}
+
+	wantLog := []string{
+		"revert: flux v2.2.2 upgrade on cluster-02",
+		"chore: upgrade flux to v2.2.2 on cluster-01, cluster-02, cluster-03",
+		"init",
+	}
+	if got := strings.Split(git(t, root, "log", "--format=%s", "origin/main"), "\n"); !reflect.DeepEqual(got, wantLog) {
+		t.Errorf("pushed commits = %v, want %v", got, wantLog)
+	}
+}
+
+func TestUpgradeStageSucceeded(t *testing.T) {
+	root := fleetRepo(t)
+	upgrade, err := NewFleetUpgrade(logr.Discard(), testUpgradeConfig(), "2.2.2", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgrade.waitForUpgrade = func(target *upgradeTarget, sha string) error { return nil }
+	upgrade.waitForRollback = func(target *upgradeTarget, sha string) error {
+		t.Errorf("cluster %s is rolled back after successful upgrade", target.cluster.Name)
+		return nil
+	}
+
+	stages, err := upgrade.Stages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upgrade.upgradeStage(stages[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := git(t, root, "show", "--name-only", "--format=", "origin/main"); got != "clusters/cluster-mgmt/flux-system/kustomization.yaml" {
+		t.Errorf("upgrade commit changed %q, want only cluster-mgmt flux-system", got)
+	}
+}
@@ -0,0 +1,74 @@
+package generator
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// addonsFile is written next to the CNI HelmChartProxy and applied by the same Flux Kustomization
+const addonsFile = "addons.yaml"
+
+// HelmChartProxyNames returns names of all HelmChartProxies generated for the cluster,
+// they are in the cluster namespace on its management cluster
+func HelmChartProxyNames(cfg *config.Config, cluster *config.ClusterConfig) []string {
+	names := []string{CNIHelmChartProxyName(cluster)}
+	for _, addon := range cfg.ClusterAddons(cluster) {
+		names = append(names, addonHelmChartProxyName(cluster, addon))
+	}
+	return names
+}
+
+// CNIHelmChartProxyName returns name of the HelmChartProxy of the cluster CNI
+func CNIHelmChartProxyName(cluster *config.ClusterConfig) string {
+	return cluster.CNI.Type + "-" + cluster.Name
+}
+
+func addonHelmChartProxyName(cluster *config.ClusterConfig, addon config.AddonConfig) string {
+	return addon.Name + "-" + cluster.Name
+}
+
+// addonHelmChartProxies generates a HelmChartProxy per add-on of the cluster. CAAPH only matches
+// clusters in the namespace of the HelmChartProxy, so add-ons shared by a group are rendered
+// for each cluster of the group rather than once with a group label selector.
+// Returns nil if the cluster has no add-ons.
+func (g *Generator) addonHelmChartProxies(cluster *config.ClusterConfig) ([]byte, error) {
+	addons := g.cfg.ClusterAddons(cluster)
+	if len(addons) == 0 {
+		return nil, nil
+	}
+
+	var objects []*unstructured.Unstructured
+	for _, addon := range addons {
+		hcp := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "addons.cluster.x-k8s.io/v1alpha1",
+			"kind":       "HelmChartProxy",
+			"metadata": map[string]interface{}{
+				"name":      addonHelmChartProxyName(cluster, addon),
+				"namespace": cluster.Name,
+			},
+			"spec": map[string]interface{}{
+				"clusterSelector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"cluster.x-k8s.io/cluster-name": cluster.Name,
+					},
+				},
+				"repoURL":   addon.RepoURL,
+				"chartName": addon.Chart,
+				"version":   addon.Version,
+				"namespace": addon.Namespace,
+			},
+		}}
+		if addon.Values != "" {
+			if err := unstructured.SetNestedField(hcp.Object, addon.Values, "spec", "valuesTemplate"); err != nil {
+				return nil, err
+			}
+		}
+		if err := g.mirrorHelmChartProxy(hcp); err != nil {
+			return nil, err
+		}
+		objects = append(objects, hcp)
+	}
+
+	return encodeObjects(objects)
+}
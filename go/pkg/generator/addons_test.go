@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func addonsConfig() *config.Config {
+	return &config.Config{
+		AddonGroups: []config.AddonGroupConfig{
+			{Name: "observability", Addons: []config.AddonConfig{
+				{Name: "metrics-server", RepoURL: "https://kubernetes-sigs.github.io/metrics-server/", Chart: "metrics-server", Version: "3.11.0", Namespace: "kube-system"},
+				{Name: "kube-state-metrics", RepoURL: "https://prometheus-community.github.io/helm-charts", Chart: "kube-state-metrics", Version: "5.15.2", Namespace: "monitoring"},
+			}},
+		},
+		Clusters: []config.ClusterConfig{
+			{
+				Name:        "cluster-01",
+				CNI:         config.CNIConfig{Type: config.CNICilium},
+				AddonGroups: []string{"observability"},
+				Addons: []config.AddonConfig{
+					{Name: "metrics-server", RepoURL: "https://kubernetes-sigs.github.io/metrics-server/", Chart: "metrics-server", Version: "3.12.0", Namespace: "kube-system", Values: "replicas: 2\n"},
+				},
+			},
+			{Name: "cluster-02", CNI: config.CNIConfig{Type: config.CNICalico}},
+		},
+	}
+}
+
+func TestHelmChartProxyNames(t *testing.T) {
+	cfg := addonsConfig()
+
+	tests := []struct {
+		cluster string
+		want    []string
+	}{
+		{"cluster-01", []string{"cilium-cluster-01", "metrics-server-cluster-01", "kube-state-metrics-cluster-01"}},
+		{"cluster-02", []string{"calico-cluster-02"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cluster, func(t *testing.T) {
+			got := HelmChartProxyNames(cfg, cfg.Cluster(tt.cluster))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HelmChartProxyNames(%s) = %v, want %v", tt.cluster, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddonHelmChartProxies(t *testing.T) {
+	cfg := addonsConfig()
+	g := &Generator{log: logr.Discard(), cfg: cfg}
+
+	data, err := g.addonHelmChartProxies(cfg.Cluster("cluster-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := decodeObjects(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d HelmChartProxies, want 2", len(objects))
+	}
+
+	// the cluster own definition replaces the group one in place
+	hcp := objects[0]
+	if hcp.GetName() != "metrics-server-cluster-01" || hcp.GetNamespace() != "cluster-01" {
+		t.Errorf("got HelmChartProxy %s/%s, want cluster-01/metrics-server-cluster-01", hcp.GetNamespace(), hcp.GetName())
+	}
+	spec := hcp.Object["spec"].(map[string]interface{})
+	if spec["version"] != "3.12.0" || spec["valuesTemplate"] != "replicas: 2\n" {
+		t.Errorf("metrics-server is not overridden by the cluster add-on: %v", spec)
+	}
+	selector := spec["clusterSelector"].(map[string]interface{})["matchLabels"].(map[string]interface{})
+	if selector["cluster.x-k8s.io/cluster-name"] != "cluster-01" {
+		t.Errorf("clusterSelector = %v, want cluster-01 only", selector)
+	}
+
+	spec = objects[1].Object["spec"].(map[string]interface{})
+	if _, ok := spec["valuesTemplate"]; ok {
+		t.Errorf("valuesTemplate is set for add-on without values: %v", spec)
+	}
+	if spec["namespace"] != "monitoring" || spec["chartName"] != "kube-state-metrics" {
+		t.Errorf("unexpected kube-state-metrics spec: %v", spec)
+	}
+
+	data, err = g.addonHelmChartProxies(cfg.Cluster("cluster-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		t.Errorf("cluster without add-ons got %q, want nil", data)
+	}
+}
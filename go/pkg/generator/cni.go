@@ -0,0 +1,156 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// cniLabel is set on the CAPI Cluster and selected by the CNI HelmChartProxy of the cluster
+const cniLabel = "cni"
+
+// cniIngressRules are the ports each CNI needs open between nodes, they are set in AWSCluster
+// spec.network.cni.cniIngressRules. Same as CAPA defaults for Calico, which are dropped when
+// the rules are set explicitly.
+var cniIngressRules = map[string][]ingressRule{
+	config.CNICilium: {
+		{"(cilium) VXLAN overlay", "udp", 8472, 8472},
+		{"(cilium) health checks", "tcp", 4240, 4240},
+	},
+	config.CNICalico: {
+		{"(calico) BGP", "tcp", 179, 179},
+		{"(calico) IP-in-IP", "4", -1, 65535},
+		{"(calico) VXLAN", "udp", 4789, 4789},
+		{"(calico) Typha", "tcp", 5473, 5473},
+	},
+}
+
+type ingressRule struct {
+	description string
+	protocol    string
+	fromPort    int
+	toPort      int
+}
+
+// cniIngressRulesYAML renders ingress rules of the CNI type as a YAML list indented
+// to fit under cniIngressRules in templates/aws/cluster.yaml
+func cniIngressRulesYAML(cniType string) (string, error) {
+	rules, ok := cniIngressRules[cniType]
+	if !ok {
+		return "", fmt.Errorf("no ingress rules defined for CNI %s", cniType)
+	}
+
+	var b strings.Builder
+	for _, rule := range rules {
+		fmt.Fprintf(&b, "      - description: %q\n", rule.description)
+		fmt.Fprintf(&b, "        protocol: %q\n", rule.protocol)
+		fmt.Fprintf(&b, "        fromPort: %d\n", rule.fromPort)
+		fmt.Fprintf(&b, "        toPort: %d\n", rule.toPort)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// cniHelmChartProxy generates a HelmChartProxy which installs the CNI only on this cluster. It is
+// based on the HelmChartProxy vendored in k8s-platform/cni-caaph-resource/<type>/v<version>, with
+// cluster Helm values from config merged over the vendored values.
+func (g *Generator) cniHelmChartProxy(cluster *config.ClusterConfig) ([]byte, error) {
+	base := filepath.Join(g.repoRoot, "k8s-platform", "cni-caaph-resource", cluster.CNI.Type, "v"+cluster.CNI.Version,
+		cluster.CNI.Type+"-helm-chart-proxy.yaml")
+	if cluster.CNI.Type == config.CNICilium && !cluster.Mesh.Enabled() {
+		base = strings.TrimSuffix(base, ".yaml") + "-no-mesh.yaml"
+	}
+
+	data, err := os.ReadFile(base)
+	if err != nil {
+		return nil, fmt.Errorf("%s v%s is not vendored: %w", cluster.CNI.Type, cluster.CNI.Version, err)
+	}
+	objects, err := decodeObjects(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) != 1 || objects[0].GetKind() != "HelmChartProxy" {
+		return nil, fmt.Errorf("%s must contain a single HelmChartProxy", base)
+	}
+	hcp := objects[0]
+
+	hcp.SetName(CNIHelmChartProxyName(cluster))
+	hcp.SetNamespace(cluster.Name)
+	selector := map[string]interface{}{
+		"cluster.x-k8s.io/cluster-name": cluster.Name,
+		cniLabel:                        cluster.CNI.Type,
+	}
+	if err := unstructured.SetNestedMap(hcp.Object, selector, "spec", "clusterSelector", "matchLabels"); err != nil {
+		return nil, err
+	}
+
+	if cluster.CNI.Values != "" {
+		valuesTemplate, _, _ := unstructured.NestedString(hcp.Object, "spec", "valuesTemplate")
+		merged, err := mergeValuesTemplate(valuesTemplate, cluster.CNI.Values)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge CNI values: %w", err)
+		}
+		if err := unstructured.SetNestedField(hcp.Object, merged, "spec", "valuesTemplate"); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.mirrorHelmChartProxy(hcp); err != nil {
+		return nil, err
+	}
+
+	return encodeObjects(objects)
+}
+
+var templateActionRegexp = regexp.MustCompile(`{{.*?}}`)
+
+// mergeValuesTemplate merges Helm values over a CAAPH valuesTemplate. The template contains
+// Go template actions which are not valid YAML, so they are swapped with placeholders while
+// the values are merged. Comments of the template are not preserved.
+func mergeValuesTemplate(valuesTemplate, values string) (string, error) {
+	var actions []string
+	protected := templateActionRegexp.ReplaceAllStringFunc(valuesTemplate, func(action string) string {
+		actions = append(actions, action)
+		return fmt.Sprintf("__template_action_%d__", len(actions)-1)
+	})
+
+	base := map[string]interface{}{}
+	if err := sigsyaml.Unmarshal([]byte(protected), &base); err != nil {
+		return "", fmt.Errorf("failed to parse valuesTemplate: %w", err)
+	}
+	override := map[string]interface{}{}
+	if err := sigsyaml.Unmarshal([]byte(values), &override); err != nil {
+		return "", fmt.Errorf("failed to parse values: %w", err)
+	}
+
+	out, err := sigsyaml.Marshal(mergeValues(base, override))
+	if err != nil {
+		return "", err
+	}
+
+	merged := string(out)
+	for i, action := range actions {
+		merged = strings.Replace(merged, fmt.Sprintf("__template_action_%d__", i), action, 1)
+	}
+	return merged, nil
+}
+
+// mergeValues merges override into base the same way Helm merges values files: maps are
+// merged recursively, everything else is replaced
+func mergeValues(base, override map[string]interface{}) map[string]interface{} {
+	for k, v := range override {
+		if overrideMap, ok := v.(map[string]interface{}); ok {
+			if baseMap, ok := base[k].(map[string]interface{}); ok {
+				base[k] = mergeValues(baseMap, overrideMap)
+				continue
+			}
+		}
+		base[k] = v
+	}
+	return base
+}
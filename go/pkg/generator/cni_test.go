@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestCNIIngressRulesYAML(t *testing.T) {
+	for cniType, rules := range cniIngressRules {
+		t.Run(cniType, func(t *testing.T) {
+			out, err := cniIngressRulesYAML(cniType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the rules are rendered under cniIngressRules in templates/aws/cluster.yaml
+			var parsed struct {
+				CNIIngressRules []struct {
+					Description string `json:"description"`
+					Protocol    string `json:"protocol"`
+					FromPort    int    `json:"fromPort"`
+					ToPort      int    `json:"toPort"`
+				} `json:"cniIngressRules"`
+			}
+			if err := sigsyaml.UnmarshalStrict([]byte("    cniIngressRules:\n"+out+"\n"), &parsed); err != nil {
+				t.Fatalf("rules are not valid YAML: %v\n%s", err, out)
+			}
+			if len(parsed.CNIIngressRules) != len(rules) {
+				t.Fatalf("rules = %d, want %d", len(parsed.CNIIngressRules), len(rules))
+			}
+			for i, rule := range rules {
+				got := parsed.CNIIngressRules[i]
+				if got.Description != rule.description || got.Protocol != rule.protocol || got.FromPort != rule.fromPort || got.ToPort != rule.toPort {
+					t.Errorf("rule %d = %+v, want %+v", i, got, rule)
+				}
+			}
+		})
+	}
+
+	if _, err := cniIngressRulesYAML("flannel"); err == nil {
+		t.Error("cniIngressRulesYAML() of unknown CNI returned nil error")
+	}
+}
+
+func TestMergeValuesTemplate(t *testing.T) {
+	valuesTemplate := `cluster:
+  name: {{ .Cluster.metadata.name }}
+  id: {{ index .Cluster.metadata.labels "cilium-cluster-id" }}
+hubble:
+  enabled: true
+  relay:
+    enabled: false
+clustermesh:
+  config:
+    clusters: {{ range $key, $value := .Cluster.metadata.annotations }}{{ if eq $key "cilium-mesh-clusters" }}{{ $value }}{{ end }}{{ end }}
+`
+	values := `hubble:
+  relay:
+    enabled: true
+ipam:
+  mode: kubernetes
+`
+	merged, err := mergeValuesTemplate(valuesTemplate, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"name: {{ .Cluster.metadata.name }}",
+		`id: {{ index .Cluster.metadata.labels "cilium-cluster-id" }}`,
+		`clusters: {{ range $key, $value := .Cluster.metadata.annotations }}{{ if eq $key "cilium-mesh-clusters" }}{{ $value }}{{ end }}{{ end }}`,
+	} {
+		if !strings.Contains(merged, want) {
+			t.Errorf("template action is not restored, want %q in:\n%s", want, merged)
+		}
+	}
+	if strings.Contains(merged, "__template_action_") {
+		t.Errorf("placeholder is left in:\n%s", merged)
+	}
+
+	parsed := map[string]interface{}{}
+	if err := sigsyaml.Unmarshal([]byte(templateActionRegexp.ReplaceAllString(merged, "x")), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	hubble := parsed["hubble"].(map[string]interface{})
+	if hubble["enabled"] != true || hubble["relay"].(map[string]interface{})["enabled"] != true {
+		t.Errorf("hubble = %v, want enabled with relay", hubble)
+	}
+	if parsed["ipam"].(map[string]interface{})["mode"] != "kubernetes" {
+		t.Errorf("ipam = %v, want values added", parsed["ipam"])
+	}
+
+	if _, err := mergeValuesTemplate(valuesTemplate, "hubble: [\n"); err == nil {
+		t.Error("mergeValuesTemplate() of invalid values returned nil error")
+	}
+}
+
+func TestCNIHelmChartProxyMesh(t *testing.T) {
+	g := tenantTestGenerator(t, false)
+	platform, err := filepath.Abs(filepath.Join("..", "..", "..", "k8s-platform"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(platform, filepath.Join(g.repoRoot, "k8s-platform")); err != nil {
+		t.Fatal(err)
+	}
+	cluster := g.cfg.Cluster("cluster-01")
+	cluster.CNI.Version = config.CiliumVersion
+
+	noMesh, err := g.cniHelmChartProxy(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.Mesh = config.MeshConfig{Label: "main", ClusterID: 1}
+	mesh, err := g.cniHelmChartProxy(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(noMesh), "cilium-mesh-clusters") || !strings.Contains(string(mesh), "cilium-mesh-clusters") {
+		t.Errorf("mesh annotation values are only expected in the mesh HelmChartProxy:\nno mesh:\n%s\nmesh:\n%s", noMesh, mesh)
+	}
+}
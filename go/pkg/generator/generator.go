@@ -0,0 +1,361 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// Generator renders cluster manifests from templates/ into clusters/<management cluster>/<cluster>,
+// replacing `helper.sh -g` which did the same from config/*.env files with envsubst.
+type Generator struct {
+	log      logr.Logger
+	cfg      *config.Config
+	repoRoot string
+}
+
+func NewGenerator(log logr.Logger, cfg *config.Config) *Generator {
+	return &Generator{log: log, cfg: cfg, repoRoot: utils.RepoRoot()}
+}
+
+// Generate generates manifests for the named clusters, or for all clusters in config if no names are given.
+// Temporary kind cluster is not generated, it is not a CAPI cluster.
+func (g *Generator) Generate(names ...string) error {
+	generated := 0
+	managementClusters := []string{}
+	for i := range g.cfg.Clusters {
+		cluster := &g.cfg.Clusters[i]
+		if cluster.Provider == "kind" {
+			continue
+		}
+		if len(names) > 0 && !contains(names, cluster.Name) {
+			continue
+		}
+		if err := g.generateCluster(cluster); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		generated++
+		if !contains(managementClusters, cluster.ManagedBy()) {
+			managementClusters = append(managementClusters, cluster.ManagedBy())
+		}
+	}
+
+	if len(names) > 0 && generated != len(names) {
+		return fmt.Errorf("some of the clusters %v are not defined in config", names)
+	}
+
+	for _, managementCluster := range managementClusters {
+		if err := g.generatePlatform(managementCluster); err != nil {
+			return fmt.Errorf("management cluster %s: %w", managementCluster, err)
+		}
+		if err := g.generateIdentities(managementCluster); err != nil {
+			return fmt.Errorf("management cluster %s: %w", managementCluster, err)
+		}
+	}
+	return nil
+}
+
+// ClusterDir returns the directory of the cluster manifests. Management cluster manifests
+// live under the temporary cluster, because that's where it is created from.
+func (g *Generator) ClusterDir(cluster *config.ClusterConfig) string {
+	return filepath.Join(g.repoRoot, "clusters", cluster.ManagedBy(), cluster.Name)
+}
+
+// ClusterPaths returns paths written by Generate for the cluster, relative to the repository root: the cluster
+// directory, the kustomization of its management cluster directory and AWS identities if the cluster uses one
+func (g *Generator) ClusterPaths(cluster *config.ClusterConfig) ([]string, error) {
+	dir := g.ClusterDir(cluster)
+	paths := []string{dir, filepath.Join(filepath.Dir(dir), "kustomization.yaml")}
+	if cluster.AWS.Identity != "" {
+		paths = append(paths, filepath.Join(filepath.Dir(dir), identitiesFile))
+	}
+	return g.relPaths(paths)
+}
+
+// RemoveCluster deletes the generated manifests of the cluster and removes it from the kustomization of its
+// management cluster directory. Paths which are changed are returned, they are relative to the repository root.
+func (g *Generator) RemoveCluster(cluster *config.ClusterConfig) ([]string, error) {
+	dir := g.ClusterDir(cluster)
+	parent := filepath.Join(filepath.Dir(dir), "kustomization.yaml")
+	g.log.Info("Removing cluster manifests", "cluster", cluster.Name, "path", dir)
+	if err := removeKustomizationResource(parent, cluster.Name); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove %s: %w", dir, err)
+	}
+	return g.relPaths([]string{dir, parent})
+}
+
+func (g *Generator) relPaths(paths []string) ([]string, error) {
+	var rel []string
+	for _, path := range paths {
+		r, err := filepath.Rel(g.repoRoot, path)
+		if err != nil {
+			return nil, err
+		}
+		rel = append(rel, r)
+	}
+	return rel, nil
+}
+
+// generateCluster expects pod and service CIDRs to be set, see ipam.Allocator
+func (g *Generator) generateCluster(cluster *config.ClusterConfig) error {
+	if cluster.Provider != "aws" {
+		return fmt.Errorf("only aws provider is supported, got %q", cluster.Provider)
+	}
+	if cluster.PodCIDR == "" || cluster.ServiceCIDR == "" {
+		return fmt.Errorf("pod and service CIDRs must be set or allocated")
+	}
+
+	dir := g.ClusterDir(cluster)
+	g.log.Info("Generating cluster manifests", "cluster", cluster.Name, "path", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "caaph"), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	vars := g.templateVars(cluster)
+	var err error
+	if vars["CNI_INGRESS_RULES"], err = cniIngressRulesYAML(cluster.CNI.Type); err != nil {
+		return err
+	}
+	if vars["FLUX_IMAGES"], err = g.kustomizationImages(g.fluxComponents(cluster.Flux.Version)); err != nil {
+		return err
+	}
+
+	templates := map[string]string{
+		"capi-cluster.yaml":  filepath.Join("aws", "cluster.yaml"),
+		"namespace.yaml":     "capi-workload-namespace.yaml",
+		"platform.yaml":      "platform.yaml",
+		"kustomization.yaml": "kustomization.yaml",
+	}
+	// platform.yaml of a management cluster bootstraps Flux from its own flux-system directory
+	if cluster.ManagementCluster == "" {
+		templates["platform.yaml"] = "platform-management.yaml"
+	}
+
+	files := map[string][]byte{}
+	for file, template := range templates {
+		if files[file], err = g.expand(template, vars); err != nil {
+			return err
+		}
+	}
+
+	if files[filepath.Join("caaph", "cni.yaml")], err = g.cniHelmChartProxy(cluster); err != nil {
+		return err
+	}
+
+	addons, err := g.addonHelmChartProxies(cluster)
+	if err != nil {
+		return err
+	}
+	// when all add-ons are removed from config, the file is removed and Flux prunes their HelmChartProxies
+	addonsPath := filepath.Join(dir, "caaph", addonsFile)
+	if addons != nil {
+		files[filepath.Join("caaph", addonsFile)] = addons
+	} else if err := os.Remove(addonsPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", addonsPath, err)
+	}
+
+	for file, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+
+	// kustomization.yaml is re-rendered from the template, keep tenants added by `tenant add`
+	tenants, err := filepath.Glob(filepath.Join(dir, clusterTenantFile("*")))
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		if err := addKustomizationResource(filepath.Join(dir, "kustomization.yaml"), filepath.Base(tenant)); err != nil {
+			return err
+		}
+	}
+
+	return addKustomizationResource(filepath.Join(filepath.Dir(dir), "kustomization.yaml"), cluster.Name)
+}
+
+// generatePlatform writes platform.yaml of the management cluster, Flux Kustomizations of the
+// components installed on the management cluster itself
+func (g *Generator) generatePlatform(managementCluster string) error {
+	caaphImages, err := g.kustomizationImages(filepath.Join(g.repoRoot, "k8s-platform", "cluster-api-addon-provider-helm", "manifests.yaml"))
+	if err != nil {
+		return err
+	}
+	data, err := g.expand("platform-caaph.yaml", map[string]string{"CAAPH_IMAGES": caaphImages})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(g.repoRoot, "clusters", managementCluster, "platform.yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// templateVars returns values of the variables used in templates/, same names as in config/*.env
+func (g *Generator) templateVars(cluster *config.ClusterConfig) map[string]string {
+	// cilium-cluster-id label is read by the Cilium HelmChartProxy, 0 is Cilium default for clusters outside a mesh
+	meshLabel, clusterID := config.MeshLabelNone, "0"
+	if cluster.Mesh.Enabled() {
+		meshLabel, clusterID = cluster.Mesh.Label, strconv.Itoa(cluster.Mesh.ClusterID)
+	}
+
+	identityKind, identityName := g.identityRef(cluster.AWS.Identity)
+
+	return map[string]string{
+		"CLUSTER_NAME":                   cluster.Name,
+		"INITIALLY_MANAGED_BY":           cluster.ManagedBy(),
+		"POD_CIDR":                       cluster.PodCIDR,
+		"SERVICE_CIDR":                   cluster.ServiceCIDR,
+		"KUBERNETES_VERSION":             cluster.KubernetesVersion,
+		"AWS_REGION":                     cluster.AWS.Region,
+		"AWS_SSH_KEY_NAME":               cluster.AWS.SSHKeyName,
+		"AWS_IDENTITY_KIND":              identityKind,
+		"AWS_IDENTITY_NAME":              identityName,
+		"AWS_CONTROL_PLANE_MACHINE_TYPE": cluster.AWS.ControlPlaneMachineType,
+		"AWS_NODE_MACHINE_TYPE":          cluster.AWS.NodeMachineType,
+		"CONTROL_PLANE_MACHINE_COUNT":    strconv.Itoa(cluster.ControlPlaneMachineCount),
+		"WORKER_MACHINE_COUNT":           strconv.Itoa(cluster.WorkerMachineCount),
+		"MESH_LABEL_SELECTOR":            meshLabel,
+		"CLUSTER_INT_ID":                 clusterID,
+		"CNI_TYPE":                       cluster.CNI.Type,
+		"FLUXCD_VERSION":                 cluster.Flux.Version,
+		"FLUX_DECRYPTION":                fluxDecryption(cluster),
+	}
+}
+
+// fluxDecryption renders Kustomization spec.decryption when SOPS is enabled. Decryption secret
+// is created in the cluster namespace on the management cluster, see sops.CreateDecryptionSecret
+func fluxDecryption(cluster *config.ClusterConfig) string {
+	return decryption(cluster.Flux.Decryption)
+}
+
+// decryption renders Kustomization spec.decryption with the secret in the namespace of the Kustomization
+func decryption(enabled bool) string {
+	if !enabled {
+		return ""
+	}
+	return "  decryption:\n    provider: sops\n    secretRef:\n      name: " + config.SOPSSecretName
+}
+
+// expand substitutes ${VAR} in a template, like envsubst. Unlike envsubst, unknown
+// variables are an error rather than silently replaced with an empty string. A line which
+// consists of a single variable with an empty value is removed, this is how optional
+// blocks of YAML are rendered.
+func (g *Generator) expand(template string, vars map[string]string) ([]byte, error) {
+	path := filepath.Join(g.repoRoot, "templates", template)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "${") && strings.HasSuffix(trimmed, "}") {
+			if value, ok := vars[trimmed[2:len(trimmed)-1]]; ok && value == "" {
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	data = []byte(strings.Join(kept, ""))
+
+	var missing []string
+	out := os.Expand(string(data), func(name string) string {
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template %s uses unknown variables: %s", template, strings.Join(missing, ", "))
+	}
+	return []byte(out), nil
+}
+
+// addKustomizationResource adds the cluster directory to the management cluster kustomization
+func addKustomizationResource(path, resource string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	kustomization := map[string]interface{}{}
+	if err := sigsyaml.Unmarshal(data, &kustomization); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	resources, _ := kustomization["resources"].([]interface{})
+	for _, r := range resources {
+		if r == resource {
+			return nil
+		}
+	}
+	kustomization["resources"] = append(resources, resource)
+
+	out, err := sigsyaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// decodeObjects splits a multi-document YAML into objects
+func decodeObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode YAML document: %w", err)
+		}
+		if len(obj.Object) > 0 {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// encodeObjects joins objects into a multi-document YAML
+func encodeObjects(objects []*unstructured.Unstructured) ([]byte, error) {
+	var b bytes.Buffer
+	for _, obj := range objects {
+		out, err := sigsyaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("---\n")
+		b.Write(out)
+	}
+	return b.Bytes(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestRemoveCluster(t *testing.T) {
+	g := tenantTestGenerator(t, false)
+	parent := filepath.Join(g.repoRoot, "clusters", "cluster-mgmt", "kustomization.yaml")
+	if err := os.WriteFile(parent, []byte("resources:\n- cluster-01\n- cluster-02\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := g.RemoveCluster(g.cfg.Cluster("cluster-01"))
+	if err != nil {
+		t.Fatalf("RemoveCluster() error = %v", err)
+	}
+	want := []string{filepath.Join("clusters", "cluster-mgmt", "cluster-01"), filepath.Join("clusters", "cluster-mgmt", "kustomization.yaml")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("RemoveCluster() paths = %v, want %v", paths, want)
+	}
+	if _, err := os.Stat(g.ClusterDir(g.cfg.Cluster("cluster-01"))); !os.IsNotExist(err) {
+		t.Errorf("cluster-01 directory is not removed: %v", err)
+	}
+	if _, err := os.Stat(g.ClusterDir(g.cfg.Cluster("cluster-02"))); err != nil {
+		t.Errorf("cluster-02 directory is removed: %v", err)
+	}
+	resources := readResources(t, parent)
+	if !reflect.DeepEqual(resources, []string{"cluster-02"}) {
+		t.Errorf("parent kustomization resources = %v, want [cluster-02]", resources)
+	}
+
+	// the cluster is already removed, only missing parent kustomization is an error
+	if _, err := g.RemoveCluster(g.cfg.Cluster("cluster-01")); err != nil {
+		t.Errorf("RemoveCluster() of removed cluster error = %v", err)
+	}
+	if err := os.Remove(parent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.RemoveCluster(g.cfg.Cluster("cluster-02")); err == nil {
+		t.Error("RemoveCluster() without parent kustomization returned nil error")
+	}
+}
+
+func TestClusterPaths(t *testing.T) {
+	g := tenantTestGenerator(t, false)
+	cluster := g.cfg.Cluster("cluster-01")
+	want := []string{filepath.Join("clusters", "cluster-mgmt", "cluster-01"), filepath.Join("clusters", "cluster-mgmt", "kustomization.yaml")}
+	if paths, err := g.ClusterPaths(cluster); err != nil || !reflect.DeepEqual(paths, want) {
+		t.Errorf("ClusterPaths() = %v, %v, want %v", paths, err, want)
+	}
+
+	cluster.AWS.Identity = "dev"
+	want = append(want, filepath.Join("clusters", "cluster-mgmt", identitiesFile))
+	if paths, err := g.ClusterPaths(cluster); err != nil || !reflect.DeepEqual(paths, want) {
+		t.Errorf("ClusterPaths() with identity = %v, %v, want %v", paths, err, want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	g := &Generator{repoRoot: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(g.repoRoot, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	template := "metadata:\n  name: ${NAME}\n  labels:\n${LABELS}\n    app: $APP\nspec: {}\n"
+	if err := os.WriteFile(filepath.Join(g.repoRoot, "templates", "test.yaml"), []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		want    string
+		wantErr string
+	}{
+		{"optional block", map[string]string{"NAME": "a", "LABELS": "    mesh: main", "APP": "demo"},
+			"metadata:\n  name: a\n  labels:\n    mesh: main\n    app: demo\nspec: {}\n", ""},
+		{"empty optional block is removed", map[string]string{"NAME": "a", "LABELS": "", "APP": "demo"},
+			"metadata:\n  name: a\n  labels:\n    app: demo\nspec: {}\n", ""},
+		{"empty value inline is kept", map[string]string{"NAME": "", "LABELS": "", "APP": "demo"},
+			"metadata:\n  name: \n  labels:\n    app: demo\nspec: {}\n", ""},
+		{"unknown variables", map[string]string{"NAME": "a"}, "", "unknown variables: LABELS, APP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := g.expand("test.yaml", tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expand() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("expand() =\n%s\nwant\n%s", out, tt.want)
+			}
+		})
+	}
+
+	if _, err := g.expand("missing.yaml", nil); err == nil {
+		t.Error("expand() of missing template returned nil error")
+	}
+}
+
+func TestClusterIDLabel(t *testing.T) {
+	g := tenantTestGenerator(t, false)
+	cluster := g.cfg.Cluster("cluster-01")
+	cluster.PodCIDR, cluster.ServiceCIDR = "192.168.16.0/20", "10.96.0.0/12"
+
+	for _, tt := range []struct {
+		mesh config.MeshConfig
+		want string
+	}{
+		{config.MeshConfig{}, `cilium-cluster-id: "0"`},
+		{config.MeshConfig{Label: "main", ClusterID: 7}, `cilium-cluster-id: "7"`},
+	} {
+		cluster.Mesh = tt.mesh
+		vars := g.templateVars(cluster)
+		vars["CNI_INGRESS_RULES"] = ""
+		out, err := g.expand(filepath.Join("aws", "cluster.yaml"), vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), tt.want) {
+			t.Errorf("mesh %+v: want %s in:\n%s", tt.mesh, tt.want, out)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// identitiesFile is generated next to cluster directories of a management cluster, identities are
+// cluster scoped and may be shared by several clusters
+const identitiesFile = "aws-identities.yaml"
+
+var identityKinds = map[string]string{
+	config.AWSIdentityStatic: "AWSClusterStaticIdentity",
+	config.AWSIdentityRole:   "AWSClusterRoleIdentity",
+}
+
+// identityRef returns kind and name of the identity referenced by AWSCluster
+func (g *Generator) identityRef(name string) (string, string) {
+	identity, ok := g.cfg.AWSIdentity(name)
+	if !ok {
+		return "AWSClusterControllerIdentity", "default"
+	}
+	return identityKinds[identity.Type], identity.Name
+}
+
+// generateIdentities writes identities used by clusters of the management cluster, including
+// source identities of role identities. Each identity allows namespaces of all these clusters.
+func (g *Generator) generateIdentities(managementCluster string) error {
+	namespaces := make(map[string][]string)
+	for _, cluster := range g.cfg.Clusters {
+		if cluster.Provider != "aws" || cluster.AWS.Identity == "" || cluster.ManagedBy() != managementCluster {
+			continue
+		}
+		for name := cluster.AWS.Identity; name != ""; {
+			identity, _ := g.cfg.AWSIdentity(name)
+			if contains(namespaces[name], cluster.Name) {
+				break // role identities sourcing each other in a loop
+			}
+			namespaces[name] = append(namespaces[name], cluster.Name)
+			name = identity.SourceIdentity
+		}
+	}
+
+	dir := filepath.Join(g.repoRoot, "clusters", managementCluster)
+	path := filepath.Join(dir, identitiesFile)
+	if len(namespaces) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return removeKustomizationResource(filepath.Join(dir, "kustomization.yaml"), identitiesFile)
+	}
+
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objects []*unstructured.Unstructured
+	for _, name := range names {
+		identity, _ := g.cfg.AWSIdentity(name)
+		sort.Strings(namespaces[name])
+		spec := map[string]interface{}{
+			"allowedNamespaces": map[string]interface{}{"list": toInterfaceSlice(namespaces[name])},
+		}
+
+		switch identity.Type {
+		case config.AWSIdentityStatic:
+			spec["secretRef"] = identity.SecretName()
+		case config.AWSIdentityRole:
+			spec["roleARN"] = identity.RoleARN
+			spec["sessionName"] = identity.Name
+			if identity.ExternalID != "" {
+				spec["externalID"] = identity.ExternalID
+			}
+			kind, sourceName := g.identityRef(identity.SourceIdentity)
+			spec["sourceIdentityRef"] = map[string]interface{}{"kind": kind, "name": sourceName}
+		}
+
+		objects = append(objects, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+			"kind":       identityKinds[identity.Type],
+			"metadata":   map[string]interface{}{"name": identity.Name},
+			"spec":       spec,
+		}})
+	}
+
+	data, err := encodeObjects(objects)
+	if err != nil {
+		return err
+	}
+	g.log.Info("Generating AWS identities", "managementCluster", managementCluster, "identities", names)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return addKustomizationResource(filepath.Join(dir, "kustomization.yaml"), identitiesFile)
+}
+
+// removeKustomizationResource is the reverse of addKustomizationResource
+func removeKustomizationResource(path, resource string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	kustomization := map[string]interface{}{}
+	if err := sigsyaml.Unmarshal(data, &kustomization); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	resources, _ := kustomization["resources"].([]interface{})
+	kept := []interface{}{}
+	for _, r := range resources {
+		if r != resource {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(resources) {
+		return nil
+	}
+	kustomization["resources"] = kept
+
+	out, err := sigsyaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+func toInterfaceSlice(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestGenerateIdentities(t *testing.T) {
+	repoRoot := t.TempDir()
+	dir := filepath.Join(repoRoot, "clusters", "cluster-mgmt")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	kustomization := filepath.Join(dir, "kustomization.yaml")
+	if err := os.WriteFile(kustomization, []byte("resources:\n- cluster-01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		AWSIdentities: []config.AWSIdentityConfig{
+			{Name: "static", Type: config.AWSIdentityStatic, AccessKeyIDEnv: "AWS_ACCESS_KEY_ID", SecretAccessKeyEnv: "AWS_SECRET_ACCESS_KEY"},
+			{Name: "role", Type: config.AWSIdentityRole, RoleARN: "arn:aws:iam::123456789012:role/capa", ExternalID: "demo", SourceIdentity: "static"},
+			{Name: "unused", Type: config.AWSIdentityRole, RoleARN: "arn:aws:iam::123456789012:role/unused"},
+		},
+		Clusters: []config.ClusterConfig{
+			{Name: "cluster-mgmt", Provider: "aws", AWS: config.AWSConfig{Identity: "static"}},
+			{Name: "cluster-01", Provider: "aws", ManagementCluster: "cluster-mgmt", AWS: config.AWSConfig{Identity: "role"}},
+			{Name: "cluster-02", Provider: "aws", ManagementCluster: "cluster-mgmt", AWS: config.AWSConfig{Identity: "static"}},
+			{Name: "cluster-03", Provider: "aws", ManagementCluster: "cluster-mgmt"},
+		},
+	}
+	g := &Generator{log: logr.Discard(), cfg: cfg, repoRoot: repoRoot}
+
+	if err := g.generateIdentities("cluster-mgmt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, identitiesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := decodeObjects(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d identities, want role and static", len(objects))
+	}
+
+	role, static := objects[0], objects[1]
+	if role.GetKind() != "AWSClusterRoleIdentity" || role.GetName() != "role" {
+		t.Errorf("got %s %s, want AWSClusterRoleIdentity role", role.GetKind(), role.GetName())
+	}
+	spec := role.Object["spec"].(map[string]interface{})
+	if spec["roleARN"] != "arn:aws:iam::123456789012:role/capa" || spec["externalID"] != "demo" {
+		t.Errorf("unexpected role identity spec: %v", spec)
+	}
+	wantSource := map[string]interface{}{"kind": "AWSClusterStaticIdentity", "name": "static"}
+	if !reflect.DeepEqual(spec["sourceIdentityRef"], wantSource) {
+		t.Errorf("sourceIdentityRef = %v, want %v", spec["sourceIdentityRef"], wantSource)
+	}
+	if got := spec["allowedNamespaces"]; !reflect.DeepEqual(got, map[string]interface{}{"list": []interface{}{"cluster-01"}}) {
+		t.Errorf("role allowedNamespaces = %v, want cluster-01", got)
+	}
+
+	// static identity is used directly by cluster-02 and as the source of cluster-01 role,
+	// cluster-mgmt is managed by the kind cluster and is not in its namespaces
+	spec = static.Object["spec"].(map[string]interface{})
+	if spec["secretRef"] != "static-credentials" {
+		t.Errorf("secretRef = %v, want static-credentials", spec["secretRef"])
+	}
+	if got := spec["allowedNamespaces"]; !reflect.DeepEqual(got, map[string]interface{}{"list": []interface{}{"cluster-01", "cluster-02"}}) {
+		t.Errorf("static allowedNamespaces = %v, want cluster-01 and cluster-02", got)
+	}
+
+	out, err := os.ReadFile(kustomization)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "- "+identitiesFile) {
+		t.Errorf("%s is not added to kustomization:\n%s", identitiesFile, out)
+	}
+
+	// identities are removed when no cluster uses them
+	cfg.Clusters = cfg.Clusters[3:]
+	if err := g.generateIdentities("cluster-mgmt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, identitiesFile)); !os.IsNotExist(err) {
+		t.Errorf("%s is not removed: %v", identitiesFile, err)
+	}
+	out, err = os.ReadFile(kustomization)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), identitiesFile) {
+		t.Errorf("%s is not removed from kustomization:\n%s", identitiesFile, out)
+	}
+}
+
+func TestIdentityRef(t *testing.T) {
+	g := &Generator{cfg: &config.Config{AWSIdentities: []config.AWSIdentityConfig{{Name: "static", Type: config.AWSIdentityStatic}}}}
+
+	tests := []struct {
+		identity string
+		kind     string
+		name     string
+	}{
+		{"static", "AWSClusterStaticIdentity", "static"},
+		{"", "AWSClusterControllerIdentity", "default"},
+	}
+	for _, tt := range tests {
+		kind, name := g.identityRef(tt.identity)
+		if kind != tt.kind || name != tt.name {
+			t.Errorf("identityRef(%q) = %s %s, want %s %s", tt.identity, kind, name, tt.kind, tt.name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/mirror"
+)
+
+// kustomizationImages renders Kustomization spec.images which point the images of the manifests files
+// at the mirror registry. It is empty when the registry is not set, so the template line is removed.
+func (g *Generator) kustomizationImages(manifestFiles ...string) (string, error) {
+	if g.cfg.Mirror.Registry == "" {
+		return "", nil
+	}
+	overrides, err := mirror.ImageOverrides(g.cfg.Mirror.Registry, manifestFiles...)
+	if err != nil {
+		return "", err
+	}
+	if len(overrides) == 0 {
+		return "", nil
+	}
+	var b strings.Builder
+	b.WriteString("  images:\n")
+	for _, override := range overrides {
+		fmt.Fprintf(&b, "  - name: %s\n    newName: %s\n", override.Name, override.NewName)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// fluxComponents returns gotk-components.yaml of the vendored Flux version
+func (g *Generator) fluxComponents(version string) string {
+	return filepath.Join(g.repoRoot, "k8s-platform", "flux", "v"+version, "gotk-components.yaml")
+}
+
+// mirrorHelmChartProxy points the HelmChartProxy at the chart pushed to the mirror registry and
+// overrides images of the chart with their mirrored names. Images overrides are merged over the
+// values of the HelmChartProxy, so that the images of the chart are always pulled from the mirror.
+func (g *Generator) mirrorHelmChartProxy(hcp *unstructured.Unstructured) error {
+	if g.cfg.Mirror.Registry == "" {
+		return nil
+	}
+	name, _, _ := unstructured.NestedString(hcp.Object, "spec", "chartName")
+	version, _, _ := unstructured.NestedString(hcp.Object, "spec", "version")
+	chartPath := mirror.ChartPath(g.cfg.Mirror.Path, name, version)
+	if _, err := os.Stat(chartPath); err != nil {
+		return fmt.Errorf("chart %s %s is not mirrored, run `mirror` command first: %w", name, version, err)
+	}
+	values, err := mirror.ChartImageValues(chartPath, g.cfg.Mirror.Registry)
+	if err != nil {
+		return err
+	}
+	out, err := sigsyaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	valuesTemplate, _, _ := unstructured.NestedString(hcp.Object, "spec", "valuesTemplate")
+	merged, err := mergeValuesTemplate(valuesTemplate, string(out))
+	if err != nil {
+		return fmt.Errorf("failed to merge mirror image values of chart %s: %w", name, err)
+	}
+	if err := unstructured.SetNestedField(hcp.Object, merged, "spec", "valuesTemplate"); err != nil {
+		return err
+	}
+	return unstructured.SetNestedField(hcp.Object, mirror.ChartRepository(g.cfg.Mirror.Registry), "spec", "repoURL")
+}
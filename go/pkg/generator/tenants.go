@@ -0,0 +1,280 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// Tenant is a team which deploys its apps from its own repository. Tenant manifests are generated into
+// tenants/base/<name> (namespace, service account, role binding, Flux sync objects and guardrails: resource
+// quota, limit range and default deny network policy sized from config.TenantConfig) and
+// tenants/<environment>/<name> (overlay which sets the path in the tenant repository). Each selected
+// cluster gets a Flux Kustomization which applies the overlay on the cluster from its management cluster.
+type Tenant struct {
+	Name        string
+	Namespace   string
+	RepoURL     string
+	Branch      string
+	Path        string
+	Environment string
+}
+
+const tenantsDir = "tenants"
+
+// ClusterTenant is a tenant added to a cluster. GitSecret is the secret with the SSH key of the
+// tenant repository in the tenant namespace, empty if the repository is not pulled over SSH.
+type ClusterTenant struct {
+	Name      string
+	Namespace string
+	GitSecret string
+}
+
+// tenantGitSecret returns name of the secret with the SSH key of the tenant repository
+func tenantGitSecret(name string) string {
+	return name + "-git"
+}
+
+// clusterTenantFile is the file of the Flux Kustomization of the tenant in the cluster directory
+func clusterTenantFile(name string) string {
+	return "tenant-" + name + ".yaml"
+}
+
+// AddTenant generates tenant manifests and wires the tenant into the clusters. It can be run again
+// to update the tenant or to add it to more clusters, clusters wired previously are not removed.
+func (g *Generator) AddTenant(tenant Tenant, clusters []*config.ClusterConfig) error {
+	if errs := validation.IsDNS1123Label(tenant.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid tenant name %q: %s", tenant.Name, strings.Join(errs, ", "))
+	}
+	if tenant.Environment == "base" {
+		return fmt.Errorf("environment can't be named base")
+	}
+	if tenant.RepoURL == "" {
+		return fmt.Errorf("tenant repository URL must be set")
+	}
+	gitSecretRef := ""
+	if strings.HasPrefix(tenant.RepoURL, "ssh://") {
+		if g.cfg.Tenant(tenant.Name).KeySource.Type == "" {
+			return fmt.Errorf("tenant %s repository is pulled over SSH, set keySource of the tenant in config", tenant.Name)
+		}
+		gitSecretRef = "  secretRef:\n    name: " + tenantGitSecret(tenant.Name)
+	}
+
+	// default deny policy of the tenant namespace is a CiliumNetworkPolicy
+	for _, cluster := range clusters {
+		if cluster.CNI.Type != config.CNICilium {
+			return fmt.Errorf("cluster %s: tenants require cilium CNI, got %s", cluster.Name, cluster.CNI.Type)
+		}
+	}
+
+	sizing := g.cfg.Tenant(tenant.Name)
+	vars := map[string]string{
+		"TENANT_NAME":           tenant.Name,
+		"TENANT_NAMESPACE":      tenant.Namespace,
+		"TENANT_REPO_URL":       tenant.RepoURL,
+		"TENANT_GIT_SECRET_REF": gitSecretRef,
+		"TENANT_BRANCH":         tenant.Branch,
+		"TENANT_PATH":           tenant.Path,
+		"TENANT_ENV":            tenant.Environment,
+		"TENANT_QUOTA_CPU":      sizing.Quota.CPU,
+		"TENANT_QUOTA_MEMORY":   sizing.Quota.Memory,
+		"TENANT_QUOTA_PODS":     sizing.Quota.Pods,
+		"TENANT_LIMIT_CPU":      sizing.Limits.DefaultCPU,
+		"TENANT_LIMIT_MEMORY":   sizing.Limits.DefaultMemory,
+		"TENANT_REQUEST_CPU":    sizing.Limits.DefaultRequestCPU,
+		"TENANT_REQUEST_MEMORY": sizing.Limits.DefaultRequestMemory,
+		// tenant Kustomization is reconciled by Flux on the workload cluster, the decryption secret
+		// is created in the tenant namespace there, see ClusterTenants
+		"TENANT_DECRYPTION": decryption(g.cfg.SOPS.Enabled),
+	}
+
+	baseDir := filepath.Join(g.repoRoot, tenantsDir, "base", tenant.Name)
+	overlayDir := filepath.Join(g.repoRoot, tenantsDir, tenant.Environment, tenant.Name)
+	files := map[string]string{
+		filepath.Join(baseDir, "kustomization.yaml"):    filepath.Join("tenant", "base", "kustomization.yaml"),
+		filepath.Join(baseDir, "rbac.yaml"):             filepath.Join("tenant", "base", "rbac.yaml"),
+		filepath.Join(baseDir, "sync.yaml"):             filepath.Join("tenant", "base", "sync.yaml"),
+		filepath.Join(baseDir, "guardrails.yaml"):       filepath.Join("tenant", "base", "guardrails.yaml"),
+		filepath.Join(overlayDir, "kustomization.yaml"): filepath.Join("tenant", "overlay", "kustomization.yaml"),
+		filepath.Join(overlayDir, "patch.yaml"):         filepath.Join("tenant", "overlay", "patch.yaml"),
+	}
+
+	g.log.Info("Generating tenant manifests", "tenant", tenant.Name, "environment", tenant.Environment)
+	for path, template := range files {
+		if err := g.writeTemplate(path, template, vars); err != nil {
+			return err
+		}
+	}
+
+	// environment kustomization includes all tenants of the environment
+	envKustomization := filepath.Join(g.repoRoot, tenantsDir, tenant.Environment, "kustomization.yaml")
+	if err := ensureKustomization(envKustomization); err != nil {
+		return err
+	}
+	if err := addKustomizationResource(envKustomization, tenant.Name); err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		vars["CLUSTER_NAME"] = cluster.Name
+		vars["FLUX_DECRYPTION"] = fluxDecryption(cluster)
+
+		dir := g.ClusterDir(cluster)
+		if _, err := os.Stat(filepath.Join(dir, "kustomization.yaml")); err != nil {
+			return fmt.Errorf("cluster %s manifests are not generated, run `generate %s` first", cluster.Name, cluster.Name)
+		}
+		g.log.Info("Adding tenant to cluster", "tenant", tenant.Name, "cluster", cluster.Name)
+		if err := g.writeTemplate(filepath.Join(dir, clusterTenantFile(tenant.Name)), filepath.Join("tenant", "cluster.yaml"), vars); err != nil {
+			return err
+		}
+		if err := addKustomizationResource(filepath.Join(dir, "kustomization.yaml"), clusterTenantFile(tenant.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TenantPlacement returns namespace of the tenant and names of the clusters it is added to
+func (g *Generator) TenantPlacement(name string) (string, []string, error) {
+	path := filepath.Join(g.repoRoot, tenantsDir, "base", name, "rbac.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("tenant %s not found: %w", name, err)
+	}
+	objects, err := decodeObjects(data)
+	if err != nil {
+		return "", nil, err
+	}
+	namespace := ""
+	for _, obj := range objects {
+		if obj.GetKind() == "Namespace" {
+			namespace = obj.GetName()
+		}
+	}
+	if namespace == "" {
+		return "", nil, fmt.Errorf("tenant %s: namespace not found in %s", name, path)
+	}
+
+	clusterFiles, err := filepath.Glob(filepath.Join(g.repoRoot, "clusters", "*", "*", clusterTenantFile(name)))
+	if err != nil {
+		return "", nil, err
+	}
+	var clusters []string
+	for _, path := range clusterFiles {
+		clusters = append(clusters, filepath.Base(filepath.Dir(path)))
+	}
+	return namespace, clusters, nil
+}
+
+// ClusterTenants returns the tenants added to the cluster
+func (g *Generator) ClusterTenants(cluster *config.ClusterConfig) ([]ClusterTenant, error) {
+	files, err := filepath.Glob(filepath.Join(g.ClusterDir(cluster), clusterTenantFile("*")))
+	if err != nil {
+		return nil, err
+	}
+	var tenants []ClusterTenant
+	for _, path := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "tenant-"), ".yaml")
+		namespace, _, err := g.TenantPlacement(name)
+		if err != nil {
+			return nil, err
+		}
+		gitSecret, err := g.tenantGitSecretRef(name)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, ClusterTenant{Name: name, Namespace: namespace, GitSecret: gitSecret})
+	}
+	return tenants, nil
+}
+
+// tenantGitSecretRef returns the secret referenced by the GitRepository of the tenant
+func (g *Generator) tenantGitSecretRef(name string) (string, error) {
+	path := filepath.Join(g.repoRoot, tenantsDir, "base", name, "sync.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("tenant %s not found: %w", name, err)
+	}
+	objects, err := decodeObjects(data)
+	if err != nil {
+		return "", err
+	}
+	for _, obj := range objects {
+		if obj.GetKind() == "GitRepository" {
+			secret, _, _ := unstructured.NestedString(obj.Object, "spec", "secretRef", "name")
+			return secret, nil
+		}
+	}
+	return "", nil
+}
+
+// RemoveTenant removes the tenant from all clusters and environments and deletes its manifests
+func (g *Generator) RemoveTenant(name string) error {
+	clusterFiles, err := filepath.Glob(filepath.Join(g.repoRoot, "clusters", "*", "*", clusterTenantFile(name)))
+	if err != nil {
+		return err
+	}
+	for _, path := range clusterFiles {
+		g.log.Info("Removing tenant from cluster", "tenant", name, "cluster", filepath.Base(filepath.Dir(path)))
+		if err := removeKustomizationResource(filepath.Join(filepath.Dir(path), "kustomization.yaml"), filepath.Base(path)); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	overlays, err := filepath.Glob(filepath.Join(g.repoRoot, tenantsDir, "*", name))
+	if err != nil {
+		return err
+	}
+	if len(overlays) == 0 {
+		return fmt.Errorf("tenant %s not found in %s", name, tenantsDir)
+	}
+	for _, dir := range overlays {
+		envDir := filepath.Dir(dir)
+		if filepath.Base(envDir) != "base" {
+			if err := removeKustomizationResource(filepath.Join(envDir, "kustomization.yaml"), name); err != nil {
+				return err
+			}
+		}
+		g.log.Info("Removing tenant manifests", "tenant", name, "path", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// writeTemplate expands the template and writes it, creating the directory if needed
+func (g *Generator) writeTemplate(path, template string, vars map[string]string) error {
+	data, err := g.expand(template, vars)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// ensureKustomization creates an empty kustomization if it doesn't exist
+func ensureKustomization(path string) error {
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	data := []byte("apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources: []\n")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,230 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// tenantTestGenerator returns a generator over a temporary repo with templates/ of this repo
+// and generated directories of cluster-01 and cluster-02
+func tenantTestGenerator(t *testing.T, sops bool) *Generator {
+	t.Helper()
+	repoRoot := t.TempDir()
+	templates, err := filepath.Abs(filepath.Join("..", "..", "..", "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(templates, filepath.Join(repoRoot, "templates")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		SOPS: config.SOPSConfig{Enabled: sops},
+		Clusters: []config.ClusterConfig{
+			{Name: "cluster-mgmt", Provider: "aws", CNI: config.CNIConfig{Type: config.CNICilium}},
+			{Name: "cluster-01", Provider: "aws", ManagementCluster: "cluster-mgmt", CNI: config.CNIConfig{Type: config.CNICilium}, Flux: config.FluxConfig{Decryption: sops}},
+			{Name: "cluster-02", Provider: "aws", ManagementCluster: "cluster-mgmt", CNI: config.CNIConfig{Type: config.CNICilium}, Flux: config.FluxConfig{Decryption: sops}},
+		},
+	}
+	g := &Generator{log: logr.Discard(), cfg: cfg, repoRoot: repoRoot}
+	for _, name := range []string{"cluster-01", "cluster-02"} {
+		dir := g.ClusterDir(cfg.Cluster(name))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources:\n- platform.yaml\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+func TestTenantDecryption(t *testing.T) {
+	for _, sops := range []bool{false, true} {
+		g := tenantTestGenerator(t, sops)
+		tenant := Tenant{Name: "team-1", Namespace: "team-1-apps", RepoURL: "https://github.com/olga-mir/team-1", Branch: "main", Path: "./", Environment: "dev"}
+		if err := g.AddTenant(tenant, []*config.ClusterConfig{g.cfg.Cluster("cluster-01")}); err != nil {
+			t.Fatal(err)
+		}
+
+		// tenant Kustomization on the workload cluster and its Kustomization on the management cluster
+		for _, path := range []string{
+			filepath.Join(g.repoRoot, "tenants", "base", "team-1", "sync.yaml"),
+			filepath.Join(g.ClusterDir(g.cfg.Cluster("cluster-01")), "tenant-team-1.yaml"),
+		} {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(data), "name: "+config.SOPSSecretName); got != sops {
+				t.Errorf("sops %v: decryption in %s = %v:\n%s", sops, filepath.Base(path), got, data)
+			}
+			if _, err := decodeObjects(data); err != nil {
+				t.Errorf("%s is not valid YAML: %v", filepath.Base(path), err)
+			}
+		}
+
+		tenants, err := g.ClusterTenants(g.cfg.Cluster("cluster-01"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []ClusterTenant{{Name: "team-1", Namespace: "team-1-apps"}}
+		if !reflect.DeepEqual(tenants, want) {
+			t.Errorf("ClusterTenants(cluster-01) = %v, want %v", tenants, want)
+		}
+		tenants, err = g.ClusterTenants(g.cfg.Cluster("cluster-02"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tenants) != 0 {
+			t.Errorf("ClusterTenants(cluster-02) = %v, want none", tenants)
+		}
+	}
+}
+
+func TestAddTenantValidation(t *testing.T) {
+	valid := Tenant{Name: "team-1", Namespace: "team-1-apps", RepoURL: "https://github.com/olga-mir/team-1", Branch: "main", Path: "./", Environment: "dev"}
+
+	tests := []struct {
+		name    string
+		modify  func(*Tenant)
+		cluster string
+	}{
+		{"invalid name", func(tn *Tenant) { tn.Name = "Team_1" }, "cluster-01"},
+		{"base environment", func(tn *Tenant) { tn.Environment = "base" }, "cluster-01"},
+		{"no repository", func(tn *Tenant) { tn.RepoURL = "" }, "cluster-01"},
+		{"ssh without key source", func(tn *Tenant) { tn.RepoURL = "ssh://git@github.com/olga-mir/team-1" }, "cluster-01"},
+		{"not cilium", func(tn *Tenant) {}, "cluster-calico"},
+		{"not generated", func(tn *Tenant) {}, "cluster-02-missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tenantTestGenerator(t, false)
+			g.cfg.Clusters = append(g.cfg.Clusters,
+				config.ClusterConfig{Name: "cluster-calico", Provider: "aws", ManagementCluster: "cluster-mgmt", CNI: config.CNIConfig{Type: config.CNICalico}},
+				config.ClusterConfig{Name: "cluster-02-missing", Provider: "aws", ManagementCluster: "cluster-mgmt", CNI: config.CNIConfig{Type: config.CNICilium}},
+			)
+			tenant := valid
+			tt.modify(&tenant)
+			if err := g.AddTenant(tenant, []*config.ClusterConfig{g.cfg.Cluster(tt.cluster)}); err == nil {
+				t.Errorf("AddTenant() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAddTenant(t *testing.T) {
+	g := tenantTestGenerator(t, false)
+	g.cfg.Tenants = []config.TenantConfig{{Name: "team-1", KeySource: config.SecretSourceConfig{Type: "file", Path: "/tmp/key"}}}
+	tenant := Tenant{Name: "team-1", Namespace: "apps", RepoURL: "ssh://git@github.com/olga-mir/team-1", Branch: "main", Path: "./k8s", Environment: "dev"}
+	clusters := []*config.ClusterConfig{g.cfg.Cluster("cluster-01"), g.cfg.Cluster("cluster-02")}
+	if err := g.AddTenant(tenant, clusters); err != nil {
+		t.Fatal(err)
+	}
+	// adding it again doesn't duplicate kustomization entries
+	if err := g.AddTenant(tenant, clusters[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"kustomization.yaml", "rbac.yaml", "sync.yaml", "guardrails.yaml"} {
+		if _, err := os.Stat(filepath.Join(g.repoRoot, "tenants", "base", "team-1", name)); err != nil {
+			t.Errorf("base manifest is not generated: %v", err)
+		}
+	}
+	for _, name := range []string{"kustomization.yaml", "patch.yaml"} {
+		if _, err := os.Stat(filepath.Join(g.repoRoot, "tenants", "dev", "team-1", name)); err != nil {
+			t.Errorf("overlay manifest is not generated: %v", err)
+		}
+	}
+
+	resources := readResources(t, filepath.Join(g.repoRoot, "tenants", "dev", "kustomization.yaml"))
+	if !reflect.DeepEqual(resources, []string{"team-1"}) {
+		t.Errorf("dev kustomization resources = %v, want [team-1]", resources)
+	}
+	for _, cluster := range clusters {
+		resources := readResources(t, filepath.Join(g.ClusterDir(cluster), "kustomization.yaml"))
+		if !reflect.DeepEqual(resources, []string{"platform.yaml", "tenant-team-1.yaml"}) {
+			t.Errorf("%s kustomization resources = %v, want [platform.yaml tenant-team-1.yaml]", cluster.Name, resources)
+		}
+	}
+
+	namespace, placement, err := g.TenantPlacement("team-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespace != "apps" || !reflect.DeepEqual(placement, []string{"cluster-01", "cluster-02"}) {
+		t.Errorf("TenantPlacement(team-1) = %s, %v, want apps, [cluster-01 cluster-02]", namespace, placement)
+	}
+
+	// repository pulled over SSH references the key secret created by CreateSecrets
+	tenants, err := g.ClusterTenants(g.cfg.Cluster("cluster-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ClusterTenant{{Name: "team-1", Namespace: "apps", GitSecret: "team-1-git"}}
+	if !reflect.DeepEqual(tenants, want) {
+		t.Errorf("ClusterTenants(cluster-01) = %v, want %v", tenants, want)
+	}
+}
+
+func TestRemoveTenant(t *testing.T) {
+	g := tenantTestGenerator(t, false)
+	clusters := []*config.ClusterConfig{g.cfg.Cluster("cluster-01"), g.cfg.Cluster("cluster-02")}
+	for _, name := range []string{"team-1", "team-2"} {
+		tenant := Tenant{Name: name, Namespace: name, RepoURL: "https://github.com/olga-mir/" + name, Branch: "main", Path: "./", Environment: "dev"}
+		if err := g.AddTenant(tenant, clusters); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := g.RemoveTenant("team-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"base", "dev"} {
+		if _, err := os.Stat(filepath.Join(g.repoRoot, "tenants", dir, "team-1")); !os.IsNotExist(err) {
+			t.Errorf("tenants/%s/team-1 is not removed: %v", dir, err)
+		}
+	}
+	resources := readResources(t, filepath.Join(g.repoRoot, "tenants", "dev", "kustomization.yaml"))
+	if !reflect.DeepEqual(resources, []string{"team-2"}) {
+		t.Errorf("dev kustomization resources = %v, want [team-2]", resources)
+	}
+	for _, cluster := range clusters {
+		if _, err := os.Stat(filepath.Join(g.ClusterDir(cluster), "tenant-team-1.yaml")); !os.IsNotExist(err) {
+			t.Errorf("%s tenant file is not removed: %v", cluster.Name, err)
+		}
+		resources := readResources(t, filepath.Join(g.ClusterDir(cluster), "kustomization.yaml"))
+		if !reflect.DeepEqual(resources, []string{"platform.yaml", "tenant-team-2.yaml"}) {
+			t.Errorf("%s kustomization resources = %v, want [platform.yaml tenant-team-2.yaml]", cluster.Name, resources)
+		}
+	}
+
+	if err := g.RemoveTenant("team-1"); err == nil {
+		t.Errorf("RemoveTenant() of removed tenant error = nil, want error")
+	}
+}
+
+// readResources returns resources of the kustomization
+func readResources(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kustomization struct {
+		Resources []string `json:"resources"`
+	}
+	if err := sigsyaml.Unmarshal(data, &kustomization); err != nil {
+		t.Fatal(err)
+	}
+	return kustomization.Resources
+}
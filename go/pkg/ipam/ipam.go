@@ -0,0 +1,331 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// Network is the pod and service CIDRs of a cluster
+type Network struct {
+	PodCIDR     string `json:"podCIDR"`
+	ServiceCIDR string `json:"serviceCIDR"`
+}
+
+// state is persisted in IPAMConfig.StatePath. Clusters which are removed from config keep
+// their allocations until they are released, so that their ranges are not handed out while
+// the clusters may still exist.
+type state struct {
+	Clusters map[string]Network `json:"clusters"`
+}
+
+// Allocator assigns pod and service CIDRs to clusters which don't set them in config.
+// Pod CIDRs of all clusters must be distinct (Cilium Cluster Mesh routes pod traffic between
+// clusters), service CIDRs allocated from the pool are distinct too, but service CIDRs set
+// explicitly may be shared because they are not routed outside of the cluster.
+type Allocator struct {
+	log   logr.Logger
+	cfg   config.IPAMConfig
+	state state
+}
+
+func NewAllocator(log logr.Logger, cfg config.IPAMConfig) (*Allocator, error) {
+	a := &Allocator{log: log, cfg: cfg, state: state{Clusters: map[string]Network{}}}
+
+	data, err := os.ReadFile(cfg.StatePath)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IPAM state: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.state); err != nil {
+		return nil, fmt.Errorf("failed to parse IPAM state %s: %w", cfg.StatePath, err)
+	}
+	if a.state.Clusters == nil {
+		a.state.Clusters = map[string]Network{}
+	}
+	return a, nil
+}
+
+// Assign sets PodCIDR and ServiceCIDR of the clusters which leave them blank and saves the allocations.
+// CIDR of a cluster is taken, in order of preference, from config, previous allocation, its live
+// CAPI Cluster or the pool. live are networks of CAPI Clusters on management clusters by cluster name,
+// a cluster which is already running can't change its CIDRs, and other clusters must not collide with it.
+func (a *Allocator) Assign(clusters []config.ClusterConfig, live map[string]Network) error {
+	// networks of all known clusters, including removed from config and not managed by this config
+	networks := make(map[string]*Network)
+	for name, network := range a.state.Clusters {
+		n := network
+		networks[name] = &n
+	}
+	for name, network := range live {
+		if _, ok := networks[name]; !ok {
+			n := network
+			networks[name] = &n
+		}
+	}
+
+	var names []string
+	for i := range clusters {
+		cluster := &clusters[i]
+		if cluster.Provider == "kind" {
+			continue
+		}
+		names = append(names, cluster.Name)
+
+		network := Network{}
+		if previous, ok := networks[cluster.Name]; ok {
+			network = *previous
+		}
+		if cluster.PodCIDR != "" {
+			network.PodCIDR = cluster.PodCIDR
+		}
+		if cluster.ServiceCIDR != "" {
+			network.ServiceCIDR = cluster.ServiceCIDR
+		}
+		if network.ServiceCIDR == "" && a.cfg.ServiceCIDRPool == "" {
+			network.ServiceCIDR = config.DefaultServiceCIDR
+		}
+
+		if running, ok := live[cluster.Name]; ok {
+			if network.PodCIDR != "" && running.PodCIDR != "" && network.PodCIDR != running.PodCIDR {
+				return fmt.Errorf("cluster %s is running with pod CIDR %s, it can't be changed to %s", cluster.Name, running.PodCIDR, network.PodCIDR)
+			}
+			if network.ServiceCIDR != "" && running.ServiceCIDR != "" && network.ServiceCIDR != running.ServiceCIDR {
+				return fmt.Errorf("cluster %s is running with service CIDR %s, it can't be changed to %s", cluster.Name, running.ServiceCIDR, network.ServiceCIDR)
+			}
+		}
+		networks[cluster.Name] = &network
+	}
+
+	if err := checkCollisions(networks); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		network := networks[name]
+		var err error
+		if network.PodCIDR == "" {
+			if network.PodCIDR, err = allocate(a.cfg.PodCIDRPool, a.cfg.PodPrefixLength, usedPrefixes(networks, false)); err != nil {
+				return fmt.Errorf("cluster %s: failed to allocate pod CIDR: %w", name, err)
+			}
+			a.log.Info("Allocated pod CIDR", "cluster", name, "cidr", network.PodCIDR)
+		}
+		if network.ServiceCIDR == "" {
+			if network.ServiceCIDR, err = allocate(a.cfg.ServiceCIDRPool, a.cfg.ServicePrefixLength, usedPrefixes(networks, true)); err != nil {
+				return fmt.Errorf("cluster %s: failed to allocate service CIDR: %w", name, err)
+			}
+			a.log.Info("Allocated service CIDR", "cluster", name, "cidr", network.ServiceCIDR)
+		}
+	}
+
+	for i := range clusters {
+		if network, ok := networks[clusters[i].Name]; ok {
+			clusters[i].PodCIDR = network.PodCIDR
+			clusters[i].ServiceCIDR = network.ServiceCIDR
+			a.state.Clusters[clusters[i].Name] = *network
+		}
+	}
+	return a.save()
+}
+
+// Release forgets allocations of a cluster, its ranges can be allocated to other clusters
+func (a *Allocator) Release(name string) error {
+	if _, ok := a.state.Clusters[name]; !ok {
+		return fmt.Errorf("cluster %s has no allocations", name)
+	}
+	delete(a.state.Clusters, name)
+	return a.save()
+}
+
+// Allocations returns all allocations by cluster name
+func (a *Allocator) Allocations() map[string]Network {
+	return a.state.Clusters
+}
+
+func (a *Allocator) save() error {
+	data, err := json.MarshalIndent(a.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.cfg.StatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create IPAM state directory: %w", err)
+	}
+	return os.WriteFile(a.cfg.StatePath, data, 0644)
+}
+
+// checkCollisions checks that pod CIDR of each cluster does not overlap pod or service CIDRs of any
+// cluster, and the cluster own service CIDR
+func checkCollisions(networks map[string]*Network) error {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if networks[name].PodCIDR == "" {
+			continue
+		}
+		pod, err := netip.ParsePrefix(networks[name].PodCIDR)
+		if err != nil {
+			return fmt.Errorf("cluster %s: invalid pod CIDR: %w", name, err)
+		}
+		for _, other := range names {
+			for _, cidr := range []string{networks[other].PodCIDR, networks[other].ServiceCIDR} {
+				if cidr == "" || (other == name && cidr == networks[name].PodCIDR) {
+					continue
+				}
+				prefix, err := netip.ParsePrefix(cidr)
+				if err != nil {
+					return fmt.Errorf("cluster %s: invalid CIDR: %w", other, err)
+				}
+				if pod.Overlaps(prefix) {
+					return fmt.Errorf("pod CIDR %s of cluster %s overlaps %s of cluster %s", pod, name, prefix, other)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// usedPrefixes returns pod and service CIDRs of all clusters. For service CIDR allocation the default
+// service CIDR is left out: it is given to clusters when there is no service pool, and clusters
+// may share it, so it must not exhaust a pool which includes it, e.g. 10.96.0.0/12 itself.
+func usedPrefixes(networks map[string]*Network, excludeDefaultServiceCIDR bool) []netip.Prefix {
+	var used []netip.Prefix
+	for _, network := range networks {
+		for _, cidr := range []string{network.PodCIDR, network.ServiceCIDR} {
+			if excludeDefaultServiceCIDR && cidr == network.ServiceCIDR && cidr == config.DefaultServiceCIDR {
+				continue
+			}
+			if prefix, err := netip.ParsePrefix(cidr); err == nil {
+				used = append(used, prefix)
+			}
+		}
+	}
+	return used
+}
+
+// allocate returns the first subnet of the pool with the given prefix length which does not
+// overlap any of the used prefixes. Only IPv4 pools are supported.
+func allocate(pool string, prefixLength int, used []netip.Prefix) (string, error) {
+	if pool == "" {
+		return "", fmt.Errorf("CIDR is not set in config and IPAM pool is not configured")
+	}
+	poolPrefix, err := netip.ParsePrefix(pool)
+	if err != nil {
+		return "", fmt.Errorf("invalid pool %s: %w", pool, err)
+	}
+	poolPrefix = poolPrefix.Masked()
+	if !poolPrefix.Addr().Is4() {
+		return "", fmt.Errorf("pool %s: only IPv4 pools are supported", pool)
+	}
+	if prefixLength < poolPrefix.Bits() || prefixLength > 32 {
+		return "", fmt.Errorf("prefix length /%d does not fit in pool %s", prefixLength, pool)
+	}
+
+	start := ipv4ToUint(poolPrefix.Addr())
+	size := uint64(1) << (32 - prefixLength)
+	count := uint64(1) << (prefixLength - poolPrefix.Bits())
+	for i := uint64(0); i < count; i++ {
+		candidate := netip.PrefixFrom(uintToIPv4(uint32(uint64(start)+i*size)), prefixLength)
+		free := true
+		for _, u := range used {
+			if candidate.Overlaps(u) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("pool %s has no free /%d subnets", pool, prefixLength)
+}
+
+func ipv4ToUint(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func uintToIPv4(v uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+// AssignCIDRs fills in blank pod and service CIDRs of clusters in cfg. CAPI Clusters are read from
+// management clusters which are reachable, the ones which are not deployed yet are skipped.
+func AssignCIDRs(log logr.Logger, cfg *config.Config) (*Allocator, error) {
+	allocator, err := NewAllocator(log, cfg.IPAM)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]Network)
+	for _, name := range cfg.ManagementClusterNames() {
+		clusterAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, name)
+		if err != nil {
+			log.Info("Skipping live CIDR check, cluster is not available", "cluster", name, "reason", err.Error())
+			continue
+		}
+		networks, err := LiveNetworks(clusterAuth.Config)
+		if err != nil {
+			log.Info("Skipping live CIDR check, cluster is not available", "cluster", name, "reason", err.Error())
+			continue
+		}
+		for cluster, network := range networks {
+			live[cluster] = network
+		}
+	}
+
+	if err := allocator.Assign(cfg.Clusters, live); err != nil {
+		return nil, err
+	}
+	return allocator, nil
+}
+
+// LiveNetworks returns networks of CAPI Clusters on a management cluster by cluster name
+func LiveNetworks(restConfig *rest.Config) (map[string]Network, error) {
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	client, err := runtimeclient.New(restConfig, runtimeclient.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	clusters := &clusterv1.ClusterList{}
+	if err := client.List(context.TODO(), clusters); err != nil {
+		return nil, fmt.Errorf("failed to list CAPI clusters: %w", err)
+	}
+
+	networks := make(map[string]Network)
+	for _, cluster := range clusters.Items {
+		network := Network{}
+		if n := cluster.Spec.ClusterNetwork; n != nil {
+			if n.Pods != nil && len(n.Pods.CIDRBlocks) > 0 {
+				network.PodCIDR = n.Pods.CIDRBlocks[0]
+			}
+			if n.Services != nil && len(n.Services.CIDRBlocks) > 0 {
+				network.ServiceCIDR = n.Services.CIDRBlocks[0]
+			}
+		}
+		networks[cluster.Name] = network
+	}
+	return networks, nil
+}
@@ -0,0 +1,132 @@
+package ipam
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestAllocate(t *testing.T) {
+	used := []netip.Prefix{
+		netip.MustParsePrefix("192.168.0.0/20"),
+		netip.MustParsePrefix("192.168.32.0/19"),
+	}
+
+	tests := []struct {
+		name    string
+		pool    string
+		prefix  int
+		want    string
+		wantErr bool
+	}{
+		{"first free", "192.168.0.0/16", 20, "192.168.16.0/20", false},
+		{"larger subnets", "192.168.0.0/17", 19, "192.168.64.0/19", false},
+		{"pool exhausted", "192.168.0.0/19", 19, "", true},
+		{"prefix shorter than pool", "192.168.0.0/16", 12, "", true},
+		{"no pool", "", 20, "", true},
+		{"ipv6", "fd00::/48", 64, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := allocate(tt.pool, tt.prefix, used)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("allocate(%q, %d) error = %v, wantErr %v", tt.pool, tt.prefix, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("allocate(%q, %d) = %q, want %q", tt.pool, tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssign(t *testing.T) {
+	cfg := config.IPAMConfig{
+		PodCIDRPool:     "192.168.0.0/16",
+		PodPrefixLength: 20,
+		StatePath:       filepath.Join(t.TempDir(), "ipam.json"),
+	}
+
+	a, err := NewAllocator(logr.Discard(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := []config.ClusterConfig{
+		{Name: "cluster-mgmt", PodCIDR: "192.168.0.0/20"},
+		{Name: "cluster-01"},
+		{Name: "cluster-02"},
+	}
+	live := map[string]Network{"other": {PodCIDR: "192.168.16.0/20"}}
+	if err := a.Assign(clusters, live); err != nil {
+		t.Fatal(err)
+	}
+	if clusters[1].PodCIDR != "192.168.32.0/20" || clusters[2].PodCIDR != "192.168.48.0/20" {
+		t.Errorf("unexpected allocations %s, %s", clusters[1].PodCIDR, clusters[2].PodCIDR)
+	}
+	if clusters[1].ServiceCIDR != config.DefaultServiceCIDR {
+		t.Errorf("service CIDR = %s, want default %s", clusters[1].ServiceCIDR, config.DefaultServiceCIDR)
+	}
+
+	// allocations are stable across runs, even if cluster order changes
+	a, err = NewAllocator(logr.Discard(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters = []config.ClusterConfig{{Name: "cluster-02"}, {Name: "cluster-01"}}
+	if err := a.Assign(clusters, nil); err != nil {
+		t.Fatal(err)
+	}
+	if clusters[0].PodCIDR != "192.168.48.0/20" || clusters[1].PodCIDR != "192.168.32.0/20" {
+		t.Errorf("allocations changed: %s, %s", clusters[0].PodCIDR, clusters[1].PodCIDR)
+	}
+
+	// explicit CIDR colliding with a live cluster
+	clusters = []config.ClusterConfig{{Name: "cluster-03", PodCIDR: "192.168.16.0/24"}}
+	if err := a.Assign(clusters, live); err == nil {
+		t.Error("expected collision with live cluster")
+	}
+
+	// running cluster can't change its CIDR
+	clusters = []config.ClusterConfig{{Name: "cluster-01", PodCIDR: "192.168.64.0/20"}}
+	if err := a.Assign(clusters, map[string]Network{"cluster-01": {PodCIDR: "192.168.32.0/20"}}); err == nil {
+		t.Error("expected error changing CIDR of a running cluster")
+	}
+}
+
+func TestAssignServicePoolWithDefaultServiceCIDR(t *testing.T) {
+	cfg := config.IPAMConfig{
+		PodCIDRPool:     "192.168.0.0/16",
+		PodPrefixLength: 20,
+		StatePath:       filepath.Join(t.TempDir(), "ipam.json"),
+	}
+	a, err := NewAllocator(logr.Discard(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := []config.ClusterConfig{{Name: "cluster-01"}}
+	if err := a.Assign(clusters, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the default service CIDR of existing clusters does not exhaust the pool which includes it
+	cfg.ServiceCIDRPool, cfg.ServicePrefixLength = config.DefaultServiceCIDR, 16
+	a, err = NewAllocator(logr.Discard(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters = []config.ClusterConfig{{Name: "cluster-01"}, {Name: "cluster-02"}, {Name: "cluster-03"}}
+	live := map[string]Network{"other": {PodCIDR: "192.168.128.0/20", ServiceCIDR: config.DefaultServiceCIDR}}
+	if err := a.Assign(clusters, live); err != nil {
+		t.Fatal(err)
+	}
+	if clusters[0].ServiceCIDR != config.DefaultServiceCIDR {
+		t.Errorf("service CIDR of cluster-01 changed to %s", clusters[0].ServiceCIDR)
+	}
+	if clusters[1].ServiceCIDR != "10.96.0.0/16" || clusters[2].ServiceCIDR != "10.97.0.0/16" {
+		t.Errorf("unexpected service allocations %s, %s", clusters[1].ServiceCIDR, clusters[2].ServiceCIDR)
+	}
+}
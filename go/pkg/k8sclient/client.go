@@ -4,6 +4,8 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
 )
 
 // ClusterAuthInfo is a struct that contains a Kubernetes clientset and a Kubernetes config.
@@ -26,6 +28,7 @@ func GetKubernetesClient(kubeconfigPath, contextName, clusterName string) (*Clus
 	if err != nil {
 		return nil, err
 	}
+	audit.WrapConfig(restConfig, clusterName)
 
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
@@ -10,6 +10,8 @@ import (
 
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
 )
 
 func CreateCluster(kubeconfigPath string) error {
@@ -45,7 +47,14 @@ nodes:
 	// cmd.Stdout = os.Stdout
 	// cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
+	err = cmd.Run()
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionCreate,
+		Cluster: clusterName,
+		Object:  audit.ObjectRef{Kind: "KindCluster", Name: clusterName},
+		Diff:    "added context kind-" + clusterName + " to " + kubeconfigPath,
+	}, err)
+	if err != nil {
 		return fmt.Errorf("failed to create kind cluster: %w", err)
 	}
 
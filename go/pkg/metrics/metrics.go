@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+)
+
+const namespace = "multicluster"
+
+// Wait helpers, values of "wait" label
+const (
+	WaitCRDs             = "crds"
+	WaitFluxResources    = "flux_resources"
+	WaitCAPIProvisioning = "capi_provisioning"
+	WaitClusterRunning   = "cluster_running"
+	WaitControlPlane     = "control_plane"
+	WaitPivot            = "pivot"
+)
+
+// Deploy and clusterctl operations take minutes, buckets go from 10 seconds to about 45 minutes
+var durationBuckets = prometheus.ExponentialBuckets(10, 1.5, 14)
+
+var (
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of operations such as deploy and uninstall.",
+		Buckets:   durationBuckets,
+	}, []string{"operation", "result"})
+
+	operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Number of operations by result, success or failure.",
+	}, []string{"operation", "result"})
+
+	phaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "phase_duration_seconds",
+		Help:      "Duration of phases of operations.",
+		Buckets:   durationBuckets,
+	}, []string{"operation", "phase"})
+
+	phaseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "phase_failures_total",
+		Help:      "Number of failed phases of operations.",
+	}, []string{"operation", "phase"})
+
+	waitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "wait_duration_seconds",
+		Help:      "Time spent waiting for CRDs, Flux resources, CAPI clusters and pivot.",
+		Buckets:   durationBuckets,
+	}, []string{"wait"})
+
+	clusters = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "clusters",
+		Help:      "Number of clusters from config by CAPI cluster phase.",
+	}, []string{"phase"})
+)
+
+func init() {
+	// controller-runtime registry is served by the operator manager, serve exposes it too
+	ctrlmetrics.Registry.MustRegister(operationDuration, operations, phaseDuration, phaseFailures, waitDuration, clusters)
+}
+
+// Operation runs f and records its duration and result
+func Operation(operation string, f func() error) error {
+	start := time.Now()
+	err := f()
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	operationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+	operations.WithLabelValues(operation, result).Inc()
+	return err
+}
+
+// Phase runs a phase of the operation and records its duration, and failure if it fails
+func Phase(operation, phase string, f func() error) error {
+	start := time.Now()
+	err := f()
+	phaseDuration.WithLabelValues(operation, phase).Observe(time.Since(start).Seconds())
+	if err != nil {
+		phaseFailures.WithLabelValues(operation, phase).Inc()
+	}
+	return err
+}
+
+// ObserveWait records time since start in a wait helper, it is deferred at the beginning of the helper
+func ObserveWait(wait string, start time.Time) {
+	waitDuration.WithLabelValues(wait).Observe(time.Since(start).Seconds())
+}
+
+// SetClusters sets the number of clusters in each phase
+func SetClusters(statuses []v1alpha1.ClusterStatus) {
+	clusters.Reset()
+	for _, s := range statuses {
+		phase := s.Phase
+		if phase == "" {
+			phase = "Pending"
+		}
+		clusters.WithLabelValues(phase).Inc()
+	}
+}
+
+// Push pushes all metrics to Prometheus Pushgateway, it is used at the end of CLI runs
+func Push(url, command string) error {
+	return push.New(url, "multicluster-demo").
+		Gatherer(ctrlmetrics.Registry).
+		Grouping("command", command).
+		Push()
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+)
+
+func TestOperation(t *testing.T) {
+	failed := fmt.Errorf("failed")
+
+	if err := Operation("test_deploy", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := Operation("test_deploy", func() error { return failed }); err != failed {
+		t.Errorf("Operation() error = %v, want %v", err, failed)
+	}
+	if err := Operation("test_deploy", func() error { return failed }); err != failed {
+		t.Errorf("Operation() error = %v, want %v", err, failed)
+	}
+
+	if got := testutil.ToFloat64(operations.WithLabelValues("test_deploy", "success")); got != 1 {
+		t.Errorf("successful operations = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(operations.WithLabelValues("test_deploy", "failure")); got != 2 {
+		t.Errorf("failed operations = %v, want 2", got)
+	}
+
+	// the counter and the duration histogram of both results are served from the controller-runtime registry
+	expected := `
+# HELP multicluster_operations_total Number of operations by result, success or failure.
+# TYPE multicluster_operations_total counter
+multicluster_operations_total{operation="test_deploy",result="failure"} 2
+multicluster_operations_total{operation="test_deploy",result="success"} 1
+`
+	if err := testutil.GatherAndCompare(ctrlmetrics.Registry, strings.NewReader(expected), "multicluster_operations_total"); err != nil {
+		t.Error(err)
+	}
+	if got, err := testutil.GatherAndCount(ctrlmetrics.Registry, "multicluster_operation_duration_seconds"); err != nil || got != 2 {
+		t.Errorf("operation duration series = %d, %v, want 2", got, err)
+	}
+}
+
+func TestPhase(t *testing.T) {
+	if err := Phase("test_phase", "bootstrap", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := Phase("test_phase", "pivot", func() error { return fmt.Errorf("failed") }); err == nil {
+		t.Error("Phase() error = nil, want the phase error")
+	}
+
+	if got := testutil.ToFloat64(phaseFailures.WithLabelValues("test_phase", "pivot")); got != 1 {
+		t.Errorf("pivot failures = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(phaseFailures.WithLabelValues("test_phase", "bootstrap")); got != 0 {
+		t.Errorf("bootstrap failures = %v, want 0", got)
+	}
+
+	expected := `
+# HELP multicluster_phase_failures_total Number of failed phases of operations.
+# TYPE multicluster_phase_failures_total counter
+multicluster_phase_failures_total{operation="test_phase",phase="bootstrap"} 0
+multicluster_phase_failures_total{operation="test_phase",phase="pivot"} 1
+`
+	if err := testutil.GatherAndCompare(ctrlmetrics.Registry, strings.NewReader(expected), "multicluster_phase_failures_total"); err != nil {
+		t.Error(err)
+	}
+	if got, err := testutil.GatherAndCount(ctrlmetrics.Registry, "multicluster_phase_duration_seconds"); err != nil || got != 2 {
+		t.Errorf("phase duration series = %d, %v, want 2", got, err)
+	}
+}
+
+func TestSetClusters(t *testing.T) {
+	SetClusters([]v1alpha1.ClusterStatus{
+		{Name: "cluster-mgmt", Phase: "Provisioned"},
+		{Name: "cluster-01", Phase: "Provisioning"},
+		{Name: "cluster-02", Phase: "Provisioning"},
+		{Name: "cluster-03"},
+	})
+
+	expected := `
+# HELP multicluster_clusters Number of clusters from config by CAPI cluster phase.
+# TYPE multicluster_clusters gauge
+multicluster_clusters{phase="Pending"} 1
+multicluster_clusters{phase="Provisioned"} 1
+multicluster_clusters{phase="Provisioning"} 2
+`
+	if err := testutil.GatherAndCompare(ctrlmetrics.Registry, strings.NewReader(expected), "multicluster_clusters"); err != nil {
+		t.Error(err)
+	}
+
+	// phases which no cluster is in anymore are not reported
+	SetClusters([]v1alpha1.ClusterStatus{
+		{Name: "cluster-mgmt", Phase: "Provisioned"},
+		{Name: "cluster-01", Phase: "Provisioned"},
+	})
+
+	expected = `
+# HELP multicluster_clusters Number of clusters from config by CAPI cluster phase.
+# TYPE multicluster_clusters gauge
+multicluster_clusters{phase="Provisioned"} 2
+`
+	if err := testutil.GatherAndCompare(ctrlmetrics.Registry, strings.NewReader(expected), "multicluster_clusters"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(clusters.WithLabelValues("Provisioned")); got != 2 {
+		t.Errorf("provisioned clusters = %v, want 2", got)
+	}
+}
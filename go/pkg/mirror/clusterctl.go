@@ -0,0 +1,102 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// WriteClusterctlConfig takes clusterctl config of a cluster and writes a copy of it
+// to the mirror directory with provider and cert-manager URLs pointing at the mirrored
+// components. If mirror registry is set, provider images are pulled from it.
+// It returns path to the new config file.
+func WriteClusterctlConfig(basePath, clusterName string, mirrorConfig config.MirrorConfig, providersConfig config.ProvidersConfig) (string, error) {
+	clusterctlConfig := map[string]interface{}{}
+
+	data, err := os.ReadFile(basePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read clusterctl config %s: %w", basePath, err)
+	}
+	if err := yaml.Unmarshal(data, &clusterctlConfig); err != nil {
+		return "", fmt.Errorf("failed to parse clusterctl config %s: %w", basePath, err)
+	}
+
+	mirrored, err := providers(providersConfig)
+	if err != nil {
+		return "", err
+	}
+
+	var mirroredProviders []interface{}
+	images := map[string]interface{}{}
+	for _, p := range mirrored {
+		componentsPath := filepath.Join(mirrorConfig.Path, providersDir, p.label, p.version, p.componentsFile)
+		if _, err := os.Stat(componentsPath); err != nil {
+			return "", fmt.Errorf("provider %s is not mirrored, run `mirror` command first: %w", p.label, err)
+		}
+		mirroredProviders = append(mirroredProviders, map[string]interface{}{
+			"name": p.name,
+			"type": p.providerType,
+			"url":  "file://" + componentsPath,
+		})
+		if mirrorConfig.Registry != "" {
+			if images[p.label], err = imagesOverride(componentsPath, mirrorConfig.Registry); err != nil {
+				return "", err
+			}
+		}
+	}
+	clusterctlConfig["providers"] = mirroredProviders
+
+	certManagerPath := filepath.Join(mirrorConfig.Path, providersDir, "cert-manager", config.CertManagerVersion, "cert-manager.yaml")
+	clusterctlConfig["cert-manager"] = map[string]interface{}{
+		"url":     "file://" + certManagerPath,
+		"version": config.CertManagerVersion,
+	}
+
+	if mirrorConfig.Registry != "" {
+		if images["cert-manager"], err = imagesOverride(certManagerPath, mirrorConfig.Registry); err != nil {
+			return "", err
+		}
+		clusterctlConfig["images"] = images
+	}
+
+	out, err := yaml.Marshal(clusterctlConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal clusterctl config: %w", err)
+	}
+
+	configPath := filepath.Join(mirrorConfig.Path, clusterctlDir, clusterName+".yaml")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create clusterctl config directory: %w", err)
+	}
+	if err := os.WriteFile(configPath, out, 0644); err != nil {
+		return "", fmt.Errorf("failed to write clusterctl config: %w", err)
+	}
+	return configPath, nil
+}
+
+// imagesOverride returns clusterctl image override of a provider. clusterctl replaces the repository
+// of the images and keeps their names, so the override is the repository path of the provider images
+// in the mirror registry, the same as RegistryImage gives for each image.
+func imagesOverride(componentsPath, registry string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(componentsPath)
+	if err != nil {
+		return nil, err
+	}
+	var repository string
+	for _, image := range manifestImages(data) {
+		dir := path.Dir(imageName(image))
+		if repository != "" && dir != repository {
+			return nil, fmt.Errorf("images of %s are in different repositories %s and %s", componentsPath, repository, dir)
+		}
+		repository = dir
+	}
+	if repository == "" {
+		return nil, fmt.Errorf("no images found in %s", componentsPath)
+	}
+	return map[string]interface{}{"repository": RegistryImage(repository, registry)}, nil
+}
@@ -0,0 +1,255 @@
+package mirror
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// provider components are not always valid YAML before variables substitution,
+// so images are extracted line by line rather than by decoding the manifests
+var imageLineRegexp = regexp.MustCompile(`(?m)^\s*(?:-\s*)?image:\s*["']?([^\s"']+)["']?\s*$`)
+
+// manifestImages returns all container images referenced in a multi-document YAML
+func manifestImages(data []byte) []string {
+	seen := make(map[string]struct{})
+	var images []string
+	for _, match := range imageLineRegexp.FindAllSubmatch(data, -1) {
+		image := string(match[1])
+		if strings.Contains(image, "${") {
+			continue
+		}
+		if _, ok := seen[image]; ok {
+			continue
+		}
+		seen[image] = struct{}{}
+		images = append(images, image)
+	}
+	return images
+}
+
+// chartImages returns the images referenced in values.yaml of a packaged Helm chart.
+// Images are rendered by the chart templates, so this only works for charts which
+// follow `image: {repository: ..., tag: ...}` convention in their values, which Cilium does.
+func chartImages(chartPath string) ([]string, error) {
+	values, err := chartValues(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	collectValuesImages(values, seen)
+
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// chartValues returns values.yaml of a packaged Helm chart
+func chartValues(chartPath string) (map[string]interface{}, error) {
+	f, err := os.Open(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chart %s: %w", chartPath, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chart %s: %w", chartPath, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("values.yaml not found in chart %s", chartPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chart %s: %w", chartPath, err)
+		}
+
+		// top level values.yaml of the chart, not of its subcharts: <chart>/values.yaml
+		if strings.Count(hdr.Name, "/") != 1 || !strings.HasSuffix(hdr.Name, "/values.yaml") {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values.yaml from chart %s: %w", chartPath, err)
+		}
+
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse values.yaml from chart %s: %w", chartPath, err)
+		}
+		return values, nil
+	}
+}
+
+func collectValuesImages(values interface{}, images map[string]struct{}) {
+	switch v := values.(type) {
+	case map[string]interface{}:
+		repository, hasRepo := v["repository"].(string)
+		tag, hasTag := v["tag"].(string)
+		if hasRepo && hasTag && repository != "" && tag != "" {
+			images[repository+":"+tag] = struct{}{}
+		}
+		for _, nested := range v {
+			collectValuesImages(nested, images)
+		}
+	case []interface{}:
+		for _, nested := range v {
+			collectValuesImages(nested, images)
+		}
+	}
+}
+
+// ChartImageValues returns Helm values which point images of the packaged chart at the mirror registry.
+// Only `repository` of the images found by chartImages is overridden, digests are disabled because
+// images pushed to the mirror don't keep the digests of multi-arch manifests.
+func ChartImageValues(chartPath, registry string) (map[string]interface{}, error) {
+	values, err := chartValues(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	overrides, _ := imageValues(values, registry).(map[string]interface{})
+	if overrides == nil {
+		overrides = map[string]interface{}{}
+	}
+	return overrides, nil
+}
+
+// imageValues returns the subset of values which overrides image repositories, or nil if there are none
+func imageValues(values interface{}, registry string) interface{} {
+	v, ok := values.(map[string]interface{})
+	if !ok {
+		// images in lists can't be overridden without replacing the whole list
+		return nil
+	}
+	overrides := map[string]interface{}{}
+	repository, hasRepo := v["repository"].(string)
+	tag, hasTag := v["tag"].(string)
+	if hasRepo && hasTag && repository != "" && tag != "" {
+		overrides["repository"] = RegistryImage(repository, registry)
+		if _, ok := v["useDigest"]; ok {
+			overrides["useDigest"] = false
+		}
+	}
+	for key, nested := range v {
+		if override := imageValues(nested, registry); override != nil {
+			overrides[key] = override
+		}
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	return overrides
+}
+
+// RegistryImage returns the name of the image in the mirror registry. The original registry
+// is replaced by the mirror registry and the repository path is kept, so that images with the
+// same name from different projects don't clash, e.g.
+// ghcr.io/fluxcd/source-controller:v1.2.3 -> registry.local:5000/fluxcd/source-controller:v1.2.3
+func RegistryImage(image, registry string) string {
+	name := image
+	// the first path component is a registry host if it has a domain or a port, as in docker reference
+	if host, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		name = rest
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + name
+}
+
+// ImageOverride is a kustomize image override, Name is the image without tag or digest
+type ImageOverride struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+}
+
+// ImageOverrides returns kustomize image overrides which point all images referenced in the
+// manifests files at the mirror registry. They are set on Flux Kustomizations which install
+// components from the repository, e.g. Flux on workload clusters and CAAPH.
+func ImageOverrides(registry string, manifestFiles ...string) ([]ImageOverride, error) {
+	seen := make(map[string]struct{})
+	var overrides []ImageOverride
+	for _, path := range manifestFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for _, image := range manifestImages(data) {
+			name := imageName(image)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			overrides = append(overrides, ImageOverride{Name: name, NewName: RegistryImage(name, registry)})
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Name < overrides[j].Name })
+	return overrides, nil
+}
+
+// imageName strips tag and digest from the image reference
+func imageName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// RewriteImages returns a mutator which points all containers of a workload at the mirror registry
+func RewriteImages(registry string) utils.ObjectMutator {
+	return func(obj *unstructured.Unstructured) error {
+		if registry == "" {
+			return nil
+		}
+
+		var podSpecPath []string
+		switch obj.GetKind() {
+		case "Deployment", "StatefulSet", "DaemonSet", "Job":
+			podSpecPath = []string{"spec", "template", "spec"}
+		case "CronJob":
+			podSpecPath = []string{"spec", "jobTemplate", "spec", "template", "spec"}
+		default:
+			return nil
+		}
+
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, found, err := unstructured.NestedSlice(obj.Object, append(podSpecPath, field)...)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			for i := range containers {
+				container, ok := containers[i].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if image, ok := container["image"].(string); ok {
+					container["image"] = RegistryImage(image, registry)
+				}
+			}
+			if err := unstructured.SetNestedSlice(obj.Object, containers, append(podSpecPath, field)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistryImage(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"ghcr.io/fluxcd/source-controller:v1.2.3", "registry.local:5000/fluxcd/source-controller:v1.2.3"},
+		{"registry.k8s.io/cluster-api/cluster-api-controller:v1.6.0", "registry.local:5000/cluster-api/cluster-api-controller:v1.6.0"},
+		{"quay.io/cilium/cilium", "registry.local:5000/cilium/cilium"},
+		{"localhost/app:1", "registry.local:5000/app:1"},
+		{"cilium/cilium:v1.12.3", "registry.local:5000/cilium/cilium:v1.12.3"},
+		{"busybox", "registry.local:5000/busybox"},
+	}
+	for _, tt := range tests {
+		if got := RegistryImage(tt.image, "registry.local:5000/"); got != tt.want {
+			t.Errorf("RegistryImage(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestImageName(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/fluxcd/source-controller:v1.2.3":   "ghcr.io/fluxcd/source-controller",
+		"registry.local:5000/app":                   "registry.local:5000/app",
+		"registry.local:5000/app:1":                 "registry.local:5000/app",
+		"quay.io/cilium/cilium:v1.12.3@sha256:abcd": "quay.io/cilium/cilium",
+	}
+	for image, want := range tests {
+		if got := imageName(image); got != want {
+			t.Errorf("imageName(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestImageValues(t *testing.T) {
+	values := map[string]interface{}{
+		"image": map[string]interface{}{"repository": "quay.io/cilium/cilium", "tag": "v1.12.3", "useDigest": true},
+		"hubble": map[string]interface{}{
+			"enabled": true,
+			"relay": map[string]interface{}{
+				"image": map[string]interface{}{"repository": "quay.io/cilium/hubble-relay", "tag": "v1.12.3"},
+			},
+		},
+		"extraContainers": []interface{}{map[string]interface{}{"repository": "quay.io/x", "tag": "1"}},
+	}
+	want := map[string]interface{}{
+		"image": map[string]interface{}{"repository": "mirror/cilium/cilium", "useDigest": false},
+		"hubble": map[string]interface{}{
+			"relay": map[string]interface{}{
+				"image": map[string]interface{}{"repository": "mirror/cilium/hubble-relay"},
+			},
+		},
+	}
+	if got := imageValues(values, "mirror"); !reflect.DeepEqual(got, want) {
+		t.Errorf("imageValues() = %v, want %v", got, want)
+	}
+}
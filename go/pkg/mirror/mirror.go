@@ -0,0 +1,520 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const (
+	providersDir   = "providers"
+	fluxDir        = "flux"
+	chartsDir      = "charts"
+	imagesDir      = "images"
+	clusterctlDir  = "clusterctl"
+	imagesListFile = "images.txt"
+
+	githubReleaseURLTpl = "https://github.com/%s/releases/download/%s/%s"
+)
+
+// provider describes a clusterctl provider which is downloaded into the mirror.
+// Directory layout follows clusterctl local repository convention:
+// {basepath}/{label}/{version}/{componentsFile}, metadata.yaml is stored next to the components file.
+type provider struct {
+	name           string
+	providerType   string
+	label          string
+	repo           string
+	version        string
+	componentsFile string
+}
+
+// knownProviders maps "<type>/<name>" to the GitHub repo and components file of the provider
+var knownProviders = map[string]struct{ repo, componentsFile string }{
+	"CoreProvider/cluster-api":     {"kubernetes-sigs/cluster-api", "core-components.yaml"},
+	"BootstrapProvider/kubeadm":    {"kubernetes-sigs/cluster-api", "bootstrap-components.yaml"},
+	"ControlPlaneProvider/kubeadm": {"kubernetes-sigs/cluster-api", "control-plane-components.yaml"},
+	"InfrastructureProvider/aws":   {"kubernetes-sigs/cluster-api-provider-aws", "infrastructure-components.yaml"},
+	"AddonProvider/helm":           {"kubernetes-sigs/cluster-api-addon-provider-helm", "addon-components.yaml"},
+}
+
+// providerLabelPrefix is the clusterctl label prefix of each provider type, e.g. "bootstrap-kubeadm"
+var providerLabelPrefix = map[string]string{
+	"CoreProvider":           "",
+	"BootstrapProvider":      "bootstrap-",
+	"ControlPlaneProvider":   "control-plane-",
+	"InfrastructureProvider": "infrastructure-",
+	"AddonProvider":          "addon-",
+}
+
+// providers returns the providers from config. CAAPH is always mirrored because
+// it is deployed on workload clusters even when it is not installed by clusterctl.
+func providers(cfg config.ProvidersConfig) ([]provider, error) {
+	byType := []struct {
+		providerType string
+		names        []string
+	}{
+		{"CoreProvider", []string{cfg.Core}},
+		{"BootstrapProvider", cfg.Bootstrap},
+		{"ControlPlaneProvider", cfg.ControlPlane},
+		{"InfrastructureProvider", cfg.Infrastructure},
+		{"AddonProvider", cfg.Addon},
+	}
+
+	var result []provider
+	hasHelm := false
+	for _, t := range byType {
+		for _, nameVersion := range t.names {
+			name, version, _ := strings.Cut(nameVersion, ":")
+			if version == "" {
+				return nil, fmt.Errorf("provider %q must be pinned to a version, e.g. %s:v1.0.0", nameVersion, name)
+			}
+			known, ok := knownProviders[t.providerType+"/"+name]
+			if !ok {
+				return nil, fmt.Errorf("%s %q can't be mirrored, only %v are supported", t.providerType, name, knownProviderNames())
+			}
+			if t.providerType == "AddonProvider" && name == "helm" {
+				hasHelm = true
+			}
+			result = append(result, provider{name, t.providerType, providerLabelPrefix[t.providerType] + name, known.repo, version, known.componentsFile})
+		}
+	}
+
+	if !hasHelm {
+		known := knownProviders["AddonProvider/helm"]
+		result = append(result, provider{"helm", "AddonProvider", "addon-helm", known.repo, config.CAAPHVersion, known.componentsFile})
+	}
+	return result, nil
+}
+
+func knownProviderNames() []string {
+	names := make([]string, 0, len(knownProviders))
+	for name := range knownProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mirror downloads everything that deploy normally fetches from the internet
+// into a local directory, so that clusters can be built without outbound access.
+type Mirror struct {
+	log        logr.Logger
+	cfg        *config.Config
+	httpClient *http.Client
+	images     map[string]struct{}
+}
+
+func NewMirror(log logr.Logger, cfg *config.Config) (*Mirror, error) {
+	if cfg.Mirror.Path == "" {
+		return nil, fmt.Errorf("mirror path is not set in config")
+	}
+
+	return &Mirror{
+		log:        log,
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+		images:     make(map[string]struct{}),
+	}, nil
+}
+
+// Run populates the mirror directory. Files which already exist are not downloaded again,
+// so the mirror directory also works as a cache between runs.
+func (m *Mirror) Run() error {
+	mirrored, err := providers(m.cfg.CAPI.Providers)
+	if err != nil {
+		return err
+	}
+	for _, p := range mirrored {
+		if err := m.mirrorProvider(p); err != nil {
+			return err
+		}
+	}
+
+	if err := m.mirrorCertManager(); err != nil {
+		return err
+	}
+
+	for _, version := range m.fluxVersions() {
+		if err := m.mirrorFlux(version); err != nil {
+			return err
+		}
+	}
+
+	charts, err := m.charts()
+	if err != nil {
+		return err
+	}
+	for _, c := range charts {
+		if err := m.mirrorChart(c); err != nil {
+			return err
+		}
+	}
+
+	// CAAPH is not installed by clusterctl in this project, but from the manifests vendored in the repo
+	caaphManifests := filepath.Join(utils.RepoRoot(), "k8s-platform", "cluster-api-addon-provider-helm", "manifests.yaml")
+	if err := m.collectImagesFromFile(caaphManifests); err != nil {
+		return err
+	}
+
+	return m.writeImagesList()
+}
+
+func (m *Mirror) mirrorProvider(p provider) error {
+	dir := filepath.Join(m.cfg.Mirror.Path, providersDir, p.label, p.version)
+	m.log.Info("Mirroring provider", "name", p.name, "type", p.providerType, "version", p.version)
+
+	for _, file := range []string{p.componentsFile, "metadata.yaml"} {
+		url := fmt.Sprintf(githubReleaseURLTpl, p.repo, p.version, file)
+		if err := m.download(url, filepath.Join(dir, file)); err != nil {
+			return err
+		}
+	}
+	return m.collectImagesFromFile(filepath.Join(dir, p.componentsFile))
+}
+
+func (m *Mirror) mirrorCertManager() error {
+	dir := filepath.Join(m.cfg.Mirror.Path, providersDir, "cert-manager", config.CertManagerVersion)
+	m.log.Info("Mirroring cert-manager", "version", config.CertManagerVersion)
+
+	url := fmt.Sprintf(githubReleaseURLTpl, "cert-manager/cert-manager", config.CertManagerVersion, "cert-manager.yaml")
+	if err := m.download(url, filepath.Join(dir, "cert-manager.yaml")); err != nil {
+		return err
+	}
+	return m.collectImagesFromFile(filepath.Join(dir, "cert-manager.yaml"))
+}
+
+func (m *Mirror) mirrorFlux(version string) error {
+	path := FluxComponentsPath(m.cfg.Mirror.Path, version)
+	m.log.Info("Mirroring Flux", "version", version)
+
+	// install.yaml from the release page is the same file as `flux install --export` output
+	url := fmt.Sprintf(githubReleaseURLTpl, "fluxcd/flux2", "v"+version, "install.yaml")
+	if err := m.download(url, path); err != nil {
+		return err
+	}
+	return m.collectImagesFromFile(path)
+}
+
+// FluxComponentsPath returns location of gotk-components.yaml of the given Flux version in the mirror
+func FluxComponentsPath(mirrorPath, version string) string {
+	return filepath.Join(mirrorPath, fluxDir, "v"+version, "gotk-components.yaml")
+}
+
+// chart is a Helm chart installed by CAAPH, a CNI or an add-on
+type chart struct {
+	repoURL string
+	name    string
+	version string
+}
+
+// ChartPath returns location of the packaged chart in the mirror
+func ChartPath(mirrorPath, name, version string) string {
+	return filepath.Join(mirrorPath, chartsDir, name+"-"+version+".tgz")
+}
+
+// ChartRepository returns the OCI repository in the mirror registry where charts are pushed to,
+// it is used as repoURL of HelmChartProxies in air-gapped setup
+func ChartRepository(registry string) string {
+	return "oci://" + strings.TrimSuffix(registry, "/") + "/" + chartsDir
+}
+
+// charts returns all distinct charts of CNIs and add-ons of the clusters in config. CNI charts
+// are taken from the HelmChartProxies vendored in k8s-platform/cni-caaph-resource.
+func (m *Mirror) charts() ([]chart, error) {
+	seen := make(map[chart]struct{})
+	var charts []chart
+	add := func(c chart) {
+		if _, ok := seen[c]; !ok {
+			seen[c] = struct{}{}
+			charts = append(charts, c)
+		}
+	}
+	for i := range m.cfg.Clusters {
+		cluster := &m.cfg.Clusters[i]
+		if cluster.Provider == "kind" {
+			continue
+		}
+		vendored, err := filepath.Glob(filepath.Join(utils.RepoRoot(), "k8s-platform", "cni-caaph-resource",
+			cluster.CNI.Type, "v"+cluster.CNI.Version, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range vendored {
+			c, err := helmChartProxyChart(path)
+			if err != nil {
+				return nil, err
+			}
+			add(c)
+		}
+		for _, addon := range m.cfg.ClusterAddons(cluster) {
+			add(chart{addon.RepoURL, addon.Chart, addon.Version})
+		}
+	}
+	return charts, nil
+}
+
+func helmChartProxyChart(path string) (chart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return chart{}, err
+	}
+	hcp := struct {
+		Spec struct {
+			RepoURL   string `json:"repoURL"`
+			ChartName string `json:"chartName"`
+			Version   string `json:"version"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal(data, &hcp); err != nil {
+		return chart{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if hcp.Spec.Version == "" {
+		return chart{}, fmt.Errorf("chart version is not pinned in %s", path)
+	}
+	return chart{hcp.Spec.RepoURL, hcp.Spec.ChartName, hcp.Spec.Version}, nil
+}
+
+// mirrorChart downloads the packaged chart found in index.yaml of its Helm repository
+func (m *Mirror) mirrorChart(c chart) error {
+	path := ChartPath(m.cfg.Mirror.Path, c.name, c.version)
+	m.log.Info("Mirroring chart", "chart", c.name, "version", c.version, "repo", c.repoURL)
+
+	if _, err := os.Stat(path); err != nil {
+		url, err := m.chartURL(c)
+		if err != nil {
+			return err
+		}
+		if err := m.download(url, path); err != nil {
+			return err
+		}
+	}
+
+	images, err := chartImages(path)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		m.images[image] = struct{}{}
+	}
+	return nil
+}
+
+func (m *Mirror) chartURL(c chart) (string, error) {
+	if strings.HasPrefix(c.repoURL, "oci://") {
+		return "", fmt.Errorf("chart %s is in OCI repository %s, only HTTP chart repositories can be mirrored", c.name, c.repoURL)
+	}
+	base, err := url.Parse(strings.TrimSuffix(c.repoURL, "/") + "/")
+	if err != nil {
+		return "", fmt.Errorf("invalid chart repository %s: %w", c.repoURL, err)
+	}
+	indexURL := base.JoinPath("index.yaml").String()
+	resp, err := m.httpClient.Get(indexURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", indexURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", indexURL, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", indexURL, err)
+	}
+
+	index := struct {
+		Entries map[string][]struct {
+			Version string   `json:"version"`
+			URLs    []string `json:"urls"`
+		} `json:"entries"`
+	}{}
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", indexURL, err)
+	}
+	for _, entry := range index.Entries[c.name] {
+		if strings.TrimPrefix(entry.Version, "v") != strings.TrimPrefix(c.version, "v") || len(entry.URLs) == 0 {
+			continue
+		}
+		// chart URLs in the index may be relative to the repository
+		chartURL, err := base.Parse(entry.URLs[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid URL of chart %s in %s: %w", c.name, indexURL, err)
+		}
+		return chartURL.String(), nil
+	}
+	return "", fmt.Errorf("chart %s version %s not found in %s", c.name, c.version, indexURL)
+}
+
+// fluxVersions returns all distinct Flux versions used by the clusters in config
+func (m *Mirror) fluxVersions() []string {
+	seen := make(map[string]struct{})
+	var versions []string
+	for _, cluster := range m.cfg.Clusters {
+		if _, ok := seen[cluster.Flux.Version]; ok || cluster.Flux.Version == "" {
+			continue
+		}
+		seen[cluster.Flux.Version] = struct{}{}
+		versions = append(versions, cluster.Flux.Version)
+	}
+	return versions
+}
+
+func (m *Mirror) collectImagesFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for _, image := range manifestImages(data) {
+		m.images[image] = struct{}{}
+	}
+	return nil
+}
+
+func (m *Mirror) writeImagesList() error {
+	var lines []string
+	for _, image := range m.Images() {
+		if m.cfg.Mirror.Registry != "" {
+			lines = append(lines, image+" "+RegistryImage(image, m.cfg.Mirror.Registry))
+		} else {
+			lines = append(lines, image)
+		}
+	}
+
+	path := filepath.Join(m.cfg.Mirror.Path, imagesListFile)
+	m.log.Info("Writing images list", "path", path, "count", len(lines))
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Images returns a sorted list of all images referenced by the mirrored components
+func (m *Mirror) Images() []string {
+	images := make([]string, 0, len(m.images))
+	for image := range m.images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// SaveImages pulls all mirrored images and saves them as tarballs in the mirror directory.
+// If push is set, the images are also pushed to the mirror registry and the charts are pushed
+// to ChartRepository in it. This requires `docker` and, to push charts, `helm` binaries on the host.
+func (m *Mirror) SaveImages(push bool) error {
+	if push && m.cfg.Mirror.Registry == "" {
+		return fmt.Errorf("can't push images, mirror registry is not set in config")
+	}
+
+	dir := filepath.Join(m.cfg.Mirror.Path, imagesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create images directory: %w", err)
+	}
+
+	for _, image := range m.Images() {
+		tarball := filepath.Join(dir, imageFileName(image))
+		if _, err := os.Stat(tarball); err == nil && !push {
+			continue
+		}
+
+		m.log.Info("Pulling image", "image", image)
+		if err := docker("pull", image); err != nil {
+			return err
+		}
+		if err := docker("save", "-o", tarball, image); err != nil {
+			return err
+		}
+
+		if push {
+			target := RegistryImage(image, m.cfg.Mirror.Registry)
+			m.log.Info("Pushing image", "image", target)
+			if err := docker("tag", image, target); err != nil {
+				return err
+			}
+			if err := docker("push", target); err != nil {
+				return err
+			}
+		}
+	}
+	if push {
+		return m.pushCharts()
+	}
+	return nil
+}
+
+// pushCharts pushes the mirrored charts, helm derives the chart name and version from the package
+func (m *Mirror) pushCharts() error {
+	charts, err := filepath.Glob(filepath.Join(m.cfg.Mirror.Path, chartsDir, "*.tgz"))
+	if err != nil {
+		return err
+	}
+	repository := ChartRepository(m.cfg.Mirror.Registry)
+	for _, chart := range charts {
+		m.log.Info("Pushing chart", "chart", filepath.Base(chart), "repository", repository)
+		cmd := exec.Command("helm", "push", chart, repository)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("helm push %s failed: %w: %s", filepath.Base(chart), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) download(url, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		m.log.V(1).Info("Already mirrored, skipping", "path", dest)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dest, err)
+	}
+
+	resp, err := m.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	// write to a temp file first, so that interrupted downloads don't end up in the cache
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+
+	return os.Rename(tmp.Name(), dest)
+}
+
+func docker(args ...string) error {
+	cmd := exec.Command("docker", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("docker %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func imageFileName(image string) string {
+	return strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image) + ".tar"
+}
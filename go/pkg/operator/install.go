@@ -0,0 +1,254 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/secrets"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const (
+	crdName        = "multiclusters." + config.APIGroup
+	keysSecretName = "multicluster-operator-keys"
+	pollInterval   = 15 * time.Second
+
+	// ipamConfigMapName keeps IPAM state of the operator, the file system of its pod is ephemeral
+	ipamConfigMapName = "multicluster-operator-ipam"
+	ipamStateKey      = "ipam.json"
+)
+
+// Keys in the keys secret, the secret is mounted in KeysDir of the operator
+const (
+	// gitKey is the Flux key of the management cluster, the operator pushes generated manifests with it
+	gitKey        = "identity"
+	knownHostsKey = "known_hosts"
+	ageKey        = "age.agekey"
+)
+
+// fluxKey is the key of the Flux key of the cluster in the keys secret
+func fluxKey(cluster string) string {
+	return "flux-" + cluster
+}
+
+// tenantKey is the key of the SSH key of the tenant repository in the keys secret
+func tenantKey(tenant string) string {
+	return "tenant-" + tenant
+}
+
+// Install installs the operator on the permanent management cluster and creates MultiCluster resource
+// from the config file. Keys are copied to a secret mounted by the operator.
+func Install(ctx context.Context, log logr.Logger, clusterAuth *k8sclient.ClusterAuthInfo, cfg *config.Config) error {
+	dynamicClient, err := dynamic.NewForConfig(clusterAuth.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	manifests := filepath.Join(utils.RepoRoot(), "k8s-platform", "multicluster-operator")
+
+	log.Info("Installing multicluster operator", "cluster", clusterAuth.ClusterName, "image", cfg.Operator.Image)
+	if err := utils.ServerSideApplyManifestsFile(dynamicClient, filepath.Join(manifests, "crd.yaml")); err != nil {
+		return err
+	}
+	if err := utils.WaitForCRDs(ctx, clusterAuth.Config, []string{crdName}); err != nil {
+		return err
+	}
+
+	// the Deployment is applied on every deploy, so that a new image is rolled out
+	if err := utils.ServerSideApplyManifestsFile(dynamicClient, filepath.Join(manifests, "operator.yaml"), setImage(cfg.Operator.Image)); err != nil {
+		return err
+	}
+	// the operator pod starts once the secret is created
+	if err := createKeysSecret(clusterAuth.Clientset, cfg); err != nil {
+		return err
+	}
+	if err := seedIPAMState(clusterAuth.Clientset, cfg); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	multiCluster, err := v1alpha1.Load(data)
+	if err != nil {
+		return err
+	}
+	multiCluster.Namespace, multiCluster.Name = Namespace, Name
+	// the operator builds its own kubeconfig
+	multiCluster.Spec.KubeconfigPath = ""
+
+	client, err := newClient(clusterAuth)
+	if err != nil {
+		return err
+	}
+	existing := &v1alpha1.MultiCluster{}
+	err = client.Get(ctx, runtimeclient.ObjectKeyFromObject(multiCluster), existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		err = client.Create(ctx, multiCluster)
+	case err == nil:
+		existing.Spec = multiCluster.Spec
+		err = client.Update(ctx, existing)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s/%s: %w", config.MultiClusterKind, Namespace, Name, err)
+	}
+	return nil
+}
+
+// WaitForReady waits for the operator to report MultiCluster resource Ready
+func WaitForReady(ctx context.Context, log logr.Logger, clusterAuth *k8sclient.ClusterAuthInfo, timeout time.Duration) error {
+	client, err := newClient(clusterAuth)
+	if err != nil {
+		return err
+	}
+
+	return tracing.Run(ctx, "wait operator ready", func(ctx context.Context) error {
+		return waitForReady(ctx, log, client, timeout)
+	}, tracing.Cluster(clusterAuth.ClusterName), tracing.Namespace(Namespace), tracing.GVR(v1alpha1.GroupVersion.WithResource("multiclusters")))
+}
+
+func waitForReady(ctx context.Context, log logr.Logger, client runtimeclient.Client, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		multiCluster := &v1alpha1.MultiCluster{}
+		if err := client.Get(ctx, runtimeclient.ObjectKey{Namespace: Namespace, Name: Name}, multiCluster); err != nil {
+			return fmt.Errorf("failed to get %s %s/%s: %w", config.MultiClusterKind, Namespace, Name, err)
+		}
+		message := "not reconciled yet"
+		if ready := meta.FindStatusCondition(multiCluster.Status.Conditions, v1alpha1.ConditionReady); ready != nil {
+			if ready.Status == metav1.ConditionTrue {
+				return nil
+			}
+			message = ready.Message
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s %s/%s to become ready: %s", config.MultiClusterKind, Namespace, Name, message)
+		}
+		log.Info("Waiting for operator to reconcile the fleet", "message", message)
+		time.Sleep(pollInterval)
+	}
+}
+
+// setImage returns a mutator which sets the image of the operator Deployment
+func setImage(image string) utils.ObjectMutator {
+	return func(obj *unstructured.Unstructured) error {
+		if obj.GetKind() != "Deployment" {
+			return nil
+		}
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		for _, c := range containers {
+			c.(map[string]interface{})["image"] = image
+		}
+		return unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", "containers")
+	}
+}
+
+// createKeysSecret creates or updates the secret with the keys the operator needs: Flux keys of the clusters,
+// SSH keys of tenant repositories and the age key. Key sources in config point to the machine where deploy
+// is run, the operator reads the keys from the mounted secret instead, see Options.KeysDir.
+func createKeysSecret(clientset kubernetes.Interface, cfg *config.Config) error {
+	data := map[string][]byte{knownHostsKey: []byte(config.GithubKnownHosts)}
+	for _, cluster := range cfg.Clusters {
+		if cluster.Provider == "kind" {
+			continue
+		}
+		source, err := secrets.NewSource(cluster.Flux.KeySource)
+		if err != nil {
+			return err
+		}
+		key, err := source.Get()
+		if err != nil {
+			return fmt.Errorf("failed to read Flux key of %s from %s: %w", cluster.Name, source, err)
+		}
+		data[fluxKey(cluster.Name)] = key
+		if cluster.ManagementCluster == "" {
+			data[gitKey] = key
+		}
+	}
+	for _, tenant := range cfg.Tenants {
+		if tenant.KeySource.Type == "" {
+			continue
+		}
+		source, err := secrets.NewSource(tenant.KeySource)
+		if err != nil {
+			return err
+		}
+		if data[tenantKey(tenant.Name)], err = source.Get(); err != nil {
+			return fmt.Errorf("failed to read key of tenant %s from %s: %w", tenant.Name, source, err)
+		}
+	}
+	if cfg.SOPS.Enabled {
+		key, err := os.ReadFile(cfg.SOPS.AgeKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read age key: %w", err)
+		}
+		data[ageKey] = key
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: keysSecretName, Namespace: Namespace},
+		Data:       data,
+	}
+	secretsClient := clientset.CoreV1().Secrets(Namespace)
+	_, err := secretsClient.Create(context.TODO(), secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secretsClient.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create secret %s/%s: %w", Namespace, keysSecretName, err)
+	}
+	return nil
+}
+
+// seedIPAMState copies IPAM state of this machine to the operator, so that it keeps CIDRs allocated by deploy.
+// Once the state is created the operator owns it and it is not overwritten.
+func seedIPAMState(clientset kubernetes.Interface, cfg *config.Config) error {
+	state, err := os.ReadFile(cfg.IPAM.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read IPAM state: %w", err)
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ipamConfigMapName, Namespace: Namespace},
+		Data:       map[string]string{ipamStateKey: string(state)},
+	}
+	_, err = clientset.CoreV1().ConfigMaps(Namespace).Create(context.TODO(), configMap, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create configmap %s/%s: %w", Namespace, ipamConfigMapName, err)
+	}
+	return nil
+}
+
+func newClient(clusterAuth *k8sclient.ClusterAuthInfo) (runtimeclient.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	client, err := runtimeclient.New(clusterAuth.Config, runtimeclient.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,421 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/ipam"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/sops"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/status"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tenant"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// Deploy creates a single MultiCluster resource in Namespace, named Name
+const (
+	Namespace = "multicluster-system"
+	Name      = "fleet"
+)
+
+// Options configure the operator
+type Options struct {
+	// RepoDir is a directory the repository is cloned to, generated manifests are committed and pushed
+	// from it. Generation and tenant secrets are skipped if it is not set.
+	RepoDir string
+	// KeysDir is the directory the keys secret created by Install is mounted to. Keys from it override
+	// the key sources from the MultiCluster spec, which point to the files on the machine where deploy was run.
+	KeysDir string
+	// Interval between reconciliations of the fleet
+	Interval time.Duration
+	// MetricsAddr is the address of /metrics endpoint, "0" disables it
+	MetricsAddr string
+}
+
+// Reconciler continuously reconciles the fleet described by MultiCluster resource: builds kubeconfig
+// of the clusters from CAPI kubeconfig secrets, creates Flux and SOPS secrets, generates manifests
+// and reports clusters status.
+type Reconciler struct {
+	log        logr.Logger
+	opts       Options
+	client     runtimeclient.Client
+	restConfig *rest.Config
+	clientset  kubernetes.Interface
+}
+
+// Run starts the operator on the cluster of restConfig and blocks until ctx is cancelled
+func Run(ctx context.Context, log logr.Logger, restConfig *rest.Config, opts Options) error {
+	if opts.RepoDir != "" {
+		var err error
+		if opts.RepoDir, err = filepath.Abs(opts.RepoDir); err != nil {
+			return err
+		}
+		// generator and git helpers work in the repository of the current directory, it is cloned by generate
+		if err := os.MkdirAll(opts.RepoDir, 0755); err != nil {
+			return err
+		}
+		if err := os.Chdir(opts.RepoDir); err != nil {
+			return fmt.Errorf("failed to change directory to the repository: %w", err)
+		}
+	}
+
+	// writes of the manager and the clients to this cluster are recorded in the audit log
+	audit.WrapConfig(restConfig, audit.InCluster)
+
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: opts.MetricsAddr},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create manager: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	r := &Reconciler{
+		log:        log,
+		opts:       opts,
+		client:     mgr.GetClient(),
+		restConfig: restConfig,
+		clientset:  clientset,
+	}
+
+	if err := ctrl.NewControllerManagedBy(mgr).For(&v1alpha1.MultiCluster{}).Complete(r); err != nil {
+		return fmt.Errorf("failed to create controller: %w", err)
+	}
+
+	log.Info("Starting operator", "repoDir", opts.RepoDir, "interval", opts.Interval)
+	return mgr.Start(ctx)
+}
+
+// Reconcile reconciles the fleet in a span, steps of reconciliation are its child spans
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	err = tracing.Run(ctx, "reconcile", func(ctx context.Context) error {
+		result, err = r.reconcile(ctx, req)
+		return err
+	}, tracing.Namespace(req.Namespace), attribute.String("multicluster.name", req.Name))
+	return result, err
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.log.WithValues("multicluster", req.NamespacedName)
+
+	multiCluster := &v1alpha1.MultiCluster{}
+	if err := r.client.Get(ctx, req.NamespacedName, multiCluster); err != nil {
+		return ctrl.Result{}, runtimeclient.IgnoreNotFound(err)
+	}
+
+	st := &multiCluster.Status
+	st.ObservedGeneration = multiCluster.Generation
+	// each step sets its condition, its duration and failure are recorded in metrics and it is traced
+	step := func(conditionType string, f func(ctx context.Context) error) bool {
+		err := metrics.Phase("reconcile", conditionType, func() error {
+			return tracing.Run(ctx, "reconcile "+conditionType, f)
+		})
+		condition := metav1.Condition{Type: conditionType, Status: metav1.ConditionTrue, Reason: "Succeeded"}
+		if err != nil {
+			condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, "Failed", err.Error()
+			log.Error(err, "Reconciliation step failed", "condition", conditionType)
+		}
+		condition.ObservedGeneration = st.ObservedGeneration
+		meta.SetStatusCondition(&st.Conditions, condition)
+		return err == nil
+	}
+
+	var cfg *config.Config
+	ok := step(v1alpha1.ConditionConfigValid, func(context.Context) (err error) {
+		cfg, err = r.loadConfig(multiCluster.Spec.DeepCopy())
+		return err
+	})
+	if ok {
+		ok = step(v1alpha1.ConditionKubeconfig, func(ctx context.Context) error { return r.syncKubeconfig(ctx, cfg) }) && ok
+		if r.opts.RepoDir != "" {
+			ok = step(v1alpha1.ConditionGenerated, func(ctx context.Context) error { return r.generate(ctx, cfg, multiCluster) }) && ok
+		}
+		// tenants of the clusters are read from the checkout updated by generate
+		ok = step(v1alpha1.ConditionSecrets, func(context.Context) error { return r.syncSecrets(cfg) }) && ok
+
+		names := []string{}
+		for _, cluster := range cfg.Clusters {
+			if cluster.Provider != "kind" {
+				names = append(names, cluster.Name)
+			}
+		}
+		ok = step(v1alpha1.ConditionClustersReady, func(ctx context.Context) (err error) {
+			if st.Clusters, err = status.Collect(ctx, r.client, names); err != nil {
+				return err
+			}
+			metrics.SetClusters(st.Clusters)
+			if !status.AllReady(st.Clusters) {
+				return fmt.Errorf("not all clusters are ready")
+			}
+			return nil
+		}) && ok
+	}
+
+	ready := metav1.Condition{Type: v1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: "Succeeded", ObservedGeneration: st.ObservedGeneration}
+	if !ok {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "NotReady", "see other conditions"
+	}
+	meta.SetStatusCondition(&st.Conditions, ready)
+
+	if err := r.client.Status().Update(ctx, multiCluster); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update status: %w", err)
+	}
+
+	return ctrl.Result{RequeueAfter: r.opts.Interval}, nil
+}
+
+// loadConfig converts the spec to config and points key sources of this cluster to the mounted keys
+func (r *Reconciler) loadConfig(spec *v1alpha1.MultiClusterSpec) (*config.Config, error) {
+	// kubeconfig is built by the operator, see syncKubeconfig, its path is set in K8S_MULTI_KUBECONFIG
+	spec.KubeconfigPath = ""
+	cfg, err := config.FromSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.opts.KeysDir == "" {
+		return cfg, nil
+	}
+	// keys are copied to the keys secret by createKeysSecret
+	keySource := func(key string) config.SecretSourceConfig {
+		return config.SecretSourceConfig{Type: config.SecretSourceFile, Path: filepath.Join(r.opts.KeysDir, key)}
+	}
+	cfg.SOPS.AgeKeyPath = filepath.Join(r.opts.KeysDir, ageKey)
+	for i := range cfg.Clusters {
+		cluster := &cfg.Clusters[i]
+		if cluster.Provider != "kind" {
+			cluster.Flux.KeySource = keySource(fluxKey(cluster.Name))
+			cluster.Flux.KeyPath = cluster.Flux.KeySource.Path
+		}
+	}
+	for i := range cfg.Tenants {
+		if cfg.Tenants[i].KeySource.Type != "" {
+			cfg.Tenants[i].KeySource = keySource(tenantKey(cfg.Tenants[i].Name))
+		}
+	}
+	return cfg, nil
+}
+
+// syncKubeconfig writes kubeconfig with contexts of all clusters from the kubeconfig secrets created
+// by CAPI, so that clusters are accessed by name like on the machine where deploy was run
+func (r *Reconciler) syncKubeconfig(ctx context.Context, cfg *config.Config) error {
+	kubeconfig := clientcmdapi.NewConfig()
+	for _, cluster := range cfg.Clusters {
+		if cluster.Provider == "kind" {
+			continue
+		}
+		secret, err := r.clientset.CoreV1().Secrets(cluster.Name).Get(ctx, cluster.Name+"-kubeconfig", metav1.GetOptions{})
+		if err != nil {
+			if runtimeclient.IgnoreNotFound(err) == nil {
+				r.log.Info("Kubeconfig secret does not exist yet", "cluster", cluster.Name)
+				continue
+			}
+			return fmt.Errorf("failed to get kubeconfig of %s: %w", cluster.Name, err)
+		}
+		clusterConfig, err := clientcmd.Load(secret.Data["value"])
+		if err != nil {
+			return fmt.Errorf("failed to parse kubeconfig of %s: %w", cluster.Name, err)
+		}
+		for key, value := range clusterConfig.Clusters {
+			kubeconfig.Clusters[key] = value
+		}
+		for key, value := range clusterConfig.Contexts {
+			kubeconfig.Contexts[key] = value
+		}
+		for key, value := range clusterConfig.AuthInfos {
+			kubeconfig.AuthInfos[key] = value
+		}
+	}
+	return clientcmd.WriteToFile(*kubeconfig, cfg.KubeconfigPath)
+}
+
+// syncSecrets creates or updates the Flux secret and the SOPS decryption secrets on this cluster, and
+// the Flux secret and tenant secrets on the workload clusters which are running
+func (r *Reconciler) syncSecrets(cfg *config.Config) error {
+	for _, cluster := range cfg.Clusters {
+		if cluster.Provider == "kind" {
+			continue
+		}
+		clusterAuth := &k8sclient.ClusterAuthInfo{ClusterName: cluster.Name, Config: r.restConfig}
+		var err error
+		if cluster.ManagementCluster == "" {
+			if clusterAuth.Clientset, err = kubernetes.NewForConfig(r.restConfig); err != nil {
+				return err
+			}
+		} else if clusterAuth, err = utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, cluster.Name); err != nil {
+			// kubeconfig is written by syncKubeconfig, clusters without kubeconfig secret are not running yet
+			r.log.Info("Skipping secrets of cluster, it is not available", "cluster", cluster.Name, "reason", err.Error())
+			continue
+		}
+
+		flux, err := fluxcd.NewFluxCD(r.log, cluster.Flux, cfg.Github, clusterAuth)
+		if err != nil {
+			return err
+		}
+		if err := flux.CreateFluxSystemSecret(); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+
+		if cluster.ManagementCluster == "" {
+			if cfg.SOPS.Enabled {
+				namespaces := append([]string{config.FluxNamespace}, cfg.ManagedClusters(cluster.Name)...)
+				if err := sops.NewSOPS(r.log, cfg.SOPS).CreateDecryptionSecret(clusterAuth.Clientset, namespaces...); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !r.hasCheckout() {
+			continue
+		}
+		tenants, err := generator.NewGenerator(r.log, cfg).ClusterTenants(&cluster)
+		if err != nil {
+			return err
+		}
+		if err := tenant.CreateSecrets(r.log, cfg, clusterAuth.Clientset, tenants); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+// hasCheckout reports whether the repository is cloned to RepoDir
+func (r *Reconciler) hasCheckout() bool {
+	if r.opts.RepoDir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(r.opts.RepoDir, ".git"))
+	return err == nil
+}
+
+// checkout clones the repository to RepoDir, RepoDir is an empty volume when the pod starts, or updates it
+func (r *Reconciler) checkout(cfg *config.Config) error {
+	command, args := "pull", []string{"-C", r.opts.RepoDir, "pull", "--ff-only", "origin", cfg.Github.Branch}
+	if !r.hasCheckout() {
+		r.log.Info("Cloning repository", "url", cfg.Github.URL, "branch", cfg.Github.Branch)
+		command, args = "clone", []string{"clone", "--branch", cfg.Github.Branch, cfg.Github.URL, r.opts.RepoDir}
+	}
+	if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s failed: %w: %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// generate regenerates cluster manifests in the repository and pushes them if they changed
+func (r *Reconciler) generate(ctx context.Context, cfg *config.Config, multiCluster *v1alpha1.MultiCluster) error {
+	if err := r.checkout(cfg); err != nil {
+		return err
+	}
+
+	if err := r.restoreIPAMState(ctx, cfg); err != nil {
+		return err
+	}
+	_, assignErr := ipam.AssignCIDRs(r.log, cfg)
+	// allocations are saved even if assignment of some clusters failed
+	if err := r.saveIPAMState(ctx, cfg); err != nil {
+		return err
+	}
+	if assignErr != nil {
+		return assignErr
+	}
+	if err := generator.NewGenerator(r.log, cfg).Generate(); err != nil {
+		return err
+	}
+
+	changed, err := utils.GitHasChanges("clusters")
+	if err != nil || !changed {
+		return err
+	}
+
+	message := fmt.Sprintf("Generate cluster manifests from %s/%s generation %d", multiCluster.Namespace, multiCluster.Name, multiCluster.Generation)
+	sha, err := utils.GitCommitAndPush([]string{"clusters"}, message, cfg.Github.Branch)
+	if err != nil {
+		return err
+	}
+	r.log.Info("Pushed generated manifests", "revision", sha)
+	return nil
+}
+
+// restoreIPAMState writes IPAM state of the operator from its configmap to IPAM state path
+func (r *Reconciler) restoreIPAMState(ctx context.Context, cfg *config.Config) error {
+	configMap, err := r.clientset.CoreV1().ConfigMaps(Namespace).Get(ctx, ipamConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// nothing is allocated yet, a stale state file is removed
+		if err := os.Remove(cfg.IPAM.StatePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get IPAM state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.IPAM.StatePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(cfg.IPAM.StatePath, []byte(configMap.Data[ipamStateKey]), 0644)
+}
+
+// saveIPAMState stores IPAM state written by ipam.AssignCIDRs in the configmap
+func (r *Reconciler) saveIPAMState(ctx context.Context, cfg *config.Config) error {
+	state, err := os.ReadFile(cfg.IPAM.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read IPAM state: %w", err)
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ipamConfigMapName, Namespace: Namespace},
+		Data:       map[string]string{ipamStateKey: string(state)},
+	}
+	configMaps := r.clientset.CoreV1().ConfigMaps(Namespace)
+	_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save IPAM state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package operator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+const spec = `
+apiVersion: multicluster.olga-mir.github.io/v1alpha1
+kind: MultiCluster
+metadata:
+  name: fleet
+spec:
+  clusters:
+    - name: "cluster-mgmt"
+      provider: "aws"
+      flux:
+        keyPath: "/home/user/.ssh/flux"
+    - name: "cluster-01"
+      managementCluster: "cluster-mgmt"
+      provider: "aws"
+      flux:
+        keyPath: "/home/user/.ssh/flux"
+  github:
+    user: "olga-mir"
+    repoName: "k8s-multi-cluster"
+  sops:
+    enabled: true
+  tenants:
+    - name: "team-1"
+      keySource:
+        type: "command"
+        command: ["op", "read", "op://team-1/key"]
+    - name: "team-2"
+`
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("K8S_MULTI_KUBECONFIG", filepath.Join(t.TempDir(), "kubeconfig"))
+	multiCluster, err := v1alpha1.Load([]byte(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Reconciler{log: logr.Discard(), opts: Options{KeysDir: "/etc/multicluster/keys"}}
+	cfg, err := r.loadConfig(multiCluster.Spec.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cluster := range cfg.Clusters {
+		if cluster.Provider == "kind" {
+			continue
+		}
+		want := config.SecretSourceConfig{Type: config.SecretSourceFile, Path: "/etc/multicluster/keys/flux-" + cluster.Name}
+		if !reflect.DeepEqual(cluster.Flux.KeySource, want) || cluster.Flux.KeyPath != want.Path {
+			t.Errorf("%s Flux key = %+v, %s, want %+v", cluster.Name, cluster.Flux.KeySource, cluster.Flux.KeyPath, want)
+		}
+	}
+	if got := cfg.Tenant("team-1").KeySource; got.Type != config.SecretSourceFile || got.Path != "/etc/multicluster/keys/tenant-team-1" {
+		t.Errorf("team-1 key source = %+v, want the mounted key", got)
+	}
+	if got := cfg.Tenant("team-2").KeySource; got.Type != "" {
+		t.Errorf("team-2 key source = %+v, want none", got)
+	}
+	if cfg.SOPS.AgeKeyPath != "/etc/multicluster/keys/age.agekey" {
+		t.Errorf("age key path = %s, want the mounted key", cfg.SOPS.AgeKeyPath)
+	}
+
+	// key sources of the spec are kept without keys dir
+	r.opts.KeysDir = ""
+	if cfg, err = r.loadConfig(multiCluster.Spec.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Tenant("team-1").KeySource; got.Type != config.SecretSourceCommand {
+		t.Errorf("team-1 key source = %+v, want the source from the spec", got)
+	}
+}
+
+func TestSetImage(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "Deployment",
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "operator", "image": "multicluster-demo:latest"}},
+		}}},
+	}}
+	if err := setImage("ghcr.io/olga-mir/multicluster-demo:v2")(deployment); err != nil {
+		t.Fatal(err)
+	}
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	if image := containers[0].(map[string]interface{})["image"]; image != "ghcr.io/olga-mir/multicluster-demo:v2" {
+		t.Errorf("image = %v, want the configured image", image)
+	}
+
+	namespace := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Namespace"}}
+	if err := setImage("image")(namespace); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(namespace.Object, "spec"); found {
+		t.Errorf("namespace is modified: %v", namespace.Object)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCreateKeysSecret(t *testing.T) {
+	fileSource := func(path string) config.SecretSourceConfig {
+		return config.SecretSourceConfig{Type: config.SecretSourceFile, Path: path}
+	}
+	cfg := &config.Config{
+		Clusters: []config.ClusterConfig{
+			{Name: "kind", Provider: "kind"},
+			{Name: "cluster-mgmt", Provider: "aws", Flux: config.FluxConfig{KeySource: fileSource(writeFile(t, "mgmt", "mgmt-key"))}},
+			{Name: "cluster-01", Provider: "aws", ManagementCluster: "cluster-mgmt", Flux: config.FluxConfig{KeySource: fileSource(writeFile(t, "01", "01-key"))}},
+		},
+		Tenants: []config.TenantConfig{
+			{Name: "team-1", KeySource: fileSource(writeFile(t, "team-1", "team-1-key"))},
+			{Name: "team-2"},
+		},
+		SOPS: config.SOPSConfig{Enabled: true, AgeKeyPath: writeFile(t, "age", "age-key")},
+	}
+	// keys are rotated in the existing secret
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: keysSecretName, Namespace: Namespace},
+		Data:       map[string][]byte{"identity": []byte("stale")},
+	})
+
+	if err := createKeysSecret(clientset, cfg); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := clientset.CoreV1().Secrets(Namespace).Get(context.TODO(), keysSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"identity":          "mgmt-key",
+		"known_hosts":       config.GithubKnownHosts,
+		"flux-cluster-mgmt": "mgmt-key",
+		"flux-cluster-01":   "01-key",
+		"tenant-team-1":     "team-1-key",
+		"age.agekey":        "age-key",
+	}
+	if len(secret.Data) != len(want) {
+		t.Errorf("secret keys = %d, want %d", len(secret.Data), len(want))
+	}
+	for key, value := range want {
+		if got := string(secret.Data[key]); got != value {
+			t.Errorf("secret key %s = %q, want %q", key, got, value)
+		}
+	}
+
+	cfg.Tenants[0].KeySource.Path = filepath.Join(t.TempDir(), "missing")
+	if err := createKeysSecret(clientset, cfg); err == nil {
+		t.Error("createKeysSecret() with missing tenant key error = nil, want error")
+	}
+}
+
+func TestIPAMState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "ipam", "ipam.json")
+	cfg := &config.Config{IPAM: config.IPAMConfig{StatePath: statePath}}
+	clientset := fake.NewSimpleClientset()
+	r := &Reconciler{log: logr.Discard(), clientset: clientset}
+	ctx := context.TODO()
+
+	// nothing to seed without local state
+	if err := seedIPAMState(clientset, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.restoreIPAMState(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("state file is restored without configmap: %v", err)
+	}
+
+	// local state is seeded once, the operator state is not overwritten
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(statePath, []byte(`{"local":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedIPAMState(clientset, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(statePath, []byte(`{"operator":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.saveIPAMState(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedIPAMState(clientset, &config.Config{IPAM: config.IPAMConfig{StatePath: writeFile(t, "ipam.json", `{"local":true}`)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pod restarts with an empty file system
+	if err := os.RemoveAll(filepath.Dir(statePath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.restoreIPAMState(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"operator":true}` {
+		t.Errorf("restored state = %s, want the state saved by the operator", data)
+	}
+}
+
+func TestSyncKubeconfig(t *testing.T) {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["cluster-01"] = &clientcmdapi.Cluster{Server: "https://cluster-01:6443"}
+	kubeconfig.AuthInfos["cluster-01-admin"] = &clientcmdapi.AuthInfo{Token: "token"}
+	kubeconfig.Contexts["cluster-01-admin@cluster-01"] = &clientcmdapi.Context{Cluster: "cluster-01", AuthInfo: "cluster-01-admin"}
+	value, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// kubeconfig secret of cluster-02 is not created yet
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-01-kubeconfig", Namespace: "cluster-01"},
+		Data:       map[string][]byte{"value": value},
+	})
+	cfg := &config.Config{
+		KubeconfigPath: filepath.Join(t.TempDir(), "kubeconfig"),
+		Clusters: []config.ClusterConfig{
+			{Name: "kind", Provider: "kind"},
+			{Name: "cluster-01", Provider: "aws"},
+			{Name: "cluster-02", Provider: "aws"},
+		},
+	}
+
+	r := &Reconciler{log: logr.Discard(), clientset: clientset}
+	if err := r.syncKubeconfig(context.TODO(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	written, err := clientcmd.LoadFromFile(cfg.KubeconfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Contexts) != 1 || written.Contexts["cluster-01-admin@cluster-01"] == nil {
+		t.Errorf("kubeconfig contexts = %v, want cluster-01-admin@cluster-01", written.Contexts)
+	}
+	if cluster := written.Clusters["cluster-01"]; cluster == nil || cluster.Server != "https://cluster-01:6443" {
+		t.Errorf("kubeconfig cluster-01 = %v", cluster)
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	multiCluster := &v1alpha1.MultiCluster{ObjectMeta: metav1.ObjectMeta{Namespace: Namespace, Name: Name}}
+	meta.SetStatusCondition(&multiCluster.Status.Conditions, metav1.Condition{Type: v1alpha1.ConditionReady, Status: metav1.ConditionFalse, Reason: "NotReady", Message: "see other conditions"})
+	client := fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(multiCluster).Build()
+
+	err := waitForReady(context.TODO(), logr.Discard(), client, 0)
+	if err == nil || !strings.Contains(err.Error(), "see other conditions") {
+		t.Errorf("waitForReady() error = %v, want timeout with the Ready message", err)
+	}
+
+	meta.SetStatusCondition(&multiCluster.Status.Conditions, metav1.Condition{Type: v1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: "Succeeded"})
+	client = fakeclient.NewClientBuilder().WithScheme(scheme).WithObjects(multiCluster).Build()
+	if err := waitForReady(context.TODO(), logr.Discard(), client, 0); err != nil {
+		t.Errorf("waitForReady() error = %v, want nil", err)
+	}
+}
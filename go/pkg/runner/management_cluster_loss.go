@@ -0,0 +1,295 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/backup"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/capi"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/sops"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const managementClusterLoss = "management_cluster_loss"
+
+// ManagementClusterLossOptions configure the management cluster loss scenario
+type ManagementClusterLossOptions struct {
+	// LostCluster is the permanent management cluster which is lost
+	LostCluster string
+	// NewManagementCluster is provisioned by the new kind cluster and takes over the workload clusters.
+	// Its manifests must be generated to the kind cluster path in the repository.
+	NewManagementCluster string
+	// BackupDir is where the backup of the lost cluster is saved
+	BackupDir string
+}
+
+// ManagementClusterLoss rehearses recovery from a loss of the permanent management cluster:
+//  1. the lost cluster is backed up
+//  2. it is disconnected: its Flux is suspended and Cluster API controllers are scaled to 0
+//  3. a new kind cluster is created and bootstrapped
+//  4. Cluster API objects are restored to it from the backup
+//  5. kind provisions the new management cluster and the workload clusters are pivoted to it
+//
+// Then the workload clusters must become ready on the new management cluster with the same Machines as
+// before, a Machine which was deleted, created or got a new provider ID fails the scenario.
+// The lost cluster is left on the kind cluster, so that it can be deleted by Cluster API.
+func ManagementClusterLoss(log logr.Logger, cfg *config.Config, opts ManagementClusterLossOptions) error {
+	return metrics.Operation(managementClusterLoss, func() error {
+		return tracing.Run(context.Background(), "scenario management cluster loss", func(ctx context.Context) error {
+			return runManagementClusterLoss(ctx, log, cfg, opts)
+		}, tracing.Cluster(opts.LostCluster))
+	})
+}
+
+// runStep runs a step of the scenario in a span, its duration and failure are recorded in metrics
+func runStep(ctx context.Context, step string, f func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	return metrics.Phase(managementClusterLoss, step, func() error {
+		return tracing.Run(ctx, "scenario "+step, f, attrs...)
+	})
+}
+
+func runManagementClusterLoss(ctx context.Context, log logr.Logger, cfg *config.Config, opts ManagementClusterLossOptions) error {
+	newCluster, err := validateManagementClusterLoss(cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	lostAuth, err := utils.GetClusterAuthInfoByName(cfg.KubeconfigPath, opts.LostCluster)
+	if err != nil {
+		return err
+	}
+	lostCAPI, err := capi.NewClusterAPI(log, lostAuth, cfg)
+	if err != nil {
+		return fmt.Errorf("error creating Cluster API client: %w", err)
+	}
+
+	// the lost cluster manages itself, its own objects are in the namespace named after it
+	namespaces, err := lostCAPI.ClusterNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	workloadNamespaces := slices.DeleteFunc(slices.Clone(namespaces), func(namespace string) bool {
+		return namespace == lostAuth.ClusterName
+	})
+	machinesBefore, err := lostCAPI.MachineProviderIDs(ctx, workloadNamespaces...)
+	if err != nil {
+		return err
+	}
+	for machine, providerID := range machinesBefore {
+		if providerID == "" {
+			return fmt.Errorf("machine %s is not provisioned, the scenario needs a healthy fleet", machine)
+		}
+	}
+	log.Info("Recorded Machines of workload clusters", "namespaces", workloadNamespaces, "machines", len(machinesBefore))
+
+	var backupPath string
+	err = runStep(ctx, "backup", func(ctx context.Context) error {
+		backupPath, err = backup.Backup(ctx, log, cfg, opts.LostCluster, opts.BackupDir, false)
+		return err
+	}, tracing.Cluster(opts.LostCluster))
+	if err != nil {
+		return fmt.Errorf("error backing up %s: %w", opts.LostCluster, err)
+	}
+
+	err = runStep(ctx, "disconnect", func(ctx context.Context) error {
+		lostFluxCD, err := fluxcd.NewFluxCD(log, cfg.Cluster(opts.LostCluster).Flux, cfg.Github, lostAuth)
+		if err != nil {
+			return fmt.Errorf("error creating FluxCD client: %w", err)
+		}
+		if err := lostFluxCD.SuspendKustomization("flux-system"); err != nil {
+			return err
+		}
+		return lostCAPI.ScaleProviders(ctx, 0)
+	}, tracing.Cluster(opts.LostCluster))
+	if err != nil {
+		return fmt.Errorf("error disconnecting %s: %w", opts.LostCluster, err)
+	}
+	log.Info("Management cluster is disconnected, it doesn't reconcile Cluster API objects", "cluster", opts.LostCluster)
+
+	// Cluster API objects are restored before Flux is bootstrapped on kind, so that kind adopts the clusters
+	// which Flux applies from the repository instead of creating them
+	err = runStep(ctx, "restore", func(ctx context.Context) error {
+		_, err := backup.Restore(ctx, log, cfg, backupPath, backup.RestoreOptions{Target: config.DefaultKindClusterName, SkipFlux: true})
+		return err
+	}, tracing.Cluster(config.DefaultKindClusterName))
+	if err != nil {
+		return fmt.Errorf("error restoring %s to kind cluster: %w", opts.LostCluster, err)
+	}
+
+	kindAuth, err := k8sclient.GetKubernetesClient(cfg.KubeconfigPath, config.DefaultKindClusterCtxName, config.DefaultKindClusterName)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client for kind cluster: %w", err)
+	}
+	kindCAPI, err := capi.NewClusterAPI(log, kindAuth, cfg)
+	if err != nil {
+		return fmt.Errorf("error creating Cluster API client: %w", err)
+	}
+	kindFluxCD, err := fluxcd.NewFluxCD(log, cfg.Cluster(config.DefaultKindClusterName).Flux, cfg.Github, kindAuth)
+	if err != nil {
+		return fmt.Errorf("error creating FluxCD client: %w", err)
+	}
+
+	var decryption *sops.SOPS
+	if cfg.SOPS.Enabled {
+		decryption = sops.NewSOPS(log, cfg.SOPS)
+		namespaces := append([]string{config.FluxNamespace}, cfg.ManagedClusters(config.DefaultKindClusterName)...)
+		if err := decryption.CreateDecryptionSecret(kindAuth.Clientset, namespaces...); err != nil {
+			return fmt.Errorf("error creating SOPS decryption secret: %w", err)
+		}
+	}
+
+	err = runStep(ctx, "bootstrap", func(ctx context.Context) error {
+		if err := kindFluxCD.InstallFluxCD(ctx); err != nil {
+			return fmt.Errorf("error installing FluxCD: %w", err)
+		}
+		return kindFluxCD.WaitForFluxResources(ctx)
+	}, tracing.Cluster(config.DefaultKindClusterName))
+	if err != nil {
+		return err
+	}
+
+	newName := utils.ClusterNameDataFromName(newCluster.Name).Name
+	newAuth := &k8sclient.ClusterAuthInfo{}
+	err = runStep(ctx, "new_mgmt_cluster", func(ctx context.Context) error {
+		if err := kindCAPI.WaitForWorkloadClusterFullyRunning(ctx, newName, generator.HelmChartProxyNames(cfg, newCluster)...); err != nil {
+			return err
+		}
+		if err := kindCAPI.GetClusterAuthInfoForWorkloadCluster(ctx, newAuth, newName); err != nil {
+			return err
+		}
+		newCAPI, err := capi.NewClusterAPI(log, newAuth, cfg)
+		if err != nil {
+			return fmt.Errorf("error creating Cluster API client: %w", err)
+		}
+		if err := newCAPI.InstallClusterAPI(ctx); err != nil {
+			return fmt.Errorf("error installing Cluster API: %w", err)
+		}
+		return newCAPI.CreateAWSIdentitySecrets(cfg.AWSIdentities)
+	}, tracing.Cluster(newCluster.Name))
+	if err != nil {
+		return fmt.Errorf("error provisioning %s: %w", newCluster.Name, err)
+	}
+
+	// Flux on kind would recreate objects which are moved off it
+	if err := kindFluxCD.SuspendKustomization("flux-system"); err != nil {
+		return fmt.Errorf("error suspending kustomization flux-system: %w", err)
+	}
+
+	err = runStep(ctx, "pivot", func(ctx context.Context) error {
+		if err := kindCAPI.PivotCluster(ctx, newAuth); err != nil {
+			return err
+		}
+		for _, namespace := range workloadNamespaces {
+			if err := kindCAPI.MoveNamespace(ctx, newAuth, namespace); err != nil {
+				return err
+			}
+		}
+		newFluxCD, err := fluxcd.NewFluxCD(log, newCluster.Flux, cfg.Github, newAuth)
+		if err != nil {
+			return fmt.Errorf("error creating FluxCD client: %w", err)
+		}
+		if err := newFluxCD.CreateFluxSystemSecret(); err != nil {
+			return err
+		}
+		if decryption != nil {
+			namespaces := append([]string{config.FluxNamespace}, workloadNamespaces...)
+			return decryption.CreateDecryptionSecret(newAuth.Clientset, namespaces...)
+		}
+		return nil
+	}, tracing.Cluster(newCluster.Name))
+	if err != nil {
+		return fmt.Errorf("error pivoting to %s: %w", newCluster.Name, err)
+	}
+
+	err = runStep(ctx, "verify", func(ctx context.Context) error {
+		newCAPI, err := capi.NewClusterAPI(log, newAuth, cfg)
+		if err != nil {
+			return fmt.Errorf("error creating Cluster API client: %w", err)
+		}
+		for _, namespace := range workloadNamespaces {
+			var helmChartProxies []string
+			if cluster := cfg.Cluster(namespace); cluster != nil {
+				helmChartProxies = generator.HelmChartProxyNames(cfg, cluster)
+			}
+			if err := newCAPI.WaitForWorkloadClusterFullyRunning(ctx, utils.ClusterNameDataFromName(namespace).Name, helmChartProxies...); err != nil {
+				return err
+			}
+		}
+		machinesAfter, err := newCAPI.MachineProviderIDs(ctx, workloadNamespaces...)
+		if err != nil {
+			return err
+		}
+		if changes := machineChanges(machinesBefore, machinesAfter); len(changes) > 0 {
+			return fmt.Errorf("machines are recreated: %s", strings.Join(changes, "; "))
+		}
+		return nil
+	}, tracing.Cluster(newCluster.Name))
+	if err != nil {
+		return fmt.Errorf("workload clusters are not adopted by %s: %w", newCluster.Name, err)
+	}
+
+	log.Info("Workload clusters are adopted by the new management cluster without recreating Machines",
+		"managementCluster", newCluster.Name, "clusters", workloadNamespaces, "machines", len(machinesBefore))
+	log.Info("Lost management cluster is managed by the kind cluster, delete its Cluster there to remove its infrastructure",
+		"cluster", opts.LostCluster, "namespace", lostAuth.ClusterName)
+	return nil
+}
+
+// validateManagementClusterLoss checks the options before anything is changed and returns config of the new
+// management cluster
+func validateManagementClusterLoss(cfg *config.Config, opts ManagementClusterLossOptions) (*config.ClusterConfig, error) {
+	if cfg.Cluster(opts.LostCluster) == nil {
+		return nil, fmt.Errorf("cluster %s is not defined in config", opts.LostCluster)
+	}
+	newCluster := cfg.Cluster(opts.NewManagementCluster)
+	if newCluster == nil {
+		return nil, fmt.Errorf("cluster %s is not defined in config", opts.NewManagementCluster)
+	}
+	if newCluster.Name == opts.LostCluster {
+		return nil, fmt.Errorf("new management cluster must be different from the lost cluster %s", opts.LostCluster)
+	}
+	if newCluster.Provider == "kind" || newCluster.ManagedBy() != config.DefaultKindClusterName {
+		return nil, fmt.Errorf("new management cluster %s must be provisioned by the kind cluster", newCluster.Name)
+	}
+	exists, err := utils.HasContext(cfg.KubeconfigPath, config.DefaultKindClusterCtxName)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("kind cluster exists, the scenario bootstraps a new one, delete it with `kind delete cluster --name %s`", config.DefaultKindClusterName)
+	}
+	return newCluster, nil
+}
+
+// machineChanges compares Machines by provider ID and describes the ones which were deleted, created or replaced
+func machineChanges(before, after map[string]string) []string {
+	var changes []string
+	for machine, providerID := range before {
+		afterID, ok := after[machine]
+		switch {
+		case !ok:
+			changes = append(changes, machine+" is deleted")
+		case afterID != providerID:
+			changes = append(changes, fmt.Sprintf("%s provider ID changed from %s to %s", machine, providerID, afterID))
+		}
+	}
+	for machine := range after {
+		if _, ok := before[machine]; !ok {
+			changes = append(changes, machine+" is created")
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMachineChanges(t *testing.T) {
+	before := map[string]string{
+		"cluster-01/cp-1":     "aws:///ap-southeast-2a/i-01",
+		"cluster-01/worker-1": "aws:///ap-southeast-2a/i-02",
+		"cluster-02/cp-1":     "aws:///ap-southeast-2b/i-03",
+	}
+	if changes := machineChanges(before, before); len(changes) != 0 {
+		t.Errorf("machineChanges() of the same machines = %v", changes)
+	}
+
+	after := map[string]string{
+		"cluster-01/cp-1":     "aws:///ap-southeast-2a/i-01",
+		"cluster-01/worker-1": "aws:///ap-southeast-2a/i-04",
+		"cluster-02/cp-2":     "aws:///ap-southeast-2b/i-05",
+	}
+	want := []string{
+		"cluster-01/worker-1 provider ID changed from aws:///ap-southeast-2a/i-02 to aws:///ap-southeast-2a/i-04",
+		"cluster-02/cp-1 is deleted",
+		"cluster-02/cp-2 is created",
+	}
+	if changes := machineChanges(before, after); !reflect.DeepEqual(changes, want) {
+		t.Errorf("machineChanges() = %v, want %v", changes, want)
+	}
+}
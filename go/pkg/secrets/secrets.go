@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// Source provides a secret value, e.g. a private key
+type Source interface {
+	Get() ([]byte, error)
+	// String describes where the secret is read from, it never contains the secret
+	String() string
+}
+
+// NewSource returns the source for the config, see config.SecretSourceConfig
+func NewSource(cfg config.SecretSourceConfig) (Source, error) {
+	switch cfg.Type {
+	case config.SecretSourceFile:
+		return fileSource{path: cfg.Path}, nil
+	case config.SecretSourceEnv:
+		return envSource{name: cfg.Env}, nil
+	case config.SecretSourceCommand:
+		return commandSource{command: cfg.Command}, nil
+	case config.SecretSourceKubernetes:
+		return kubernetesSource{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("unsupported secret source type %q", cfg.Type)
+	}
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) Get() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s, err)
+	}
+	return data, nil
+}
+
+func (s fileSource) String() string {
+	return "file " + s.path
+}
+
+type envSource struct {
+	name string
+}
+
+func (s envSource) Get() ([]byte, error) {
+	value, ok := os.LookupEnv(s.name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("%s is not set", s)
+	}
+	return []byte(value), nil
+}
+
+func (s envSource) String() string {
+	return "environment variable " + s.name
+}
+
+// commandSource runs the command without a shell and uses its stdout
+type commandSource struct {
+	command []string
+}
+
+func (s commandSource) Get() ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s: %w", s, strings.TrimSpace(stderr.String()), err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s returned nothing", s)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s commandSource) String() string {
+	return "command " + s.command[0]
+}
+
+// kubernetesSource reads a key of a secret on a cluster from config, usually the management cluster
+type kubernetesSource struct {
+	cfg config.SecretSourceConfig
+}
+
+func (s kubernetesSource) Get() ([]byte, error) {
+	clusterAuth, err := utils.GetClusterAuthInfoByName(s.cfg.KubeconfigPath, s.cfg.Cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cluster %s: %w", s.cfg.Cluster, err)
+	}
+
+	secret, err := clusterAuth.Clientset.CoreV1().Secrets(s.cfg.Namespace).Get(context.TODO(), s.cfg.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", s, err)
+	}
+	value, ok := secret.Data[s.cfg.Key]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("%s has no key %s", s, s.cfg.Key)
+	}
+	return value, nil
+}
+
+func (s kubernetesSource) String() string {
+	return fmt.Sprintf("secret %s/%s on cluster %s", s.cfg.Namespace, s.cfg.Name, s.cfg.Cluster)
+}
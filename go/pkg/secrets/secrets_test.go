@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "identity")
+	if err := os.WriteFile(keyPath, []byte("private key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MULTICLUSTER_TEST_KEY", "env key")
+	t.Setenv("MULTICLUSTER_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		cfg     config.SecretSourceConfig
+		want    string
+		wantErr string
+	}{
+		{"file", config.SecretSourceConfig{Type: config.SecretSourceFile, Path: keyPath}, "private key\n", ""},
+		{"missing file", config.SecretSourceConfig{Type: config.SecretSourceFile, Path: filepath.Join(dir, "missing")}, "", "failed to read file"},
+		{"env", config.SecretSourceConfig{Type: config.SecretSourceEnv, Env: "MULTICLUSTER_TEST_KEY"}, "env key", ""},
+		{"empty env", config.SecretSourceConfig{Type: config.SecretSourceEnv, Env: "MULTICLUSTER_TEST_EMPTY"}, "", "environment variable MULTICLUSTER_TEST_EMPTY is not set"},
+		{"unset env", config.SecretSourceConfig{Type: config.SecretSourceEnv, Env: "MULTICLUSTER_TEST_UNSET"}, "", "environment variable MULTICLUSTER_TEST_UNSET is not set"},
+		{"command", config.SecretSourceConfig{Type: config.SecretSourceCommand, Command: []string{"echo", "command key"}}, "command key\n", ""},
+		{"command is not run in a shell", config.SecretSourceConfig{Type: config.SecretSourceCommand, Command: []string{"echo", "$HOME"}}, "$HOME\n", ""},
+		{"failing command", config.SecretSourceConfig{Type: config.SecretSourceCommand, Command: []string{"sh", "-c", "echo denied >&2; exit 1"}}, "", "command sh failed: denied"},
+		{"command without output", config.SecretSourceConfig{Type: config.SecretSourceCommand, Command: []string{"true"}}, "", "command true returned nothing"},
+		{"missing command", config.SecretSourceConfig{Type: config.SecretSourceCommand, Command: []string{filepath.Join(dir, "missing")}}, "", "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewSource(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := source.Get()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSourceUnsupported(t *testing.T) {
+	if _, err := NewSource(config.SecretSourceConfig{Type: "vault"}); err == nil {
+		t.Error("expected error for unsupported source type")
+	}
+}
+
+func TestSourceString(t *testing.T) {
+	tests := []struct {
+		cfg  config.SecretSourceConfig
+		want string
+	}{
+		{config.SecretSourceConfig{Type: config.SecretSourceFile, Path: "/keys/identity"}, "file /keys/identity"},
+		{config.SecretSourceConfig{Type: config.SecretSourceEnv, Env: "FLUX_KEY"}, "environment variable FLUX_KEY"},
+		{config.SecretSourceConfig{Type: config.SecretSourceCommand, Command: []string{"op", "read", "op://vault/flux/key"}}, "command op"},
+		{config.SecretSourceConfig{Type: config.SecretSourceKubernetes, Cluster: "cluster-mgmt", Namespace: "flux-system", Name: "flux-system"}, "secret flux-system/flux-system on cluster cluster-mgmt"},
+	}
+	for _, tt := range tests {
+		source, err := NewSource(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := source.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+// Job states
+const (
+	JobPending   = "Pending"
+	JobRunning   = "Running"
+	JobSucceeded = "Succeeded"
+	JobFailed    = "Failed"
+)
+
+// JobFunc runs a job of a type, e.g. deployer.Deploy for "deploy"
+type JobFunc func(log logr.Logger, cfg *config.Config) error
+
+// Job is an asynchronous run of deploy or uninstall
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	logs *logBuffer
+}
+
+// errJobRunning is returned when a job is started while another job is running. Jobs change the
+// same clusters and kubeconfig, so they run one at a time.
+var errJobRunning = fmt.Errorf("another job is running")
+
+// jobStore keeps jobs in memory, they are lost when the server restarts
+type jobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	running string
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*Job)}
+}
+
+// start runs the job in background, job logs are written to its buffer and to the server log output
+func (s *jobStore) start(jobType string, run JobFunc, cfg *config.Config, output io.Writer) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != "" {
+		return Job{}, fmt.Errorf("%w: %s", errJobRunning, s.running)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		ID:        hex.EncodeToString(id),
+		Type:      jobType,
+		State:     JobPending,
+		CreatedAt: time.Now(),
+		logs:      &logBuffer{},
+	}
+	s.jobs[job.ID] = job
+	s.running = job.ID
+
+	log := zap.New(zap.WriteTo(io.MultiWriter(job.logs, output))).WithValues("job", job.ID, "type", jobType)
+	go s.run(job, run, log, cfg)
+	return *job, nil
+}
+
+func (s *jobStore) run(job *Job, run JobFunc, log logr.Logger, cfg *config.Config) {
+	s.update(job, func() {
+		now := time.Now()
+		job.State, job.StartedAt = JobRunning, &now
+	})
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return run(log, cfg)
+	}()
+
+	if err != nil {
+		log.Error(err, "Job failed")
+	} else {
+		log.Info("Job succeeded")
+	}
+	s.update(job, func() {
+		now := time.Now()
+		job.State, job.FinishedAt = JobSucceeded, &now
+		if err != nil {
+			job.State, job.Error = JobFailed, err.Error()
+		}
+		s.running = ""
+	})
+}
+
+func (s *jobStore) update(job *Job, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// list returns jobs, newest first
+func (s *jobStore) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// logBuffer is written by the job and read by API requests concurrently
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// from returns logs from the byte offset, so that clients can follow logs of a running job
+func (b *logBuffer) from(offset int) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	offset = max(0, min(offset, b.buf.Len()))
+	return bytes.Clone(b.buf.Bytes()[offset:])
+}
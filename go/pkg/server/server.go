@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/status"
+)
+
+const apiPrefix = "/api/v1"
+
+// Server exposes deploy and uninstall runs as asynchronous jobs and fleet status over HTTP.
+// Config is read once when the server starts.
+type Server struct {
+	log      logr.Logger
+	cfg      *config.Config
+	jobTypes map[string]JobFunc
+	jobs     *jobStore
+	// token is required in "Authorization: Bearer" header of API requests if it is set
+	token string
+}
+
+func NewServer(log logr.Logger, cfg *config.Config, jobTypes map[string]JobFunc, token string) *Server {
+	return &Server{log: log, cfg: cfg, jobTypes: jobTypes, jobs: newJobStore(), token: token}
+}
+
+// Handler returns the API routes:
+//
+//	POST /api/v1/jobs               {"type": "deploy"} starts a job
+//	GET  /api/v1/jobs               lists jobs, newest first
+//	GET  /api/v1/jobs/<id>          returns the job
+//	GET  /api/v1/jobs/<id>/logs     returns JSON log lines of the job from ?offset=<bytes>
+//	GET  /api/v1/status             returns status of the clusters in config
+//	GET  /healthz
+//	GET  /metrics                   Prometheus metrics, see pkg/metrics
+func (s *Server) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc(apiPrefix+"/jobs", s.handleJobs)
+	api.HandleFunc(apiPrefix+"/jobs/", s.handleJob)
+	api.HandleFunc(apiPrefix+"/status", s.handleStatus)
+
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix+"/", s.authenticate(api))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// ListenAndServe serves the API until ctx is cancelled. Jobs have access to cloud credentials and
+// kubeconfigs, so the API is served on a non-loopback address only if the token is set.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if err := checkListenAddr(addr, s.token); err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: addr, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	s.log.Info("Serving API", "address", addr, "jobTypes", s.jobTypeNames())
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// checkListenAddr refuses addresses reachable from other hosts when the API is not authenticated
+func checkListenAddr(addr, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if token != "" || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("refusing to serve API without a token on non-loopback address %q, set MULTICLUSTER_API_TOKEN or listen on 127.0.0.1", addr)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.jobs.list())
+	case http.MethodPost:
+		var request struct {
+			Type string `json:"type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		run, ok := s.jobTypes[request.Type]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported job type %q, supported: %s", request.Type, strings.Join(s.jobTypeNames(), ", ")))
+			return
+		}
+		job, err := s.jobs.start(request.Type, run, s.cfg, os.Stderr)
+		if errors.Is(err, errJobRunning) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.log.Info("Started job", "job", job.ID, "type", job.Type)
+		w.Header().Set("Location", apiPrefix+"/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	id, logs := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, apiPrefix+"/jobs/"), "/logs")
+	job, ok := s.jobs.get(id)
+	if !ok || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+		return
+	}
+	if !logs {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset %q", value))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Write(job.logs.from(offset))
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, status.Fleet(r.Context(), s.log, s.cfg))
+}
+
+func (s *Server) jobTypeNames() []string {
+	names := make([]string, 0, len(s.jobTypes))
+	for name := range s.jobTypes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
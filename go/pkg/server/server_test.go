@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+func TestJobs(t *testing.T) {
+	release := make(chan struct{})
+	jobTypes := map[string]JobFunc{
+		"deploy": func(log logr.Logger, cfg *config.Config) error {
+			log.Info("Deploying")
+			<-release
+			return nil
+		},
+		"uninstall": func(log logr.Logger, cfg *config.Config) error {
+			return fmt.Errorf("nothing to uninstall")
+		},
+	}
+	srv := httptest.NewServer(NewServer(logr.Discard(), &config.Config{}, jobTypes, "secret").Handler())
+	defer srv.Close()
+
+	request := func(method, path, body string) (*http.Response, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		result := map[string]interface{}{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result
+	}
+	waitFor := func(id, state string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if _, job := request(http.MethodGet, "/api/v1/jobs/"+id, ""); job["state"] == state {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not become %s", id, state)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/v1/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without token: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	if resp, _ := request(http.MethodPost, "/api/v1/jobs", `{"type": "upgrade"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unsupported type: status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp, deploy := request(http.MethodPost, "/api/v1/jobs", `{"type": "deploy"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("start deploy: status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	deployID := deploy["id"].(string)
+	waitFor(deployID, JobRunning)
+
+	if resp, _ := request(http.MethodPost, "/api/v1/jobs", `{"type": "uninstall"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("job while another is running: status = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+
+	close(release)
+	waitFor(deployID, JobSucceeded)
+
+	logs, err := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/jobs/"+deployID+"/logs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []map[string]interface{}
+	for decoder := json.NewDecoder(resp.Body); decoder.More(); {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	resp.Body.Close()
+	if len(lines) != 2 || lines[0]["msg"] != "Deploying" || lines[0]["job"] != deployID {
+		t.Errorf("logs = %v", lines)
+	}
+
+	_, uninstall := request(http.MethodPost, "/api/v1/jobs", `{"type": "uninstall"}`)
+	waitFor(uninstall["id"].(string), JobFailed)
+	if _, job := request(http.MethodGet, "/api/v1/jobs/"+uninstall["id"].(string), ""); job["error"] != "nothing to uninstall" {
+		t.Errorf("failed job error = %v", job["error"])
+	}
+
+	if resp, _ := request(http.MethodGet, "/api/v1/jobs/unknown", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestCheckListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		token   string
+		wantErr bool
+	}{
+		{"127.0.0.1:8080", "", false},
+		{"[::1]:8080", "", false},
+		{"localhost:8080", "", false},
+		{":8080", "", true},
+		{"0.0.0.0:8080", "", true},
+		{"10.0.0.5:8080", "", true},
+		{":8080", "secret", false},
+		{"8080", "secret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr+"/"+tt.token, func(t *testing.T) {
+			if err := checkListenAddr(tt.addr, tt.token); (err != nil) != tt.wantErr {
+				t.Errorf("checkListenAddr(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package sops
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// SOPS manages the age key which Flux uses to decrypt SOPS encrypted manifests
+type SOPS struct {
+	log logr.Logger
+	cfg config.SOPSConfig
+}
+
+func NewSOPS(log logr.Logger, cfg config.SOPSConfig) *SOPS {
+	return &SOPS{log: log, cfg: cfg}
+}
+
+// EnsureAgeKey imports the age key from AgeKeyPath or generates a new one with age-keygen
+// if the file does not exist. Returns the public key (recipient) of the key.
+func (s *SOPS) EnsureAgeKey() (string, error) {
+	if _, err := os.Stat(s.cfg.AgeKeyPath); os.IsNotExist(err) {
+		s.log.Info("Generating age key", "path", s.cfg.AgeKeyPath)
+		if err := os.MkdirAll(filepath.Dir(s.cfg.AgeKeyPath), 0700); err != nil {
+			return "", fmt.Errorf("failed to create age key directory: %w", err)
+		}
+		if out, err := exec.Command("age-keygen", "-o", s.cfg.AgeKeyPath).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("age-keygen failed: %s: %w", out, err)
+		}
+	}
+
+	out, err := exec.Command("age-keygen", "-y", s.cfg.AgeKeyPath).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key of %s: %w", s.cfg.AgeKeyPath, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// WriteConfig writes .sops.yaml to the repo root, so that `sops --encrypt --in-place <file>`
+// encrypts data and stringData of Secrets with the public key
+func (s *SOPS) WriteConfig(publicKey string) error {
+	path := filepath.Join(utils.RepoRoot(), ".sops.yaml")
+	content := fmt.Sprintf(`# generated by multicluster-demo, Flux decrypts these files with %s secret
+creation_rules:
+  - path_regex: .*\.yaml$
+    encrypted_regex: ^(data|stringData)$
+    age: %s
+`, config.SOPSSecretName, publicKey)
+
+	s.log.Info("Writing SOPS config", "path", path)
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// CreateDecryptionSecret creates or updates the secret with the age private key in each namespace.
+// Namespaces which don't exist yet (e.g. before Flux applied them from the repo) are created.
+func (s *SOPS) CreateDecryptionSecret(clientset kubernetes.Interface, namespaces ...string) error {
+	key, err := s.privateKey()
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		if _, err := clientset.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error creating namespace %s: %w", namespace, err)
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      config.SOPSSecretName,
+				Namespace: namespace,
+			},
+			Data: map[string][]byte{"age.agekey": key},
+		}
+
+		s.log.Info("Creating SOPS decryption secret", "namespace", namespace)
+		secrets := clientset.CoreV1().Secrets(namespace)
+		_, err := secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("error creating secret in namespace %s: %w", namespace, err)
+		}
+	}
+	return nil
+}
+
+// privateKey returns the key without comments, which is the format Flux expects
+func (s *SOPS) privateKey() ([]byte, error) {
+	data, err := os.ReadFile(s.cfg.AgeKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age key: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "AGE-SECRET-KEY-") {
+			return []byte(line + "\n"), nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not contain an age private key", s.cfg.AgeKeyPath)
+}
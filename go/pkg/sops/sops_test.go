@@ -0,0 +1,86 @@
+package sops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+)
+
+const testKey = "AGE-SECRET-KEY-1QQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQ"
+
+func writeKey(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "age.agekey")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrivateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"age-keygen output", "# created: 2024-01-01T00:00:00Z\n# public key: age1xyz\n" + testKey + "\n", testKey + "\n", false},
+		{"key only", testKey, testKey + "\n", false},
+		{"no key", "# public key: age1xyz\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSOPS(logr.Discard(), config.SOPSConfig{AgeKeyPath: writeKey(t, tt.content)})
+			got, err := s.privateKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("privateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("privateKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	s := NewSOPS(logr.Discard(), config.SOPSConfig{AgeKeyPath: filepath.Join(t.TempDir(), "missing")})
+	if _, err := s.privateKey(); err == nil {
+		t.Error("expected error for missing key file")
+	}
+}
+
+func TestCreateDecryptionSecret(t *testing.T) {
+	// the secret exists in team-1 with a stale key and team-2 namespace does not exist yet
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-1"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: config.SOPSSecretName, Namespace: "team-1"},
+			Data:       map[string][]byte{"age.agekey": []byte("stale")},
+		},
+	)
+
+	s := NewSOPS(logr.Discard(), config.SOPSConfig{AgeKeyPath: writeKey(t, testKey+"\n")})
+	if err := s.CreateDecryptionSecret(clientset, "team-1", "team-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, namespace := range []string{"team-1", "team-2"} {
+		if _, err := clientset.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{}); err != nil {
+			t.Errorf("namespace %s: %v", namespace, err)
+		}
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), config.SOPSSecretName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("secret in %s: %v", namespace, err)
+		}
+		if got := string(secret.Data["age.agekey"]); got != testKey+"\n" {
+			t.Errorf("age.agekey in %s = %q, want the private key", namespace, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package status
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/apis/multicluster/v1alpha1"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+// PhaseNotFound is the phase of a cluster from config which doesn't exist on the management cluster,
+// PhaseUnknown is the phase of a cluster whose management cluster is not available
+const (
+	PhaseNotFound = "NotFound"
+	PhaseUnknown  = "Unknown"
+)
+
+// Collect returns status of the named clusters from CAPI Clusters on a management cluster,
+// clusters are in the namespaces named after them
+func Collect(ctx context.Context, client runtimeclient.Client, names []string) ([]v1alpha1.ClusterStatus, error) {
+	var statuses []v1alpha1.ClusterStatus
+	for _, name := range names {
+		cluster := &clusterv1.Cluster{}
+		if err := client.Get(ctx, runtimeclient.ObjectKey{Namespace: name, Name: name}, cluster); err != nil {
+			if runtimeclient.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("failed to get cluster %s: %w", name, err)
+			}
+			statuses = append(statuses, v1alpha1.ClusterStatus{Name: name, Phase: PhaseNotFound})
+			continue
+		}
+		statuses = append(statuses, v1alpha1.ClusterStatus{
+			Name:                name,
+			Phase:               cluster.Status.Phase,
+			InfrastructureReady: cluster.Status.InfrastructureReady,
+			ControlPlaneReady:   cluster.Status.ControlPlaneReady,
+			Ready:               conditions.IsTrue(cluster, clusterv1.ReadyCondition),
+		})
+	}
+	return statuses, nil
+}
+
+// AllReady returns true if all clusters are ready
+func AllReady(statuses []v1alpha1.ClusterStatus) bool {
+	for _, s := range statuses {
+		if !s.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// FleetStatus is status of all clusters in config. Clusters whose management clusters can't be
+// reached have PhaseUnknown.
+type FleetStatus struct {
+	Clusters    []v1alpha1.ClusterStatus `json:"clusters"`
+	Unreachable []string                 `json:"unreachableManagementClusters"`
+}
+
+// Fleet collects status of clusters from their management clusters. The permanent management
+// cluster is looked up on the temporary cluster and on itself, because deploy pivots it onto itself.
+func Fleet(ctx context.Context, log logr.Logger, cfg *config.Config) *FleetStatus {
+	fleet := &FleetStatus{Clusters: []v1alpha1.ClusterStatus{}, Unreachable: []string{}}
+	found := make(map[string]v1alpha1.ClusterStatus)
+
+	for _, mgmt := range cfg.ManagementClusterNames() {
+		names := cfg.ManagedClusters(mgmt)
+		if mgmt != config.DefaultKindClusterName {
+			names = append(names, mgmt)
+		}
+		statuses, err := collectFrom(ctx, cfg.KubeconfigPath, mgmt, names)
+		if err != nil {
+			// temporary kind cluster is deleted after the permanent management cluster is pivoted
+			if mgmt != config.DefaultKindClusterName {
+				log.Info("Management cluster is not available", "cluster", mgmt, "reason", err.Error())
+				fleet.Unreachable = append(fleet.Unreachable, mgmt)
+			}
+			continue
+		}
+		for _, s := range statuses {
+			if previous, ok := found[s.Name]; !ok || previous.Phase == PhaseNotFound {
+				found[s.Name] = s
+			}
+		}
+	}
+
+	for _, cluster := range cfg.Clusters {
+		if cluster.Provider == "kind" {
+			continue
+		}
+		s, ok := found[cluster.Name]
+		if !ok {
+			s = v1alpha1.ClusterStatus{Name: cluster.Name, Phase: PhaseUnknown}
+		}
+		fleet.Clusters = append(fleet.Clusters, s)
+	}
+	metrics.SetClusters(fleet.Clusters)
+	return fleet
+}
+
+func collectFrom(ctx context.Context, kubeconfigPath, mgmt string, names []string) ([]v1alpha1.ClusterStatus, error) {
+	clusterAuth, err := utils.GetClusterAuthInfoByName(kubeconfigPath, mgmt)
+	if err != nil {
+		return nil, err
+	}
+	scheme := runtime.NewScheme()
+	if err := clusterv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	client, err := runtimeclient.New(clusterAuth.Config, runtimeclient.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return Collect(ctx, client, names)
+}
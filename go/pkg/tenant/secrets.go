@@ -0,0 +1,66 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/fluxcd"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/sops"
+)
+
+// CreateSecrets creates secrets which Flux on the workload cluster needs to reconcile its tenants in the
+// tenant namespaces: the SSH key of tenant repositories pulled over SSH and the SOPS decryption key.
+// Namespaces which don't exist yet are created, Flux applies the rest of the tenant namespace later.
+func CreateSecrets(log logr.Logger, cfg *config.Config, clientset kubernetes.Interface, tenants []generator.ClusterTenant) error {
+	for _, tenant := range tenants {
+		if tenant.GitSecret != "" {
+			if err := createGitSecret(log, cfg.Tenant(tenant.Name), clientset, tenant); err != nil {
+				return err
+			}
+		}
+		if cfg.SOPS.Enabled {
+			if err := sops.NewSOPS(log, cfg.SOPS).CreateDecryptionSecret(clientset, tenant.Namespace); err != nil {
+				return fmt.Errorf("tenant %s: %w", tenant.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func createGitSecret(log logr.Logger, tenantConfig config.TenantConfig, clientset kubernetes.Interface, tenant generator.ClusterTenant) error {
+	if tenantConfig.KeySource.Type == "" {
+		return fmt.Errorf("tenant %s repository is pulled over SSH, set keySource of the tenant in config", tenant.Name)
+	}
+	data, err := fluxcd.SSHSecretData(tenantConfig.KeySource)
+	if err != nil {
+		return fmt.Errorf("tenant %s: error reading repository key: %w", tenant.Name, err)
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tenant.Namespace}}
+	if _, err := clientset.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating namespace %s: %w", tenant.Namespace, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: tenant.GitSecret, Namespace: tenant.Namespace},
+		Data:       data,
+	}
+	log.Info("Creating tenant repository secret", "tenant", tenant.Name, "namespace", tenant.Namespace)
+	secrets := clientset.CoreV1().Secrets(tenant.Namespace)
+	_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("error creating secret %s/%s: %w", tenant.Namespace, tenant.GitSecret, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package tenant
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/generator"
+)
+
+// writeSSHKey writes a new private key and returns its path
+func writeSSHKey(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCreateSecrets(t *testing.T) {
+	cfg := &config.Config{
+		Tenants: []config.TenantConfig{{Name: "team-1", KeySource: config.SecretSourceConfig{Type: "file", Path: writeSSHKey(t)}}},
+	}
+	tenants := []generator.ClusterTenant{
+		{Name: "team-1", Namespace: "apps", GitSecret: "team-1-git"},
+		{Name: "team-2", Namespace: "team-2"},
+	}
+	// the secret of team-1 exists with a stale key
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-1-git", Namespace: "apps"},
+			Data:       map[string][]byte{"identity": []byte("stale")},
+		},
+	)
+
+	if err := CreateSecrets(logr.Discard(), cfg, clientset, tenants); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := clientset.CoreV1().Secrets("apps").Get(context.TODO(), "team-1-git", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"identity", "identity.pub", "known_hosts"} {
+		if len(secret.Data[key]) == 0 || string(secret.Data[key]) == "stale" {
+			t.Errorf("secret key %s = %q, want it set from the key source", key, secret.Data[key])
+		}
+	}
+
+	// repository of team-2 is not pulled over SSH and SOPS is disabled, nothing is created for it
+	if _, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "team-2", metav1.GetOptions{}); err == nil {
+		t.Errorf("namespace team-2 is created, want no secrets for the tenant")
+	}
+
+	// tenant pulled over SSH without key source in config
+	tenants = []generator.ClusterTenant{{Name: "team-3", Namespace: "team-3", GitSecret: "team-3-git"}}
+	if err := CreateSecrets(logr.Discard(), cfg, clientset, tenants); err == nil {
+		t.Errorf("CreateSecrets() without key source error = nil, want error")
+	}
+}
@@ -0,0 +1,137 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/utils"
+)
+
+const pollInterval = 15 * time.Second
+
+var kustomizationGVR = schema.GroupVersionResource{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Resource: "kustomizations"}
+
+// guardrails are generated from templates/tenant/base/guardrails.yaml
+var guardrails = []struct {
+	name string
+	gvr  schema.GroupVersionResource
+}{
+	{"tenant-quota", schema.GroupVersionResource{Version: "v1", Resource: "resourcequotas"}},
+	{"tenant-limits", schema.GroupVersionResource{Version: "v1", Resource: "limitranges"}},
+	{"default-deny", schema.GroupVersionResource{Group: "cilium.io", Version: "v2", Resource: "ciliumnetworkpolicies"}},
+}
+
+// Verifier checks that tenant guardrails are applied on the clusters the tenant is added to
+type Verifier struct {
+	log logr.Logger
+	cfg *config.Config
+}
+
+func NewVerifier(log logr.Logger, cfg *config.Config) *Verifier {
+	return &Verifier{log: log, cfg: cfg}
+}
+
+// Verify waits for the tenant Flux Kustomization of each cluster to become ready on the management
+// cluster, then checks that guardrails exist in the tenant namespace on the cluster
+func (v *Verifier) Verify(name, namespace string, clusters []string, timeout time.Duration) error {
+	for _, clusterName := range clusters {
+		var cluster *config.ClusterConfig
+		for i := range v.cfg.Clusters {
+			if v.cfg.Clusters[i].Name == clusterName {
+				cluster = &v.cfg.Clusters[i]
+			}
+		}
+		if cluster == nil {
+			return fmt.Errorf("cluster %s is not defined in config", clusterName)
+		}
+
+		if err := v.waitReconciled(cluster, name, timeout); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+
+		missing, err := v.missingGuardrails(cluster, namespace)
+		if err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("cluster %s: tenant %s guardrails are missing in namespace %s: %v", cluster.Name, name, namespace, missing)
+		}
+		v.log.Info("Tenant guardrails are in place", "tenant", name, "cluster", cluster.Name, "namespace", namespace)
+	}
+	return nil
+}
+
+// waitReconciled waits for the Kustomization tenant-<name> in the cluster namespace on its management cluster
+func (v *Verifier) waitReconciled(cluster *config.ClusterConfig, name string, timeout time.Duration) error {
+	mgmtAuth, err := utils.GetClusterAuthInfoByName(v.cfg.KubeconfigPath, cluster.ManagedBy())
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(mgmtAuth.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	kustomizationName := "tenant-" + name
+	deadline := time.Now().Add(timeout)
+	for {
+		kustomization, err := dynamicClient.Resource(kustomizationGVR).Namespace(cluster.Name).Get(context.TODO(), kustomizationName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get kustomization %s: %w", kustomizationName, err)
+		}
+		if err == nil && isReady(kustomization) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for kustomization %s/%s to become ready, check that the tenant is pushed", cluster.Name, kustomizationName)
+		}
+		v.log.Info("Waiting for tenant kustomization", "cluster", cluster.Name, "kustomization", kustomizationName)
+		time.Sleep(pollInterval)
+	}
+}
+
+// missingGuardrails returns "<resource>/<name>" of guardrails which don't exist on the cluster
+func (v *Verifier) missingGuardrails(cluster *config.ClusterConfig, namespace string) ([]string, error) {
+	clusterAuth, err := utils.GetClusterAuthInfoByName(v.cfg.KubeconfigPath, cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, g := range guardrails {
+		exists, err := utils.ResourcesExist(clusterAuth.Config, namespace, g.name, g.gvr)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, g.gvr.Resource+"/"+g.name)
+		}
+	}
+	return missing, nil
+}
+
+// isReady returns true if the Ready condition of the object is True for its current generation, the
+// condition is stale until the controller observes the generation, e.g. right after the tenant is pushed
+func isReady(obj *unstructured.Unstructured) bool {
+	observedGeneration, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if !found || observedGeneration != obj.GetGeneration() {
+		return false
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Ready" {
+			return condition["status"] == "True"
+		}
+	}
+	return false
+}
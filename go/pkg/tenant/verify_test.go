@@ -0,0 +1,38 @@
+package tenant
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsReady(t *testing.T) {
+	ready := []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}}
+	notReady := []interface{}{map[string]interface{}{"type": "Ready", "status": "False"}}
+
+	tests := []struct {
+		name   string
+		status map[string]interface{}
+		want   bool
+	}{
+		{"ready", map[string]interface{}{"observedGeneration": int64(2), "conditions": ready}, true},
+		{"not ready", map[string]interface{}{"observedGeneration": int64(2), "conditions": notReady}, false},
+		{"stale generation", map[string]interface{}{"observedGeneration": int64(1), "conditions": ready}, false},
+		{"generation not observed", map[string]interface{}{"conditions": ready}, false},
+		{"no conditions", map[string]interface{}{"observedGeneration": int64(2)}, false},
+		{"no status", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			obj.SetGeneration(2)
+			if tt.status != nil {
+				obj.Object["status"] = tt.status
+			}
+			if got := isReady(obj); got != tt.want {
+				t.Errorf("isReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
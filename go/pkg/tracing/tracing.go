@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	serviceName = "multicluster-demo"
+	tracerName  = "github.com/olga-mir/k8s-multi-cluster/go"
+)
+
+// Span attributes
+const (
+	ClusterKey   = attribute.Key("multicluster.cluster")
+	NamespaceKey = attribute.Key("k8s.namespace.name")
+	GVRKey       = attribute.Key("k8s.gvr")
+)
+
+// Setup configures the global tracer provider to export spans to OTLP/HTTP endpoint, e.g.
+// http://localhost:4318, and/or to a file as JSON. Without an endpoint and a file spans are not recorded.
+// Returned function flushes the spans and must be called before the program exits.
+func Setup(ctx context.Context, endpoint, file string) (func(context.Context) error, error) {
+	if endpoint == "" && file == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid OTLP endpoint %q, expected URL such as http://localhost:4318", endpoint)
+		}
+		exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+		if u.Scheme == "http" {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+		}
+		if u.Path != "" && u.Path != "/" {
+			exporterOpts = append(exporterOpts, otlptracehttp.WithURLPath(u.Path))
+		}
+		exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	var f *os.File
+	if file != "" {
+		if f, err = os.Create(file); err != nil {
+			return nil, fmt.Errorf("failed to create trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if f != nil {
+			err = errors.Join(err, f.Close())
+		}
+		return err
+	}, nil
+}
+
+// Run runs f in a span, error returned by f is recorded in the span.
+// Spans started from ctx passed to f are children of this span.
+func Run(ctx context.Context, name string, f func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+	defer span.End()
+
+	err := f(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// Cluster is the name of the cluster the span operates on
+func Cluster(name string) attribute.KeyValue {
+	return ClusterKey.String(name)
+}
+
+// Namespace is the namespace of the resources the span operates on
+func Namespace(namespace string) attribute.KeyValue {
+	return NamespaceKey.String(namespace)
+}
+
+// GVR is the resource the span operates on, e.g. cluster.x-k8s.io/v1beta1, Resource=clusters
+func GVR(gvr schema.GroupVersionResource) attribute.KeyValue {
+	return GVRKey.String(gvr.String())
+}
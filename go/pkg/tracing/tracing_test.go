@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.json")
+	shutdown, err := Setup(context.Background(), "", file)
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	failed := errors.New("cluster is not provisioned")
+	err = Run(context.Background(), "deploy", func(ctx context.Context) error {
+		return Run(ctx, "wait cluster provisioned", func(context.Context) error { return failed }, Cluster("cluster-01"), Namespace("cluster-01"))
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("Run() error = %v, want %v", err, failed)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type span struct {
+		Name        string
+		SpanContext struct{ TraceID, SpanID string }
+		Parent      struct{ SpanID string }
+		Status      struct{ Code string }
+		Attributes  []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}
+	spans := map[string]span{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var s span
+		if err := decoder.Decode(&s); err != nil {
+			t.Fatalf("invalid span in trace file: %v", err)
+		}
+		spans[s.Name] = s
+	}
+
+	parent, child := spans["deploy"], spans["wait cluster provisioned"]
+	if parent.SpanContext.SpanID == "" || child.Parent.SpanID != parent.SpanContext.SpanID || child.SpanContext.TraceID != parent.SpanContext.TraceID {
+		t.Errorf("span %q is not a child of %q", child.Name, parent.Name)
+	}
+	if child.Status.Code != "Error" || parent.Status.Code != "Error" {
+		t.Errorf("status = %s, %s, want Error", child.Status.Code, parent.Status.Code)
+	}
+	attrs := map[string]interface{}{}
+	for _, a := range child.Attributes {
+		attrs[a.Key] = a.Value.Value
+	}
+	if attrs[string(ClusterKey)] != "cluster-01" || attrs[string(NamespaceKey)] != "cluster-01" {
+		t.Errorf("attributes = %v", attrs)
+	}
+}
@@ -13,14 +13,19 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/audit"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/config"
 	"github.com/olga-mir/k8s-multi-cluster/go/pkg/k8sclient"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/metrics"
+	"github.com/olga-mir/k8s-multi-cluster/go/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -30,9 +35,12 @@ import (
 
 // TODO - rework utils to receiver methods
 
+// FieldManager is the field manager of objects applied with server-side apply
+const FieldManager = "multicluster-demo"
+
 // WaitAllResourcesReady waits for all specified resources to be ready in the given namespaces.
 // If namespaces array is empty the function returns immediatelly
-func WaitAllResourcesReady(clusterAuth k8sclient.ClusterAuthInfo, namespaces []string, gvr []schema.GroupVersionResource) error {
+func WaitAllResourcesReady(ctx context.Context, clusterAuth k8sclient.ClusterAuthInfo, namespaces []string, gvr []schema.GroupVersionResource) error {
 	if len(namespaces) == 0 {
 		return nil
 	}
@@ -45,7 +53,9 @@ func WaitAllResourcesReady(clusterAuth k8sclient.ClusterAuthInfo, namespaces []s
 			wg.Add(1)
 			go func(ns string, resource schema.GroupVersionResource) {
 				defer wg.Done()
-				err := waitForResourceReady(clusterAuth.Config, ns, resource, 10*time.Minute)
+				err := tracing.Run(ctx, "wait resources ready", func(ctx context.Context) error {
+					return waitForResourceReady(ctx, clusterAuth.Config, ns, resource, 10*time.Minute)
+				}, tracing.Cluster(clusterAuth.ClusterName), tracing.Namespace(ns), tracing.GVR(resource))
 				resultChan <- err
 			}(ns, resource)
 		}
@@ -83,7 +93,7 @@ func ListAllNamespacesWithPrefix(k8sClient *kubernetes.Clientset, prefix string)
 	return namespaces, nil
 }
 
-func waitForResourceReady(restConfig *rest.Config, namespace string, resource schema.GroupVersionResource, timeout time.Duration) error {
+func waitForResourceReady(ctx context.Context, restConfig *rest.Config, namespace string, resource schema.GroupVersionResource, timeout time.Duration) error {
 	dynamicClient, err := dynamic.NewForConfig(restConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create dynamic client: %w", err)
@@ -103,7 +113,7 @@ func waitForResourceReady(restConfig *rest.Config, namespace string, resource sc
 
 		case <-ticker.C:
 			// Check resource status
-			ready, err := isResourceReady(dynamicClient, namespace, resource)
+			ready, err := isResourceReady(ctx, dynamicClient, namespace, resource)
 			if err != nil {
 				return err
 			}
@@ -114,8 +124,8 @@ func waitForResourceReady(restConfig *rest.Config, namespace string, resource sc
 	}
 }
 
-func isResourceReady(dynamicClient dynamic.Interface, namespace string, gvr schema.GroupVersionResource) (bool, error) {
-	resources, err := dynamicClient.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+func isResourceReady(ctx context.Context, dynamicClient dynamic.Interface, namespace string, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return false, fmt.Errorf("failed to list resources for %s: %w", gvr.Resource, err)
 	}
@@ -163,8 +173,33 @@ func ResourcesExist(restConfig *rest.Config, namespace string, resourceName stri
 
 }
 
-// ApplyManifestsFile applies all manifests in a provided file
-func ApplyManifestsFile(dynamicClient dynamic.Interface, manifestFile string) error {
+// ObjectMutator modifies an object before it is applied to the cluster
+type ObjectMutator func(obj *unstructured.Unstructured) error
+
+// ApplyManifestsFile applies all manifests in a provided file. Optional mutators
+// are called on each object before it is created.
+func ApplyManifestsFile(dynamicClient dynamic.Interface, manifestFile string, mutators ...ObjectMutator) error {
+	return applyManifestsFile(dynamicClient, manifestFile, func(resource dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+		_, err := resource.Create(context.TODO(), obj, metav1.CreateOptions{})
+		return err
+	}, mutators...)
+}
+
+// ServerSideApplyManifestsFile is like ApplyManifestsFile, but objects are applied with server-side apply,
+// so that objects which already exist are updated to the manifests, e.g. a Deployment to a new image
+func ServerSideApplyManifestsFile(dynamicClient dynamic.Interface, manifestFile string, mutators ...ObjectMutator) error {
+	return applyManifestsFile(dynamicClient, manifestFile, func(resource dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		force := true
+		_, err = resource.Patch(context.TODO(), obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: FieldManager, Force: &force})
+		return err
+	}, mutators...)
+}
+
+func applyManifestsFile(dynamicClient dynamic.Interface, manifestFile string, apply func(dynamic.ResourceInterface, *unstructured.Unstructured) error, mutators ...ObjectMutator) error {
 	fileData, err := os.ReadFile(manifestFile)
 	if err != nil {
 		return fmt.Errorf("failed to read %s: %w", manifestFile, err)
@@ -184,14 +219,19 @@ func ApplyManifestsFile(dynamicClient dynamic.Interface, manifestFile string) er
 			continue // Skip empty objects
 		}
 
+		for _, mutate := range mutators {
+			if err := mutate(&obj); err != nil {
+				return fmt.Errorf("failed to mutate resource (Kind: %s, Name: %s): %w", obj.GetKind(), obj.GetName(), err)
+			}
+		}
+
 		gvr := schema.GroupVersionResource{
 			Group:    obj.GetObjectKind().GroupVersionKind().Group,
 			Version:  obj.GetObjectKind().GroupVersionKind().Version,
 			Resource: getResourceName(obj.GetKind()),
 		}
 
-		_, err := dynamicClient.Resource(gvr).Namespace(obj.GetNamespace()).Create(context.TODO(), &obj, metav1.CreateOptions{})
-		if err != nil {
+		if err := apply(dynamicClient.Resource(gvr).Namespace(obj.GetNamespace()), &obj); err != nil {
 			return fmt.Errorf("failed to apply resource (Kind: %s, Name: %s): %w", obj.GetKind(), obj.GetName(), err)
 		}
 	}
@@ -216,20 +256,24 @@ func getResourceName(kind string) string {
 	return strings.ToLower(kind) + "s"
 }
 
-func WaitForCRDs(config *rest.Config, crds []string) error {
+func WaitForCRDs(ctx context.Context, config *rest.Config, crds []string) error {
+	defer metrics.ObserveWait(metrics.WaitCRDs, time.Now())
 	apiExtClient, err := apiextensionsclientset.NewForConfig(config)
 	if err != nil {
 		return fmt.Errorf("error creating API extensions client: %w", err)
 	}
 	for _, crd := range crds {
-		if err := waitUntilCRDEstablished(apiExtClient, crd); err != nil {
+		err := tracing.Run(ctx, "wait CRD established", func(ctx context.Context) error {
+			return waitUntilCRDEstablished(ctx, apiExtClient, crd)
+		}, tracing.GVR(apiextensionsv1.SchemeGroupVersion.WithResource("customresourcedefinitions")), attribute.String("k8s.crd.name", crd))
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func waitUntilCRDEstablished(clientSet apiextensionsclientset.Interface, crdName string) error {
+func waitUntilCRDEstablished(ctx context.Context, clientSet apiextensionsclientset.Interface, crdName string) error {
 	timeout := time.After(5 * time.Minute)
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
@@ -239,7 +283,11 @@ func waitUntilCRDEstablished(clientSet apiextensionsclientset.Interface, crdName
 		case <-timeout:
 			return fmt.Errorf("timeout waiting for CRD %s to be established", crdName)
 		case <-ticker.C:
-			crd, err := clientSet.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), crdName, metav1.GetOptions{})
+			crd, err := clientSet.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crdName, metav1.GetOptions{})
+			// CRD is created by a controller or Flux, it may not exist yet
+			if errors.IsNotFound(err) {
+				continue
+			}
 			if err != nil {
 				return fmt.Errorf("error getting CRD %s: %w", crdName, err)
 			}
@@ -269,10 +317,93 @@ func RepoRoot() string {
 	return repoRoot
 }
 
+// GitCommitAndPush commits the given paths and pushes the commit to the branch. Only the paths are
+// committed, changes which the user has staged in other paths stay staged and are not pushed.
+// It returns SHA of the new commit.
+func GitCommitAndPush(paths []string, message, branch string) (string, error) {
+	repoRoot := RepoRoot()
+
+	addArgs := append([]string{"-C", repoRoot, "add", "--"}, paths...)
+	commitArgs := append([]string{"-C", repoRoot, "commit", "-m", message, "--"}, paths...)
+	commands := [][]string{
+		addArgs,
+		commitArgs,
+		{"-C", repoRoot, "push", "origin", "HEAD:" + branch},
+	}
+	for _, args := range commands {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			err = fmt.Errorf("git %s failed: %w: %s", args[2], err, strings.TrimSpace(string(out)))
+			if args[2] == "push" {
+				audit.LogResult(audit.Record{
+					Action: audit.ActionPush,
+					Object: audit.ObjectRef{Kind: "GitBranch", Name: branch},
+					Diff:   strings.Join(paths, ", "),
+				}, err)
+			}
+			return "", err
+		}
+	}
+
+	out, err := exec.Command("git", "-C", repoRoot, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD revision: %w", err)
+	}
+	sha := strings.TrimSpace(string(out))
+	audit.Log(audit.Record{
+		Action: audit.ActionPush,
+		Object: audit.ObjectRef{Kind: "GitBranch", Name: branch},
+		Diff:   fmt.Sprintf("commit %s: %s", sha, strings.Join(paths, ", ")),
+	})
+	return sha, nil
+}
+
+// GitHasChanges reports whether the paths have uncommitted changes, including untracked files
+func GitHasChanges(paths ...string) (bool, error) {
+	args := append([]string{"-C", RepoRoot(), "status", "--porcelain", "--"}, paths...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return false, fmt.Errorf("git status failed: %w", err)
+	}
+	return len(strings.TrimSpace(string(out))) > 0, nil
+}
+
 type ClusterNameData struct {
 	Name string
 }
 
+// ClusterNameDataFromName converts cluster name as it is used in config.yaml (e.g. "cluster-01")
+// to the data expected by CAPI name templates, which add "cluster-" prefix themselves.
+func ClusterNameDataFromName(name string) ClusterNameData {
+	return ClusterNameData{Name: strings.TrimPrefix(name, "cluster-")}
+}
+
+// GetClusterAuthInfoByName returns ClusterAuthInfo for a cluster defined in config.yaml.
+// Kubeconfig must already contain the context for the cluster (see GetClusterAuthInfoForWorkloadCluster)
+func GetClusterAuthInfoByName(kubeconfigPath, name string) (*k8sclient.ClusterAuthInfo, error) {
+	if name == config.DefaultKindClusterName {
+		return k8sclient.GetKubernetesClient(kubeconfigPath, config.DefaultKindClusterCtxName, config.DefaultKindClusterName)
+	}
+
+	clusterName, contextName, err := GetCAPIClusterNameAndContext(ClusterNameDataFromName(name))
+	if err != nil {
+		return nil, fmt.Errorf("error getting cluster name and context: %w", err)
+	}
+	return k8sclient.GetKubernetesClient(kubeconfigPath, contextName, clusterName)
+}
+
+// HasContext reports whether kubeconfig has the context, a missing kubeconfig has no contexts
+func HasContext(kubeconfigPath, contextName string) (bool, error) {
+	kubeconfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	_, ok := kubeconfig.Contexts[contextName]
+	return ok, nil
+}
+
 // GetCAPIClusterCtxName generates a CAPI cluster context name using a template and data.
 func GetCAPIClusterNameAndContext(data ClusterNameData) (string, string, error) {
 	t, err := template.New("clustername").Parse(config.DefaultCAPIClusterNameTpl)
@@ -335,3 +466,43 @@ func MergeKubeconfigs(srcKubeconfig, dstKubeconfigPath string) error {
 
 	return nil
 }
+
+// RemoveKubeconfigContext removes the context from kubeconfig together with its cluster and user entries,
+// unless they are used by other contexts. Missing context is not an error.
+func RemoveKubeconfigContext(kubeconfigPath, contextName string) error {
+	kubeconfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	kubeContext, ok := kubeconfig.Contexts[contextName]
+	if !ok {
+		return nil
+	}
+	delete(kubeconfig.Contexts, contextName)
+	clusterUsed, authInfoUsed := false, false
+	for _, other := range kubeconfig.Contexts {
+		clusterUsed = clusterUsed || other.Cluster == kubeContext.Cluster
+		authInfoUsed = authInfoUsed || other.AuthInfo == kubeContext.AuthInfo
+	}
+	if !clusterUsed {
+		delete(kubeconfig.Clusters, kubeContext.Cluster)
+	}
+	if !authInfoUsed {
+		delete(kubeconfig.AuthInfos, kubeContext.AuthInfo)
+	}
+	if kubeconfig.CurrentContext == contextName {
+		kubeconfig.CurrentContext = ""
+	}
+
+	err = clientcmd.WriteToFile(*kubeconfig, kubeconfigPath)
+	audit.LogResult(audit.Record{
+		Action:  audit.ActionDelete,
+		Cluster: kubeContext.Cluster,
+		Object:  audit.ObjectRef{Kind: "Kubeconfig", Name: kubeconfigPath},
+		Diff:    "removed context " + contextName,
+	}, err)
+	if err != nil {
+		return fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	return nil
+}
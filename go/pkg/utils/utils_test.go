@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestRemoveKubeconfigContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	kubeconfig := clientcmdapi.NewConfig()
+	for _, name := range []string{"cluster-mgmt", "cluster-01"} {
+		kubeconfig.Clusters[name] = &clientcmdapi.Cluster{Server: "https://" + name}
+		kubeconfig.AuthInfos[name+"-admin"] = &clientcmdapi.AuthInfo{Token: name}
+		kubeconfig.Contexts[name+"-admin@"+name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name + "-admin"}
+	}
+	// second context of cluster-mgmt shares its cluster and user
+	kubeconfig.Contexts["mgmt"] = &clientcmdapi.Context{Cluster: "cluster-mgmt", AuthInfo: "cluster-mgmt-admin"}
+	kubeconfig.CurrentContext = "cluster-01-admin@cluster-01"
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"cluster-01-admin@cluster-01", "cluster-mgmt-admin@cluster-mgmt", "missing"} {
+		if err := RemoveKubeconfigContext(path, name); err != nil {
+			t.Fatalf("RemoveKubeconfigContext(%s) error = %v", name, err)
+		}
+	}
+
+	got, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Contexts) != 1 || got.Contexts["mgmt"] == nil {
+		t.Errorf("contexts = %v, want only mgmt", got.Contexts)
+	}
+	if len(got.Clusters) != 1 || got.Clusters["cluster-mgmt"] == nil {
+		t.Errorf("clusters = %v, want only cluster-mgmt", got.Clusters)
+	}
+	if len(got.AuthInfos) != 1 || got.AuthInfos["cluster-mgmt-admin"] == nil {
+		t.Errorf("users = %v, want only cluster-mgmt-admin", got.AuthInfos)
+	}
+	if got.CurrentContext != "" {
+		t.Errorf("current context = %q, want it cleared", got.CurrentContext)
+	}
+
+	if err := RemoveKubeconfigContext(filepath.Join(t.TempDir(), "missing"), "mgmt"); err == nil {
+		t.Error("RemoveKubeconfigContext() of missing kubeconfig returned nil error")
+	}
+}
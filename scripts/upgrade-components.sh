@@ -2,6 +2,7 @@
 
 echo "NOTE: this script does not safely upgrade components in the cluster"
 echo "it only updates the code base with updated versions (before installation)"
+echo "Go app equivalent which also shows CRD changes: multicluster-demo components vendor flux <version>"
 
 REPO_ROOT=$(git rev-parse --show-toplevel)
 